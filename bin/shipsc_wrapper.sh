@@ -3,8 +3,9 @@
 #
 # This script is set as the ForceCommand in the authorized_keys file of the
 # dedicated SSH user (e.g. 'shipscmd'). It allows the operator to execute only
-# three safe verbs of the shipsc CLI:
+# four safe verbs of the shipsc CLI:
 #   • fetch   – retrieve the current Administrator password for a host
+#   • history – list every escrowed Administrator password for a host
 #   • rotate  – rotate the Administrator password for a host (optional NEWPW)
 #   • bde     – retrieve the BitLocker recovery key for a host
 #
@@ -100,6 +101,7 @@ SHIPS2-Go Restricted Operator Shell v${VERSION}
 
 Allowed commands:
   fetch   HOSTNAME                    - Retrieve administrator password
+  history HOSTNAME                    - List previous administrator passwords
   rotate  HOSTNAME [NEWPASSWORD]     - Rotate administrator password
   bde     HOSTNAME                    - Retrieve BitLocker recovery key
 
@@ -132,6 +134,16 @@ case "$verb" in
         exec "${SHIPSC_BIN}" fetch "$hostname"
         ;;
         
+    history)
+        if [[ ${#cmd_array[@]} -ne 2 ]]; then
+            denied "history requires exactly one argument: HOSTNAME"
+        fi
+        validate_hostname "$hostname"
+        log_json "ALLOW" "$verb" "$hostname"
+        log "ALLOW: $USER from ${SSH_CLIENT%% *} executed: history $hostname"
+        exec "${SHIPSC_BIN}" history "$hostname"
+        ;;
+
     bde)
         if [[ ${#cmd_array[@]} -ne 2 ]]; then
             denied "bde requires exactly one argument: HOSTNAME"
@@ -169,6 +181,7 @@ SHIPS2-Go SSH Wrapper Help
 
 Allowed commands:
   fetch HOSTNAME                 - Get current admin password
+  history HOSTNAME               - List previous admin passwords
   rotate HOSTNAME [PASSWORD]     - Rotate admin password (auto-gen if not provided)
   bde HOSTNAME                   - Get BitLocker recovery key
 
@@ -0,0 +1,124 @@
+// cmd/client/agent.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	neturl "net/url"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// checkInStatus mirrors store.CheckInStatus as returned by
+// POST /api/v1/agent/checkin.
+type checkInStatus struct {
+	Hostname         string    `json:"hostname"`
+	RotationRequired bool      `json:"rotation_required"`
+	Reason           string    `json:"reason"`
+	RequiredSince    time.Time `json:"required_since"`
+	RequiredBy       string    `json:"required_by"`
+	CheckedOutUntil  time.Time `json:"checked_out_until"`
+}
+
+// cmdAgent checks in with the server and, when it asks for a rotation
+// because the password was fetched or none is escrowed, rotates the local
+// account with a generated password. Meant to run from a scheduled task.
+func cmdAgent(server string, args []string) error {
+	flagSet := flag.NewFlagSet("agent", flag.ContinueOnError)
+	user := flagSet.String("user", "", "local account whose password is escrowed")
+	setter := flagSet.String("setter", os.Getenv("SHIPS_PASSWORD_SETTER"),
+		"how to apply the password: chpasswd (Linux default) or exec:PROGRAM")
+	actor := flagSet.String("actor", "shipsc-agent", "who performed the rotation")
+	rest, err := parseArgs(flagSet, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 1 || *user == "" {
+		return errors.New("usage: shipsc agent [HOSTNAME] -user ACCOUNT [-setter S] [-actor name]")
+	}
+	hostname := ""
+	if len(rest) == 1 {
+		hostname = rest[0]
+	} else if hostname, err = os.Hostname(); err != nil {
+		return fmt.Errorf("determining hostname: %w", err)
+	}
+
+	identity, err := machineIdentity()
+	if err != nil {
+		return err
+	}
+	var status checkInStatus
+	payload := map[string]string{"host": hostname, "machine_identity": identity}
+	if err := postJSON(server+"/api/v1/agent/checkin", payload, &status); err != nil {
+		return fmt.Errorf("checking in: %w", err)
+	}
+	if !status.CheckedOutUntil.IsZero() {
+		fmt.Printf("Password of %s is checked out until %s; not rotating.\n", hostname,
+			status.CheckedOutUntil.Format(time.RFC3339))
+		return nil
+	}
+	if !status.RotationRequired {
+		fmt.Printf("No rotation required for %s.\n", hostname)
+		return nil
+	}
+	if status.RequiredBy != "" {
+		fmt.Printf("Rotation required for %s: %s by %s at %s.\n", hostname, status.Reason,
+			status.RequiredBy, status.RequiredSince.Format(time.RFC3339))
+	} else {
+		fmt.Printf("Rotation required for %s: %s.\n", hostname, status.Reason)
+	}
+	return rotateGenerated(server, hostname, *actor, true, *user, *setter)
+}
+
+// cmdOverdue GETs /api/v1/rotations/overdue and prints machines whose
+// fetched password has not been rotated within the grace period.
+func cmdOverdue(server string, args []string) error {
+	flagSet := flag.NewFlagSet("overdue", flag.ContinueOnError)
+	grace := flagSet.String("grace", "", "grace period after a fetch, e.g. 12h or 7d "+
+		"(default: the server's SHIPS_ROTATION_GRACE)")
+	asJSON := flagSet.Bool("json", false, "print JSON instead of a table")
+	rest, err := parseArgs(flagSet, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 0 {
+		return errors.New("usage: shipsc overdue [-grace 24h] [-json]")
+	}
+
+	url := server + "/api/v1/rotations/overdue"
+	if *grace != "" {
+		url += "?" + neturl.Values{"grace": {*grace}}.Encode()
+	}
+	var resp struct {
+		Grace    string `json:"grace"`
+		Machines []struct {
+			Hostname      string    `json:"hostname"`
+			RequiredSince time.Time `json:"required_since"`
+			RequiredBy    string    `json:"required_by"`
+			LastCheckIn   time.Time `json:"last_checkin"`
+		} `json:"machines"`
+	}
+	if err := httpGetJSON(url, &resp); err != nil {
+		return err
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(resp)
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "HOST\tFETCHED AT\tFETCHED BY\tLAST CHECK-IN")
+	for _, machine := range resp.Machines {
+		lastCheckIn := "never"
+		if !machine.LastCheckIn.IsZero() {
+			lastCheckIn = machine.LastCheckIn.Format(time.RFC3339)
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", machine.Hostname,
+			machine.RequiredSince.Format(time.RFC3339), machine.RequiredBy, lastCheckIn)
+	}
+	return writer.Flush()
+}
@@ -0,0 +1,114 @@
+// cmd/client/approval.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	neturl "net/url"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// approvalRequest mirrors store.ApprovalRequest.
+type approvalRequest struct {
+	ID          int64     `json:"id"`
+	Hostname    string    `json:"hostname"`
+	Secret      string    `json:"secret"`
+	Requester   string    `json:"requester"`
+	Reason      string    `json:"reason"`
+	State       string    `json:"state"`
+	RequestedAt time.Time `json:"requested_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	DecidedBy   string    `json:"decided_by"`
+	UsableUntil time.Time `json:"usable_until"`
+}
+
+// approvalRequiredError reads the 202 reply the server sends when a secret
+// needs a second person's approval and explains what to do next.
+func approvalRequiredError(body io.Reader) error {
+	var reply struct {
+		Request approvalRequest `json:"request"`
+	}
+	if err := json.NewDecoder(body).Decode(&reply); err != nil {
+		return fmt.Errorf("approval required: %w", err)
+	}
+	request := reply.Request
+	return fmt.Errorf("approval required (%s): request %d waits until %s for someone else "+
+		"to run `shipsc approve %d`; repeat this command once it is approved",
+		request.Reason, request.ID, request.ExpiresAt.Format(time.RFC3339), request.ID)
+}
+
+// cmdApprovals GETs /api/v1/approvals and lists the requests, by default
+// only those waiting for a decision.
+func cmdApprovals(server string, args []string) error {
+	flagSet := flag.NewFlagSet("approvals", flag.ContinueOnError)
+	state := flagSet.String("state", "pending",
+		"pending, approved, denied, used, expired, or all")
+	rest, err := parseArgs(flagSet, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 0 {
+		return errors.New("usage: shipsc approvals [-state pending]")
+	}
+	url := server + "/api/v1/approvals"
+	if *state != "all" {
+		url += "?" + neturl.Values{"state": {*state}}.Encode()
+	}
+
+	var resp struct {
+		Requests []approvalRequest `json:"requests"`
+	}
+	if err := httpGetJSON(url, &resp); err != nil {
+		return err
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "ID\tHOST\tSECRET\tREQUESTER\tSTATE\tREQUESTED AT\tREASON")
+	for _, request := range resp.Requests {
+		fmt.Fprintf(writer, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", request.ID, request.Hostname,
+			request.Secret, request.Requester, request.State,
+			request.RequestedAt.Format(time.RFC3339), request.Reason)
+	}
+	return writer.Flush()
+}
+
+// cmdDecide POSTs /api/v1/approvals/:id/approve or /deny.
+func cmdDecide(server string, args []string, approve bool) error {
+	command := "deny"
+	if approve {
+		command = "approve"
+	}
+	flagSet := flag.NewFlagSet(command, flag.ContinueOnError)
+	note := flagSet.String("note", "", "reason for the decision, kept in the audit log")
+	rest, err := parseArgs(flagSet, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 {
+		return fmt.Errorf("usage: shipsc %s REQUEST_ID [-note text]", command)
+	}
+	id, err := strconv.ParseInt(rest[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid request ID %q", rest[0])
+	}
+
+	var request approvalRequest
+	url := fmt.Sprintf("%s/api/v1/approvals/%d/%s", server, id, command)
+	if err := postJSON(url, map[string]string{"note": *note}, &request); err != nil {
+		return err
+	}
+	if approve {
+		fmt.Printf("Approved request %d: %s may retrieve the %s of %s once until %s.\n",
+			id, request.Requester, request.Secret, request.Hostname,
+			request.UsableUntil.Format(time.RFC3339))
+	} else {
+		fmt.Printf("Denied request %d by %s for the %s of %s.\n",
+			id, request.Requester, request.Secret, request.Hostname)
+	}
+	return nil
+}
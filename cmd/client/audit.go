@@ -0,0 +1,121 @@
+// cmd/client/audit.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	neturl "net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// auditEntry mirrors store.AuditEntry as returned by GET /api/v1/audit.
+type auditEntry struct {
+	ID         int64     `json:"id"`
+	Hostname   string    `json:"hostname"`
+	Action     string    `json:"action"`
+	Actor      string    `json:"actor"`
+	RemoteAddr string    `json:"remote_addr"`
+	Detail     string    `json:"detail"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// cmdAudit GETs /api/v1/audit and prints the matching entries.
+func cmdAudit(server string, args []string) error {
+	flagSet := flag.NewFlagSet("audit", flag.ContinueOnError)
+	host := flagSet.String("host", "", "only entries for this hostname")
+	actor := flagSet.String("actor", "", "only entries by this actor")
+	action := flagSet.String("action", "", "only this action, e.g. fetch_password")
+	remoteAddr := flagSet.String("remote-addr", "", "only entries from this address")
+	since := flagSet.String("since", "", "start time: 30d, 12h, 2025-07-01 or RFC 3339")
+	until := flagSet.String("until", "", "end time, same formats as -since")
+	limit := flagSet.Int("limit", 100, "maximum entries per page")
+	before := flagSet.Int64("before", 0, "continue from the page ending before this ID")
+	asJSON := flagSet.Bool("json", false, "print JSON instead of a table")
+	rest, err := parseArgs(flagSet, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 0 {
+		return errors.New("usage: shipsc audit [-host H] [-actor A] [-action X] " +
+			"[-since T] [-until T] [-limit N] [-before ID] [-json]")
+	}
+
+	query := neturl.Values{}
+	for name, value := range map[string]string{
+		"host": *host, "actor": *actor, "action": *action, "remote_addr": *remoteAddr,
+	} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	for name, value := range map[string]string{"since": *since, "until": *until} {
+		if value == "" {
+			continue
+		}
+		parsed, err := parseTimeFlag(value, time.Now())
+		if err != nil {
+			return fmt.Errorf("-%s: %w", name, err)
+		}
+		query.Set(name, parsed.Format(time.RFC3339))
+	}
+	query.Set("limit", strconv.Itoa(*limit))
+	if *before > 0 {
+		query.Set("before", strconv.FormatInt(*before, 10))
+	}
+	url := fmt.Sprintf("%s/api/v1/audit?%s", server, query.Encode())
+
+	var resp struct {
+		Entries    []auditEntry `json:"entries"`
+		NextBefore int64        `json:"next_before"`
+	}
+	if err := httpGetJSON(url, &resp); err != nil {
+		return err
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(resp)
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "ID\tTIME\tHOST\tACTION\tACTOR\tREMOTE\tDETAIL")
+	for _, entry := range resp.Entries {
+		fmt.Fprintf(writer, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", entry.ID,
+			entry.Timestamp.Format(time.RFC3339), entry.Hostname, entry.Action,
+			entry.Actor, entry.RemoteAddr, entry.Detail)
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if resp.NextBefore != 0 {
+		fmt.Fprintf(os.Stderr, "more entries available: add -before %d\n", resp.NextBefore)
+	}
+	return nil
+}
+
+// parseTimeFlag accepts a look-back duration relative to now ("30d", "12h",
+// "90m"), a date ("2025-07-01") or an RFC 3339 timestamp.
+func parseTimeFlag(value string, now time.Time) (time.Time, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		count, err := strconv.Atoi(days)
+		if err == nil && count >= 0 {
+			return now.AddDate(0, 0, -count), nil
+		}
+	}
+	if duration, err := time.ParseDuration(value); err == nil {
+		return now.Add(-duration), nil
+	}
+	if date, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return date, nil
+	}
+	if timestamp, err := time.Parse(time.RFC3339, value); err == nil {
+		return timestamp, nil
+	}
+	return time.Time{}, fmt.Errorf("unrecognised time %q", value)
+}
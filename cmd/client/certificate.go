@@ -0,0 +1,185 @@
+// cmd/client/certificate.go
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// issuedCertificate is the reply of certificate enrollment and renewal.
+type issuedCertificate struct {
+	Host          string    `json:"host"`
+	Serial        string    `json:"serial"`
+	NotAfter      time.Time `json:"not_after"`
+	Certificate   string    `json:"certificate"`
+	CACertificate string    `json:"ca_certificate"`
+}
+
+// cmdRenew exchanges the machine's client certificate for a new one with a
+// fresh key before it expires. The current certificate authenticates the
+// request and is revoked by the server once the new one is issued.
+func cmdRenew(server string, args []string) error {
+	flagSet := flag.NewFlagSet("renew", flag.ContinueOnError)
+	certFile := flagSet.String("cert", os.Getenv("SHIPS_CLIENT_CERT"), "client certificate to renew")
+	keyFile := flagSet.String("key", os.Getenv("SHIPS_CLIENT_KEY"), "its private key")
+	caFile := flagSet.String("ca", "", "also write the CA certificate to this file")
+	before := flagSet.String("before", "30d", "only renew when the certificate expires within this period")
+	force := flagSet.Bool("force", false, "renew regardless of the expiry date")
+	rest, err := parseArgs(flagSet, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 0 || *certFile == "" || *keyFile == "" {
+		return errors.New("usage: shipsc renew [-cert FILE -key FILE] [-ca FILE] [-before 30d] [-force]")
+	}
+
+	current, err := readCertificate(*certFile)
+	if err != nil {
+		return err
+	}
+	renewAt, err := parseTimeFlag(*before, current.NotAfter)
+	if err != nil {
+		return fmt.Errorf("-before: %w", err)
+	}
+	if !*force && time.Now().Before(renewAt) {
+		fmt.Printf("Certificate %s is valid until %s; not renewing yet.\n",
+			current.SerialNumber.Text(16), current.NotAfter.Format("2006-01-02"))
+		return nil
+	}
+	hostname := current.Subject.CommonName
+	if len(current.DNSNames) > 0 {
+		hostname = current.DNSNames[0]
+	}
+
+	key, csr, err := newCertificateRequest(hostname)
+	if err != nil {
+		return err
+	}
+	client, err := newHTTPClient(*certFile, *keyFile)
+	if err != nil {
+		return err
+	}
+	issued, err := requestCertificate(client, server+"/api/v1/certificates/renew",
+		map[string]string{"csr": csr})
+	if err != nil {
+		return err
+	}
+	if err := saveCertificate(issued, key, *certFile, *keyFile, *caFile); err != nil {
+		return err
+	}
+	fmt.Printf("Renewed %s; certificate %s valid until %s written to %s.\n",
+		issued.Host, issued.Serial, issued.NotAfter.Format("2006-01-02"), *certFile)
+	return nil
+}
+
+// newCertificateRequest generates an ECDSA P-256 key and a CSR for hostname,
+// returning the PEM encoded private key and request.
+func newCertificateRequest(hostname string) ([]byte, string, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, "", err
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: hostname},
+		DNSNames: []string{hostname},
+	}, key)
+	if err != nil {
+		return nil, "", fmt.Errorf("creating certificate request: %w", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, "", err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}),
+		string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})), nil
+}
+
+// requestCertificate POSTs payload to url and decodes the issued certificate.
+func requestCertificate(client *http.Client, url string, payload map[string]string) (*issuedCertificate, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	// #nosec G107 – server is trusted / controlled
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(resp.Body) // nolint:errcheck // best effort error text
+		return nil, fmt.Errorf("server %s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	var issued issuedCertificate
+	if err := json.NewDecoder(resp.Body).Decode(&issued); err != nil {
+		return nil, err
+	}
+	return &issued, nil
+}
+
+// saveCertificate writes the private key, the issued certificate and, when
+// caFile is set, the CA certificate. Each file is replaced atomically.
+func saveCertificate(issued *issuedCertificate, key []byte, certFile, keyFile, caFile string) error {
+	if err := writeFileAtomic(keyFile, key, 0o600); err != nil {
+		return fmt.Errorf("writing private key: %w", err)
+	}
+	if err := writeFileAtomic(certFile, []byte(issued.Certificate), 0o644); err != nil {
+		return fmt.Errorf("writing certificate: %w", err)
+	}
+	if caFile != "" {
+		if err := writeFileAtomic(caFile, []byte(issued.CACertificate), 0o644); err != nil {
+			return fmt.Errorf("writing CA certificate: %w", err)
+		}
+	}
+	return nil
+}
+
+// readCertificate parses the first certificate of a PEM file.
+func readCertificate(path string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(path) // #nosec G304 – path comes from the operator
+	if err != nil {
+		return nil, fmt.Errorf("reading certificate: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("%s contains no PEM certificate", path)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// writeFileAtomic replaces path with data through a temporary file in the
+// same directory, so readers never see a partial file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name()) // nolint:errcheck // gone after a successful rename
+	if err := temp.Chmod(perm); err != nil {
+		temp.Close()
+		return err
+	}
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
@@ -0,0 +1,39 @@
+// cmd/client/decommission.go
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	neturl "net/url"
+	"time"
+)
+
+// cmdDecommission POSTs /api/v1/machines/:host/decommission to retire a
+// machine and prints when its secrets will be purged.
+func cmdDecommission(server string, args []string) error {
+	flagSet := flag.NewFlagSet("decommission", flag.ContinueOnError)
+	reason := flagSet.String("reason", "", "why the machine is retired, e.g. a ticket number")
+	actor := flagSet.String("actor", "manual", "who decommissions the machine")
+	rest, err := parseArgs(flagSet, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 || *reason == "" {
+		return errors.New("usage: shipsc decommission HOSTNAME -reason TEXT [-actor name]")
+	}
+
+	url := fmt.Sprintf("%s/api/v1/machines/%s/decommission", server, neturl.PathEscape(rest[0]))
+	var retirement struct {
+		RetiredAt  time.Time `json:"retired_at"`
+		PurgeAfter time.Time `json:"purge_after"`
+	}
+	payload := map[string]string{"reason": *reason, "actor": *actor}
+	if err := postJSON(url, payload, &retirement); err != nil {
+		return err
+	}
+	fmt.Printf("Decommissioned %s at %s.\n", rest[0], retirement.RetiredAt.Format(time.RFC3339))
+	fmt.Printf("Its secrets stay readable by admins until %s and are then destroyed.\n",
+		retirement.PurgeAfter.Format(time.RFC3339))
+	return nil
+}
@@ -0,0 +1,103 @@
+// cmd/client/enroll.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const enrollUsage = "usage: shipsc enroll [HOSTNAME] -token TOKEN " +
+	"[-out FILE | -cert FILE -key FILE [-ca FILE]]"
+
+// cmdEnroll redeems a one-time enrollment token for this machine's own
+// credential: an API token stored where SHIPS_TOKEN_FILE can point to, or,
+// with -cert and -key, a client certificate from the server's built-in CA.
+func cmdEnroll(server string, args []string) error {
+	flagSet := flag.NewFlagSet("enroll", flag.ContinueOnError)
+	token := flagSet.String("token", "", "one-time enrollment token from `ships-server enroll-token create`")
+	out := flagSet.String("out", "", "write the credential to this file instead of printing it")
+	certFile := flagSet.String("cert", "", "request a client certificate and write it to this file")
+	keyFile := flagSet.String("key", "", "write the certificate's new private key to this file")
+	caFile := flagSet.String("ca", "", "write the CA certificate to this file")
+	rest, err := parseArgs(flagSet, args)
+	if err != nil {
+		return err
+	}
+	wantCertificate := *certFile != "" || *keyFile != ""
+	if *token == "" || len(rest) > 1 ||
+		(wantCertificate && (*certFile == "" || *keyFile == "" || *out != "")) ||
+		(!wantCertificate && *caFile != "") {
+		return errors.New(enrollUsage)
+	}
+	hostname := ""
+	if len(rest) == 1 {
+		hostname = rest[0]
+	} else if hostname, err = os.Hostname(); err != nil {
+		return fmt.Errorf("determining hostname: %w", err)
+	}
+
+	payload := map[string]string{
+		"host":             hostname,
+		"enrollment_token": *token,
+	}
+	if wantCertificate {
+		key, csr, err := newCertificateRequest(hostname)
+		if err != nil {
+			return err
+		}
+		payload["csr"] = csr
+		client, err := newHTTPClient("", "")
+		if err != nil {
+			return err
+		}
+		issued, err := requestCertificate(client, server+"/api/v1/enroll", payload)
+		if err != nil {
+			return err
+		}
+		if err := saveCertificate(issued, key, *certFile, *keyFile, *caFile); err != nil {
+			return err
+		}
+		fmt.Printf("Enrolled %s; certificate %s valid until %s written to %s.\n",
+			issued.Host, issued.Serial, issued.NotAfter.Format("2006-01-02"), *certFile)
+		fmt.Println("Set SHIPS_CLIENT_CERT and SHIPS_CLIENT_KEY to use it; renew with shipsc renew.")
+		return nil
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal enrollment payload: %w", err)
+	}
+	resp, err := doRequest(http.MethodPost, server+"/api/v1/enroll", body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(resp.Body) // nolint:errcheck // best effort error text
+		return fmt.Errorf("server %s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	var result struct {
+		Host  string `json:"host"`
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+
+	if *out == "" {
+		fmt.Println(result.Token)
+		return nil
+	}
+	if err := os.WriteFile(*out, []byte(result.Token+"\n"), 0o600); err != nil {
+		return fmt.Errorf("writing credential: %w", err)
+	}
+	fmt.Printf("Enrolled %s; credential written to %s (set SHIPS_TOKEN_FILE to it).\n",
+		result.Host, *out)
+	return nil
+}
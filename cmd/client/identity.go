@@ -0,0 +1,108 @@
+// cmd/client/identity.go
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// machineIdentity returns the stable identity shipsc reports with every
+// write, so the server notices when a reimaged machine reuses a hostname.
+// SHIPS_MACHINE_IDENTITY selects it: "smbios" for the SMBIOS system UUID,
+// "serial" for the system serial number, "cert" for the SHA-256
+// fingerprint of SHIPS_CLIENT_CERT, or "exec:PROGRAM" for the output of a
+// site-specific program. Unset reports none, as on an admin workstation
+// writing for other machines. It is read once per run.
+var machineIdentity = sync.OnceValues(func() (string, error) {
+	spec := os.Getenv("SHIPS_MACHINE_IDENTITY")
+	switch spec {
+	case "":
+		return "", nil
+	case "smbios", "serial":
+		value, err := readSMBIOS(spec)
+		if err != nil {
+			return "", fmt.Errorf("SHIPS_MACHINE_IDENTITY=%s: %w", spec, err)
+		}
+		return spec + ":" + value, nil
+	case "cert":
+		fingerprint, err := certificateFingerprint(os.Getenv("SHIPS_CLIENT_CERT"))
+		if err != nil {
+			return "", fmt.Errorf("SHIPS_MACHINE_IDENTITY=cert: %w", err)
+		}
+		return "cert:" + fingerprint, nil
+	}
+	program, ok := strings.CutPrefix(spec, "exec:")
+	if !ok || program == "" {
+		return "", fmt.Errorf("unknown SHIPS_MACHINE_IDENTITY %q "+
+			"(want smbios, serial, cert or exec:PROGRAM)", spec)
+	}
+	output, err := exec.Command(program).Output() // #nosec G204 – program comes from the operator
+	if err != nil {
+		return "", fmt.Errorf("SHIPS_MACHINE_IDENTITY: %s: %w", program, err)
+	}
+	return nonEmptyIdentity(output)
+})
+
+// readSMBIOS reads the system UUID ("smbios") or serial number ("serial")
+// from the firmware tables: sysfs on Linux, CIM on Windows.
+func readSMBIOS(field string) (string, error) {
+	switch runtime.GOOS {
+	case "linux":
+		file := map[string]string{"smbios": "product_uuid", "serial": "product_serial"}[field]
+		output, err := os.ReadFile("/sys/class/dmi/id/" + file)
+		if err != nil {
+			return "", err
+		}
+		return nonEmptyIdentity(output)
+	case "windows":
+		query := map[string]string{
+			"smbios": "(Get-CimInstance Win32_ComputerSystemProduct).UUID",
+			"serial": "(Get-CimInstance Win32_BIOS).SerialNumber",
+		}[field]
+		output, err := exec.Command("powershell.exe", "-NoProfile", "-NonInteractive",
+			"-Command", query).Output()
+		if err != nil {
+			return "", err
+		}
+		return nonEmptyIdentity(output)
+	}
+	return "", fmt.Errorf("not supported on %s; use exec:PROGRAM", runtime.GOOS)
+}
+
+// certificateFingerprint returns the hex SHA-256 of the first certificate
+// in the PEM file certFile.
+func certificateFingerprint(certFile string) (string, error) {
+	if certFile == "" {
+		return "", errors.New("SHIPS_CLIENT_CERT is not set")
+	}
+	data, err := os.ReadFile(certFile) // #nosec G304 – path comes from the operator
+	if err != nil {
+		return "", err
+	}
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type == "CERTIFICATE" {
+			sum := sha256.Sum256(block.Bytes)
+			return hex.EncodeToString(sum[:]), nil
+		}
+	}
+	return "", fmt.Errorf("%s contains no PEM certificate", certFile)
+}
+
+// nonEmptyIdentity trims output and refuses an empty result, which some
+// firmware reports instead of a serial number.
+func nonEmptyIdentity(output []byte) (string, error) {
+	identity := string(bytes.TrimSpace(output))
+	if identity == "" {
+		return "", errors.New("empty identity")
+	}
+	return identity, nil
+}
@@ -0,0 +1,98 @@
+// cmd/client/lease.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"time"
+)
+
+// passwordLease mirrors store.Lease.
+type passwordLease struct {
+	ID        int64     `json:"id"`
+	Holder    string    `json:"holder"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// cmdCheckout POSTs /api/v1/password/:host/checkout and prints the password
+// with the lease. If someone else holds the password it says who and until
+// when.
+func cmdCheckout(server string, args []string) error {
+	flagSet := flag.NewFlagSet("checkout", flag.ContinueOnError)
+	duration := flagSet.String("for", "1h", "lease duration, e.g. 30m, 2h or 1d")
+	actor := flagSet.String("actor", "manual", "who checks the password out")
+	rest, err := parseArgs(flagSet, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 {
+		return errors.New("usage: shipsc checkout HOSTNAME [-for 2h] [-actor name]")
+	}
+	host := rest[0]
+
+	body, err := json.Marshal(map[string]string{"duration": *duration, "actor": *actor})
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("%s/api/v1/password/%s/checkout", server, neturl.PathEscape(host))
+	resp, err := doRequest(http.MethodPost, url, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusAccepted {
+		return approvalRequiredError(resp.Body)
+	}
+
+	var result struct {
+		Error    string         `json:"error"`
+		Password string         `json:"password"`
+		Lease    *passwordLease `json:"lease"`
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &result); err != nil || result.Lease == nil {
+		return fmt.Errorf("server %s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	if resp.StatusCode == http.StatusConflict {
+		return fmt.Errorf("%s is checked out by %s until %s", host, result.Lease.Holder,
+			result.Lease.ExpiresAt.Format(time.RFC3339))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server %s: %s", resp.Status, result.Error)
+	}
+
+	fmt.Printf("Password:   %s\n", result.Password)
+	fmt.Printf("Lease:      %d, until %s\n", result.Lease.ID,
+		result.Lease.ExpiresAt.Format(time.RFC3339))
+	fmt.Println("The password will be rotated once the lease ends; " +
+		"run `shipsc checkin HOSTNAME` when done.")
+	return nil
+}
+
+// cmdCheckin POSTs /api/v1/password/:host/checkin to end a lease early.
+func cmdCheckin(server string, args []string) error {
+	flagSet := flag.NewFlagSet("checkin", flag.ContinueOnError)
+	actor := flagSet.String("actor", "manual", "who checked the password out")
+	rest, err := parseArgs(flagSet, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 {
+		return errors.New("usage: shipsc checkin HOSTNAME [-actor name]")
+	}
+	url := fmt.Sprintf("%s/api/v1/password/%s/checkin", server, neturl.PathEscape(rest[0]))
+	if err := postJSON(url, map[string]string{"actor": *actor}, nil); err != nil {
+		return err
+	}
+	fmt.Printf("Checked in %s; its password will be rotated.\n", rest[0])
+	return nil
+}
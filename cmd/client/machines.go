@@ -0,0 +1,140 @@
+// cmd/client/machines.go
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	neturl "net/url"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// inventoryMachine mirrors store.Machine as returned by GET /api/v1/machines.
+type inventoryMachine struct {
+	Hostname      string    `json:"hostname"`
+	FirstSeen     time.Time `json:"first_seen"`
+	LastRotation  time.Time `json:"last_rotation"`
+	LastKeyUpdate time.Time `json:"last_key_update"`
+	LastFetch     time.Time `json:"last_fetch"`
+	RetiredAt     time.Time `json:"retired_at"`
+	Identity      string    `json:"machine_identity"`
+	Generation    int       `json:"generation"`
+}
+
+// machinePage is one page of GET /api/v1/machines.
+type machinePage struct {
+	Machines   []inventoryMachine `json:"machines"`
+	NextCursor string             `json:"next_cursor"`
+}
+
+// cmdList GETs /api/v1/machines and prints the inventory as a table, CSV or
+// JSON. With -all it follows the cursor until every page is fetched.
+func cmdList(server string, args []string) error {
+	flagSet := flag.NewFlagSet("list", flag.ContinueOnError)
+	search := flagSet.String("q", "", "only hostnames containing this text")
+	prefix := flagSet.String("prefix", "", "only hostnames starting with this text")
+	sortBy := flagSet.String("sort", "hostname", "hostname, first_seen, last_rotation, "+
+		"last_key_update or last_fetch; prefix with - for newest first")
+	limit := flagSet.Int("limit", 100, "maximum machines per page")
+	cursor := flagSet.String("cursor", "", "continue from this next_cursor value")
+	all := flagSet.Bool("all", false, "fetch every page")
+	format := flagSet.String("format", "table", "output format: table, csv or json")
+	rest, err := parseArgs(flagSet, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 0 {
+		return errors.New("usage: shipsc list [-q TEXT] [-prefix P] [-sort S] [-limit N] " +
+			"[-cursor C | -all] [-format table|csv|json]")
+	}
+	if *format != "table" && *format != "csv" && *format != "json" {
+		return fmt.Errorf("unknown format %q: use table, csv or json", *format)
+	}
+
+	query := neturl.Values{}
+	for name, value := range map[string]string{"q": *search, "prefix": *prefix, "sort": *sortBy} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	query.Set("limit", strconv.Itoa(*limit))
+
+	var result machinePage
+	next := *cursor
+	for {
+		if next != "" {
+			query.Set("cursor", next)
+		}
+		var page machinePage
+		if err := httpGetJSON(server+"/api/v1/machines?"+query.Encode(), &page); err != nil {
+			return err
+		}
+		result.Machines = append(result.Machines, page.Machines...)
+		result.NextCursor = page.NextCursor
+		next = page.NextCursor
+		if !*all || next == "" {
+			break
+		}
+	}
+
+	switch *format {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	case "csv":
+		writer := csv.NewWriter(os.Stdout)
+		if err := writer.Write([]string{"hostname", "first_seen", "last_rotation",
+			"last_key_update", "last_fetch", "retired_at", "machine_identity",
+			"generation"}); err != nil {
+			return err
+		}
+		for _, machine := range result.Machines {
+			if err := writer.Write([]string{machine.Hostname,
+				formatInventoryTime(machine.FirstSeen, ""),
+				formatInventoryTime(machine.LastRotation, ""),
+				formatInventoryTime(machine.LastKeyUpdate, ""),
+				formatInventoryTime(machine.LastFetch, ""),
+				formatInventoryTime(machine.RetiredAt, ""),
+				machine.Identity, strconv.Itoa(machine.Generation)}); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			return err
+		}
+	default:
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "HOST\tFIRST SEEN\tLAST ROTATION\tLAST KEY UPDATE\tLAST FETCH\tRETIRED")
+		for _, machine := range result.Machines {
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\n", machine.Hostname,
+				formatInventoryTime(machine.FirstSeen, "-"),
+				formatInventoryTime(machine.LastRotation, "never"),
+				formatInventoryTime(machine.LastKeyUpdate, "never"),
+				formatInventoryTime(machine.LastFetch, "never"),
+				formatInventoryTime(machine.RetiredAt, "-"))
+		}
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+	}
+	if result.NextCursor != "" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "more machines available: add -cursor %s\n", result.NextCursor)
+	}
+	return nil
+}
+
+// formatInventoryTime formats an inventory time as RFC 3339, or returns
+// never for the zero time.
+func formatInventoryTime(timestamp time.Time, never string) string {
+	if timestamp.IsZero() {
+		return never
+	}
+	return timestamp.Format(time.RFC3339)
+}
@@ -3,12 +3,31 @@
 //
 // Usage examples:
 //   shipsc fetch   HOSTNAME
-//   shipsc rotate  HOSTNAME NEWPASSWORD [-actor name]
-//   shipsc bde     HOSTNAME
-//   shipsc update-key HOSTNAME 48-DIGIT-KEY [-actor name]
+//   shipsc history HOSTNAME
+//   shipsc rotate  HOSTNAME [NEWPASSWORD] [-actor name]
+//   shipsc rotate  HOSTNAME -generate [-apply -user ACCOUNT] [-actor name]
+//   shipsc confirm VERSION-ID [-actor name]
+//   shipsc checkout HOSTNAME [-for 2h]
+//   shipsc checkin HOSTNAME
+//   shipsc approvals [-state pending]
+//   shipsc approve REQUEST_ID [-note text]
+//   shipsc deny    REQUEST_ID [-note text]
+//   shipsc list    [-q TEXT] [-sort last_fetch] [-all] [-format csv]
+//   shipsc report  compliance [-max-age 30d] [-format csv]
+//   shipsc decommission HOSTNAME -reason TEXT
+//   shipsc rename  OLD-HOSTNAME NEW-HOSTNAME
+//   shipsc agent   [HOSTNAME] -user ACCOUNT [-setter S]
+//   shipsc overdue [-grace 24h] [-json]
+//   shipsc bde     HOSTNAME [-protector KEYID]
+//   shipsc bde     -key-id KEYID
+//   shipsc update-key HOSTNAME 48-DIGIT-KEY [-volume C:] [-protector-id GUID] [-actor name]
+//   shipsc audit   [-host H] [-actor A] [-since 30d] [-json]
+//   shipsc enroll  [HOSTNAME] -token TOKEN [-out FILE | -cert FILE -key FILE]
+//   shipsc renew   [-cert FILE -key FILE] [-before 30d]
 //
 // The server URL defaults to http://localhost:8080 but can be overridden
-// with the environment variable SHIPS_SERVER.
+// with the environment variable SHIPS_SERVER. When the server requires API
+// tokens, set SHIPS_TOKEN (or SHIPS_TOKEN_FILE) to the token to use.
 package main
 
 import (
@@ -19,9 +38,15 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	neturl "net/url"
 	"os"
+	"strconv"
 	"strings"
+	"text/tabwriter"
 	"time"
+
+	"github.com/jottavia/SHIPS2-Go/internal/bitlocker"
+	"github.com/jottavia/SHIPS2-Go/internal/passgen"
 )
 
 const defaultServer = "http://localhost:8080"
@@ -46,6 +71,9 @@ func main() {
 
 	if err := dispatchCommand(server, command, args); err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		if errors.Is(err, errNonCompliant) {
+			os.Exit(exitNonCompliant)
+		}
 		os.Exit(1)
 	}
 }
@@ -65,12 +93,44 @@ func dispatchCommand(server, command string, args []string) error {
 	switch command {
 	case "fetch":
 		return cmdFetch(server, args)
+	case "history":
+		return cmdHistory(server, args)
 	case "rotate":
 		return cmdRotate(server, args)
+	case "confirm":
+		return cmdConfirm(server, args)
+	case "checkout":
+		return cmdCheckout(server, args)
+	case "checkin":
+		return cmdCheckin(server, args)
+	case "approvals":
+		return cmdApprovals(server, args)
+	case "approve":
+		return cmdDecide(server, args, true)
+	case "deny":
+		return cmdDecide(server, args, false)
+	case "list":
+		return cmdList(server, args)
+	case "report":
+		return cmdReport(server, args)
+	case "decommission":
+		return cmdDecommission(server, args)
+	case "rename":
+		return cmdRename(server, args)
+	case "agent":
+		return cmdAgent(server, args)
+	case "overdue":
+		return cmdOverdue(server, args)
 	case "bde":
 		return cmdBDE(server, args)
 	case "update-key", "update_key":
 		return cmdUpdateKey(server, args)
+	case "audit":
+		return cmdAudit(server, args)
+	case "enroll":
+		return cmdEnroll(server, args)
+	case "renew":
+		return cmdRenew(server, args)
 	case "help", "-h", "--help":
 		usageAndExit("")
 		return nil
@@ -86,18 +146,50 @@ func usageAndExit(format string, arguments ...interface{}) {
 	}
 	fmt.Fprintf(os.Stderr, "SHIPS2-Go client usage:\n")
 	fmt.Fprintf(os.Stderr, "  shipsc fetch HOSTNAME\n")
-	fmt.Fprintf(os.Stderr, "  shipsc rotate HOSTNAME NEWPASSWORD [-actor name]\n")
-	fmt.Fprintf(os.Stderr, "  shipsc bde HOSTNAME\n")
+	fmt.Fprintf(os.Stderr, "  shipsc history HOSTNAME\n")
+	fmt.Fprintf(os.Stderr, "  shipsc rotate HOSTNAME [NEWPASSWORD] [-actor name]\n")
+	fmt.Fprintf(os.Stderr,
+		"  shipsc rotate HOSTNAME -generate [-apply -user ACCOUNT [-setter S]] [-actor name]\n")
+	fmt.Fprintf(os.Stderr, "  shipsc confirm VERSION-ID [-actor name]\n")
+	fmt.Fprintf(os.Stderr, "  shipsc checkout HOSTNAME [-for 2h] [-actor name]\n")
+	fmt.Fprintf(os.Stderr, "  shipsc checkin HOSTNAME [-actor name]\n")
+	fmt.Fprintf(os.Stderr, "  shipsc approvals [-state pending]\n")
+	fmt.Fprintf(os.Stderr, "  shipsc approve|deny REQUEST_ID [-note text]\n")
+	fmt.Fprintf(os.Stderr,
+		"  shipsc list [-q TEXT] [-prefix P] [-sort -last_fetch] [-all] [-format table|csv|json]\n")
+	fmt.Fprintf(os.Stderr,
+		"  shipsc report compliance [-max-age 30d] [-all] [-format table|csv|json]\n")
+	fmt.Fprintf(os.Stderr, "  shipsc decommission HOSTNAME -reason TEXT [-actor name]\n")
+	fmt.Fprintf(os.Stderr, "  shipsc rename OLD-HOSTNAME NEW-HOSTNAME [-actor name]\n")
+	fmt.Fprintf(os.Stderr, "  shipsc agent [HOSTNAME] -user ACCOUNT [-setter S] [-actor name]\n")
+	fmt.Fprintf(os.Stderr, "  shipsc overdue [-grace 24h] [-json]\n")
+	fmt.Fprintf(os.Stderr, "  shipsc bde HOSTNAME [-protector KEYID]\n")
+	fmt.Fprintf(os.Stderr, "  shipsc bde -key-id KEYID\n")
 	fmt.Fprintf(os.Stderr,
-		"  shipsc update-key HOSTNAME 48-DIGIT-KEY [-actor name]\n")
+		"  shipsc update-key HOSTNAME 48-DIGIT-KEY [-volume C:] [-protector-id GUID] [-actor name]\n")
+	fmt.Fprintf(os.Stderr,
+		"  shipsc audit [-host H] [-actor A] [-action X] [-since 30d] [-until T] [-json]\n")
+	fmt.Fprintf(os.Stderr,
+		"  shipsc enroll [HOSTNAME] -token TOKEN [-out FILE | -cert FILE -key FILE [-ca FILE]]\n")
+	fmt.Fprintf(os.Stderr, "  shipsc renew [-cert FILE -key FILE] [-before 30d] [-force]\n")
 	fmt.Fprintf(os.Stderr, "  shipsc version\n")
 	fmt.Fprintf(os.Stderr, "\nEnv vars:\n")
 	fmt.Fprintf(os.Stderr,
 		"  SHIPS_SERVER   server base URL (default %s)\n", defaultServer)
+	fmt.Fprintf(os.Stderr,
+		"  SHIPS_TOKEN    API token sent as a bearer token (or SHIPS_TOKEN_FILE)\n")
+	fmt.Fprintf(os.Stderr,
+		"  SHIPS_CA_CERT  CA bundle to trust for an https:// server\n")
+	fmt.Fprintf(os.Stderr,
+		"  SHIPS_CLIENT_CERT, SHIPS_CLIENT_KEY  client certificate for mutual TLS\n")
+	fmt.Fprintf(os.Stderr,
+		"  SHIPS_MACHINE_IDENTITY  identity reported with writes: smbios, serial, cert "+
+			"or exec:PROGRAM\n")
 	os.Exit(2)
 }
 
-// cmdFetch GETs /api/v1/password/:host and prints the response.
+// cmdFetch GETs /api/v1/password/:host and prints the response, including a
+// pending version awaiting confirmation or an expired one never confirmed.
 func cmdFetch(server string, args []string) error {
 	if len(args) != 1 {
 		return errors.New("usage: shipsc fetch HOSTNAME")
@@ -109,35 +201,111 @@ func cmdFetch(server string, args []string) error {
 		Password  string    `json:"password"`
 		RotatedAt time.Time `json:"rotated_at"`
 		Actor     string    `json:"actor"`
+		Pending   *struct {
+			ID        int64     `json:"id"`
+			Password  string    `json:"password"`
+			State     string    `json:"state"`
+			ExpiresAt time.Time `json:"expires_at"`
+			Actor     string    `json:"actor"`
+		} `json:"pending"`
 	}
 	if err := httpGetJSON(url, &resp); err != nil {
 		return err
 	}
 
-	fmt.Printf("Password:   %s\n", resp.Password)
-	fmt.Printf("RotatedAt:  %s\n", resp.RotatedAt.Format(time.RFC3339))
-	fmt.Printf("Actor:      %s\n", resp.Actor)
+	if resp.Password != "" {
+		fmt.Printf("Password:   %s\n", resp.Password)
+		fmt.Printf("RotatedAt:  %s\n", resp.RotatedAt.Format(time.RFC3339))
+		fmt.Printf("Actor:      %s\n", resp.Actor)
+	} else {
+		fmt.Println("Password:   (none confirmed)")
+	}
+	if pending := resp.Pending; pending != nil && pending.State == "expired" {
+		fmt.Printf("Expired:    %s (version %d by %s, never confirmed, expired %s; "+
+			"the machine may still have it)\n", pending.Password, pending.ID, pending.Actor,
+			pending.ExpiresAt.Format(time.RFC3339))
+	} else if pending != nil {
+		fmt.Printf("Pending:    %s (version %d by %s, unconfirmed until %s)\n", pending.Password,
+			pending.ID, pending.Actor, pending.ExpiresAt.Format(time.RFC3339))
+	}
 	return nil
 }
 
-// cmdRotate POSTs a rotation payload.
+// cmdHistory GETs /api/v1/password/:host/history and prints every version.
+func cmdHistory(server string, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: shipsc history HOSTNAME")
+	}
+	host := args[0]
+	url := fmt.Sprintf("%s/api/v1/password/%s/history", server, host)
+
+	var resp struct {
+		Hostname string `json:"hostname"`
+		History  []struct {
+			ID        int64     `json:"id"`
+			Password  string    `json:"password"`
+			RotatedAt time.Time `json:"rotated_at"`
+			Actor     string    `json:"actor"`
+			State     string    `json:"state"`
+		} `json:"history"`
+	}
+	if err := httpGetJSON(url, &resp); err != nil {
+		return err
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "ID\tROTATED AT\tACTOR\tSTATE\tPASSWORD")
+	for _, version := range resp.History {
+		fmt.Fprintf(writer, "%d\t%s\t%s\t%s\t%s\n", version.ID,
+			version.RotatedAt.Format(time.RFC3339), version.Actor, version.State, version.Password)
+	}
+	return writer.Flush()
+}
+
+// cmdRotate POSTs a rotation payload. Without NEWPASSWORD the server
+// generates the password according to its policy and returns it; with
+// -generate shipsc generates it locally instead, so it never appears on a
+// command line, and -apply then sets it on a local account.
 func cmdRotate(server string, args []string) error {
 	flagSet := flag.NewFlagSet("rotate", flag.ContinueOnError)
 	actor := flagSet.String("actor", "manual", "who performed the rotation")
-	if err := flagSet.Parse(args); err != nil {
+	generate := flagSet.Bool("generate", false, "generate the password locally")
+	apply := flagSet.Bool("apply", false, "set the generated password on the local account -user")
+	user := flagSet.String("user", "", "local account to apply the password to")
+	setter := flagSet.String("setter", os.Getenv("SHIPS_PASSWORD_SETTER"),
+		"how to apply the password: chpasswd (Linux default) or exec:PROGRAM")
+	rest, err := parseArgs(flagSet, args)
+	if err != nil {
 		return err
 	}
-	rest := flagSet.Args()
-	if len(rest) != 2 {
-		return errors.New("usage: shipsc rotate HOSTNAME NEWPASSWORD [-actor name]")
+	if len(rest) < 1 || len(rest) > 2 || (*generate && len(rest) != 1) || (*apply && !*generate) {
+		return errors.New("usage: shipsc rotate HOSTNAME [NEWPASSWORD] [-actor name]\n" +
+			"       shipsc rotate HOSTNAME -generate [-apply -user ACCOUNT [-setter S]] [-actor name]")
+	}
+	if *generate {
+		return rotateGenerated(server, rest[0], *actor, *apply, *user, *setter)
 	}
-	hostname, password := rest[0], rest[1]
 
+	identity, err := machineIdentity()
+	if err != nil {
+		return err
+	}
 	payload := map[string]string{
-		"host":     hostname,
-		"password": password,
-		"actor":    *actor,
+		"host":             rest[0],
+		"actor":            *actor,
+		"machine_identity": identity,
+	}
+	if len(rest) == 1 {
+		var result struct {
+			Password string `json:"password"`
+		}
+		if err := postJSON(server+"/api/v1/rotate", payload, &result); err != nil {
+			return err
+		}
+		fmt.Printf("Password:   %s\n", result.Password)
+		return nil
 	}
+	payload["password"] = rest[1]
 	// Marshal the payload and propagate any error.
 	body, err := json.Marshal(payload)
 	if err != nil {
@@ -147,26 +315,183 @@ func cmdRotate(server string, args []string) error {
 	return httpPost(url, body)
 }
 
-// cmdBDE GETs the BitLocker key.
+// rotateGenerated escrows a locally generated password for hostname and,
+// with apply, sets it on the local account user. The escrow comes first so
+// a password is never in use without the server knowing it. When applying,
+// the password is escrowed as pending and only confirmed once the account
+// has it, so a failed apply leaves the confirmed password untouched and the
+// pending one is cancelled, or expires if even that fails.
+func rotateGenerated(server, hostname, actor string, apply bool, user, setterSpec string) error {
+	var setter passwordSetter
+	if apply {
+		if err := validateAccountName(user); err != nil {
+			return fmt.Errorf("-apply needs -user: %w", err)
+		}
+		var err error
+		if setter, err = newPasswordSetter(setterSpec); err != nil {
+			return err
+		}
+	}
+	identity, err := machineIdentity()
+	if err != nil {
+		return err
+	}
+	password, err := passgen.Generate(passgen.Default())
+	if err != nil {
+		return err
+	}
+
+	payload := map[string]any{
+		"host":             hostname,
+		"password":         password,
+		"actor":            actor,
+		"machine_identity": identity,
+	}
+	if !apply {
+		if err := postJSON(server+"/api/v1/rotate", payload, nil); err != nil {
+			return fmt.Errorf("escrowing password (nothing changed): %w", err)
+		}
+		fmt.Println(password)
+		return nil
+	}
+
+	payload["two_phase"] = true
+	var pending struct {
+		ID        int64     `json:"id"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if err := postJSON(server+"/api/v1/rotate", payload, &pending); err != nil {
+		return fmt.Errorf("escrowing password (nothing changed): %w", err)
+	}
+	if err := setter.SetPassword(user, password); err != nil {
+		if cancelErr := cancelRotation(server, pending.ID, actor); cancelErr != nil {
+			return fmt.Errorf("applying password to %s failed (%w); cancelling pending "+
+				"version %d failed too, so it stays unconfirmed and expires at %s: %v",
+				user, err, pending.ID, pending.ExpiresAt.Format(time.RFC3339), cancelErr)
+		}
+		return fmt.Errorf("applying password to %s failed; pending version %d was "+
+			"cancelled and the escrowed password is unchanged: %w", user, pending.ID, err)
+	}
+	if err := confirmRotation(server, pending.ID, actor); err != nil {
+		return fmt.Errorf("password of %s was changed but confirming version %d failed; "+
+			"run `shipsc confirm %d` before %s: %w", user, pending.ID, pending.ID,
+			pending.ExpiresAt.Format(time.RFC3339), err)
+	}
+	fmt.Printf("Password of %s rotated and escrowed for %s.\n", user, hostname)
+	return nil
+}
+
+// cmdConfirm confirms a pending password version that has been applied.
+func cmdConfirm(server string, args []string) error {
+	flagSet := flag.NewFlagSet("confirm", flag.ContinueOnError)
+	actor := flagSet.String("actor", "manual", "who confirmed the rotation")
+	rest, err := parseArgs(flagSet, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 {
+		return errors.New("usage: shipsc confirm VERSION-ID [-actor name]")
+	}
+	id, err := strconv.ParseInt(rest[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid version ID %q", rest[0])
+	}
+	if err := confirmRotation(server, id, *actor); err != nil {
+		return err
+	}
+	fmt.Printf("Password version %d confirmed.\n", id)
+	return nil
+}
+
+// confirmRotation POSTs /api/v1/rotate/:id/confirm.
+func confirmRotation(server string, id int64, actor string) error {
+	url := fmt.Sprintf("%s/api/v1/rotate/%d/confirm", server, id)
+	return postJSON(url, map[string]string{"actor": actor}, nil)
+}
+
+// cancelRotation POSTs /api/v1/rotate/:id/cancel.
+func cancelRotation(server string, id int64, actor string) error {
+	url := fmt.Sprintf("%s/api/v1/rotate/%d/cancel", server, id)
+	return postJSON(url, map[string]string{"actor": actor}, nil)
+}
+
+// cmdBDE GETs the BitLocker keys of every volume of a host.
 func cmdBDE(server string, args []string) error {
-	if len(args) != 1 {
-		return errors.New("usage: shipsc bde HOSTNAME")
+	flagSet := flag.NewFlagSet("bde", flag.ContinueOnError)
+	protector := flagSet.String("protector", "",
+		"only keys whose protector ID starts with this Key ID")
+	keyID := flagSet.String("key-id", "",
+		"find the key by the Key ID on the recovery screen, without a hostname")
+	rest, err := parseArgs(flagSet, args)
+	if err != nil {
+		return err
 	}
-	host := args[0]
+	if *keyID != "" && len(rest) == 0 {
+		return findBDEKeyByKeyID(server, *keyID)
+	}
+	if len(rest) != 1 {
+		return errors.New("usage: shipsc bde HOSTNAME [-protector KEYID] | shipsc bde -key-id KEYID")
+	}
+	host := rest[0]
 	url := fmt.Sprintf("%s/api/v1/bde/%s", server, host)
+	if *protector != "" {
+		url += "?protector=" + neturl.QueryEscape(*protector)
+	}
 
 	var resp struct {
-		Key       string    `json:"key"`
-		UpdatedAt time.Time `json:"updated_at"`
-		Actor     string    `json:"actor"`
+		Volumes []struct {
+			Volume      string    `json:"volume"`
+			ProtectorID string    `json:"protector_id"`
+			Key         string    `json:"key"`
+			UpdatedAt   time.Time `json:"updated_at"`
+			Actor       string    `json:"actor"`
+		} `json:"volumes"`
+	}
+	if err := httpGetJSON(url, &resp); err != nil {
+		return err
+	}
+
+	for i, volume := range resp.Volumes {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("Volume:     %s\n", volume.Volume)
+		fmt.Printf("KeyID:      %s\n", volume.ProtectorID)
+		fmt.Printf("Key:        %s\n", volume.Key)
+		fmt.Printf("UpdatedAt:  %s\n", volume.UpdatedAt.Format(time.RFC3339))
+		fmt.Printf("Actor:      %s\n", volume.Actor)
+	}
+	return nil
+}
+
+// findBDEKeyByKeyID GETs /api/v1/bde/by-key-id/:prefix and prints the host
+// and recovery key of every match.
+func findBDEKeyByKeyID(server, keyID string) error {
+	url := fmt.Sprintf("%s/api/v1/bde/by-key-id/%s", server, neturl.PathEscape(keyID))
+
+	var resp struct {
+		Matches []struct {
+			Hostname    string    `json:"hostname"`
+			Volume      string    `json:"volume"`
+			ProtectorID string    `json:"protector_id"`
+			Key         string    `json:"key"`
+			UpdatedAt   time.Time `json:"updated_at"`
+		} `json:"matches"`
 	}
 	if err := httpGetJSON(url, &resp); err != nil {
 		return err
 	}
 
-	fmt.Printf("Key:        %s\n", resp.Key)
-	fmt.Printf("UpdatedAt:  %s\n", resp.UpdatedAt.Format(time.RFC3339))
-	fmt.Printf("Actor:      %s\n", resp.Actor)
+	for i, match := range resp.Matches {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("Hostname:   %s\n", match.Hostname)
+		fmt.Printf("Volume:     %s\n", match.Volume)
+		fmt.Printf("KeyID:      %s\n", match.ProtectorID)
+		fmt.Printf("Key:        %s\n", match.Key)
+		fmt.Printf("UpdatedAt:  %s\n", match.UpdatedAt.Format(time.RFC3339))
+	}
 	return nil
 }
 
@@ -174,20 +499,34 @@ func cmdBDE(server string, args []string) error {
 func cmdUpdateKey(server string, args []string) error {
 	flagSet := flag.NewFlagSet("update-key", flag.ContinueOnError)
 	actor := flagSet.String("actor", "manual", "who provided the key")
-	if err := flagSet.Parse(args); err != nil {
+	volume := flagSet.String("volume", "", "mount point or volume GUID the key unlocks")
+	protectorID := flagSet.String("protector-id", "", "key protector GUID of the recovery password")
+	rest, err := parseArgs(flagSet, args)
+	if err != nil {
 		return err
 	}
-	rest := flagSet.Args()
 	if len(rest) != 2 {
-		return errors.New(
-			"usage: shipsc update-key HOSTNAME 48-DIGIT-KEY [-actor name]")
+		return errors.New("usage: shipsc update-key HOSTNAME 48-DIGIT-KEY " +
+			"[-volume C:] [-protector-id GUID] [-actor name]")
 	}
 	hostname, key := rest[0], rest[1]
+	// Catch typos before they are escrowed; the server checks again.
+	key, err = bitlocker.NormalizeRecoveryKey(key)
+	if err != nil {
+		return err
+	}
+	identity, err := machineIdentity()
+	if err != nil {
+		return err
+	}
 
 	payload := map[string]string{
-		"host":  hostname,
-		"key":   key,
-		"actor": *actor,
+		"host":             hostname,
+		"key":              key,
+		"volume":           *volume,
+		"protector_id":     *protectorID,
+		"actor":            *actor,
+		"machine_identity": identity,
 	}
 	// Marshal the payload and propagate any error.
 	body, err := json.Marshal(payload)
@@ -202,15 +541,82 @@ func cmdUpdateKey(server string, args []string) error {
 // Helpers
 //--------------------------------------------------------------------------
 
-func httpGetJSON(url string, responseStruct interface{}) error {
-	client := &http.Client{Timeout: 30 * time.Second}
+// parseArgs parses flagSet from args and returns the positional arguments.
+// Unlike flagSet.Parse it also accepts flags after positional arguments, as
+// in "shipsc rotate HOSTNAME PASSWORD -actor name".
+func parseArgs(flagSet *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flagSet.Parse(args); err != nil {
+			return nil, err
+		}
+		args = flagSet.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		if args[0] == "--" {
+			return append(positional, args[1:]...), nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// apiToken returns the API token to authenticate with: SHIPS_TOKEN, or the
+// contents of the file named by SHIPS_TOKEN_FILE. Empty means none.
+func apiToken() (string, error) {
+	if token := os.Getenv("SHIPS_TOKEN"); token != "" {
+		return token, nil
+	}
+	tokenFile := os.Getenv("SHIPS_TOKEN_FILE")
+	if tokenFile == "" {
+		return "", nil
+	}
+	data, err := os.ReadFile(tokenFile) // #nosec G304 – path comes from the operator
+	if err != nil {
+		return "", fmt.Errorf("reading API token: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// doRequest sends an API request, adding the bearer token when configured.
+func doRequest(method, url string, body []byte) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
 	// #nosec G107 – server is trusted / controlled
-	resp, err := client.Get(url)
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	token, err := apiToken()
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	client, err := newHTTPClient(os.Getenv("SHIPS_CLIENT_CERT"), os.Getenv("SHIPS_CLIENT_KEY"))
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
+
+func httpGetJSON(url string, responseStruct interface{}) error {
+	resp, err := doRequest(http.MethodGet, url, nil)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusAccepted {
+		return approvalRequiredError(resp.Body)
+	}
 	if resp.StatusCode != http.StatusOK {
 		data, readErr := io.ReadAll(resp.Body)
 		if readErr != nil {
@@ -223,10 +629,30 @@ func httpGetJSON(url string, responseStruct interface{}) error {
 	return json.NewDecoder(resp.Body).Decode(responseStruct)
 }
 
+// postJSON POSTs payload to url and decodes the reply into response unless
+// it is nil.
+func postJSON(url string, payload, response any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := doRequest(http.MethodPost, url, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		data, _ := io.ReadAll(resp.Body) // nolint:errcheck // best effort error text
+		return fmt.Errorf("server %s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	if response == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(response)
+}
+
 func httpPost(url string, body []byte) error {
-	client := &http.Client{Timeout: 30 * time.Second}
-	// #nosec G107
-	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	resp, err := doRequest(http.MethodPost, url, body)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,36 @@
+// cmd/client/rename.go
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	neturl "net/url"
+)
+
+// cmdRename POSTs /api/v1/machines/:host/rename to move a machine, with its
+// secrets and history, to a new hostname.
+func cmdRename(server string, args []string) error {
+	flagSet := flag.NewFlagSet("rename", flag.ContinueOnError)
+	actor := flagSet.String("actor", "manual", "who renames the machine")
+	rest, err := parseArgs(flagSet, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 2 {
+		return errors.New("usage: shipsc rename OLD-HOSTNAME NEW-HOSTNAME [-actor name]")
+	}
+
+	url := fmt.Sprintf("%s/api/v1/machines/%s/rename", server, neturl.PathEscape(rest[0]))
+	var renamed struct {
+		Hostname string `json:"hostname"`
+	}
+	payload := map[string]string{"new_host": rest[1], "actor": *actor}
+	if err := postJSON(url, payload, &renamed); err != nil {
+		return err
+	}
+	fmt.Printf("Renamed %s to %s; its secrets and history moved with it.\n",
+		rest[0], renamed.Hostname)
+	fmt.Println("An enrolled machine must re-enroll under its new name.")
+	return nil
+}
@@ -0,0 +1,126 @@
+// cmd/client/report.go
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	neturl "net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// exitNonCompliant is the exit status of shipsc report compliance when a
+// machine fails the report, distinct from 1 for errors and 2 for usage.
+const exitNonCompliant = 3
+
+// errNonCompliant is returned by cmdReport when a machine fails the report.
+var errNonCompliant = errors.New("non-compliant machines found")
+
+// complianceReport mirrors store.ComplianceReport.
+type complianceReport struct {
+	GeneratedAt  time.Time `json:"generated_at"`
+	MaxAge       string    `json:"max_age"`
+	Total        int       `json:"total"`
+	NonCompliant int       `json:"non_compliant"`
+	Machines     []struct {
+		Hostname      string    `json:"hostname"`
+		Compliant     bool      `json:"compliant"`
+		Issues        []string  `json:"issues"`
+		LastRotation  time.Time `json:"last_rotation"`
+		LastKeyUpdate time.Time `json:"last_key_update"`
+	} `json:"machines"`
+}
+
+// cmdReport GETs /api/v1/reports/compliance and prints the machines failing
+// it, or every machine with -all. It returns errNonCompliant when any
+// machine fails, so monitoring scripts can rely on the exit status.
+func cmdReport(server string, args []string) error {
+	if len(args) == 0 || args[0] != "compliance" {
+		return errors.New("usage: shipsc report compliance [-max-age 30d] [-all] " +
+			"[-format table|csv|json]")
+	}
+	flagSet := flag.NewFlagSet("report compliance", flag.ContinueOnError)
+	maxAge := flagSet.String("max-age", "30d", "oldest acceptable password rotation, "+
+		"e.g. 30d or 720h")
+	all := flagSet.Bool("all", false, "list compliant machines too")
+	format := flagSet.String("format", "table", "output format: table, csv or json")
+	rest, err := parseArgs(flagSet, args[1:])
+	if err != nil {
+		return err
+	}
+	if len(rest) != 0 {
+		return errors.New("usage: shipsc report compliance [-max-age 30d] [-all] " +
+			"[-format table|csv|json]")
+	}
+	if *format != "table" && *format != "csv" && *format != "json" {
+		return fmt.Errorf("unknown format %q: use table, csv or json", *format)
+	}
+
+	url := server + "/api/v1/reports/compliance?" + neturl.Values{"max_age": {*maxAge}}.Encode()
+	var report complianceReport
+	if err := httpGetJSON(url, &report); err != nil {
+		return err
+	}
+
+	switch *format {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			return err
+		}
+	case "csv":
+		writer := csv.NewWriter(os.Stdout)
+		if err := writer.Write([]string{"hostname", "compliant", "issues",
+			"last_rotation", "last_key_update"}); err != nil {
+			return err
+		}
+		for _, machine := range report.Machines {
+			if machine.Compliant && !*all {
+				continue
+			}
+			if err := writer.Write([]string{machine.Hostname,
+				strconv.FormatBool(machine.Compliant), strings.Join(machine.Issues, ";"),
+				formatInventoryTime(machine.LastRotation, ""),
+				formatInventoryTime(machine.LastKeyUpdate, "")}); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			return err
+		}
+	default:
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "HOST\tSTATUS\tISSUES\tLAST ROTATION\tLAST KEY UPDATE")
+		for _, machine := range report.Machines {
+			if machine.Compliant && !*all {
+				continue
+			}
+			status := "FAIL"
+			if machine.Compliant {
+				status = "OK"
+			}
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", machine.Hostname, status,
+				strings.Join(machine.Issues, ", "),
+				formatInventoryTime(machine.LastRotation, "never"),
+				formatInventoryTime(machine.LastKeyUpdate, "never"))
+		}
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+		fmt.Printf("%d of %d machines non-compliant (max age %s)\n",
+			report.NonCompliant, report.Total, report.MaxAge)
+	}
+
+	if report.NonCompliant > 0 {
+		return errNonCompliant
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+// cmd/client/setter.go
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// passwordSetter changes the password of a local account. shipsc rotate
+// -apply uses one after the new password has been escrowed.
+type passwordSetter interface {
+	SetPassword(user, password string) error
+}
+
+// chpasswdSetter sets passwords with chpasswd(8), which reads "user:password"
+// lines on standard input so the password never appears in argv.
+type chpasswdSetter struct {
+	path string
+}
+
+func (setter chpasswdSetter) SetPassword(user, password string) error {
+	return runSetter(exec.Command(setter.path), user+":"+password+"\n")
+}
+
+// execSetter runs a site-specific program with the account name as its only
+// argument and the password on standard input.
+type execSetter struct {
+	program string
+}
+
+func (setter execSetter) SetPassword(user, password string) error {
+	return runSetter(exec.Command(setter.program, user), password+"\n") // #nosec G204 – program comes from the operator
+}
+
+// runSetter runs command with input on stdin and folds its output into the
+// error on failure.
+func runSetter(command *exec.Cmd, input string) error {
+	command.Stdin = strings.NewReader(input)
+	var output bytes.Buffer
+	command.Stdout = &output
+	command.Stderr = &output
+	if err := command.Run(); err != nil {
+		if message := strings.TrimSpace(output.String()); message != "" {
+			return fmt.Errorf("%s: %w: %s", command.Path, err, message)
+		}
+		return fmt.Errorf("%s: %w", command.Path, err)
+	}
+	return nil
+}
+
+// newPasswordSetter returns the setter named by spec: "chpasswd", the
+// default on Linux, or "exec:PROGRAM" for any other mechanism.
+func newPasswordSetter(spec string) (passwordSetter, error) {
+	if spec == "" {
+		if runtime.GOOS != "linux" {
+			return nil, fmt.Errorf("no default password setter on %s; use -setter exec:PROGRAM",
+				runtime.GOOS)
+		}
+		spec = "chpasswd"
+	}
+	if program, ok := strings.CutPrefix(spec, "exec:"); ok {
+		if program == "" {
+			return nil, errors.New("-setter exec: needs a program")
+		}
+		return execSetter{program: program}, nil
+	}
+	if spec != "chpasswd" {
+		return nil, fmt.Errorf("unknown password setter %q (want chpasswd or exec:PROGRAM)", spec)
+	}
+	path, err := exec.LookPath("chpasswd")
+	if err != nil {
+		return nil, fmt.Errorf("chpasswd not found: %w", err)
+	}
+	return chpasswdSetter{path: path}, nil
+}
+
+// validateAccountName rejects names chpasswd would misparse.
+func validateAccountName(user string) error {
+	if user == "" || strings.ContainsAny(user, ":\n\r") || strings.TrimSpace(user) != user {
+		return fmt.Errorf("invalid account name %q", user)
+	}
+	return nil
+}
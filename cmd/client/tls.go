@@ -0,0 +1,54 @@
+// cmd/client/tls.go
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// newHTTPClient returns the client used for API calls. For https:// servers
+// SHIPS_CA_CERT adds a CA bundle to trust (e.g. the server's own CA), and
+// certFile / keyFile (normally SHIPS_CLIENT_CERT / SHIPS_CLIENT_KEY) present
+// a client certificate for mutual TLS.
+func newHTTPClient(certFile, keyFile string) (*http.Client, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	caFile := os.Getenv("SHIPS_CA_CERT")
+	if caFile == "" && certFile == "" && keyFile == "" {
+		return client, nil
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile) // #nosec G304 – path comes from the operator
+		if err != nil {
+			return nil, fmt.Errorf("reading CA certificate: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("SHIPS_CA_CERT contains no PEM certificates")
+		}
+		config.RootCAs = pool
+	}
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, errors.New("client certificate and key must be given together")
+		}
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	client.Transport = transport
+	return client, nil
+}
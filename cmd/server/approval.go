@@ -0,0 +1,111 @@
+// cmd/server/approval.go
+package main
+
+import (
+    "context"
+    "errors"
+    "flag"
+    "fmt"
+    "os"
+    "strconv"
+    "text/tabwriter"
+    "time"
+
+    "github.com/jottavia/SHIPS2-Go/internal/store"
+)
+
+const approvalUsage = `usage:
+  ships-server approval require [-db path] [-actor name] [-secret all] PATTERN
+  ships-server approval remove [-db path] [-actor name] PATTERN
+  ships-server approval list [-db path]
+PATTERN is a hostname or glob such as EXEC-*; secrets: password, bde, all`
+
+// cmdApproval manages which machines need two-person approval before their
+// secrets are released.
+func cmdApproval(args []string) error {
+    if len(args) == 0 {
+        return errors.New(approvalUsage)
+    }
+    flagSet := flag.NewFlagSet("approval "+args[0], flag.ContinueOnError)
+    dbPath := flagSet.String("db", getDBPath(), "SQLite database path")
+    actor := flagSet.String("actor", defaultAdminActor(), "who changed the policy")
+    secret := flagSet.String("secret", store.SecretAll, "password, bde or all")
+    if err := flagSet.Parse(args[1:]); err != nil {
+        return err
+    }
+
+    var wantArgs int
+    switch args[0] {
+    case "require", "remove":
+        wantArgs = 1
+    case "list":
+        wantArgs = 0
+    default:
+        return fmt.Errorf("unknown approval command %q\n%s", args[0], approvalUsage)
+    }
+    if flagSet.NArg() != wantArgs {
+        return errors.New(approvalUsage)
+    }
+
+    st, err := store.New(*dbPath)
+    if err != nil {
+        return fmt.Errorf("opening db: %w", err)
+    }
+    defer st.Close()
+    ctx := context.Background()
+
+    switch args[0] {
+    case "require":
+        if err := st.SetApprovalPolicy(ctx, flagSet.Arg(0), *secret, *actor); err != nil {
+            return err
+        }
+        fmt.Printf("Retrieving %s of %s now needs a second person's approval.\n",
+            *secret, flagSet.Arg(0))
+    case "remove":
+        if err := st.RemoveApprovalPolicy(ctx, flagSet.Arg(0), *actor); err != nil {
+            return err
+        }
+        fmt.Printf("Removed the approval policy for %s.\n", flagSet.Arg(0))
+    case "list":
+        policies, err := st.ListApprovalPolicies(ctx)
+        if err != nil {
+            return err
+        }
+        writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+        fmt.Fprintln(writer, "PATTERN\tSECRET\tCREATED\tCREATED BY")
+        for _, policy := range policies {
+            fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", policy.Pattern, policy.Secret,
+                formatTime(policy.CreatedAt), policy.CreatedBy)
+        }
+        return writer.Flush()
+    }
+    return nil
+}
+
+// approvalSettingsFromEnv reads SHIPS_APPROVAL_TTL, SHIPS_APPROVAL_WINDOW,
+// SHIPS_APPROVAL_BULK_THRESHOLD and SHIPS_APPROVAL_BULK_PERIOD over the
+// defaults.
+func approvalSettingsFromEnv() (store.ApprovalSettings, error) {
+    settings := store.DefaultApprovalSettings()
+    for name, target := range map[string]*time.Duration{
+        "SHIPS_APPROVAL_TTL":         &settings.RequestTTL,
+        "SHIPS_APPROVAL_WINDOW":      &settings.Window,
+        "SHIPS_APPROVAL_BULK_PERIOD": &settings.BulkPeriod,
+    } {
+        value, err := parseLifetime(os.Getenv(name))
+        if err != nil {
+            return settings, fmt.Errorf("%s: %w", name, err)
+        }
+        if value != 0 {
+            *target = value
+        }
+    }
+    if value := os.Getenv("SHIPS_APPROVAL_BULK_THRESHOLD"); value != "" {
+        threshold, err := strconv.Atoi(value)
+        if err != nil || threshold < 0 {
+            return settings, fmt.Errorf("SHIPS_APPROVAL_BULK_THRESHOLD: invalid count %q", value)
+        }
+        settings.BulkThreshold = threshold
+    }
+    return settings, nil
+}
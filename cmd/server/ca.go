@@ -0,0 +1,112 @@
+// cmd/server/ca.go
+package main
+
+import (
+    "context"
+    "errors"
+    "flag"
+    "fmt"
+    "os"
+    "text/tabwriter"
+    "time"
+
+    "github.com/jottavia/SHIPS2-Go/internal/store"
+)
+
+const caUsage = `usage:
+  ships-server ca init [-name CN] [-out FILE] [-db path] [-actor name]
+  ships-server ca cert [-out FILE] [-db path]
+  ships-server ca list [-db path]
+  ships-server ca revoke [-reason TEXT] [-db path] [-actor name] SERIAL`
+
+// cmdCA manages the built-in certificate authority that signs machine
+// client certificates requested with `shipsc enroll -cert`.
+func cmdCA(args []string) error {
+    if len(args) == 0 {
+        return errors.New(caUsage)
+    }
+    flagSet := flag.NewFlagSet("ca "+args[0], flag.ContinueOnError)
+    dbPath := flagSet.String("db", getDBPath(), "SQLite database path")
+    name := flagSet.String("name", "", "common name of the CA (default \"SHIPS2-Go machine CA\")")
+    out := flagSet.String("out", "", "also write the CA certificate to this file")
+    reason := flagSet.String("reason", "", "why the certificate is revoked")
+    actor := flagSet.String("actor", defaultAdminActor(), "who performed the change")
+    if err := flagSet.Parse(args[1:]); err != nil {
+        return err
+    }
+    wantArgs := 0
+    if args[0] == "revoke" {
+        wantArgs = 1
+    }
+    if flagSet.NArg() != wantArgs {
+        return errors.New(caUsage)
+    }
+
+    st, err := store.New(*dbPath)
+    if err != nil {
+        return fmt.Errorf("opening db: %w", err)
+    }
+    defer st.Close()
+    ctx := context.Background()
+
+    switch args[0] {
+    case "init":
+        certificate, err := st.InitCA(ctx, *name, *actor)
+        if err != nil {
+            return err
+        }
+        if err := writeCACertificate(*out, certificate); err != nil {
+            return err
+        }
+        fmt.Println("Certificate authority created. Restart ships-server to trust it;")
+        fmt.Println("machines enroll with: shipsc enroll -token TOKEN -cert FILE -key FILE")
+        return nil
+    case "cert":
+        certificate, err := st.CACertificate(ctx)
+        if err != nil {
+            return err
+        }
+        return writeCACertificate(*out, certificate)
+    case "list":
+        certificates, err := st.ListCertificates(ctx)
+        if err != nil {
+            return err
+        }
+        now := time.Now()
+        writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+        fmt.Fprintln(writer, "SERIAL\tHOST\tSTATUS\tISSUED\tEXPIRES\tISSUED BY")
+        for _, certificate := range certificates {
+            status := "valid"
+            if !certificate.RevokedAt.IsZero() {
+                status = "revoked (" + certificate.RevokeReason + ")"
+            } else if !now.Before(certificate.NotAfter) {
+                status = "expired"
+            }
+            fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\n", certificate.Serial,
+                certificate.Hostname, status, formatTime(certificate.IssuedAt),
+                formatTime(certificate.NotAfter), certificate.IssuedBy)
+        }
+        return writer.Flush()
+    case "revoke":
+        if err := st.RevokeCertificate(ctx, flagSet.Arg(0), *reason, *actor); err != nil {
+            return err
+        }
+        fmt.Printf("Certificate %s revoked\n", flagSet.Arg(0))
+        return nil
+    default:
+        return fmt.Errorf("unknown ca command %q\n%s", args[0], caUsage)
+    }
+}
+
+// writeCACertificate prints certificate, or writes it to path when set.
+func writeCACertificate(path, certificate string) error {
+    if path == "" {
+        fmt.Print(certificate)
+        return nil
+    }
+    if err := os.WriteFile(path, []byte(certificate), 0o644); err != nil { // #nosec G306 – public certificate
+        return err
+    }
+    fmt.Printf("CA certificate written to %s\n", path)
+    return nil
+}
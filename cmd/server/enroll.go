@@ -0,0 +1,95 @@
+// cmd/server/enroll.go
+package main
+
+import (
+    "context"
+    "errors"
+    "flag"
+    "fmt"
+    "os"
+    "text/tabwriter"
+    "time"
+
+    "github.com/jottavia/SHIPS2-Go/internal/store"
+)
+
+const enrollTokenUsage = `usage:
+  ships-server enroll-token create [-hostname NAME] [-expires 24h] [-db path] [-actor name]
+  ships-server enroll-token list [-db path]`
+
+// cmdEnrollToken creates and lists the one-time tokens machines redeem with
+// `shipsc enroll` for a credential of their own.
+func cmdEnrollToken(args []string) error {
+    if len(args) == 0 {
+        return errors.New(enrollTokenUsage)
+    }
+    flagSet := flag.NewFlagSet("enroll-token "+args[0], flag.ContinueOnError)
+    dbPath := flagSet.String("db", getDBPath(), "SQLite database path")
+    hostname := flagSet.String("hostname", "",
+        "only this machine may use the token (required to re-enroll a machine)")
+    expires := flagSet.String("expires", "24h", "how long the token stays usable, e.g. 24h or 7d")
+    actor := flagSet.String("actor", defaultAdminActor(), "who created the token")
+    if err := flagSet.Parse(args[1:]); err != nil {
+        return err
+    }
+    if flagSet.NArg() != 0 {
+        return errors.New(enrollTokenUsage)
+    }
+
+    switch args[0] {
+    case "create":
+        ttl, err := parseLifetime(*expires)
+        if err != nil {
+            return fmt.Errorf("-expires: %w", err)
+        }
+        st, err := store.New(*dbPath)
+        if err != nil {
+            return fmt.Errorf("opening db: %w", err)
+        }
+        defer st.Close()
+
+        token, info, err := st.CreateEnrollmentToken(context.Background(), *hostname, ttl, *actor)
+        if err != nil {
+            return err
+        }
+        target := "any new machine"
+        if info.Hostname != "" {
+            target = info.Hostname
+        }
+        fmt.Printf("Enrollment token %d for %s, valid until %s:\n\n%s\n\n",
+            info.ID, target, info.ExpiresAt.Format(time.RFC3339), token)
+        fmt.Println("On the machine run: shipsc enroll -token TOKEN")
+        return nil
+    case "list":
+        st, err := store.New(*dbPath)
+        if err != nil {
+            return fmt.Errorf("opening db: %w", err)
+        }
+        defer st.Close()
+
+        tokens, err := st.ListEnrollmentTokens(context.Background())
+        if err != nil {
+            return err
+        }
+        now := time.Now()
+        writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+        fmt.Fprintln(writer, "ID\tFOR HOST\tSTATUS\tCREATED BY\tEXPIRES\tUSED BY")
+        for _, token := range tokens {
+            status := "unused"
+            if !token.UsedAt.IsZero() {
+                status = "used " + formatTime(token.UsedAt)
+            } else if !now.Before(token.ExpiresAt) {
+                status = "expired"
+            }
+            forHost := token.Hostname
+            if forHost == "" {
+                forHost = "*"
+            }
+            fmt.Fprintf(writer, "%d\t%s\t%s\t%s\t%s\t%s\n", token.ID, forHost, status,
+                token.CreatedBy, formatTime(token.ExpiresAt), token.UsedBy)
+        }
+        return writer.Flush()
+    default:
+        return fmt.Errorf("unknown enroll-token command %q\n%s", args[0], enrollTokenUsage)
+    }
+}
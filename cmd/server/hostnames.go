@@ -0,0 +1,90 @@
+// cmd/server/hostnames.go
+package main
+
+import (
+    "context"
+    "errors"
+    "flag"
+    "fmt"
+
+    "github.com/jottavia/SHIPS2-Go/internal/store"
+)
+
+const hostnamesUsage = `usage:
+  ships-server hostnames status [-db path]
+  ships-server hostnames migrate [-db path] [-actor name] [-dry-run]`
+
+// cmdHostnames shows the hostname policy recorded in the database and
+// migrates the machines to the one SHIPS_HOSTNAME_* configure. Migrating is
+// the only way the recorded policy changes.
+func cmdHostnames(args []string) error {
+    if len(args) == 0 {
+        return errors.New(hostnamesUsage)
+    }
+    flagSet := flag.NewFlagSet("hostnames "+args[0], flag.ContinueOnError)
+    dbPath := flagSet.String("db", getDBPath(), "SQLite database path")
+    actor := flagSet.String("actor", defaultAdminActor(), "who migrated the hostnames")
+    dryRun := flagSet.Bool("dry-run", false, "only show what migrate would change")
+    if err := flagSet.Parse(args[1:]); err != nil {
+        return err
+    }
+    if flagSet.NArg() != 0 {
+        return errors.New(hostnamesUsage)
+    }
+    if args[0] != "status" && args[0] != "migrate" {
+        return fmt.Errorf("unknown hostnames command %q\n%s", args[0], hostnamesUsage)
+    }
+
+    st, err := store.New(*dbPath)
+    if err != nil {
+        return fmt.Errorf("opening db: %w", err)
+    }
+    defer st.Close()
+    ctx := context.Background()
+
+    status, err := st.HostnameStatus(ctx)
+    if err != nil {
+        return err
+    }
+    if args[0] == "status" || *dryRun {
+        if status.Recorded {
+            fmt.Printf("Recorded policy:   %s\n", status.Policy)
+        } else {
+            fmt.Println("Recorded policy:   (none; machines not migrated yet)")
+        }
+        fmt.Printf("Configured policy: %s\n", status.Configured)
+        if len(status.Pending) == 0 && status.Recorded && status.Policy == status.Configured {
+            fmt.Println("Nothing to migrate.")
+            return nil
+        }
+        printHostnameChanges(status.Pending)
+        if args[0] == "status" {
+            fmt.Println("Run `ships-server hostnames migrate` to apply the configured policy.")
+        }
+        return nil
+    }
+
+    changes, err := st.MigrateHostnames(ctx, status.Configured, *actor)
+    if err != nil {
+        return fmt.Errorf("migration failed, database unchanged: %w", err)
+    }
+    printHostnameChanges(changes)
+    fmt.Printf("Hostnames migrated to %s.\n", status.Configured)
+    if status.Policy != status.Configured {
+        if pid, err := store.ServerPID(*dbPath); err == nil && pid != 0 {
+            fmt.Printf("Restart ships-server (pid %d) so it uses the new policy.\n", pid)
+        }
+    }
+    return nil
+}
+
+// printHostnameChanges lists the renames and merges of a migration.
+func printHostnameChanges(changes []store.HostnameChange) {
+    for _, change := range changes {
+        if change.Merge {
+            fmt.Printf("  merge   %s into %s\n", change.From, change.To)
+        } else {
+            fmt.Printf("  rename  %s to %s\n", change.From, change.To)
+        }
+    }
+}
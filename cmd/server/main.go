@@ -3,6 +3,8 @@ package main
 import (
     "context"
     "crypto/subtle"
+    "crypto/tls"
+    "fmt"
     "log"
     "net/http"
     "os"
@@ -12,7 +14,9 @@ import (
 
     "github.com/gin-gonic/gin"
     "github.com/jottavia/SHIPS2-Go/internal/api"
+    "github.com/jottavia/SHIPS2-Go/internal/passgen"
     "github.com/jottavia/SHIPS2-Go/internal/store"
+    "github.com/jottavia/SHIPS2-Go/internal/syslog"
 )
 
 var version = "1.0.0" // SHIPS2-Go v1.0.0 Production Release
@@ -52,15 +56,118 @@ func loggingMiddleware() gin.HandlerFunc {
     return gin.Logger()
 }
 
+// getDBPath returns the SQLite database path from the environment or default.
+func getDBPath() string {
+    dbPath := os.Getenv("SHIPS_DB")
+    if dbPath == "" {
+        dbPath = "/var/lib/ships/ships.db" // sensible default
+    }
+    return dbPath
+}
+
 func main() {
+    // Administrative subcommands run once against the database and exit.
+    if len(os.Args) > 1 {
+        if err := dispatchCommand(os.Args[1], os.Args[2:]); err != nil {
+            fmt.Fprintf(os.Stderr, "error: %v\n", err)
+            os.Exit(1)
+        }
+        return
+    }
+    serve()
+}
+
+// dispatchCommand routes administrative subcommands.
+func dispatchCommand(command string, args []string) error {
+    switch command {
+    case "serve":
+        serve()
+        return nil
+    case "rekey":
+        return cmdRekey(args)
+    case "verify-audit":
+        return cmdVerifyAudit(args)
+    case "token":
+        return cmdToken(args)
+    case "role":
+        return cmdRole(args)
+    case "enroll-token":
+        return cmdEnrollToken(args)
+    case "ca":
+        return cmdCA(args)
+    case "approval":
+        return cmdApproval(args)
+    case "hostnames":
+        return cmdHostnames(args)
+    case "version", "--version", "-v":
+        fmt.Printf("ships-server %s\n", version)
+        return nil
+    case "help", "-h", "--help":
+        usageAndExit("")
+        return nil
+    default:
+        usageAndExit("unknown command: %s", command)
+        return nil
+    }
+}
+
+func usageAndExit(format string, arguments ...interface{}) {
+    if format != "" {
+        fmt.Fprintf(os.Stderr, format+"\n\n", arguments...)
+    }
+    fmt.Fprintf(os.Stderr, "SHIPS2-Go server usage:\n")
+    fmt.Fprintf(os.Stderr, "  ships-server [serve]\n")
+    fmt.Fprintf(os.Stderr,
+        "  ships-server rekey -old-key-file FILE -new-key-file FILE [-actor name]\n")
+    fmt.Fprintf(os.Stderr, "  ships-server verify-audit [-db path]\n")
+    fmt.Fprintf(os.Stderr, "  ships-server token issue -name NAME [-role ROLE] [-expires 90d]\n")
+    fmt.Fprintf(os.Stderr, "  ships-server token list | token revoke ID\n")
+    fmt.Fprintf(os.Stderr, "  ships-server role assign NAME ROLE | role remove NAME | role list\n")
+    fmt.Fprintf(os.Stderr, "  ships-server enroll-token create [-hostname NAME] [-expires 24h] | list\n")
+    fmt.Fprintf(os.Stderr, "  ships-server ca init [-out FILE] | ca cert | ca list | ca revoke SERIAL\n")
+    fmt.Fprintf(os.Stderr,
+        "  ships-server approval require [-secret all] PATTERN | approval remove PATTERN | list\n")
+    fmt.Fprintf(os.Stderr, "  ships-server hostnames status | hostnames migrate [-dry-run]\n")
+    fmt.Fprintf(os.Stderr, "  ships-server version\n")
+    os.Exit(2)
+}
+
+// expireStale marks unconfirmed two-phase rotations and lapsed password
+// checkouts expired, and purges the secrets of machines decommissioned
+// longer than their retention, every interval for as long as the server
+// runs.
+func expireStale(st *store.Store, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for now := range ticker.C {
+        expired, err := st.ExpirePendingRotations(context.Background(), now)
+        if err != nil {
+            log.Printf("expiring pending rotations: %v", err)
+        } else if expired > 0 {
+            log.Printf("expired %d unconfirmed pending rotation(s)", expired)
+        }
+        expired, err = st.ExpireLeases(context.Background(), now)
+        if err != nil {
+            log.Printf("expiring password checkouts: %v", err)
+        } else if expired > 0 {
+            log.Printf("expired %d password checkout(s); rotation required", expired)
+        }
+        purged, err := st.PurgeRetiredMachines(context.Background(), now)
+        if err != nil {
+            log.Printf("purging decommissioned machines: %v", err)
+        } else if purged > 0 {
+            log.Printf("purged the secrets of %d decommissioned machine(s)", purged)
+        }
+    }
+}
+
+// serve runs the HTTP API until SIGINT / SIGTERM.
+func serve() {
     // Run in release mode to avoid debug logging.
     gin.SetMode(gin.ReleaseMode)
 
     // --- Configuration via environment variables ---------------------------
-    dbPath := os.Getenv("SHIPS_DB")
-    if dbPath == "" {
-        dbPath = "/var/lib/ships/ships.db" // sensible default
-    }
+    dbPath := getDBPath()
 
     addr := os.Getenv("SHIPS_ADDR")
     if addr == "" {
@@ -68,43 +175,205 @@ func main() {
         addr = "127.0.0.1:8080"
     }
 
-    // Optional HTTP Basic Auth
+    // SHIPS_AUTH=token requires per-user API tokens on /api/v1. The shared
+    // HTTP Basic Auth account is only used when tokens are not enabled.
+    // Unset, role-restricted routes still need a token or certificate unless
+    // SHIPS_AUTH=anonymous opts out of that.
+    authMode := os.Getenv("SHIPS_AUTH")
+    if authMode != "" && authMode != "token" && authMode != "anonymous" {
+        log.Fatalf("SHIPS_AUTH must be \"token\", \"anonymous\" or unset, got %q", authMode)
+    }
     authUser := os.Getenv("SHIPS_AUTH_USER")
     authPass := os.Getenv("SHIPS_AUTH_PASS")
+    if authMode == "token" {
+        authUser, authPass = "", ""
+    }
+
+    // --- Open the SQLite store -------------------------------------------
+    st, err := store.New(dbPath)
+    if err != nil {
+        log.Fatalf("opening db: %v", err)
+    }
+    defer st.Close()
+
+    // `ships-server rekey` signals the process named in this file to reload
+    // the master key; a second server on the same database is refused.
+    removePIDFile, err := store.WritePIDFile(dbPath)
+    if err != nil {
+        log.Fatalf("writing pid file: %v", err)
+    }
+    defer removePIDFile()
+
+    // Policy for passwords the server generates when a rotation omits one.
+    passwordPolicy, err := passgen.FromEnv()
+    if err != nil {
+        log.Fatalf("configuring password policy: %v", err)
+    }
+
+    // How long a two-phase rotation may stay unconfirmed (SHIPS_PENDING_TTL).
+    pendingTTL, err := parseLifetime(os.Getenv("SHIPS_PENDING_TTL"))
+    if err != nil {
+        log.Fatalf("SHIPS_PENDING_TTL: %v", err)
+    }
+    if pendingTTL == 0 {
+        pendingTTL = api.DefaultPendingRotationTTL
+    }
+
+    // How long a fetched password may stay in use before the machine is
+    // reported as overdue for rotation (SHIPS_ROTATION_GRACE).
+    rotationGrace, err := parseLifetime(os.Getenv("SHIPS_ROTATION_GRACE"))
+    if err != nil {
+        log.Fatalf("SHIPS_ROTATION_GRACE: %v", err)
+    }
+    if rotationGrace == 0 {
+        rotationGrace = api.DefaultRotationGrace
+    }
+
+    // How long a decommissioned machine's secrets are kept (SHIPS_RETENTION).
+    retention, err := parseLifetime(os.Getenv("SHIPS_RETENTION"))
+    if err != nil {
+        log.Fatalf("SHIPS_RETENTION: %v", err)
+    }
+    if retention == 0 {
+        retention = api.DefaultRetention
+    }
+
+    // What to do when a hostname arrives with another machine identity
+    // (SHIPS_IDENTITY_MISMATCH): reject and alert, or start a new generation.
+    identityMismatch := os.Getenv("SHIPS_IDENTITY_MISMATCH")
+    if identityMismatch == "" {
+        identityMismatch = store.IdentityReject
+    }
+    if err := (store.IdentitySettings{OnMismatch: identityMismatch}).Validate(); err != nil {
+        log.Fatalf("SHIPS_IDENTITY_MISMATCH: %v", err)
+    }
+
+    // Two-person approval timing and bulk limit (SHIPS_APPROVAL_*).
+    approvalSettings, err := approvalSettingsFromEnv()
+    if err != nil {
+        log.Fatalf("configuring approvals: %v", err)
+    }
+
+    // Optional built-in TLS, with client certificates as identities. The
+    // store supplies the built-in CA and its revocations.
+    tlsConfig, certificates, err := loadTLSConfig(st)
+    if err != nil {
+        log.Fatalf("configuring TLS: %v", err)
+    }
 
     log.Printf("SHIPS2-Go server v%s starting", version)
     log.Printf("Database: %s", dbPath)
     log.Printf("Address: %s", addr)
-    if authUser != "" {
-        log.Printf("HTTP Basic Auth: enabled (user: %s)", authUser)
-    } else {
+    switch {
+    case authMode == "token":
+        log.Printf("API tokens: required (issue with `ships-server token issue`)")
+        if os.Getenv("SHIPS_AUTH_USER") != "" {
+            log.Printf("HTTP Basic Auth: ignored because SHIPS_AUTH=token")
+        }
+    case authUser != "":
+        log.Printf("HTTP Basic Auth: enabled (user: %s); consider SHIPS_AUTH=token", authUser)
+    default:
         log.Printf("HTTP Basic Auth: disabled (set SHIPS_AUTH_USER/SHIPS_AUTH_PASS to enable)")
     }
+    if authMode == "anonymous" {
+        log.Printf("WARNING: SHIPS_AUTH=anonymous lets callers without a token or client " +
+            "certificate past every role check, including admin routes")
+    }
 
-    // --- Open the SQLite store -------------------------------------------
-    st, err := store.New(dbPath)
+    switch {
+    case tlsConfig == nil:
+        log.Printf("TLS: disabled (set SHIPS_TLS_CERT/SHIPS_TLS_KEY to enable)")
+    case tlsConfig.ClientAuth == tls.RequireAndVerifyClientCert:
+        log.Printf("TLS: enabled, client certificate required")
+    case tlsConfig.ClientCAs != nil:
+        log.Printf("TLS: enabled, client certificates verified when presented")
+    default:
+        log.Printf("TLS: enabled")
+    }
+
+    log.Printf("Generated passwords: %s", passwordPolicy)
+    log.Printf("Pending rotations: expire after %s unconfirmed", pendingTTL)
+    log.Printf("Fetched passwords: overdue for rotation after %s", rotationGrace)
+    log.Printf("Decommissioned machines: secrets purged after %s", retention)
+    log.Printf("Hostnames: %s", st.HostnamePolicy())
+    if identityMismatch == store.IdentityNewGeneration {
+        log.Printf("Machine identity changes: start a new machine generation")
+    } else {
+        log.Printf("Machine identity changes: rejected and audited as identity_mismatch")
+    }
+    hostnameStatus, err := st.HostnameStatus(context.Background())
     if err != nil {
-        log.Fatalf("opening db: %v", err)
+        log.Fatalf("checking hostnames: %v", err)
+    }
+    switch {
+    case !hostnameStatus.Recorded && len(hostnameStatus.Pending) > 0:
+        log.Printf("WARNING: %d machine(s) stored under another form of their hostname, "+
+            "e.g. %s; merge or rename them with `ships-server hostnames migrate`",
+            len(hostnameStatus.Pending), hostnameStatus.Pending[0].From)
+    case hostnameStatus.Configured != hostnameStatus.Policy:
+        log.Printf("WARNING: SHIPS_HOSTNAME_* ask for %s but the database was migrated to %s, "+
+            "which stays in effect; switch with `ships-server hostnames migrate`",
+            hostnameStatus.Configured, hostnameStatus.Policy)
+    }
+    names, err := st.NonCanonicalHostnames(context.Background())
+    if err != nil {
+        log.Fatalf("checking hostnames: %v", err)
+    }
+    var invalid []string
+    for _, name := range names {
+        if _, err := st.CanonicalHostname(name); err != nil {
+            invalid = append(invalid, name)
+        }
+    }
+    if len(invalid) > 0 {
+        log.Printf("WARNING: %d machine(s) stored under an invalid hostname, e.g. %s; "+
+            "rename them with `shipsc rename`", len(invalid), invalid[0])
+    }
+    if approvalSettings.BulkThreshold > 0 {
+        log.Printf("Approvals: required after %d retrievals within %s, usable for %s",
+            approvalSettings.BulkThreshold, approvalSettings.BulkPeriod, approvalSettings.Window)
+    } else {
+        log.Printf("Approvals: by policy only (`ships-server approval`), usable for %s",
+            approvalSettings.Window)
+    }
+
+    // --- Optional syslog forwarding of audit events (SHIPS_SYSLOG) -------
+    forwarder, err := syslog.FromEnv()
+    if err != nil {
+        log.Fatalf("configuring syslog: %v", err)
+    }
+    if forwarder != nil {
+        st.SetAuditHook(forwarder.Audit)
+        defer func() {
+            if err := forwarder.Close(5 * time.Second); err != nil {
+                log.Printf("closing syslog forwarder: %v", err)
+            }
+        }()
+        log.Printf("Syslog: forwarding audit events to %s", os.Getenv("SHIPS_SYSLOG"))
+    } else {
+        log.Printf("Syslog: disabled (set SHIPS_SYSLOG to forward audit events)")
     }
-    defer st.Close()
 
     // --- Build Gin router --------------------------------------------------
     r := gin.New()
     r.Use(gin.Recovery())
     r.Use(loggingMiddleware())
 
-    // Add optional basic auth middleware
+    // Add optional basic auth middleware; enrollment is exempt because
+    // machines enroll before they hold any credential.
+    var basicAuth []gin.HandlerFunc
     if authUser != "" && authPass != "" {
-        r.Use(basicAuthMiddleware(authUser, authPass))
+        basicAuth = append(basicAuth, basicAuthMiddleware(authUser, authPass))
     }
+    protected := r.Group("/", basicAuth...)
 
     // Liveness probe for systemd / Kubernetes or simple curl checks.
-    r.GET("/healthz", func(c *gin.Context) {
+    protected.GET("/healthz", func(c *gin.Context) {
         c.String(http.StatusOK, "ok")
     })
 
     // Version endpoint
-    r.GET("/version", func(c *gin.Context) {
+    protected.GET("/version", func(c *gin.Context) {
         c.JSON(http.StatusOK, gin.H{
             "version": version,
             "service": "SHIPS2-Go",
@@ -113,7 +382,20 @@ func main() {
     })
 
     // Register the version‑1 API under /api/v1/…
-    api.New(st).Register(r)
+    apiInstance := api.New(st).
+        WithPasswordPolicy(passwordPolicy).
+        WithPendingRotationTTL(pendingTTL).
+        WithRotationGrace(rotationGrace).
+        WithRetention(retention).
+        WithApprovalSettings(approvalSettings).
+        WithIdentityMismatch(identityMismatch)
+    switch authMode {
+    case "token":
+        apiInstance.RequireTokens()
+    case "anonymous":
+        apiInstance.AllowAnonymous()
+    }
+    apiInstance.Register(r, basicAuth...)
 
     srv := &http.Server{
         Addr:         addr,
@@ -121,16 +403,50 @@ func main() {
         ReadTimeout:  10 * time.Second,
         WriteTimeout: 10 * time.Second,
         IdleTimeout:  120 * time.Second,
+        TLSConfig:    tlsConfig,
     }
 
     // --- Start the HTTP server in a goroutine -----------------------------
     go func() {
         log.Printf("SHIPS2-Go server v%s listening on %s", version, addr)
-        if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+        serveFunc := srv.ListenAndServe
+        if tlsConfig != nil {
+            // The certificate comes from TLSConfig.GetCertificate.
+            serveFunc = func() error { return srv.ListenAndServeTLS("", "") }
+        }
+        if err := serveFunc(); err != nil && err != http.ErrServerClosed {
             log.Fatalf("server error: %v", err)
         }
     }()
 
+    // --- Expire unconfirmed rotations and lapsed checkouts, purge retired --
+    go expireStale(st, time.Minute)
+
+    // --- SIGHUP reloads the master key and the TLS certificate -----------
+    reload := make(chan os.Signal, 1)
+    signal.Notify(reload, syscall.SIGHUP)
+    go func() {
+        for range reload {
+            if certificates != nil {
+                if err := certificates.reload(); err != nil {
+                    log.Printf("TLS certificate reload failed: %v", err)
+                } else {
+                    log.Println("TLS certificate reloaded")
+                }
+            }
+            if err := st.ReloadMasterKey(); err != nil {
+                log.Printf("master key reload failed: %v", err)
+                continue
+            }
+            rewrapped, err := st.RewrapDataKeys(context.Background(), "ships-server")
+            if err != nil {
+                log.Printf("master key reloaded, re-wrapping data keys failed: %v", err)
+                continue
+            }
+            log.Printf("master key reloaded, %d data key(s) re-wrapped", rewrapped)
+        }
+    }()
+
     // --- Graceful shutdown on SIGINT / SIGTERM ----------------------------
     stop := make(chan os.Signal, 1)
     signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
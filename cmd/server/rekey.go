@@ -0,0 +1,77 @@
+// cmd/server/rekey.go
+package main
+
+import (
+    "context"
+    "errors"
+    "flag"
+    "fmt"
+    "os"
+    "syscall"
+
+    "github.com/jottavia/SHIPS2-Go/internal/store"
+)
+
+// cmdRekey re-wraps every data key in the database with a new master key.
+// The old key must be the one the database is currently sealed with. The
+// server keeps running: once the new key is installed where it reads its
+// master key, rekey signals it to reload the key and re-wrap whatever it
+// sealed with the old one in the meantime.
+func cmdRekey(args []string) error {
+    flagSet := flag.NewFlagSet("rekey", flag.ContinueOnError)
+    oldKeyFile := flagSet.String("old-key-file", "", "file holding the current master key")
+    newKeyFile := flagSet.String("new-key-file", "", "file holding the new master key")
+    dbPath := flagSet.String("db", getDBPath(), "SQLite database path")
+    actor := flagSet.String("actor", "", "who performed the rotation (default $USER)")
+    if err := flagSet.Parse(args); err != nil {
+        return err
+    }
+    if *oldKeyFile == "" || *newKeyFile == "" {
+        return errors.New(
+            "usage: ships-server rekey -old-key-file FILE -new-key-file FILE [-db path] [-actor name]")
+    }
+    if *actor == "" {
+        *actor = defaultAdminActor()
+    }
+
+    oldKey, err := store.ReadMasterKeyFile(*oldKeyFile)
+    if err != nil {
+        return fmt.Errorf("reading old key: %w", err)
+    }
+    newKey, err := store.ReadMasterKeyFile(*newKeyFile)
+    if err != nil {
+        return fmt.Errorf("reading new key: %w", err)
+    }
+
+    st, err := store.Open(*dbPath, oldKey)
+    if err != nil {
+        return fmt.Errorf("opening db: %w", err)
+    }
+    defer st.Close()
+
+    count, err := st.Rekey(context.Background(), newKey, *actor)
+    if err != nil {
+        return fmt.Errorf("rekey failed, database unchanged: %w", err)
+    }
+    fmt.Printf("Re-wrapped %d data keys with the new master key.\n", count)
+
+    pid, err := store.ServerPID(*dbPath)
+    if err != nil {
+        return fmt.Errorf("finding the server: %w", err)
+    }
+    if pid == 0 {
+        fmt.Printf("No server is running; make sure it loads %s as its master key.\n", *newKeyFile)
+        return nil
+    }
+    process, err := os.FindProcess(pid)
+    if err == nil {
+        err = process.Signal(syscall.SIGHUP)
+    }
+    if err != nil {
+        return fmt.Errorf("signalling the server (pid %d): %w; run `systemctl reload ships-server`",
+            pid, err)
+    }
+    fmt.Printf("Told the server (pid %d) to reload its master key and re-wrap "+
+        "the data keys it sealed meanwhile.\n", pid)
+    return nil
+}
@@ -0,0 +1,85 @@
+// cmd/server/role.go
+package main
+
+import (
+    "context"
+    "errors"
+    "flag"
+    "fmt"
+    "os"
+    "strings"
+    "text/tabwriter"
+
+    "github.com/jottavia/SHIPS2-Go/internal/store"
+)
+
+const roleUsage = `usage:
+  ships-server role assign [-db path] [-actor name] NAME ROLE
+  ships-server role remove [-db path] [-actor name] NAME
+  ships-server role list [-db path]
+roles: machine, helpdesk, operator, admin`
+
+// cmdRole manages which role each token owner holds.
+func cmdRole(args []string) error {
+    if len(args) == 0 {
+        return errors.New(roleUsage)
+    }
+    flagSet := flag.NewFlagSet("role "+args[0], flag.ContinueOnError)
+    dbPath := flagSet.String("db", getDBPath(), "SQLite database path")
+    actor := flagSet.String("actor", defaultAdminActor(), "who changed the role")
+    if err := flagSet.Parse(args[1:]); err != nil {
+        return err
+    }
+
+    var wantArgs int
+    switch args[0] {
+    case "assign":
+        wantArgs = 2
+    case "remove":
+        wantArgs = 1
+    case "list":
+        wantArgs = 0
+    default:
+        return fmt.Errorf("unknown role command %q\n%s", args[0], roleUsage)
+    }
+    if flagSet.NArg() != wantArgs {
+        return errors.New(roleUsage)
+    }
+
+    st, err := store.New(*dbPath)
+    if err != nil {
+        return fmt.Errorf("opening db: %w", err)
+    }
+    defer st.Close()
+    ctx := context.Background()
+
+    switch args[0] {
+    case "assign":
+        role, err := store.ParseRole(strings.ToLower(flagSet.Arg(1)))
+        if err != nil {
+            return err
+        }
+        if err := st.AssignRole(ctx, flagSet.Arg(0), role, *actor); err != nil {
+            return err
+        }
+        fmt.Printf("%s is now %s.\n", flagSet.Arg(0), role)
+    case "remove":
+        if err := st.UnassignRole(ctx, flagSet.Arg(0), *actor); err != nil {
+            return err
+        }
+        fmt.Printf("Removed the role of %s.\n", flagSet.Arg(0))
+    case "list":
+        assignments, err := st.ListRoleAssignments(ctx)
+        if err != nil {
+            return err
+        }
+        writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+        fmt.Fprintln(writer, "PRINCIPAL\tROLE\tASSIGNED\tASSIGNED BY")
+        for _, assignment := range assignments {
+            fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", assignment.Principal, assignment.Role,
+                formatTime(assignment.AssignedAt), assignment.AssignedBy)
+        }
+        return writer.Flush()
+    }
+    return nil
+}
@@ -0,0 +1,134 @@
+// cmd/server/tls.go
+package main
+
+import (
+    "context"
+    "crypto/tls"
+    "crypto/x509"
+    "errors"
+    "fmt"
+    "os"
+    "sync"
+
+    "github.com/jottavia/SHIPS2-Go/internal/store"
+)
+
+// Environment variables configuring the built-in TLS listener.
+const (
+    envTLSCert       = "SHIPS_TLS_CERT"
+    envTLSKey        = "SHIPS_TLS_KEY"
+    envTLSClientCA   = "SHIPS_TLS_CLIENT_CA"
+    envTLSClientAuth = "SHIPS_TLS_CLIENT_AUTH"
+)
+
+// certificateReloader serves the listener's certificate and re-reads it on
+// SIGHUP, so a renewed certificate is picked up without a restart.
+type certificateReloader struct {
+    certFile, keyFile string
+
+    mu          sync.RWMutex
+    certificate *tls.Certificate
+}
+
+// reload reads the certificate and key files again.
+func (reloader *certificateReloader) reload() error {
+    certificate, err := tls.LoadX509KeyPair(reloader.certFile, reloader.keyFile)
+    if err != nil {
+        return fmt.Errorf("loading TLS certificate: %w", err)
+    }
+    reloader.mu.Lock()
+    defer reloader.mu.Unlock()
+    reloader.certificate = &certificate
+    return nil
+}
+
+// getCertificate implements tls.Config.GetCertificate.
+func (reloader *certificateReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+    reloader.mu.RLock()
+    defer reloader.mu.RUnlock()
+    return reloader.certificate, nil
+}
+
+// loadTLSConfig builds the listener's TLS configuration from the
+// environment. It returns nil when SHIPS_TLS_CERT is unset, i.e. the server
+// speaks plain HTTP. Client certificates are verified against the CA bundle
+// in SHIPS_TLS_CLIENT_CA and the built-in CA of st, if initialised, and
+// certificates st has revoked are refused during the handshake.
+// SHIPS_TLS_CLIENT_AUTH=require rejects connections without a certificate,
+// the default "optional" lets token clients in.
+func loadTLSConfig(st *store.Store) (*tls.Config, *certificateReloader, error) {
+    certFile := os.Getenv(envTLSCert)
+    keyFile := os.Getenv(envTLSKey)
+    clientCA := os.Getenv(envTLSClientCA)
+    clientAuth := os.Getenv(envTLSClientAuth)
+    if certFile == "" {
+        if keyFile != "" || clientCA != "" || clientAuth != "" {
+            return nil, nil, fmt.Errorf("%s must be set to use the other SHIPS_TLS_* settings",
+                envTLSCert)
+        }
+        return nil, nil, nil
+    }
+    if keyFile == "" {
+        return nil, nil, fmt.Errorf("%s requires %s", envTLSCert, envTLSKey)
+    }
+
+    reloader := &certificateReloader{certFile: certFile, keyFile: keyFile}
+    if err := reloader.reload(); err != nil {
+        return nil, nil, err
+    }
+    config := &tls.Config{
+        MinVersion:     tls.VersionTLS12,
+        GetCertificate: reloader.getCertificate,
+    }
+
+    switch clientAuth {
+    case "", "optional":
+        config.ClientAuth = tls.VerifyClientCertIfGiven
+    case "require":
+        config.ClientAuth = tls.RequireAndVerifyClientCert
+    default:
+        return nil, nil, fmt.Errorf("%s must be \"optional\" or \"require\", got %q",
+            envTLSClientAuth, clientAuth)
+    }
+
+    pool := x509.NewCertPool()
+    if clientCA != "" {
+        pem, err := os.ReadFile(clientCA) // #nosec G304 – path comes from operator config
+        if err != nil {
+            return nil, nil, fmt.Errorf("reading client CA: %w", err)
+        }
+        if !pool.AppendCertsFromPEM(pem) {
+            return nil, nil, errors.New("client CA file contains no PEM certificates")
+        }
+    }
+    builtinCA, err := st.CACertificate(context.Background())
+    switch {
+    case err == nil:
+        pool.AppendCertsFromPEM([]byte(builtinCA))
+    case !errors.Is(err, store.ErrNoCA):
+        return nil, nil, fmt.Errorf("reading built-in CA: %w", err)
+    case clientCA == "":
+        if clientAuth == "require" {
+            return nil, nil, fmt.Errorf("%s=require needs %s or `ships-server ca init`",
+                envTLSClientAuth, envTLSClientCA)
+        }
+        config.ClientAuth = tls.NoClientCert
+        return config, reloader, nil
+    }
+    config.ClientCAs = pool
+    config.VerifyConnection = func(state tls.ConnectionState) error {
+        if len(state.PeerCertificates) == 0 {
+            return nil
+        }
+        serial := state.PeerCertificates[0].SerialNumber.Text(16)
+        revoked, err := st.IsCertificateRevoked(context.Background(), serial)
+        if err != nil {
+            return fmt.Errorf("checking revocation of %s: %w", serial, err)
+        }
+        if revoked {
+            return fmt.Errorf("client certificate %s has been revoked", serial)
+        }
+        return nil
+    }
+    return config, reloader, nil
+}
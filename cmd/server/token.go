@@ -0,0 +1,191 @@
+// cmd/server/token.go
+package main
+
+import (
+    "context"
+    "errors"
+    "flag"
+    "fmt"
+    "os"
+    "strconv"
+    "strings"
+    "text/tabwriter"
+    "time"
+
+    "github.com/jottavia/SHIPS2-Go/internal/store"
+)
+
+const tokenUsage = `usage:
+  ships-server token issue -name NAME [-role ROLE] [-expires 90d] [-db path] [-actor name]
+  ships-server token list [-db path]
+  ships-server token revoke [-db path] [-actor name] ID`
+
+// cmdToken manages the API tokens clients authenticate with.
+func cmdToken(args []string) error {
+    if len(args) == 0 {
+        return errors.New(tokenUsage)
+    }
+    switch args[0] {
+    case "issue":
+        return cmdTokenIssue(args[1:])
+    case "list":
+        return cmdTokenList(args[1:])
+    case "revoke":
+        return cmdTokenRevoke(args[1:])
+    default:
+        return fmt.Errorf("unknown token command %q\n%s", args[0], tokenUsage)
+    }
+}
+
+// cmdTokenIssue creates a token and prints it once.
+func cmdTokenIssue(args []string) error {
+    flagSet := flag.NewFlagSet("token issue", flag.ContinueOnError)
+    name := flagSet.String("name", "", "owner of the token, recorded as the actor in the audit log")
+    roleName := flagSet.String("role", "", "also assign this role to NAME (machine, helpdesk, operator, admin)")
+    expires := flagSet.String("expires", "", "lifetime, e.g. 90d or 720h (default never)")
+    dbPath := flagSet.String("db", getDBPath(), "SQLite database path")
+    actor := flagSet.String("actor", defaultAdminActor(), "who issued the token")
+    if err := flagSet.Parse(args); err != nil {
+        return err
+    }
+    if *name == "" || flagSet.NArg() != 0 {
+        return errors.New(tokenUsage)
+    }
+    ttl, err := parseLifetime(*expires)
+    if err != nil {
+        return fmt.Errorf("-expires: %w", err)
+    }
+    var role store.Role
+    if *roleName != "" {
+        if role, err = store.ParseRole(strings.ToLower(*roleName)); err != nil {
+            return err
+        }
+    }
+
+    st, err := store.New(*dbPath)
+    if err != nil {
+        return fmt.Errorf("opening db: %w", err)
+    }
+    defer st.Close()
+
+    ctx := context.Background()
+    token, info, err := st.IssueAPIToken(ctx, *name, ttl, *actor)
+    if err != nil {
+        return err
+    }
+    if role != "" {
+        if err := st.AssignRole(ctx, *name, role, *actor); err != nil {
+            return err
+        }
+    }
+    fmt.Printf("Issued token %d for %s", info.ID, info.Name)
+    if !info.ExpiresAt.IsZero() {
+        fmt.Printf(" (expires %s)", info.ExpiresAt.Format(time.RFC3339))
+    }
+    if role != "" {
+        fmt.Printf(" with role %s", role)
+    }
+    fmt.Printf(".\nIt is shown only once; store it now:\n\n%s\n", token)
+    return nil
+}
+
+// cmdTokenList prints every issued token without the secrets.
+func cmdTokenList(args []string) error {
+    flagSet := flag.NewFlagSet("token list", flag.ContinueOnError)
+    dbPath := flagSet.String("db", getDBPath(), "SQLite database path")
+    if err := flagSet.Parse(args); err != nil {
+        return err
+    }
+
+    st, err := store.New(*dbPath)
+    if err != nil {
+        return fmt.Errorf("opening db: %w", err)
+    }
+    defer st.Close()
+
+    tokens, err := st.ListAPITokens(context.Background())
+    if err != nil {
+        return err
+    }
+    now := time.Now()
+    writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+    fmt.Fprintln(writer, "ID\tNAME\tMACHINE\tSTATUS\tCREATED\tCREATED BY\tEXPIRES\tLAST USED")
+    for _, token := range tokens {
+        status := "active"
+        if !token.RevokedAt.IsZero() {
+            status = "revoked"
+        } else if !token.Active(now) {
+            status = "expired"
+        }
+        machine := token.Hostname
+        if machine == "" {
+            machine = "-"
+        }
+        fmt.Fprintf(writer, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", token.ID, token.Name, machine, status,
+            formatTime(token.CreatedAt), token.CreatedBy,
+            formatTime(token.ExpiresAt), formatTime(token.LastUsedAt))
+    }
+    return writer.Flush()
+}
+
+// cmdTokenRevoke revokes a token by ID.
+func cmdTokenRevoke(args []string) error {
+    flagSet := flag.NewFlagSet("token revoke", flag.ContinueOnError)
+    dbPath := flagSet.String("db", getDBPath(), "SQLite database path")
+    actor := flagSet.String("actor", defaultAdminActor(), "who revoked the token")
+    if err := flagSet.Parse(args); err != nil {
+        return err
+    }
+    if flagSet.NArg() != 1 {
+        return errors.New(tokenUsage)
+    }
+    id, err := strconv.ParseInt(flagSet.Arg(0), 10, 64)
+    if err != nil {
+        return fmt.Errorf("invalid token ID %q", flagSet.Arg(0))
+    }
+
+    st, err := store.New(*dbPath)
+    if err != nil {
+        return fmt.Errorf("opening db: %w", err)
+    }
+    defer st.Close()
+
+    if err := st.RevokeAPIToken(context.Background(), id, *actor); err != nil {
+        return err
+    }
+    fmt.Printf("Revoked token %d.\n", id)
+    return nil
+}
+
+// defaultAdminActor names the operator running an administrative command.
+func defaultAdminActor() string {
+    return "ships-server:" + os.Getenv("USER")
+}
+
+// parseLifetime parses "90d" style day counts as well as Go durations. An
+// empty value means no expiry.
+func parseLifetime(value string) (time.Duration, error) {
+    if value == "" {
+        return 0, nil
+    }
+    if days, ok := strings.CutSuffix(value, "d"); ok {
+        count, err := strconv.Atoi(days)
+        if err != nil || count <= 0 {
+            return 0, fmt.Errorf("invalid day count %q", value)
+        }
+        return time.Duration(count) * 24 * time.Hour, nil
+    }
+    lifetime, err := time.ParseDuration(value)
+    if err != nil || lifetime <= 0 {
+        return 0, fmt.Errorf("invalid lifetime %q", value)
+    }
+    return lifetime, nil
+}
+
+// formatTime renders t for tables, with "-" for "never".
+func formatTime(t time.Time) string {
+    if t.IsZero() {
+        return "-"
+    }
+    return t.Format(time.RFC3339)
+}
@@ -0,0 +1,49 @@
+// cmd/server/verify.go
+package main
+
+import (
+    "context"
+    "errors"
+    "flag"
+    "fmt"
+    "time"
+
+    "github.com/jottavia/SHIPS2-Go/internal/store"
+)
+
+// cmdVerifyAudit walks the audit hash chain and reports the first broken
+// link. It exits non-zero when the chain does not verify so it can run from
+// cron or a monitoring check. Run it with the server's environment so the
+// same SHIPS_AUDIT_KEY_FILE is used.
+func cmdVerifyAudit(args []string) error {
+    flagSet := flag.NewFlagSet("verify-audit", flag.ContinueOnError)
+    dbPath := flagSet.String("db", getDBPath(), "SQLite database path")
+    if err := flagSet.Parse(args); err != nil {
+        return err
+    }
+
+    st, err := store.New(*dbPath)
+    if err != nil {
+        return fmt.Errorf("opening db: %w", err)
+    }
+    defer st.Close()
+
+    result, err := st.VerifyAuditChain(context.Background())
+    if err != nil {
+        return err
+    }
+    if result.BrokenAtID != 0 {
+        fmt.Printf("Verified %d entries; chain BROKEN at audit entry %d: %s\n",
+            result.Entries, result.BrokenAtID, result.Reason)
+        if result.Entries > 0 {
+            fmt.Printf("Last good link: id %d hash %s\n", result.Head.ID, result.Head.Hash)
+        }
+        return errors.New("audit chain verification failed")
+    }
+    fmt.Printf("Audit chain intact: %d entries\n", result.Entries)
+    if result.Entries > 0 {
+        fmt.Printf("Head: id %d at %s\n", result.Head.ID, result.Head.Timestamp.Format(time.RFC3339))
+        fmt.Printf("Hash: %s\n", result.Head.Hash)
+    }
+    return nil
+}
@@ -10,7 +10,7 @@
 # Usage:
 #   ./deploy_ships_server.sh [--release-url URL] [--with-wazuh] [--auth user:pass]
 #
-# Tested on Debian / Ubuntu. Requires: curl, sudo, useradd, systemd.
+# Tested on Debian / Ubuntu. Requires: curl, openssl, sudo, useradd, systemd.
 # ---------------------------------------------------------------------------
 set -euo pipefail
 
@@ -23,6 +23,8 @@ CLIENT_URL="${RELEASE_URL:-$DEFAULT_RELEASE_URL}/shipsc"
 INSTALL_DIR=/opt/ships
 BIN_DIR="${INSTALL_DIR}/bin"
 STATE_DIR=/var/lib/ships
+CONFIG_DIR=/etc/ships
+MASTER_KEY_FILE="${CONFIG_DIR}/master.key"
 SERVICE_FILE=/etc/systemd/system/ships-server.service
 SSH_USER=shipscmd
 SSH_GROUP=ships
@@ -106,6 +108,16 @@ fi
 info "Creating directories..."
 install -d -o ships -g "$SSH_GROUP" "$INSTALL_DIR" "$BIN_DIR" "$STATE_DIR"
 
+# --- master key --------------------------------------------------------
+# Kept outside the state directory so a copy of the database alone reveals
+# no secrets.
+install -d -m 0750 -o root -g "$SSH_GROUP" "$CONFIG_DIR"
+if [[ ! -f "$MASTER_KEY_FILE" ]]; then
+    info "Generating master key $MASTER_KEY_FILE (back it up off this host)..."
+    (umask 037 && openssl rand -base64 32 > "$MASTER_KEY_FILE")
+    chown root:"$SSH_GROUP" "$MASTER_KEY_FILE"
+fi
+
 # --- download binaries -------------------------------------------------
 info "Downloading SHIPS2-Go server binary..."
 if ! curl -fsSL "$BIN_URL" -o "$BIN_DIR/ships-server.tmp"; then
@@ -144,6 +156,7 @@ Restart=on-failure
 RestartSec=5
 Environment=SHIPS_ADDR=127.0.0.1:8080
 Environment=SHIPS_DB=${STATE_DIR}/ships.db
+Environment=SHIPS_MASTER_KEY_FILE=${MASTER_KEY_FILE}
 EOF
 
 # Add auth environment variables if configured
@@ -201,10 +214,14 @@ echo "Next steps:"
 echo "1. Add your SSH public key to $AUTH_KEYS with ForceCommand:"
 echo "   command=\"$FORCE_COMMAND\",no-port-forwarding,no-X11-forwarding,no-agent-forwarding ssh-rsa AAAA..."
 echo ""
-echo "2. Test SSH access:"
+echo "2. Issue an API token for each operator; requests without one are refused:"
+echo "   sudo -u ships SHIPS_DB=${STATE_DIR}/ships.db SHIPS_MASTER_KEY_FILE=${MASTER_KEY_FILE} \\"
+echo "       ${BIN_DIR}/ships-server token issue -name NAME -role operator"
+echo ""
+echo "3. Test SSH access:"
 echo "   ssh $SSH_USER@localhost fetch TESTHOST"
 echo ""
-echo "3. Deploy shipsc.exe to Windows clients at C:\\Program Files\\Ships\\"
+echo "4. Deploy shipsc.exe to Windows clients at C:\\Program Files\\Ships\\"
 echo ""
 if [[ -n "$AUTH_USER" ]]; then
     echo "HTTP Basic Auth enabled - use $AUTH_USER:$AUTH_PASS for API access"
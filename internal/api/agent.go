@@ -0,0 +1,92 @@
+// internal/api/agent.go
+package api
+
+import (
+    "fmt"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+)
+
+// DefaultRotationGrace is how long a fetched password may stay in use before
+// the machine is reported as overdue for rotation.
+const DefaultRotationGrace = 24 * time.Hour
+
+// CheckInRequest is the JSON payload of POST /api/v1/agent/checkin.
+type CheckInRequest struct {
+    Hostname        string `json:"host" binding:"required"`
+    MachineIdentity string `json:"machine_identity"`
+}
+
+// WithRotationGrace sets how long after a fetch a machine that has not
+// rotated is reported as overdue. It must be called before Register.
+func (apiInstance *API) WithRotationGrace(grace time.Duration) *API {
+    apiInstance.rotationGrace = grace
+    return apiInstance
+}
+
+// checkIn lets a machine's agent ask whether it should rotate its password.
+func (apiInstance *API) checkIn(ctx *gin.Context) {
+    var req CheckInRequest
+    if err := ctx.ShouldBindJSON(&req); err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    if !apiInstance.authorizeHost(ctx, req.Hostname) ||
+        !apiInstance.claimIdentity(ctx, req.Hostname, req.MachineIdentity, requestActor(ctx, "")) {
+        return
+    }
+
+    status, err := apiInstance.storeInstance.CheckIn(ctx.Request.Context(), req.Hostname)
+    if writeToRetired(ctx, err) {
+        return
+    }
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    ctx.JSON(http.StatusOK, status)
+}
+
+// overdueRotations lists machines whose fetched password has not been
+// rotated within the grace period, which the optional grace parameter
+// ("12h", "7d") overrides.
+func (apiInstance *API) overdueRotations(ctx *gin.Context) {
+    grace := apiInstance.rotationGrace
+    if value := ctx.Query("grace"); value != "" {
+        var err error
+        if grace, err = parseDurationParam(value); err != nil {
+            ctx.JSON(http.StatusBadRequest, gin.H{"error": "grace: " + err.Error()})
+            return
+        }
+    }
+
+    overdue, err := apiInstance.storeInstance.OverdueRotations(ctx.Request.Context(), grace)
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    ctx.JSON(http.StatusOK, gin.H{
+        "grace":    grace.String(),
+        "machines": overdue,
+    })
+}
+
+// parseDurationParam accepts a Go duration or a number of days ("30d").
+func parseDurationParam(value string) (time.Duration, error) {
+    if days, ok := strings.CutSuffix(value, "d"); ok {
+        count, err := strconv.Atoi(days)
+        if err != nil || count < 0 {
+            return 0, fmt.Errorf("invalid day count %q", value)
+        }
+        return time.Duration(count) * 24 * time.Hour, nil
+    }
+    duration, err := time.ParseDuration(value)
+    if err != nil || duration < 0 {
+        return 0, fmt.Errorf("invalid duration %q", value)
+    }
+    return duration, nil
+}
@@ -2,41 +2,137 @@
 package api
 
 import (
+    "context"
+    "errors"
     "net/http"
+    "strconv"
+    "time"
 
     "github.com/gin-gonic/gin"
+    "github.com/jottavia/SHIPS2-Go/internal/bitlocker"
+    "github.com/jottavia/SHIPS2-Go/internal/passgen"
     "github.com/jottavia/SHIPS2-Go/internal/store"
 )
 
-type API struct{ storeInstance *store.Store }
+type API struct {
+    storeInstance  *store.Store
+    requireTokens  bool
+    allowAnonymous bool
+    passwordPolicy passgen.Policy
+    pendingTTL     time.Duration
+    rotationGrace  time.Duration
+    retention      time.Duration
+
+    approvalSettings store.ApprovalSettings
+    identityMismatch string
+}
 
 // defaultAPIActor is used when the client does not specify an actor.
 const defaultAPIActor = "api-user"
 
-// RotateRequest represents the JSON payload for password rotation
+// DefaultPendingRotationTTL is how long a client has to confirm a two-phase
+// rotation before the pending password expires.
+const DefaultPendingRotationTTL = 24 * time.Hour
+
+// RotateRequest represents the JSON payload for password rotation. When
+// Password is omitted the server generates one according to its password
+// policy and returns it in the response. With TwoPhase the password is only
+// escrowed as pending until the client confirms it has applied it with
+// POST /api/v1/rotate/:id/confirm. MachineIdentity is the stable identity
+// of the machine, when it reports one; see claimIdentity.
 type RotateRequest struct {
-    Hostname string `json:"host" binding:"required"`
-    Password string `json:"password" binding:"required"`
-    Actor    string `json:"actor"`
+    Hostname        string  `json:"host" binding:"required"`
+    Password        *string `json:"password"`
+    Actor           string  `json:"actor"`
+    TwoPhase        bool    `json:"two_phase"`
+    MachineIdentity string  `json:"machine_identity"`
+}
+
+// ConfirmRequest is the optional JSON payload of
+// POST /api/v1/rotate/:id/confirm and POST /api/v1/rotate/:id/cancel.
+type ConfirmRequest struct {
+    Actor string `json:"actor"`
 }
 
-// UpdateKeyRequest represents the JSON payload for BitLocker key updates
+// UpdateKeyRequest represents the JSON payload for BitLocker key updates.
+// Volume (mount point or volume GUID) and ProtectorID identify which key
+// protector the recovery password belongs to; both are optional for
+// clients that only escrow a single key.
 type UpdateKeyRequest struct {
-    Hostname string `json:"host" binding:"required"`
-    Key      string `json:"key" binding:"required"`
-    Actor    string `json:"actor"`
+    Hostname        string `json:"host" binding:"required"`
+    Key             string `json:"key" binding:"required"`
+    Volume          string `json:"volume"`
+    ProtectorID     string `json:"protector_id"`
+    Actor           string `json:"actor"`
+    MachineIdentity string `json:"machine_identity"`
 }
 
 func New(storeInstance *store.Store) *API { 
-    return &API{storeInstance: storeInstance} 
+    return &API{
+        storeInstance:  storeInstance,
+        passwordPolicy: passgen.Default(),
+        pendingTTL:     DefaultPendingRotationTTL,
+        rotationGrace:  DefaultRotationGrace,
+        retention:      DefaultRetention,
+
+        approvalSettings: store.DefaultApprovalSettings(),
+        identityMismatch: store.IdentityReject,
+    }
+}
+
+// WithPasswordPolicy sets the policy for passwords the server generates when
+// a rotation omits the password. It must be called before Register.
+func (apiInstance *API) WithPasswordPolicy(policy passgen.Policy) *API {
+    apiInstance.passwordPolicy = policy
+    return apiInstance
 }
 
-func (apiInstance *API) Register(router *gin.Engine) {
-    v1 := router.Group("/api/v1")
-    v1.GET("/password/:host", apiInstance.getPassword)
-    v1.POST("/rotate", apiInstance.rotate)
-    v1.GET("/bde/:host", apiInstance.getBDEKey)
-    v1.POST("/update_key", apiInstance.updateKey)
+// WithPendingRotationTTL sets how long a two-phase rotation stays pending
+// before it expires unconfirmed. It must be called before Register.
+func (apiInstance *API) WithPendingRotationTTL(ttl time.Duration) *API {
+    apiInstance.pendingTTL = ttl
+    return apiInstance
+}
+
+// Register mounts the API under /api/v1. middleware, such as basic auth, runs
+// before authentication on every route except enrollment, which machines
+// call before they hold any credential.
+func (apiInstance *API) Register(router *gin.Engine, middleware ...gin.HandlerFunc) {
+    // Enrollment authenticates with its own one-time token.
+    router.POST("/api/v1/enroll", apiInstance.enroll)
+
+    v1 := router.Group("/api/v1", middleware...)
+    v1.Use(apiInstance.authenticate)
+
+    // Who may call what; see store.Role and AllowAnonymous.
+    writers := apiInstance.require(store.RoleMachine, store.RoleOperator, store.RoleAdmin)
+    keyReaders := apiInstance.require(store.RoleHelpdesk, store.RoleOperator, store.RoleAdmin)
+    passwordReaders := apiInstance.require(store.RoleOperator, store.RoleAdmin)
+    admins := apiInstance.require(store.RoleAdmin)
+
+    v1.GET("/password/:host", passwordReaders, apiInstance.getPassword)
+    v1.GET("/password/:host/history", passwordReaders, apiInstance.getPasswordHistory)
+    v1.POST("/password/:host/checkout", passwordReaders, apiInstance.checkoutPassword)
+    v1.POST("/password/:host/checkin", passwordReaders, apiInstance.checkinPassword)
+    v1.POST("/rotate", writers, apiInstance.rotate)
+    v1.POST("/rotate/:id/confirm", writers, apiInstance.confirmRotation)
+    v1.POST("/rotate/:id/cancel", writers, apiInstance.cancelRotation)
+    v1.GET("/rotations/overdue", passwordReaders, apiInstance.overdueRotations)
+    v1.POST("/agent/checkin", writers, apiInstance.checkIn)
+    v1.GET("/bde/:host", keyReaders, apiInstance.getBDEKey)
+    v1.GET("/bde/by-key-id/:prefix", keyReaders, apiInstance.findBDEKeyByKeyID)
+    v1.POST("/update_key", writers, apiInstance.updateKey)
+    v1.GET("/machines", keyReaders, apiInstance.listMachines)
+    v1.GET("/reports/compliance", keyReaders, apiInstance.complianceReport)
+    v1.POST("/machines/:host/decommission", admins, apiInstance.decommissionMachine)
+    v1.POST("/machines/:host/rename", admins, apiInstance.renameMachine)
+    v1.GET("/approvals", passwordReaders, apiInstance.listApprovals)
+    v1.POST("/approvals/:id/approve", passwordReaders, apiInstance.approveRequest)
+    v1.POST("/approvals/:id/deny", passwordReaders, apiInstance.denyRequest)
+    v1.GET("/audit", admins, apiInstance.queryAudit)
+    v1.GET("/audit/head", admins, apiInstance.auditHead)
+    v1.POST("/certificates/renew", apiInstance.require(store.RoleMachine),
+        apiInstance.renewCertificate)
 }
 
 // getRemoteAddr extracts the remote address from the request
@@ -55,11 +151,15 @@ func getRemoteAddr(ctx *gin.Context) string {
 
 func (apiInstance *API) getPassword(ctx *gin.Context) {
     hostname := ctx.Param("host")
-    actor := ctx.GetHeader("X-Actor") // Allow override via header
-    if actor == "" {
-        actor = defaultAPIActor
-    }
+    actor := requestActor(ctx, ctx.GetHeader("X-Actor"))
     remoteAddr := getRemoteAddr(ctx)
+    if apiInstance.retiredMachine(ctx, hostname) {
+        return
+    }
+    approval, answered := apiInstance.awaitingApproval(ctx, hostname, store.SecretPassword, actor)
+    if answered {
+        return
+    }
 
     pwInfo, err := apiInstance.storeInstance.GetPassword(
         ctx.Request.Context(), 
@@ -67,14 +167,55 @@ func (apiInstance *API) getPassword(ctx *gin.Context) {
         actor, 
         remoteAddr,
     )
+    if leaseConflict(ctx, err) {
+        return
+    }
     if err != nil {
         ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
         return
     }
+    if apiInstance.useApprovals(ctx, approval) {
+        return
+    }
     
     ctx.JSON(http.StatusOK, pwInfo)
 }
 
+func (apiInstance *API) getPasswordHistory(ctx *gin.Context) {
+    hostname := ctx.Param("host")
+    actor := requestActor(ctx, ctx.GetHeader("X-Actor"))
+    remoteAddr := getRemoteAddr(ctx)
+    if apiInstance.retiredMachine(ctx, hostname) {
+        return
+    }
+    approval, answered := apiInstance.awaitingApproval(ctx, hostname, store.SecretPassword, actor)
+    if answered {
+        return
+    }
+
+    history, err := apiInstance.storeInstance.ListPasswordHistory(
+        ctx.Request.Context(),
+        hostname,
+        actor,
+        remoteAddr,
+    )
+    if leaseConflict(ctx, err) {
+        return
+    }
+    if err != nil {
+        ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+        return
+    }
+    if apiInstance.useApprovals(ctx, approval) {
+        return
+    }
+
+    ctx.JSON(http.StatusOK, gin.H{
+        "hostname": hostname,
+        "history": history,
+    })
+}
+
 func (apiInstance *API) rotate(ctx *gin.Context) {
     var req RotateRequest
     if err := ctx.ShouldBindJSON(&req); err != nil {
@@ -82,41 +223,186 @@ func (apiInstance *API) rotate(ctx *gin.Context) {
         return
     }
     
-    if req.Actor == "" {
-        req.Actor = defaultAPIActor
+    if !apiInstance.authorizeHost(ctx, req.Hostname) {
+        return
     }
+    req.Actor = requestActor(ctx, req.Actor)
     remoteAddr := getRemoteAddr(ctx)
+    if !apiInstance.claimIdentity(ctx, req.Hostname, req.MachineIdentity, req.Actor) {
+        return
+    }
+
+    generated := req.Password == nil
+    if generated {
+        password, err := passgen.Generate(apiInstance.passwordPolicy)
+        if err != nil {
+            ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        req.Password = &password
+    }
+
+    if req.TwoPhase {
+        apiInstance.beginRotation(ctx, req, generated, remoteAddr)
+        return
+    }
 
     err := apiInstance.storeInstance.RotatePassword(
         ctx.Request.Context(), 
         req.Hostname, 
-        req.Password, 
+        *req.Password, 
         req.Actor, 
         remoteAddr,
     )
+    if writeToRetired(ctx, err) {
+        return
+    }
     if err != nil {
         ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
         return
     }
     
-    ctx.JSON(http.StatusOK, gin.H{
+    response := gin.H{
         "status": "rotated",
         "hostname": req.Hostname,
         "actor": req.Actor,
+    }
+    if generated {
+        // Only the caller that asked for the rotation sees the password.
+        ctx.Header("Cache-Control", "no-store")
+        response["password"] = *req.Password
+        response["generated"] = true
+    }
+    ctx.JSON(http.StatusOK, response)
+}
+
+// beginRotation escrows the password of req as a pending version and
+// replies with the version ID the client confirms once it has applied it.
+func (apiInstance *API) beginRotation(
+    ctx *gin.Context,
+    req RotateRequest,
+    generated bool,
+    remoteAddr string,
+) {
+    version, err := apiInstance.storeInstance.BeginRotation(
+        ctx.Request.Context(),
+        req.Hostname,
+        *req.Password,
+        apiInstance.pendingTTL,
+        req.Actor,
+        remoteAddr,
+    )
+    if writeToRetired(ctx, err) {
+        return
+    }
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+
+    response := gin.H{
+        "status":     "pending",
+        "id":         version.ID,
+        "expires_at": version.ExpiresAt,
+        "hostname":   req.Hostname,
+        "actor":      req.Actor,
+    }
+    if generated {
+        ctx.Header("Cache-Control", "no-store")
+        response["password"] = *req.Password
+        response["generated"] = true
+    }
+    ctx.JSON(http.StatusAccepted, response)
+}
+
+// confirmRotation makes a pending password version current once the client
+// has applied it on the machine.
+func (apiInstance *API) confirmRotation(ctx *gin.Context) {
+    apiInstance.settleRotation(ctx, "confirmed", apiInstance.storeInstance.ConfirmRotation)
+}
+
+// cancelRotation withdraws a pending password version the client could not
+// apply, leaving the confirmed password current.
+func (apiInstance *API) cancelRotation(ctx *gin.Context) {
+    apiInstance.settleRotation(ctx, "cancelled", apiInstance.storeInstance.CancelRotation)
+}
+
+// settleRotation resolves the password version named by the :id parameter
+// with settle on behalf of the caller, who must be allowed to write secrets
+// of its host, and replies with status.
+func (apiInstance *API) settleRotation(
+    ctx *gin.Context,
+    status string,
+    settle func(ctx context.Context, id int64, actor, remoteAddr string) error,
+) {
+    id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid password version ID"})
+        return
+    }
+    hostname, err := apiInstance.storeInstance.RotationHost(ctx.Request.Context(), id)
+    if errors.Is(err, store.ErrUnknownRotation) {
+        ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+        return
+    }
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    if !apiInstance.authorizeHost(ctx, hostname) {
+        return
+    }
+    var req ConfirmRequest
+    if ctx.Request.ContentLength != 0 {
+        if err := ctx.ShouldBindJSON(&req); err != nil {
+            ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+    }
+    actor := requestActor(ctx, req.Actor)
+
+    err = settle(
+        ctx.Request.Context(),
+        id,
+        actor,
+        getRemoteAddr(ctx),
+    )
+    switch {
+    case errors.Is(err, store.ErrUnknownRotation):
+        ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+        return
+    case errors.Is(err, store.ErrRotationNotPending), errors.Is(err, store.ErrRotationExpired):
+        ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+        return
+    case err != nil:
+        ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+
+    ctx.JSON(http.StatusOK, gin.H{
+        "status":   status,
+        "id":       id,
+        "hostname": hostname,
+        "actor":    actor,
     })
 }
 
 func (apiInstance *API) getBDEKey(ctx *gin.Context) {
     hostname := ctx.Param("host")
-    actor := ctx.GetHeader("X-Actor") // Allow override via header
-    if actor == "" {
-        actor = defaultAPIActor
-    }
+    actor := requestActor(ctx, ctx.GetHeader("X-Actor"))
     remoteAddr := getRemoteAddr(ctx)
+    if apiInstance.retiredMachine(ctx, hostname) {
+        return
+    }
+    approval, answered := apiInstance.awaitingApproval(ctx, hostname, store.SecretBitLocker, actor)
+    if answered {
+        return
+    }
 
-    keyInfo, err := apiInstance.storeInstance.GetBDEKey(
+    keys, err := apiInstance.storeInstance.GetBDEKeys(
         ctx.Request.Context(), 
         hostname, 
+        ctx.Query("protector"),
         actor, 
         remoteAddr,
     )
@@ -124,8 +410,63 @@ func (apiInstance *API) getBDEKey(ctx *gin.Context) {
         ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
         return
     }
-    
-    ctx.JSON(http.StatusOK, keyInfo)
+    if apiInstance.useApprovals(ctx, approval) {
+        return
+    }
+
+    // The top-level fields describe the most recently escrowed key so
+    // single-volume clients keep working; volumes lists every key.
+    ctx.JSON(http.StatusOK, gin.H{
+        "hostname":   hostname,
+        "key":        keys[0].Key,
+        "updated_at": keys[0].UpdatedAt,
+        "actor":      keys[0].Actor,
+        "volumes":    keys,
+    })
+}
+
+func (apiInstance *API) findBDEKeyByKeyID(ctx *gin.Context) {
+    keyID := ctx.Param("prefix")
+    actor := requestActor(ctx, ctx.GetHeader("X-Actor"))
+    remoteAddr := getRemoteAddr(ctx)
+
+    // Resolving the Key ID must not get around the retirement or an approval
+    // policy of the machine it belongs to.
+    hosts, err := apiInstance.storeInstance.BDEKeyIDHosts(ctx.Request.Context(), keyID)
+    if err != nil {
+        ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+        return
+    }
+    var approvals []*store.ApprovalRequest
+    for _, host := range hosts {
+        if apiInstance.retiredMachine(ctx, host) {
+            return
+        }
+        approval, answered := apiInstance.awaitingApproval(ctx, host, store.SecretBitLocker, actor)
+        if answered {
+            return
+        }
+        approvals = append(approvals, approval)
+    }
+
+    matches, err := apiInstance.storeInstance.FindBDEKeyByKeyID(
+        ctx.Request.Context(),
+        keyID,
+        actor,
+        remoteAddr,
+    )
+    if err != nil {
+        ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+        return
+    }
+    if apiInstance.useApprovals(ctx, approvals...) {
+        return
+    }
+
+    ctx.JSON(http.StatusOK, gin.H{
+        "key_id":  keyID,
+        "matches": matches,
+    })
 }
 
 func (apiInstance *API) updateKey(ctx *gin.Context) {
@@ -135,18 +476,37 @@ func (apiInstance *API) updateKey(ctx *gin.Context) {
         return
     }
     
-    if req.Actor == "" {
-        req.Actor = defaultAPIActor
+    if !apiInstance.authorizeHost(ctx, req.Hostname) {
+        return
     }
+    req.Actor = requestActor(ctx, req.Actor)
     remoteAddr := getRemoteAddr(ctx)
+    if !apiInstance.claimIdentity(ctx, req.Hostname, req.MachineIdentity, req.Actor) {
+        return
+    }
 
     err := apiInstance.storeInstance.UpdateBDEKey(
         ctx.Request.Context(), 
         req.Hostname, 
+        req.Volume,
+        req.ProtectorID,
         req.Key, 
         req.Actor, 
         remoteAddr,
     )
+    if writeToRetired(ctx, err) {
+        return
+    }
+    var keyErr *bitlocker.KeyError
+    if errors.As(err, &keyErr) {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "error":  err.Error(),
+            "code":   "invalid_recovery_key",
+            "group":  keyErr.Group,
+            "reason": keyErr.Reason,
+        })
+        return
+    }
     if err != nil {
         ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
         return
@@ -155,6 +515,7 @@ func (apiInstance *API) updateKey(ctx *gin.Context) {
     ctx.JSON(http.StatusOK, gin.H{
         "status": "key stored",
         "hostname": req.Hostname,
+        "volume": req.Volume,
         "actor": req.Actor,
     })
 }
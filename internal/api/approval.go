@@ -0,0 +1,189 @@
+// internal/api/approval.go
+package api
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "strconv"
+
+    "github.com/gin-gonic/gin"
+    "github.com/jottavia/SHIPS2-Go/internal/store"
+)
+
+// DecisionRequest is the optional JSON payload of
+// POST /api/v1/approvals/:id/approve and /deny. Actor is refused; the
+// approver is always the authenticated caller.
+type DecisionRequest struct {
+    Note  string `json:"note"`
+    Actor string `json:"actor"`
+}
+
+// WithApprovalSettings sets how long approval requests wait for a decision
+// and approved retrievals stay usable, and the bulk retrieval limit. It
+// must be called before Register.
+func (apiInstance *API) WithApprovalSettings(settings store.ApprovalSettings) *API {
+    apiInstance.approvalSettings = settings
+    return apiInstance
+}
+
+// awaitingApproval reports whether actor needs a second person's approval
+// to retrieve secret of host, in which case the request has been answered:
+// with 202 and the approval request to wait for, or with 403 if the caller
+// is not authenticated, since approvals are tied to who asked. Otherwise it
+// returns the approval that lets the retrieval through, if one was needed,
+// which useApprovals uses up once the retrieval succeeded.
+func (apiInstance *API) awaitingApproval(
+    ctx *gin.Context,
+    host, secret, actor string,
+) (*store.ApprovalRequest, bool) {
+    if _, ok := identityFrom(ctx); !ok {
+        reason, err := apiInstance.storeInstance.ApprovalReason(
+            ctx.Request.Context(),
+            host,
+            secret,
+            actor,
+            apiInstance.approvalSettings,
+        )
+        if err != nil {
+            ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return nil, true
+        }
+        if reason == "" {
+            return nil, false
+        }
+        apiInstance.deny(ctx, host, fmt.Sprintf(
+            "retrieving the %s of %s needs approval (%s), which requires an API token "+
+                "or client certificate", secret, host, reason))
+        return nil, true
+    }
+
+    request, err := apiInstance.storeInstance.AuthorizeRetrieval(
+        ctx.Request.Context(),
+        host,
+        secret,
+        actor,
+        getRemoteAddr(ctx),
+        apiInstance.approvalSettings,
+    )
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return nil, true
+    }
+    if request == nil || request.State == store.ApprovalApproved {
+        return request, false
+    }
+    ctx.JSON(http.StatusAccepted, gin.H{
+        "status":     "approval_required",
+        "request_id": request.ID,
+        "request":    request,
+    })
+    return nil, true
+}
+
+// useApprovals uses up the approvals awaitingApproval returned, so each lets
+// exactly one successful retrieval through. It reports whether the request
+// was answered instead, with 409 if a concurrent retrieval used one first.
+func (apiInstance *API) useApprovals(ctx *gin.Context, approvals ...*store.ApprovalRequest) bool {
+    for _, approval := range approvals {
+        if approval == nil {
+            continue
+        }
+        err := apiInstance.storeInstance.UseApproval(
+            ctx.Request.Context(),
+            approval.ID,
+            approval.Requester,
+            getRemoteAddr(ctx),
+        )
+        if errors.Is(err, store.ErrApprovalUsed) {
+            ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+            return true
+        }
+        if err != nil {
+            ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return true
+        }
+    }
+    return false
+}
+
+// listApprovals returns approval requests, newest first; the state
+// parameter (pending, approved, denied, used, expired) filters them.
+func (apiInstance *API) listApprovals(ctx *gin.Context) {
+    requests, err := apiInstance.storeInstance.ListApprovalRequests(
+        ctx.Request.Context(),
+        ctx.Query("state"),
+    )
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    ctx.JSON(http.StatusOK, gin.H{"requests": requests})
+}
+
+// approveRequest lets a second person approve a pending retrieval.
+func (apiInstance *API) approveRequest(ctx *gin.Context) {
+    apiInstance.decideRequest(ctx, true)
+}
+
+// denyRequest lets a second person deny a pending retrieval.
+func (apiInstance *API) denyRequest(ctx *gin.Context) {
+    apiInstance.decideRequest(ctx, false)
+}
+
+// decideRequest records the decision of the authenticated caller. A
+// declared actor is refused rather than ignored: the approver must be
+// someone the server can tell apart from the requester.
+func (apiInstance *API) decideRequest(ctx *gin.Context, approve bool) {
+    identity, ok := identityFrom(ctx)
+    if !ok {
+        ctx.Header("WWW-Authenticate", `Bearer realm="SHIPS2-Go"`)
+        ctx.JSON(http.StatusUnauthorized, gin.H{
+            "error": "deciding approval requests requires an API token or client certificate",
+        })
+        return
+    }
+    id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid approval request ID"})
+        return
+    }
+    var req DecisionRequest
+    if ctx.Request.ContentLength != 0 {
+        if err := ctx.ShouldBindJSON(&req); err != nil {
+            ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+    }
+    if req.Actor != "" || ctx.GetHeader("X-Actor") != "" {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "error": "the approver is the authenticated caller; do not declare an actor",
+        })
+        return
+    }
+
+    request, err := apiInstance.storeInstance.DecideApproval(
+        ctx.Request.Context(),
+        id,
+        approve,
+        identity.Name,
+        req.Note,
+        getRemoteAddr(ctx),
+        apiInstance.approvalSettings,
+    )
+    switch {
+    case errors.Is(err, store.ErrUnknownApproval):
+        ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+        return
+    case errors.Is(err, store.ErrSelfApproval):
+        ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+        return
+    case errors.Is(err, store.ErrApprovalDecided):
+        ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+        return
+    case err != nil:
+        ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    ctx.JSON(http.StatusOK, request)
+}
@@ -0,0 +1,95 @@
+// internal/api/audit.go
+package api
+
+import (
+    "fmt"
+    "net/http"
+    "strconv"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/jottavia/SHIPS2-Go/internal/store"
+)
+
+// queryAudit serves GET /api/v1/audit. Query parameters: host, actor,
+// action, remote_addr, since and until (RFC 3339 or Unix seconds), limit and
+// before (the next_before value of the previous page).
+func (apiInstance *API) queryAudit(ctx *gin.Context) {
+    filter := store.AuditFilter{
+        Host:       ctx.Query("host"),
+        Actor:      ctx.Query("actor"),
+        Action:     ctx.Query("action"),
+        RemoteAddr: ctx.Query("remote_addr"),
+    }
+    var err error
+    if filter.Since, err = parseTimeParam(ctx.Query("since")); err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{"error": "since: " + err.Error()})
+        return
+    }
+    if filter.Until, err = parseTimeParam(ctx.Query("until")); err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{"error": "until: " + err.Error()})
+        return
+    }
+    if filter.Limit, err = parseIntParam(ctx.Query("limit")); err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{"error": "limit: " + err.Error()})
+        return
+    }
+    beforeID, err := parseIntParam(ctx.Query("before"))
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{"error": "before: " + err.Error()})
+        return
+    }
+    filter.BeforeID = int64(beforeID)
+
+    entries, nextBeforeID, err := apiInstance.storeInstance.QueryAudit(
+        ctx.Request.Context(),
+        filter,
+    )
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+
+    ctx.JSON(http.StatusOK, gin.H{
+        "entries":     entries,
+        "next_before": nextBeforeID,
+    })
+}
+
+// auditHead serves GET /api/v1/audit/head: the newest link of the audit
+// hash chain, for notarising outside the server.
+func (apiInstance *API) auditHead(ctx *gin.Context) {
+    head, err := apiInstance.storeInstance.AuditChainHead(ctx.Request.Context())
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    ctx.JSON(http.StatusOK, head)
+}
+
+// parseTimeParam accepts an RFC 3339 timestamp or Unix seconds; empty is zero.
+func parseTimeParam(value string) (time.Time, error) {
+    if value == "" {
+        return time.Time{}, nil
+    }
+    if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+        return time.Unix(seconds, 0), nil
+    }
+    parsed, err := time.Parse(time.RFC3339, value)
+    if err != nil {
+        return time.Time{}, fmt.Errorf("expected RFC 3339 or Unix seconds, got %q", value)
+    }
+    return parsed, nil
+}
+
+// parseIntParam parses a non-negative integer query parameter; empty is 0.
+func parseIntParam(value string) (int, error) {
+    if value == "" {
+        return 0, nil
+    }
+    parsed, err := strconv.Atoi(value)
+    if err != nil || parsed < 0 {
+        return 0, fmt.Errorf("expected a non-negative integer, got %q", value)
+    }
+    return parsed, nil
+}
@@ -0,0 +1,226 @@
+// internal/api/auth.go
+package api
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+    "github.com/jottavia/SHIPS2-Go/internal/store"
+)
+
+// identityKey is the gin context key under which the authenticated caller
+// of a request is stored.
+const identityKey = "ships.identity"
+
+// Identity is the authenticated caller of a request. Name is what gets
+// written to audit_logs as the actor; Role is empty when none is assigned.
+// Hostname is set for machine credentials and machine certificates and is
+// the only host whose secrets the caller may write. TokenID or CertSerial
+// records how the caller authenticated.
+type Identity struct {
+    Name       string
+    TokenID    int64
+    CertSerial string
+    Role       store.Role
+    Hostname   string
+}
+
+// RequireTokens makes every /api/v1 route demand an API token issued with
+// `ships-server token issue`, sent as "Authorization: Bearer <token>", unless
+// the caller presented a verified TLS client certificate. The caller's
+// identity then replaces any actor the client declares. It must be called
+// before Register.
+func (apiInstance *API) RequireTokens() *API {
+    apiInstance.requireTokens = true
+    return apiInstance
+}
+
+// AllowAnonymous lets requests without a token or client certificate past
+// every role check, for deployments that still rely on HTTP Basic Auth or
+// the SSH wrapper alone. Such requests are audited under the actor they
+// declare. Without it role-restricted routes answer them with 401. It must
+// be called before Register.
+func (apiInstance *API) AllowAnonymous() *API {
+    apiInstance.allowAnonymous = true
+    return apiInstance
+}
+
+// authenticate establishes the caller of a request. A verified TLS client
+// certificate takes precedence over a bearer token unless the built-in CA
+// has revoked it. A bearer token is always checked, so an invalid one is
+// rejected with 401 and a valid one subjects the caller to its role even
+// when tokens are not required. Without either the request is rejected
+// with 401 when tokens are required and otherwise continues
+// unauthenticated, for require to refuse or admit.
+func (apiInstance *API) authenticate(ctx *gin.Context) {
+    if identity, ok := certificateIdentity(ctx.Request); ok {
+        revoked, err := apiInstance.storeInstance.IsCertificateRevoked(
+            ctx.Request.Context(),
+            identity.CertSerial,
+        )
+        if err != nil {
+            ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        if revoked {
+            ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+                "error": "client certificate " + identity.CertSerial + " has been revoked",
+            })
+            return
+        }
+        apiInstance.admit(ctx, identity)
+        return
+    }
+    scheme, token, found := strings.Cut(ctx.GetHeader("Authorization"), " ")
+    bearer := found && strings.EqualFold(scheme, "Bearer")
+    if !bearer && !apiInstance.requireTokens {
+        ctx.Next()
+        return
+    }
+    if !bearer || strings.TrimSpace(token) == "" {
+        ctx.Header("WWW-Authenticate", `Bearer realm="SHIPS2-Go"`)
+        ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "API token required"})
+        return
+    }
+
+    info, err := apiInstance.storeInstance.AuthenticateAPIToken(
+        ctx.Request.Context(),
+        strings.TrimSpace(token),
+    )
+    if errors.Is(err, store.ErrInvalidToken) {
+        ctx.Header("WWW-Authenticate", `Bearer realm="SHIPS2-Go", error="invalid_token"`)
+        ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+        return
+    }
+    if err != nil {
+        ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+
+    apiInstance.admit(ctx, &Identity{Name: info.Name, TokenID: info.ID, Hostname: info.Hostname})
+}
+
+// admit resolves the role of identity and continues the request as it.
+// Callers bound to a hostname always hold the machine role; everyone else
+// gets the role assigned to their name.
+func (apiInstance *API) admit(ctx *gin.Context, identity *Identity) {
+    if identity.Hostname != "" {
+        identity.Role = store.RoleMachine
+    } else {
+        role, err := apiInstance.storeInstance.RoleOf(ctx.Request.Context(), identity.Name)
+        if err != nil && !errors.Is(err, store.ErrNoRole) {
+            ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        identity.Role = role
+    }
+
+    ctx.Set(identityKey, identity)
+    ctx.Next()
+}
+
+// require admits the request only if the caller holds one of roles.
+// Requests without an authenticated identity get 401 unless AllowAnonymous
+// lets them through.
+func (apiInstance *API) require(roles ...store.Role) gin.HandlerFunc {
+    return func(ctx *gin.Context) {
+        identity, ok := identityFrom(ctx)
+        if !ok && apiInstance.allowAnonymous {
+            ctx.Next()
+            return
+        }
+        if !ok {
+            ctx.Header("WWW-Authenticate", `Bearer realm="SHIPS2-Go"`)
+            ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": fmt.Sprintf(
+                "%s %s requires an API token or client certificate with the role %s",
+                ctx.Request.Method, ctx.Request.URL.Path, joinRoles(roles))})
+            return
+        }
+        for _, role := range roles {
+            if identity.Role == role {
+                ctx.Next()
+                return
+            }
+        }
+        held := string(identity.Role)
+        if held == "" {
+            held = "no role"
+        }
+        apiInstance.deny(ctx, ctx.Param("host"), fmt.Sprintf("%s %s requires %s; %s has %s",
+            ctx.Request.Method, ctx.Request.URL.Path, joinRoles(roles), identity.Name, held))
+    }
+}
+
+// authorizeHost checks that a machine principal only writes secrets of its
+// own hostname: the enrolled hostname of a machine credential, or the name
+// of a token given the machine role by hand. Other roles pass. On refusal
+// the request has already been answered with 403 and audited.
+func (apiInstance *API) authorizeHost(ctx *gin.Context, host string) bool {
+    identity, ok := identityFrom(ctx)
+    if !ok {
+        return true
+    }
+    bound := identity.Hostname
+    if bound == "" && identity.Role == store.RoleMachine {
+        bound = identity.Name
+    }
+    if bound == "" || apiInstance.storeInstance.SameHostname(bound, host) {
+        return true
+    }
+    apiInstance.deny(ctx, host, fmt.Sprintf("%s %s for %s by machine %s (cross-host write)",
+        ctx.Request.Method, ctx.Request.URL.Path, host, bound))
+    return false
+}
+
+// deny records an access_denied audit entry and aborts with 403.
+func (apiInstance *API) deny(ctx *gin.Context, host, detail string) {
+    if err := apiInstance.storeInstance.RecordDenial(
+        ctx.Request.Context(),
+        host,
+        requestActor(ctx, ""),
+        getRemoteAddr(ctx),
+        detail,
+    ); err != nil {
+        ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden: " + detail})
+}
+
+// joinRoles renders roles as "a, b or c".
+func joinRoles(roles []store.Role) string {
+    names := make([]string, len(roles))
+    for i, role := range roles {
+        names[i] = string(role)
+    }
+    if len(names) < 2 {
+        return strings.Join(names, "")
+    }
+    return strings.Join(names[:len(names)-1], ", ") + " or " + names[len(names)-1]
+}
+
+// identityFrom returns the authenticated caller of ctx, if any.
+func identityFrom(ctx *gin.Context) (*Identity, bool) {
+    value, ok := ctx.Get(identityKey)
+    if !ok {
+        return nil, false
+    }
+    identity, ok := value.(*Identity)
+    return identity, ok
+}
+
+// requestActor returns the actor to audit for a request: the authenticated
+// identity when there is one, otherwise the actor the client declared (the
+// X-Actor header or the JSON body), falling back to defaultAPIActor.
+func requestActor(ctx *gin.Context, declared string) string {
+    if identity, ok := identityFrom(ctx); ok {
+        return identity.Name
+    }
+    if declared == "" {
+        return defaultAPIActor
+    }
+    return declared
+}
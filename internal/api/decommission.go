@@ -0,0 +1,97 @@
+// internal/api/decommission.go
+package api
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/jottavia/SHIPS2-Go/internal/hostname"
+    "github.com/jottavia/SHIPS2-Go/internal/store"
+)
+
+// DefaultRetention is how long the secrets of a decommissioned machine are
+// kept, readable by admins only, before they are purged.
+const DefaultRetention = 90 * 24 * time.Hour
+
+// DecommissionRequest is the JSON payload of
+// POST /api/v1/machines/:host/decommission.
+type DecommissionRequest struct {
+    Reason string `json:"reason" binding:"required"`
+    Actor  string `json:"actor"`
+}
+
+// WithRetention sets how long the secrets of a decommissioned machine are
+// kept before they are purged. It must be called before Register.
+func (apiInstance *API) WithRetention(retention time.Duration) *API {
+    apiInstance.retention = retention
+    return apiInstance
+}
+
+// decommissionMachine retires a machine: no further rotations or key
+// updates, and its secrets only readable by admins until they are purged.
+func (apiInstance *API) decommissionMachine(ctx *gin.Context) {
+    var req DecommissionRequest
+    if err := ctx.ShouldBindJSON(&req); err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    retirement, err := apiInstance.storeInstance.DecommissionMachine(
+        ctx.Request.Context(),
+        ctx.Param("host"),
+        req.Reason,
+        apiInstance.retention,
+        requestActor(ctx, req.Actor),
+        getRemoteAddr(ctx),
+    )
+    switch {
+    case errors.Is(err, store.ErrUnknownMachine):
+        ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+        return
+    case errors.Is(err, store.ErrMachineRetired):
+        ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+        return
+    case err != nil:
+        ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    ctx.JSON(http.StatusOK, retirement)
+}
+
+// retiredMachine reports whether host is decommissioned and the caller is
+// not an admin, in which case the request has been answered with 403 and
+// audited. Unauthenticated deployments have no roles and pass, as with
+// require.
+func (apiInstance *API) retiredMachine(ctx *gin.Context, host string) bool {
+    retirement, err := apiInstance.storeInstance.MachineRetirement(ctx.Request.Context(), host)
+    var hostnameError *hostname.Error
+    if errors.As(err, &hostnameError) {
+        ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return true
+    }
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return true
+    }
+    if retirement == nil {
+        return false
+    }
+    if identity, ok := identityFrom(ctx); !ok || identity.Role == store.RoleAdmin {
+        return false
+    }
+    apiInstance.deny(ctx, host, fmt.Sprintf("%s was decommissioned on %s; only admins may read its secrets",
+        host, retirement.RetiredAt.UTC().Format(time.RFC3339)))
+    return true
+}
+
+// writeToRetired answers 410 when err says the machine written to has been
+// decommissioned.
+func writeToRetired(ctx *gin.Context, err error) bool {
+    if !errors.Is(err, store.ErrMachineRetired) {
+        return false
+    }
+    ctx.JSON(http.StatusGone, gin.H{"error": err.Error()})
+    return true
+}
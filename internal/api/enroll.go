@@ -0,0 +1,130 @@
+// internal/api/enroll.go
+package api
+
+import (
+    "errors"
+    "net/http"
+
+    "github.com/gin-gonic/gin"
+    "github.com/jottavia/SHIPS2-Go/internal/store"
+)
+
+// EnrollRequest is the JSON payload of POST /api/v1/enroll. With CSR (a PEM
+// certificate signing request) the machine receives a client certificate
+// from the built-in CA instead of an API token.
+type EnrollRequest struct {
+    Hostname        string `json:"host" binding:"required"`
+    EnrollmentToken string `json:"enrollment_token" binding:"required"`
+    CSR             string `json:"csr"`
+}
+
+// RenewRequest is the JSON payload of POST /api/v1/certificates/renew.
+type RenewRequest struct {
+    CSR string `json:"csr" binding:"required"`
+}
+
+// enroll exchanges a one-time enrollment token for a machine credential.
+// It is registered outside the token middleware because the machine has no
+// credential yet; the enrollment token is its proof of authorisation.
+func (apiInstance *API) enroll(ctx *gin.Context) {
+    var req EnrollRequest
+    if err := ctx.ShouldBindJSON(&req); err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    if req.CSR != "" {
+        issued, err := apiInstance.storeInstance.EnrollMachineCertificate(
+            ctx.Request.Context(),
+            req.EnrollmentToken,
+            req.Hostname,
+            req.CSR,
+            getRemoteAddr(ctx),
+        )
+        if err != nil {
+            certificateError(ctx, err)
+            return
+        }
+        ctx.JSON(http.StatusOK, certificateResponse("enrolled", issued))
+        return
+    }
+
+    credential, err := apiInstance.storeInstance.EnrollMachine(
+        ctx.Request.Context(),
+        req.EnrollmentToken,
+        req.Hostname,
+        getRemoteAddr(ctx),
+    )
+    if errors.Is(err, store.ErrInvalidEnrollmentToken) {
+        ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+        return
+    }
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+
+    ctx.JSON(http.StatusOK, gin.H{
+        "status": "enrolled",
+        "host":   req.Hostname,
+        "token":  credential,
+    })
+}
+
+// renewCertificate issues a machine a new client certificate in exchange for
+// the still-valid one it authenticated with, which is revoked.
+func (apiInstance *API) renewCertificate(ctx *gin.Context) {
+    identity, ok := identityFrom(ctx)
+    if !ok || identity.CertSerial == "" || identity.Hostname == "" {
+        ctx.JSON(http.StatusUnauthorized, gin.H{
+            "error": "renewal requires the current machine client certificate",
+        })
+        return
+    }
+    var req RenewRequest
+    if err := ctx.ShouldBindJSON(&req); err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    issued, err := apiInstance.storeInstance.RenewMachineCertificate(
+        ctx.Request.Context(),
+        identity.CertSerial,
+        identity.Hostname,
+        req.CSR,
+        getRemoteAddr(ctx),
+    )
+    if err != nil {
+        certificateError(ctx, err)
+        return
+    }
+    ctx.JSON(http.StatusOK, certificateResponse("renewed", issued))
+}
+
+// certificateResponse is the reply to a successful certificate enrollment
+// or renewal.
+func certificateResponse(status string, issued *store.IssuedCertificate) gin.H {
+    return gin.H{
+        "status":         status,
+        "host":           issued.Hostname,
+        "serial":         issued.Serial,
+        "not_after":      issued.NotAfter,
+        "certificate":    issued.CertificatePEM,
+        "ca_certificate": issued.CAPEM,
+    }
+}
+
+// certificateError maps errors of the certificate store methods to a status.
+func certificateError(ctx *gin.Context, err error) {
+    status := http.StatusInternalServerError
+    switch {
+    case errors.Is(err, store.ErrInvalidEnrollmentToken),
+        errors.Is(err, store.ErrInvalidCertificate):
+        status = http.StatusForbidden
+    case errors.Is(err, store.ErrInvalidCSR):
+        status = http.StatusBadRequest
+    case errors.Is(err, store.ErrNoCA):
+        status = http.StatusServiceUnavailable
+    }
+    ctx.JSON(status, gin.H{"error": err.Error()})
+}
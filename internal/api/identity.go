@@ -0,0 +1,57 @@
+// internal/api/identity.go
+package api
+
+import (
+    "errors"
+    "net/http"
+
+    "github.com/gin-gonic/gin"
+    "github.com/jottavia/SHIPS2-Go/internal/store"
+)
+
+// WithIdentityMismatch sets what happens when a hostname is written with a
+// machine identity other than the one on record: store.IdentityReject
+// (the default) or store.IdentityNewGeneration. It must be called before
+// Register.
+func (apiInstance *API) WithIdentityMismatch(mode string) *API {
+    apiInstance.identityMismatch = mode
+    return apiInstance
+}
+
+// claimIdentity checks the machine identity reported with a write to host
+// before the write happens, so that a reimaged machine reusing a hostname
+// never inherits the old machine's secrets. Writes without an identity are
+// checked too, except by authenticated operators and admins, who write for
+// other machines. It returns false when the request has been answered: 409
+// for a rejected mismatch, 400 for an identity that is not well formed.
+func (apiInstance *API) claimIdentity(ctx *gin.Context, host, identity, actor string) bool {
+    if caller, ok := identityFrom(ctx); ok && identity == "" &&
+        (caller.Role == store.RoleOperator || caller.Role == store.RoleAdmin) {
+        return true
+    }
+    settings := store.IdentitySettings{
+        OnMismatch: apiInstance.identityMismatch,
+        Retention:  apiInstance.retention,
+    }
+    if _, err := store.NormalizeMachineIdentity(identity); err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return false
+    }
+    err := apiInstance.storeInstance.ClaimMachineIdentity(
+        ctx.Request.Context(),
+        host,
+        identity,
+        settings,
+        actor,
+        getRemoteAddr(ctx),
+    )
+    switch {
+    case errors.Is(err, store.ErrIdentityMismatch):
+        ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+        return false
+    case err != nil:
+        ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return false
+    }
+    return true
+}
@@ -0,0 +1,130 @@
+// internal/api/lease.go
+package api
+
+import (
+    "errors"
+    "net/http"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/jottavia/SHIPS2-Go/internal/store"
+)
+
+// DefaultLeaseDuration is how long a checkout lasts when the request does
+// not say.
+const DefaultLeaseDuration = time.Hour
+
+// CheckoutRequest is the optional JSON payload of
+// POST /api/v1/password/:host/checkout. Duration is a Go duration or a
+// number of days ("2h", "1d").
+type CheckoutRequest struct {
+    Duration string `json:"duration"`
+    Actor    string `json:"actor"`
+}
+
+// checkoutPassword leases the password of a host exclusively to the caller
+// and returns it.
+func (apiInstance *API) checkoutPassword(ctx *gin.Context) {
+    var req CheckoutRequest
+    if ctx.Request.ContentLength != 0 {
+        if err := ctx.ShouldBindJSON(&req); err != nil {
+            ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+    }
+    duration := DefaultLeaseDuration
+    if req.Duration != "" {
+        var err error
+        if duration, err = parseDurationParam(req.Duration); err != nil {
+            ctx.JSON(http.StatusBadRequest, gin.H{"error": "duration: " + err.Error()})
+            return
+        }
+    }
+    if duration <= 0 || duration > store.MaxLeaseDuration {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "error": "duration must be positive and at most " + store.MaxLeaseDuration.String(),
+        })
+        return
+    }
+
+    hostname := ctx.Param("host")
+    actor := requestActor(ctx, req.Actor)
+    approval, answered := apiInstance.awaitingApproval(ctx, hostname, store.SecretPassword, actor)
+    if answered {
+        return
+    }
+    lease, info, err := apiInstance.storeInstance.CheckoutPassword(
+        ctx.Request.Context(),
+        hostname,
+        duration,
+        actor,
+        getRemoteAddr(ctx),
+    )
+    if leaseConflict(ctx, err) || writeToRetired(ctx, err) {
+        return
+    }
+    if err != nil {
+        ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+        return
+    }
+    if apiInstance.useApprovals(ctx, approval) {
+        return
+    }
+
+    ctx.Header("Cache-Control", "no-store")
+    ctx.JSON(http.StatusOK, gin.H{
+        "hostname":   hostname,
+        "password":   info.Password,
+        "rotated_at": info.RotatedAt,
+        "actor":      info.Actor,
+        "lease":      lease,
+    })
+}
+
+// checkinPassword ends the caller's lease on the password of a host early.
+func (apiInstance *API) checkinPassword(ctx *gin.Context) {
+    var req CheckoutRequest
+    if ctx.Request.ContentLength != 0 {
+        if err := ctx.ShouldBindJSON(&req); err != nil {
+            ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+    }
+    hostname := ctx.Param("host")
+    actor := requestActor(ctx, req.Actor)
+
+    err := apiInstance.storeInstance.CheckinPassword(
+        ctx.Request.Context(),
+        hostname,
+        actor,
+        getRemoteAddr(ctx),
+    )
+    if errors.Is(err, store.ErrNoLease) || errors.Is(err, store.ErrUnknownMachine) {
+        ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+        return
+    }
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+
+    ctx.JSON(http.StatusOK, gin.H{
+        "status":   "checked_in",
+        "hostname": hostname,
+        "actor":    actor,
+    })
+}
+
+// leaseConflict answers 409 with the lease when err says the password is
+// checked out by someone else.
+func leaseConflict(ctx *gin.Context, err error) bool {
+    var leaseErr *store.LeaseError
+    if !errors.As(err, &leaseErr) {
+        return false
+    }
+    ctx.JSON(http.StatusConflict, gin.H{
+        "error": err.Error(),
+        "lease": leaseErr.Lease,
+    })
+    return true
+}
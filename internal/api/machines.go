@@ -0,0 +1,50 @@
+// internal/api/machines.go
+package api
+
+import (
+    "errors"
+    "net/http"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+    "github.com/jottavia/SHIPS2-Go/internal/store"
+)
+
+// listMachines serves GET /api/v1/machines. Query parameters: q (substring
+// of the hostname), prefix, sort (hostname, first_seen, last_rotation,
+// last_key_update or last_fetch; a leading "-" sorts newest first), limit
+// and cursor (the next_cursor value of the previous page).
+func (apiInstance *API) listMachines(ctx *gin.Context) {
+    filter := store.MachineFilter{
+        Search: ctx.Query("q"),
+        Prefix: ctx.Query("prefix"),
+        Cursor: ctx.Query("cursor"),
+    }
+    filter.Sort, filter.Descending = strings.CutPrefix(ctx.Query("sort"), "-")
+    var err error
+    if filter.Limit, err = parseIntParam(ctx.Query("limit")); err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{"error": "limit: " + err.Error()})
+        return
+    }
+
+    machines, nextCursor, err := apiInstance.storeInstance.ListMachines(
+        ctx.Request.Context(),
+        filter,
+    )
+    switch {
+    case errors.Is(err, store.ErrInvalidCursor):
+        ctx.JSON(http.StatusBadRequest, gin.H{"error": "cursor: " + err.Error()})
+        return
+    case errors.Is(err, store.ErrUnknownSort):
+        ctx.JSON(http.StatusBadRequest, gin.H{"error": "sort: " + err.Error()})
+        return
+    case err != nil:
+        ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+
+    ctx.JSON(http.StatusOK, gin.H{
+        "machines":    machines,
+        "next_cursor": nextCursor,
+    })
+}
@@ -0,0 +1,37 @@
+// internal/api/mtls.go
+package api
+
+import (
+    "net/http"
+)
+
+// certificateIdentity derives the caller from a TLS client certificate that
+// the listener verified against the configured client CA. A certificate
+// carrying DNS names is a machine certificate: its first DNS name is the
+// only host it may write, and its common name (or that DNS name) is the
+// actor. Any other certificate identifies a person by common name, or
+// e-mail address, whose role comes from role_assignments.
+func certificateIdentity(request *http.Request) (*Identity, bool) {
+    if request.TLS == nil || len(request.TLS.VerifiedChains) == 0 ||
+        len(request.TLS.VerifiedChains[0]) == 0 {
+        return nil, false
+    }
+    certificate := request.TLS.VerifiedChains[0][0]
+
+    identity := &Identity{
+        Name:       certificate.Subject.CommonName,
+        CertSerial: certificate.SerialNumber.Text(16),
+    }
+    if len(certificate.DNSNames) > 0 {
+        identity.Hostname = certificate.DNSNames[0]
+        if identity.Name == "" {
+            identity.Name = identity.Hostname
+        }
+    } else if identity.Name == "" && len(certificate.EmailAddresses) > 0 {
+        identity.Name = certificate.EmailAddresses[0]
+    }
+    if identity.Name == "" {
+        return nil, false
+    }
+    return identity, true
+}
@@ -0,0 +1,53 @@
+// internal/api/rename.go
+package api
+
+import (
+    "errors"
+    "net/http"
+
+    "github.com/gin-gonic/gin"
+    "github.com/jottavia/SHIPS2-Go/internal/hostname"
+    "github.com/jottavia/SHIPS2-Go/internal/store"
+)
+
+// RenameRequest is the JSON payload of POST /api/v1/machines/:host/rename.
+type RenameRequest struct {
+    Hostname string `json:"new_host" binding:"required"`
+    Actor    string `json:"actor"`
+}
+
+// renameMachine moves a machine, with all its secrets and history, to a new
+// hostname.
+func (apiInstance *API) renameMachine(ctx *gin.Context) {
+    var req RenameRequest
+    if err := ctx.ShouldBindJSON(&req); err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    newHost, err := apiInstance.storeInstance.CanonicalHostname(req.Hostname)
+    if err == nil {
+        err = apiInstance.storeInstance.RenameMachine(
+            ctx.Request.Context(),
+            ctx.Param("host"),
+            newHost,
+            requestActor(ctx, req.Actor),
+            getRemoteAddr(ctx),
+        )
+    }
+    var hostnameError *hostname.Error
+    switch {
+    case errors.As(err, &hostnameError):
+        ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    case errors.Is(err, store.ErrUnknownMachine):
+        ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+        return
+    case errors.Is(err, store.ErrHostnameTaken):
+        ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+        return
+    case err != nil:
+        ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    ctx.JSON(http.StatusOK, gin.H{"old_host": ctx.Param("host"), "hostname": newHost})
+}
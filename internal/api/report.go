@@ -0,0 +1,78 @@
+// internal/api/report.go
+package api
+
+import (
+    "bytes"
+    "encoding/csv"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+)
+
+// DefaultComplianceMaxAge is how recently a password must have been rotated
+// for GET /api/v1/reports/compliance when max_age is not given.
+const DefaultComplianceMaxAge = 30 * 24 * time.Hour
+
+// complianceReport serves GET /api/v1/reports/compliance. Query parameters:
+// max_age ("30d", "720h"; must be positive) and format (json or csv).
+func (apiInstance *API) complianceReport(ctx *gin.Context) {
+    maxAge := DefaultComplianceMaxAge
+    if value := ctx.Query("max_age"); value != "" {
+        var err error
+        if maxAge, err = parseDurationParam(value); err != nil {
+            ctx.JSON(http.StatusBadRequest, gin.H{"error": "max_age: " + err.Error()})
+            return
+        }
+        if maxAge <= 0 {
+            ctx.JSON(http.StatusBadRequest, gin.H{"error": "max_age: must be positive"})
+            return
+        }
+    }
+    format := ctx.DefaultQuery("format", "json")
+    if format != "json" && format != "csv" {
+        ctx.JSON(http.StatusBadRequest, gin.H{"error": "format: expected json or csv"})
+        return
+    }
+
+    report, err := apiInstance.storeInstance.ComplianceReport(ctx.Request.Context(), maxAge)
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    if format == "json" {
+        ctx.JSON(http.StatusOK, report)
+        return
+    }
+
+    var buffer bytes.Buffer
+    writer := csv.NewWriter(&buffer)
+    writer.Write([]string{"hostname", "compliant", "issues", "last_rotation", "last_key_update"})
+    for _, entry := range report.Machines {
+        writer.Write([]string{
+            entry.Hostname,
+            strconv.FormatBool(entry.Compliant),
+            strings.Join(entry.Issues, ";"),
+            formatReportTime(entry.LastRotation),
+            formatReportTime(entry.LastKeyUpdate),
+        })
+    }
+    // csv.Writer keeps the first write error for Error.
+    writer.Flush()
+    if err := writer.Error(); err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    ctx.Header("Content-Disposition", `attachment; filename="compliance.csv"`)
+    ctx.Data(http.StatusOK, "text/csv; charset=utf-8", buffer.Bytes())
+}
+
+// formatReportTime formats t as RFC 3339, or returns "" for the zero time.
+func formatReportTime(t time.Time) string {
+    if t.IsZero() {
+        return ""
+    }
+    return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,85 @@
+// internal/bitlocker/recoverykey.go
+//
+// Package bitlocker validates BitLocker recovery passwords. It is shared by
+// the server, which refuses to escrow malformed keys, and shipsc, which runs
+// the same check before sending anything.
+package bitlocker
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// groupCount and groupLength describe the 48-digit recovery password,
+	// printed by manage-bde as eight dash-separated groups of six digits.
+	groupCount  = 8
+	groupLength = 6
+	// groupLimit bounds each group: it encodes 16 bits of key material
+	// multiplied by 11, so valid values are below 65536 * 11.
+	groupLimit = 65536 * 11
+)
+
+// KeyError describes why a recovery password was rejected. Group is the
+// 1-based group at fault, or 0 when the overall format is wrong.
+type KeyError struct {
+	Group  int
+	Reason string
+}
+
+func (keyError *KeyError) Error() string {
+	if keyError.Group == 0 {
+		return "invalid recovery key: " + keyError.Reason
+	}
+	return fmt.Sprintf("invalid recovery key: group %d %s", keyError.Group, keyError.Reason)
+}
+
+// NormalizeRecoveryKey validates key and returns it in the canonical
+// dash-separated form. Surrounding whitespace is ignored and the 48 digits
+// may also be given without dashes. Errors are of type *KeyError.
+func NormalizeRecoveryKey(key string) (string, error) {
+	key = strings.TrimSpace(key)
+	var groups []string
+	if strings.Contains(key, "-") {
+		groups = strings.Split(key, "-")
+	} else {
+		if len(key) != groupCount*groupLength {
+			return "", &KeyError{Reason: fmt.Sprintf(
+				"expected %d groups of %d digits", groupCount, groupLength)}
+		}
+		for i := 0; i < len(key); i += groupLength {
+			groups = append(groups, key[i:i+groupLength])
+		}
+	}
+	if len(groups) != groupCount {
+		return "", &KeyError{Reason: fmt.Sprintf(
+			"expected %d groups of %d digits, got %d groups", groupCount, groupLength, len(groups))}
+	}
+
+	for i, group := range groups {
+		if len(group) != groupLength || strings.Trim(group, "0123456789") != "" {
+			return "", &KeyError{Group: i + 1, Reason: fmt.Sprintf(
+				"%q is not %d digits", group, groupLength)}
+		}
+		value, err := strconv.Atoi(group)
+		if err != nil {
+			return "", &KeyError{Group: i + 1, Reason: err.Error()}
+		}
+		if value%11 != 0 {
+			return "", &KeyError{Group: i + 1, Reason: fmt.Sprintf(
+				"%s fails the checksum (not divisible by 11)", group)}
+		}
+		if value >= groupLimit {
+			return "", &KeyError{Group: i + 1, Reason: fmt.Sprintf(
+				"%s is out of range (must be below %d)", group, groupLimit)}
+		}
+	}
+	return strings.Join(groups, "-"), nil
+}
+
+// ValidateRecoveryKey reports whether key is a well-formed recovery password.
+func ValidateRecoveryKey(key string) error {
+	_, err := NormalizeRecoveryKey(key)
+	return err
+}
@@ -0,0 +1,230 @@
+// internal/hostname/hostname.go
+//
+// Package hostname validates hostnames against RFC 1123 and brings them
+// into the canonical form machines are stored under, so that WINBOX01,
+// winbox01 and winbox01.corp.local can all name the same machine.
+package hostname
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// Case foldings a Policy can apply.
+const (
+	CaseUpper    = "upper"
+	CaseLower    = "lower"
+	CasePreserve = "preserve"
+)
+
+// Length limits of RFC 1123.
+const (
+	maxLength      = 253
+	maxLabelLength = 63
+)
+
+// Environment variables read by FromEnv.
+const (
+	envCase        = "SHIPS_HOSTNAME_CASE"
+	envStripDomain = "SHIPS_HOSTNAME_STRIP_DOMAIN"
+	envIDNA        = "SHIPS_HOSTNAME_IDNA"
+	envUnderscore  = "SHIPS_HOSTNAME_UNDERSCORE"
+)
+
+// GenerationSeparator joins a hostname and the generation a machine was
+// retired as when another machine took over its name, as in PC01~2. It
+// cannot occur in a valid hostname, so such names never collide with
+// machines in service.
+const GenerationSeparator = "~"
+
+// Error describes why a hostname was rejected.
+type Error struct {
+	Name   string
+	Reason string
+}
+
+func (hostnameError *Error) Error() string {
+	return fmt.Sprintf("invalid hostname %q: %s", hostnameError.Name, hostnameError.Reason)
+}
+
+// Policy describes the canonical form of a hostname. Case folds it to upper
+// or lower case or leaves it alone; StripDomain keeps only the first label,
+// so FQDNs name the same machine as their short name; IDNA converts
+// internationalised names to their ASCII (punycode) form instead of
+// rejecting them; AllowUnderscore accepts "_" in labels, which RFC 1123
+// forbids but legacy NetBIOS computer names such as LAB_1 contain.
+type Policy struct {
+	Case            string `json:"case"`
+	StripDomain     bool   `json:"strip_domain"`
+	IDNA            bool   `json:"idna"`
+	AllowUnderscore bool   `json:"allow_underscore"`
+}
+
+// Default returns the policy used when nothing is configured: upper case,
+// as Windows shows computer names, domains kept, IDNA enabled and strict
+// RFC 1123 labels, so NetBIOS names with "_" need SHIPS_HOSTNAME_UNDERSCORE.
+func Default() Policy {
+	return Policy{Case: CaseUpper, IDNA: true}
+}
+
+// FromEnv returns Default adjusted by SHIPS_HOSTNAME_CASE (upper, lower or
+// preserve), SHIPS_HOSTNAME_STRIP_DOMAIN, SHIPS_HOSTNAME_IDNA and
+// SHIPS_HOSTNAME_UNDERSCORE. The result is validated.
+func FromEnv() (Policy, error) {
+	policy := Default()
+	if value := os.Getenv(envCase); value != "" {
+		policy.Case = strings.ToLower(value)
+	}
+	if value := os.Getenv(envStripDomain); value != "" {
+		strip, err := strconv.ParseBool(value)
+		if err != nil {
+			return Policy{}, fmt.Errorf("%s: %w", envStripDomain, err)
+		}
+		policy.StripDomain = strip
+	}
+	if value := os.Getenv(envIDNA); value != "" {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return Policy{}, fmt.Errorf("%s: %w", envIDNA, err)
+		}
+		policy.IDNA = enabled
+	}
+	if value := os.Getenv(envUnderscore); value != "" {
+		allowed, err := strconv.ParseBool(value)
+		if err != nil {
+			return Policy{}, fmt.Errorf("%s: %w", envUnderscore, err)
+		}
+		policy.AllowUnderscore = allowed
+	}
+	return policy, policy.Validate()
+}
+
+// Validate checks that the policy names a known case folding.
+func (policy Policy) Validate() error {
+	switch policy.Case {
+	case CaseUpper, CaseLower, CasePreserve:
+		return nil
+	}
+	return fmt.Errorf("hostname case must be %s, %s or %s, got %q",
+		CaseUpper, CaseLower, CasePreserve, policy.Case)
+}
+
+// String describes the policy for the startup log.
+func (policy Policy) String() string {
+	description := policy.Case + " case"
+	if policy.StripDomain {
+		description += ", domain stripped"
+	}
+	if policy.IDNA {
+		description += ", IDNA"
+	}
+	if policy.AllowUnderscore {
+		description += ", underscores allowed"
+	}
+	return description
+}
+
+// Canonical returns name in the canonical form of policy, or an *Error if
+// the result is not a valid RFC 1123 hostname (allowing underscores if the
+// policy does). A trailing dot is ignored. The name of a retired generation
+// keeps its suffix, so pc01~2 becomes PC01~2 under the default policy.
+func (policy Policy) Canonical(name string) (string, error) {
+	base, generation := SplitGeneration(name)
+	canonical := strings.TrimSuffix(base, ".")
+	if policy.IDNA && !isASCII(canonical) {
+		ascii, err := idna.Lookup.ToASCII(canonical)
+		if err != nil {
+			return "", &Error{Name: name, Reason: err.Error()}
+		}
+		canonical = ascii
+	}
+	if policy.StripDomain {
+		canonical, _, _ = strings.Cut(canonical, ".")
+	}
+	switch policy.Case {
+	case CaseUpper:
+		canonical = strings.ToUpper(canonical)
+	case CaseLower:
+		canonical = strings.ToLower(canonical)
+	}
+	if err := validate(canonical, policy.AllowUnderscore); err != nil {
+		return "", &Error{Name: name, Reason: err.Reason}
+	}
+	if generation > 0 {
+		canonical += GenerationSeparator + strconv.Itoa(generation)
+	}
+	return canonical, nil
+}
+
+// SplitGeneration splits the name of a retired generation such as PC01~2
+// into the hostname and the generation. Other names are returned as they
+// are with generation 0.
+func SplitGeneration(name string) (string, int) {
+	base, suffix, found := strings.Cut(name, GenerationSeparator)
+	if !found {
+		return name, 0
+	}
+	generation, err := strconv.Atoi(suffix)
+	if err != nil || generation < 1 || strconv.Itoa(generation) != suffix {
+		return name, 0
+	}
+	return base, generation
+}
+
+// Validate checks name against RFC 1123: at most 253 characters of
+// dot-separated labels, each 1 to 63 letters, digits and hyphens that
+// neither start nor end with a hyphen. Errors are of type *Error.
+func Validate(name string) error {
+	if err := validate(name, false); err != nil {
+		return err
+	}
+	return nil
+}
+
+// validate is Validate, optionally accepting underscores in labels.
+func validate(name string, allowUnderscore bool) *Error {
+	if name == "" {
+		return &Error{Name: name, Reason: "empty"}
+	}
+	if len(name) > maxLength {
+		return &Error{Name: name, Reason: fmt.Sprintf("longer than %d characters", maxLength)}
+	}
+	for _, label := range strings.Split(name, ".") {
+		switch {
+		case label == "":
+			return &Error{Name: name, Reason: "empty label"}
+		case len(label) > maxLabelLength:
+			return &Error{Name: name, Reason: fmt.Sprintf("label %q longer than %d characters",
+				label, maxLabelLength)}
+		case label[0] == '-' || label[len(label)-1] == '-':
+			return &Error{Name: name, Reason: fmt.Sprintf("label %q starts or ends with a hyphen",
+				label)}
+		}
+		for _, character := range label {
+			if !isLetterDigitHyphen(character) && !(allowUnderscore && character == '_') {
+				return &Error{Name: name, Reason: fmt.Sprintf("character %q not allowed", character)}
+			}
+		}
+	}
+	return nil
+}
+
+// isLetterDigitHyphen reports whether character may appear in a label.
+func isLetterDigitHyphen(character rune) bool {
+	return character >= 'a' && character <= 'z' || character >= 'A' && character <= 'Z' ||
+		character >= '0' && character <= '9' || character == '-'
+}
+
+// isASCII reports whether name needs no IDNA conversion.
+func isASCII(name string) bool {
+	for index := 0; index < len(name); index++ {
+		if name[index] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
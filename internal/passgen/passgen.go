@@ -0,0 +1,293 @@
+// internal/passgen/passgen.go
+//
+// Package passgen generates passwords from crypto/rand according to a
+// policy: either a string of characters drawn from chosen classes, or a
+// passphrase of words from a wordlist.
+package passgen
+
+import (
+	"bufio"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Character classes a generated password can draw from.
+const (
+	ClassLower  = "lower"
+	ClassUpper  = "upper"
+	ClassDigit  = "digit"
+	ClassSymbol = "symbol"
+)
+
+// classCharacters lists the characters of each class. The symbols avoid
+// quotes, backslashes and spaces, which are awkward to type or paste into
+// shells and logon dialogs.
+var classCharacters = map[string]string{
+	ClassLower:  "abcdefghijklmnopqrstuvwxyz",
+	ClassUpper:  "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	ClassDigit:  "0123456789",
+	ClassSymbol: "!#$%&*+-=?@^_~",
+}
+
+// ambiguousCharacters are easily confused when a password is read aloud or
+// typed from a screen.
+const ambiguousCharacters = "0O1lI|o"
+
+// minPassphraseBits is the entropy a passphrase policy must reach.
+const minPassphraseBits = 64
+
+// Environment variables read by FromEnv.
+const (
+	envLength           = "SHIPS_PASSWORD_LENGTH"
+	envClasses          = "SHIPS_PASSWORD_CLASSES"
+	envExcludeAmbiguous = "SHIPS_PASSWORD_EXCLUDE_AMBIGUOUS"
+	envWordlist         = "SHIPS_PASSWORD_WORDLIST"
+	envWords            = "SHIPS_PASSWORD_WORDS"
+	envSeparator        = "SHIPS_PASSWORD_SEPARATOR"
+)
+
+// Policy describes the passwords Generate produces. With Wordlist set it
+// produces a passphrase of Words words joined by Separator; otherwise
+// Length characters containing at least one character of every class in
+// Classes.
+type Policy struct {
+	Length           int
+	Classes          []string
+	ExcludeAmbiguous bool
+
+	Wordlist  []string
+	Words     int
+	Separator string
+}
+
+// Default returns the policy used when nothing is configured: 20 characters
+// of all four classes without ambiguous characters.
+func Default() Policy {
+	return Policy{
+		Length:           20,
+		Classes:          []string{ClassLower, ClassUpper, ClassDigit, ClassSymbol},
+		ExcludeAmbiguous: true,
+		Words:            5,
+		Separator:        "-",
+	}
+}
+
+// FromEnv returns Default adjusted by SHIPS_PASSWORD_LENGTH,
+// SHIPS_PASSWORD_CLASSES (comma-separated class names),
+// SHIPS_PASSWORD_EXCLUDE_AMBIGUOUS, and for passphrases
+// SHIPS_PASSWORD_WORDLIST (a file with one word per line),
+// SHIPS_PASSWORD_WORDS and SHIPS_PASSWORD_SEPARATOR. The result is validated.
+func FromEnv() (Policy, error) {
+	policy := Default()
+	if value := os.Getenv(envLength); value != "" {
+		length, err := strconv.Atoi(value)
+		if err != nil {
+			return Policy{}, fmt.Errorf("%s: %w", envLength, err)
+		}
+		policy.Length = length
+	}
+	if value := os.Getenv(envClasses); value != "" {
+		policy.Classes = nil
+		for _, class := range strings.Split(value, ",") {
+			policy.Classes = append(policy.Classes, strings.TrimSpace(class))
+		}
+	}
+	if value := os.Getenv(envExcludeAmbiguous); value != "" {
+		exclude, err := strconv.ParseBool(value)
+		if err != nil {
+			return Policy{}, fmt.Errorf("%s: %w", envExcludeAmbiguous, err)
+		}
+		policy.ExcludeAmbiguous = exclude
+	}
+	if path := os.Getenv(envWordlist); path != "" {
+		words, err := LoadWordlist(path)
+		if err != nil {
+			return Policy{}, err
+		}
+		policy.Wordlist = words
+	}
+	if value := os.Getenv(envWords); value != "" {
+		count, err := strconv.Atoi(value)
+		if err != nil {
+			return Policy{}, fmt.Errorf("%s: %w", envWords, err)
+		}
+		policy.Words = count
+	}
+	if value, ok := os.LookupEnv(envSeparator); ok {
+		policy.Separator = value
+	}
+	return policy, policy.Validate()
+}
+
+// LoadWordlist reads one word per line from path, skipping blank lines,
+// comments and duplicates. Lines of a diceware list ("11111 word") are
+// reduced to the word.
+func LoadWordlist(path string) ([]string, error) {
+	file, err := os.Open(path) // #nosec G304 – path comes from operator config
+	if err != nil {
+		return nil, fmt.Errorf("reading wordlist: %w", err)
+	}
+	defer file.Close()
+
+	seen := map[string]bool{}
+	words := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		word := fields[len(fields)-1]
+		if !seen[word] {
+			seen[word] = true
+			words = append(words, word)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading wordlist: %w", err)
+	}
+	return words, nil
+}
+
+// Validate reports whether policy can produce passwords.
+func (policy Policy) Validate() error {
+	if len(policy.Wordlist) > 0 {
+		if len(policy.Wordlist) < 2 || policy.Words < 1 {
+			return errors.New("passphrase policy needs at least two words in the list and one per passphrase")
+		}
+		if bits := policy.Bits(); bits < minPassphraseBits {
+			return fmt.Errorf("passphrase of %d words from %d has %.0f bits of entropy; at least %d are required",
+				policy.Words, len(policy.Wordlist), bits, minPassphraseBits)
+		}
+		return nil
+	}
+	if len(policy.Classes) == 0 {
+		return errors.New("password policy needs at least one character class")
+	}
+	seen := map[string]bool{}
+	for _, class := range policy.Classes {
+		if _, ok := classCharacters[class]; !ok {
+			return fmt.Errorf("unknown character class %q (want %s, %s, %s or %s)",
+				class, ClassLower, ClassUpper, ClassDigit, ClassSymbol)
+		}
+		if seen[class] {
+			return fmt.Errorf("character class %q listed twice", class)
+		}
+		seen[class] = true
+	}
+	if policy.Length < 8 || policy.Length > 128 {
+		return fmt.Errorf("password length %d is outside 8-128", policy.Length)
+	}
+	if policy.Length < len(policy.Classes) {
+		return fmt.Errorf("password length %d cannot hold %d character classes",
+			policy.Length, len(policy.Classes))
+	}
+	return nil
+}
+
+// Bits returns the entropy of a password generated by policy, ignoring the
+// small loss from requiring every class.
+func (policy Policy) Bits() float64 {
+	if len(policy.Wordlist) > 0 {
+		return float64(policy.Words) * math.Log2(float64(len(policy.Wordlist)))
+	}
+	return float64(policy.Length) * math.Log2(float64(len(policy.alphabet())))
+}
+
+// String describes policy for logs, without the wordlist itself.
+func (policy Policy) String() string {
+	if len(policy.Wordlist) > 0 {
+		return fmt.Sprintf("passphrase of %d words from a list of %d (%.0f bits)",
+			policy.Words, len(policy.Wordlist), policy.Bits())
+	}
+	ambiguous := ""
+	if policy.ExcludeAmbiguous {
+		ambiguous = ", no ambiguous characters"
+	}
+	return fmt.Sprintf("%d characters of %s%s (%.0f bits)", policy.Length,
+		strings.Join(policy.Classes, ","), ambiguous, policy.Bits())
+}
+
+// Generate returns a new password according to policy.
+func Generate(policy Policy) (string, error) {
+	if err := policy.Validate(); err != nil {
+		return "", err
+	}
+	if len(policy.Wordlist) > 0 {
+		words := make([]string, policy.Words)
+		for i := range words {
+			index, err := randomIndex(len(policy.Wordlist))
+			if err != nil {
+				return "", err
+			}
+			words[i] = policy.Wordlist[index]
+		}
+		return strings.Join(words, policy.Separator), nil
+	}
+
+	alphabet := policy.alphabet()
+	password := make([]byte, policy.Length)
+	// Draw uniformly from the whole alphabet and start over until every
+	// class is present; this keeps the distribution uniform over all
+	// acceptable passwords.
+	for {
+		for i := range password {
+			index, err := randomIndex(len(alphabet))
+			if err != nil {
+				return "", err
+			}
+			password[i] = alphabet[index]
+		}
+		if policy.hasAllClasses(password) {
+			return string(password), nil
+		}
+	}
+}
+
+// alphabet returns the characters policy draws from.
+func (policy Policy) alphabet() string {
+	var builder strings.Builder
+	for _, class := range policy.Classes {
+		builder.WriteString(policy.classAlphabet(class))
+	}
+	return builder.String()
+}
+
+// classAlphabet returns the characters of class allowed by policy.
+func (policy Policy) classAlphabet(class string) string {
+	characters := classCharacters[class]
+	if !policy.ExcludeAmbiguous {
+		return characters
+	}
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(ambiguousCharacters, r) {
+			return -1
+		}
+		return r
+	}, characters)
+}
+
+// hasAllClasses reports whether password contains every class of policy.
+func (policy Policy) hasAllClasses(password []byte) bool {
+	for _, class := range policy.Classes {
+		if !strings.ContainsAny(string(password), policy.classAlphabet(class)) {
+			return false
+		}
+	}
+	return true
+}
+
+// randomIndex returns a uniformly random integer in [0, n).
+func randomIndex(n int) (int, error) {
+	index, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	return int(index.Int64()), nil
+}
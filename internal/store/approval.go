@@ -0,0 +1,540 @@
+// internal/store/approval.go
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// Secrets an approval policy or request covers.
+const (
+	SecretPassword  = "password"
+	SecretBitLocker = "bde"
+	SecretAll       = "all"
+)
+
+// States of an approval request. A pending request that nobody decided
+// before expires_at, or an approved one not used before usable_until, is
+// reported as expired.
+const (
+	ApprovalPending  = "pending"
+	ApprovalApproved = "approved"
+	ApprovalDenied   = "denied"
+	ApprovalUsed     = "used"
+	ApprovalExpired  = "expired"
+)
+
+var (
+	// ErrUnknownApproval is returned for approval requests that do not exist.
+	ErrUnknownApproval = errors.New("unknown approval request")
+	// ErrApprovalDecided is returned when deciding a request that is no
+	// longer pending.
+	ErrApprovalDecided = errors.New("approval request is no longer pending")
+	// ErrSelfApproval is returned when a requester tries to decide their own
+	// request.
+	ErrSelfApproval = errors.New("a request must be approved by someone other than the requester")
+	// ErrApprovalUsed is returned when using an approval that was already
+	// used or is no longer usable.
+	ErrApprovalUsed = errors.New("approval was already used or has expired")
+	// ErrUnknownApprovalPolicy is returned when removing a policy that does
+	// not exist.
+	ErrUnknownApprovalPolicy = errors.New("no approval policy for that pattern")
+)
+
+// ApprovalSettings tunes the two-person approval workflow. RequestTTL is how
+// long a request waits for a decision and Window how long the requester
+// then has to retrieve the secret once. With BulkThreshold set, a caller
+// who fetched that many secrets within BulkPeriod needs approval for the
+// next one on any machine.
+type ApprovalSettings struct {
+	RequestTTL    time.Duration
+	Window        time.Duration
+	BulkThreshold int
+	BulkPeriod    time.Duration
+}
+
+// DefaultApprovalSettings returns the settings used when none are
+// configured: a day to decide, an hour to retrieve, no bulk limit.
+func DefaultApprovalSettings() ApprovalSettings {
+	return ApprovalSettings{
+		RequestTTL: 24 * time.Hour,
+		Window:     time.Hour,
+		BulkPeriod: time.Hour,
+	}
+}
+
+// ApprovalPolicy makes retrieving a secret of the machines matching Pattern
+// (a hostname or a glob such as "EXEC-*", matched case-insensitively)
+// require a second person's approval.
+type ApprovalPolicy struct {
+	Pattern   string    `json:"pattern"`
+	Secret    string    `json:"secret"`
+	CreatedAt time.Time `json:"created_at"`
+	CreatedBy string    `json:"created_by"`
+}
+
+// ApprovalRequest is a request to retrieve a secret that needs approval.
+type ApprovalRequest struct {
+	ID          int64     `json:"id"`
+	Hostname    string    `json:"hostname"`
+	Secret      string    `json:"secret"`
+	Requester   string    `json:"requester"`
+	Reason      string    `json:"reason"`
+	State       string    `json:"state"`
+	RequestedAt time.Time `json:"requested_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	DecidedBy   string    `json:"decided_by"`
+	DecidedAt   time.Time `json:"decided_at"`
+	Note        string    `json:"note"`
+	UsableUntil time.Time `json:"usable_until"`
+}
+
+// ParseSecret validates the secret named by an approval policy.
+func ParseSecret(secret string) (string, error) {
+	switch secret {
+	case SecretPassword, SecretBitLocker, SecretAll:
+		return secret, nil
+	}
+	return "", fmt.Errorf("unknown secret %q (want %s, %s or %s)",
+		secret, SecretPassword, SecretBitLocker, SecretAll)
+}
+
+// SetApprovalPolicy requires approval to retrieve secret of the machines
+// matching pattern, replacing any policy for the same pattern. Audited as
+// "approval_policy_set".
+func (storeInstance *Store) SetApprovalPolicy(
+	ctx context.Context,
+	pattern, secret, actor string,
+) error {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	if pattern == "" {
+		return errors.New("pattern cannot be empty")
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	if _, err := ParseSecret(secret); err != nil {
+		return err
+	}
+
+	transaction, err := storeInstance.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer transaction.Rollback() // nolint:errcheck // no-op after Commit
+
+	now := time.Now().Unix()
+	if _, err := transaction.ExecContext(ctx,
+		`REPLACE INTO approval_policies(pattern, secret, created_at, created_by)
+         VALUES (?,?,?,?)`,
+		pattern, secret, now, actor); err != nil {
+		return err
+	}
+	entry, err := storeInstance.appendAudit(ctx, transaction, nil, "approval_policy_set",
+		actor, "local", fmt.Sprintf("%s requires approval for %s", pattern, secret), now)
+	if err != nil {
+		return err
+	}
+	if err := transaction.Commit(); err != nil {
+		return err
+	}
+	storeInstance.notifyAudit(entry)
+	return nil
+}
+
+// RemoveApprovalPolicy drops the policy for pattern. Audited as
+// "approval_policy_removed".
+func (storeInstance *Store) RemoveApprovalPolicy(ctx context.Context, pattern, actor string) error {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	transaction, err := storeInstance.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer transaction.Rollback() // nolint:errcheck // no-op after Commit
+
+	result, err := transaction.ExecContext(ctx,
+		`DELETE FROM approval_policies WHERE pattern = ?`, pattern)
+	if err != nil {
+		return err
+	}
+	if removed, err := result.RowsAffected(); err != nil {
+		return err
+	} else if removed == 0 {
+		return ErrUnknownApprovalPolicy
+	}
+	now := time.Now().Unix()
+	entry, err := storeInstance.appendAudit(ctx, transaction, nil, "approval_policy_removed",
+		actor, "local", pattern, now)
+	if err != nil {
+		return err
+	}
+	if err := transaction.Commit(); err != nil {
+		return err
+	}
+	storeInstance.notifyAudit(entry)
+	return nil
+}
+
+// ListApprovalPolicies returns every approval policy ordered by pattern.
+func (storeInstance *Store) ListApprovalPolicies(ctx context.Context) ([]ApprovalPolicy, error) {
+	rows, err := storeInstance.db.QueryContext(ctx,
+		`SELECT pattern, secret, created_at, created_by
+           FROM approval_policies ORDER BY pattern`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	policies := []ApprovalPolicy{}
+	for rows.Next() {
+		var policy ApprovalPolicy
+		var createdAt int64
+		if err := rows.Scan(&policy.Pattern, &policy.Secret, &createdAt,
+			&policy.CreatedBy); err != nil {
+			return nil, err
+		}
+		policy.CreatedAt = time.Unix(createdAt, 0)
+		policies = append(policies, policy)
+	}
+	return policies, rows.Err()
+}
+
+// AuthorizeRetrieval decides whether requester may retrieve secret of host
+// now. It returns nil when no approval is needed, or an approved, unused
+// request of the requester, which the caller must use up with UseApproval
+// once the retrieval succeeded. Otherwise it returns the pending request,
+// creating and auditing one as "approval_requested" if there is none yet.
+func (storeInstance *Store) AuthorizeRetrieval(
+	ctx context.Context,
+	host, secret, requester, remoteAddr string,
+	settings ApprovalSettings,
+) (*ApprovalRequest, error) {
+	if requester == "" {
+		requester = defaultUnknownActor
+	}
+	host, err := storeInstance.hostnames.Canonical(host)
+	if err != nil {
+		return nil, err
+	}
+	reason, err := storeInstance.approvalReason(ctx, host, secret, requester, settings)
+	if err != nil || reason == "" {
+		return nil, err
+	}
+	// The retrieval of an unknown machine fails anyway; asking for approval
+	// would only add it to the inventory.
+	machineID, err := storeInstance.existingMachineID(ctx, host)
+	if err != nil || machineID == nil {
+		return nil, err
+	}
+
+	transaction, err := storeInstance.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer transaction.Rollback() // nolint:errcheck // no-op after Commit
+
+	now := time.Now().Unix()
+	request, err := scanApprovalRequest(transaction.QueryRowContext(ctx,
+		approvalRequestQuery+`
+          WHERE r.machine_id = ? AND r.secret = ? AND r.requester = ?
+            AND r.state = ? AND r.usable_until > ?
+          ORDER BY r.id LIMIT 1`,
+		machineID, secret, requester, ApprovalApproved, now))
+	if err == nil {
+		return request, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	request, err = scanApprovalRequest(transaction.QueryRowContext(ctx,
+		approvalRequestQuery+`
+          WHERE r.machine_id = ? AND r.secret = ? AND r.requester = ?
+            AND r.state = ? AND r.expires_at > ?
+          ORDER BY r.id LIMIT 1`,
+		machineID, secret, requester, ApprovalPending, now))
+	if err == nil {
+		return request, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	request = &ApprovalRequest{
+		Hostname:    host,
+		Secret:      secret,
+		Requester:   requester,
+		Reason:      reason,
+		State:       ApprovalPending,
+		RequestedAt: time.Unix(now, 0),
+		ExpiresAt:   time.Unix(now, 0).Add(settings.RequestTTL),
+	}
+	result, err := transaction.ExecContext(ctx,
+		`INSERT INTO approval_requests(machine_id, secret, requester, reason, state,
+                                       requested_at, expires_at)
+         VALUES (?,?,?,?,?,?,?)`,
+		machineID, secret, requester, reason, ApprovalPending, now,
+		request.ExpiresAt.Unix())
+	if err != nil {
+		return nil, err
+	}
+	if request.ID, err = result.LastInsertId(); err != nil {
+		return nil, err
+	}
+	entry, err := storeInstance.appendAudit(ctx, transaction, machineID, "approval_requested",
+		requester, remoteAddr, fmt.Sprintf("request %d for %s: %s", request.ID, secret, reason),
+		now)
+	if err != nil {
+		return nil, err
+	}
+	if err := transaction.Commit(); err != nil {
+		return nil, err
+	}
+	storeInstance.notifyAudit(entry)
+	return request, nil
+}
+
+// UseApproval uses up approved request id, which AuthorizeRetrieval
+// returned to requester, after the retrieval it allowed succeeded. It
+// returns ErrApprovalUsed if a concurrent retrieval used it first or it is
+// no longer usable. Audited as "approval_used".
+func (storeInstance *Store) UseApproval(
+	ctx context.Context,
+	id int64,
+	requester, remoteAddr string,
+) error {
+	if requester == "" {
+		requester = defaultUnknownActor
+	}
+	transaction, err := storeInstance.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer transaction.Rollback() // nolint:errcheck // no-op after Commit
+
+	now := time.Now().Unix()
+	var machineID int64
+	var secret string
+	err = transaction.QueryRowContext(ctx,
+		`SELECT machine_id, secret FROM approval_requests
+          WHERE id = ? AND requester = ? AND state = ? AND usable_until > ?`,
+		id, requester, ApprovalApproved, now,
+	).Scan(&machineID, &secret)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: request %d", ErrApprovalUsed, id)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := transaction.ExecContext(ctx,
+		`UPDATE approval_requests SET state = ?, used_at = ? WHERE id = ?`,
+		ApprovalUsed, now, id); err != nil {
+		return err
+	}
+	entry, err := storeInstance.appendAudit(ctx, transaction, machineID, "approval_used",
+		requester, remoteAddr, fmt.Sprintf("request %d for %s", id, secret), now)
+	if err != nil {
+		return err
+	}
+	if err := transaction.Commit(); err != nil {
+		return err
+	}
+	storeInstance.notifyAudit(entry)
+	return nil
+}
+
+// DecideApproval approves or denies pending request id on behalf of
+// approver, who must not be the requester. An approved request can be used
+// once within settings.Window. Audited as "approval_granted" or
+// "approval_denied".
+func (storeInstance *Store) DecideApproval(
+	ctx context.Context,
+	id int64,
+	approve bool,
+	approver, note, remoteAddr string,
+	settings ApprovalSettings,
+) (*ApprovalRequest, error) {
+	if approver == "" {
+		approver = defaultUnknownActor
+	}
+	transaction, err := storeInstance.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer transaction.Rollback() // nolint:errcheck // no-op after Commit
+
+	var machineID int64
+	if err := transaction.QueryRowContext(ctx,
+		`SELECT machine_id FROM approval_requests WHERE id = ?`, id,
+	).Scan(&machineID); errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUnknownApproval
+	} else if err != nil {
+		return nil, err
+	}
+	request, err := scanApprovalRequest(transaction.QueryRowContext(ctx,
+		approvalRequestQuery+` WHERE r.id = ?`, id))
+	if err != nil {
+		return nil, err
+	}
+	if request.State != ApprovalPending {
+		return nil, fmt.Errorf("%w: request %d is %s", ErrApprovalDecided, id, request.State)
+	}
+	if strings.EqualFold(request.Requester, approver) {
+		return nil, ErrSelfApproval
+	}
+
+	now := time.Now().Unix()
+	request.DecidedBy = approver
+	request.DecidedAt = time.Unix(now, 0)
+	request.Note = note
+	action := "approval_denied"
+	request.State = ApprovalDenied
+	var usableUntil any
+	if approve {
+		action = "approval_granted"
+		request.State = ApprovalApproved
+		request.UsableUntil = time.Unix(now, 0).Add(settings.Window)
+		usableUntil = request.UsableUntil.Unix()
+	}
+	if _, err := transaction.ExecContext(ctx,
+		`UPDATE approval_requests
+            SET state = ?, decided_by = ?, decided_at = ?, note = ?, usable_until = ?
+          WHERE id = ?`,
+		request.State, approver, now, note, usableUntil, id); err != nil {
+		return nil, err
+	}
+	detail := fmt.Sprintf("request %d for %s by %s", id, request.Secret, request.Requester)
+	if note != "" {
+		detail += ": " + note
+	}
+	entry, err := storeInstance.appendAudit(ctx, transaction, machineID, action, approver,
+		remoteAddr, detail, now)
+	if err != nil {
+		return nil, err
+	}
+	if err := transaction.Commit(); err != nil {
+		return nil, err
+	}
+	storeInstance.notifyAudit(entry)
+	return request, nil
+}
+
+// ListApprovalRequests returns approval requests, newest first, optionally
+// only those in state.
+func (storeInstance *Store) ListApprovalRequests(
+	ctx context.Context,
+	state string,
+) ([]ApprovalRequest, error) {
+	rows, err := storeInstance.db.QueryContext(ctx,
+		approvalRequestQuery+` ORDER BY r.id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	requests := []ApprovalRequest{}
+	for rows.Next() {
+		request, err := scanApprovalRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		if state == "" || request.State == state {
+			requests = append(requests, *request)
+		}
+	}
+	return requests, rows.Err()
+}
+
+// ApprovalReason explains why requester would need approval to retrieve
+// secret of host, or returns "" when no approval is needed. Unlike
+// AuthorizeRetrieval it records nothing.
+func (storeInstance *Store) ApprovalReason(
+	ctx context.Context,
+	host, secret, requester string,
+	settings ApprovalSettings,
+) (string, error) {
+	if canonical, err := storeInstance.hostnames.Canonical(host); err == nil {
+		host = canonical
+	}
+	return storeInstance.approvalReason(ctx, host, secret, requester, settings)
+}
+
+// approvalReason explains why requester needs approval to retrieve secret of
+// host, or returns "" when no approval is needed.
+func (storeInstance *Store) approvalReason(
+	ctx context.Context,
+	host, secret, requester string,
+	settings ApprovalSettings,
+) (string, error) {
+	policies, err := storeInstance.ListApprovalPolicies(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, policy := range policies {
+		if policy.Secret != SecretAll && policy.Secret != secret {
+			continue
+		}
+		if matched, _ := path.Match(policy.Pattern, strings.ToLower(host)); matched {
+			return "policy " + policy.Pattern, nil
+		}
+	}
+
+	if settings.BulkThreshold <= 0 {
+		return "", nil
+	}
+	var fetched int
+	err = storeInstance.db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM audit_logs
+          WHERE actor = ? AND timestamp >= ?
+            AND action IN (`+secretReadActions+`)`,
+		requester, time.Now().Add(-settings.BulkPeriod).Unix(),
+	).Scan(&fetched)
+	if err != nil {
+		return "", err
+	}
+	if fetched >= settings.BulkThreshold {
+		return fmt.Sprintf("bulk retrieval: %d secrets fetched in the last %s",
+			fetched, settings.BulkPeriod), nil
+	}
+	return "", nil
+}
+
+// approvalRequestQuery selects the columns scanApprovalRequest reads.
+const approvalRequestQuery = `
+SELECT r.id, m.hostname, r.secret, r.requester, r.reason, r.state, r.requested_at,
+       r.expires_at, r.decided_by, r.decided_at, r.note, r.usable_until
+  FROM approval_requests r
+  JOIN machines m ON r.machine_id = m.id`
+
+// scanApprovalRequest reads a row of approvalRequestQuery, reporting
+// requests that ran out of time as expired.
+func scanApprovalRequest(row interface{ Scan(...any) error }) (*ApprovalRequest, error) {
+	var request ApprovalRequest
+	var requestedAt, expiresAt int64
+	var decidedBy, note sql.NullString
+	var decidedAt, usableUntil sql.NullInt64
+	if err := row.Scan(&request.ID, &request.Hostname, &request.Secret, &request.Requester,
+		&request.Reason, &request.State, &requestedAt, &expiresAt, &decidedBy, &decidedAt,
+		&note, &usableUntil); err != nil {
+		return nil, err
+	}
+	request.RequestedAt = time.Unix(requestedAt, 0)
+	request.ExpiresAt = time.Unix(expiresAt, 0)
+	request.DecidedBy = decidedBy.String
+	request.DecidedAt = nullableTime(decidedAt)
+	request.Note = note.String
+	request.UsableUntil = nullableTime(usableUntil)
+
+	now := time.Now()
+	switch {
+	case request.State == ApprovalPending && !now.Before(request.ExpiresAt),
+		request.State == ApprovalApproved && !now.Before(request.UsableUntil):
+		request.State = ApprovalExpired
+	}
+	return &request, nil
+}
@@ -0,0 +1,135 @@
+// internal/store/audit.go
+package store
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// Paging limits for QueryAudit.
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// AuditEntry is one row of the audit log. Hostname is empty for events not
+// tied to a machine, such as a master key rotation. Detail optionally names
+// the subject of the action, e.g. the owner of an issued API token.
+type AuditEntry struct {
+	ID         int64     `json:"id"`
+	Hostname   string    `json:"hostname"`
+	Action     string    `json:"action"`
+	Actor      string    `json:"actor"`
+	RemoteAddr string    `json:"remote_addr"`
+	Detail     string    `json:"detail,omitempty"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// AuditFilter selects audit entries. Zero values match everything. Results
+// are returned newest first; pass the ID of the last entry of a page as
+// BeforeID to fetch the next one.
+type AuditFilter struct {
+	Host       string
+	Actor      string
+	Action     string
+	RemoteAddr string
+	Since      time.Time
+	Until      time.Time
+	BeforeID   int64
+	Limit      int
+}
+
+// QueryAudit returns the audit entries matching filter, newest first, and
+// the BeforeID to use for the next page (0 when there are no more).
+func (storeInstance *Store) QueryAudit(
+	ctx context.Context,
+	filter AuditFilter,
+) ([]AuditEntry, int64, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultAuditLimit
+	}
+	if limit > maxAuditLimit {
+		limit = maxAuditLimit
+	}
+
+	var conditions []string
+	var args []any
+	if filter.Host != "" {
+		host, err := storeInstance.hostnames.Canonical(filter.Host)
+		if err != nil {
+			host = filter.Host
+		}
+		conditions = append(conditions, "m.hostname = ?")
+		args = append(args, host)
+	}
+	if filter.Actor != "" {
+		conditions = append(conditions, "a.actor = ?")
+		args = append(args, filter.Actor)
+	}
+	if filter.Action != "" {
+		conditions = append(conditions, "a.action = ?")
+		args = append(args, filter.Action)
+	}
+	if filter.RemoteAddr != "" {
+		conditions = append(conditions, "a.remote_addr = ?")
+		args = append(args, filter.RemoteAddr)
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "a.timestamp >= ?")
+		args = append(args, filter.Since.Unix())
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "a.timestamp < ?")
+		args = append(args, filter.Until.Unix())
+	}
+	if filter.BeforeID > 0 {
+		conditions = append(conditions, "a.id < ?")
+		args = append(args, filter.BeforeID)
+	}
+	// Entries of a machine merged into another are reported under that one.
+	query := `SELECT a.id, m.hostname, a.action, a.actor, a.remote_addr, a.timestamp,
+                     a.detail
+                FROM audit_logs a
+                LEFT JOIN machines m ON m.id = COALESCE(
+                    (SELECT merged_into FROM machines WHERE id = a.machine_id), a.machine_id)`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	// Fetch one extra row to learn whether another page exists.
+	query += " ORDER BY a.id DESC LIMIT ?"
+	args = append(args, limit+1)
+
+	rows, err := storeInstance.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var entry AuditEntry
+		var hostname, detail sql.NullString
+		var timestamp int64
+		if err := rows.Scan(&entry.ID, &hostname, &entry.Action, &entry.Actor,
+			&entry.RemoteAddr, &timestamp, &detail); err != nil {
+			return nil, 0, err
+		}
+		entry.Hostname = hostname.String
+		entry.Detail = detail.String
+		entry.Timestamp = time.Unix(timestamp, 0)
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	var nextBeforeID int64
+	if len(entries) > limit {
+		entries = entries[:limit]
+		nextBeforeID = entries[limit-1].ID
+	}
+	return entries, nextBeforeID, nil
+}
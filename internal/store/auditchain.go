@@ -0,0 +1,333 @@
+// internal/store/auditchain.go
+package store
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// envAuditKeyFile names a file whose contents HMAC the audit hash chain.
+// Without it rows are chained with plain SHA-256, which detects edits but
+// not an attacker who recomputes every hash after the edited row.
+const envAuditKeyFile = "SHIPS_AUDIT_KEY_FILE"
+
+// Prefixes of audit_logs.row_hash identifying how the row was hashed.
+const (
+	auditHashSHA256 = "sha256:"
+	auditHashHMAC   = "hmac-sha256:"
+)
+
+// minAuditKeySize is the shortest HMAC key we accept.
+const minAuditKeySize = 16
+
+// AuditHead is the newest link of the audit hash chain. Publishing it
+// somewhere the database host cannot write to (a ticket, a timestamping
+// service, another server's log) lets truncation be detected later.
+type AuditHead struct {
+	ID        int64     `json:"id"`
+	Hash      string    `json:"hash"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// AuditVerification is the result of walking the audit hash chain.
+// BrokenAtID is 0 when the chain is intact; otherwise it is the first row
+// whose link does not verify and Reason says why.
+type AuditVerification struct {
+	Entries    int       `json:"entries"`
+	Head       AuditHead `json:"head"`
+	BrokenAtID int64     `json:"broken_at_id,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+}
+
+// loadAuditKey reads the optional HMAC key named by SHIPS_AUDIT_KEY_FILE.
+func loadAuditKey() ([]byte, error) {
+	keyFile := os.Getenv(envAuditKeyFile)
+	if keyFile == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(keyFile) // #nosec G304 – path comes from operator config
+	if err != nil {
+		return nil, fmt.Errorf("reading audit key: %w", err)
+	}
+	auditKey := []byte(strings.TrimSpace(string(data)))
+	if len(auditKey) < minAuditKeySize {
+		return nil, fmt.Errorf("audit key in %s must be at least %d bytes",
+			keyFile, minAuditKeySize)
+	}
+	return auditKey, nil
+}
+
+// auditRow is the hashed content of one audit_logs row.
+type auditRow struct {
+	id         int64
+	machineID  sql.NullInt64
+	action     string
+	actor      string
+	remoteAddr string
+	timestamp  int64
+	detail     sql.NullString
+}
+
+// hash chains row to prevHash. The row is serialised as a JSON array so no
+// choice of field contents can make two different rows hash alike. detail
+// is only appended when set, so rows written before it existed still verify.
+func (row auditRow) hash(prevHash string, auditKey []byte) string {
+	var machineID any
+	if row.machineID.Valid {
+		machineID = row.machineID.Int64
+	}
+	fields := []any{
+		prevHash, row.id, machineID, row.action, row.actor, row.remoteAddr, row.timestamp,
+	}
+	if row.detail.String != "" {
+		fields = append(fields, row.detail.String)
+	}
+	content, _ := json.Marshal(fields) // nolint:errcheck // plain values always marshal
+	if auditKey == nil {
+		sum := sha256.Sum256(content)
+		return auditHashSHA256 + hex.EncodeToString(sum[:])
+	}
+	mac := hmac.New(sha256.New, auditKey)
+	mac.Write(content) // nolint:errcheck // hash writes never fail
+	return auditHashHMAC + hex.EncodeToString(mac.Sum(nil))
+}
+
+// appendAudit adds an entry to audit_logs inside transaction, linking it
+// to the previous row's hash. machineID is an int64 or nil; detail is an
+// optional free-text note such as the subject of an administrative action.
+// The returned entry should be passed to notifyAudit once transaction has
+// committed.
+func (storeInstance *Store) appendAudit(
+	ctx context.Context,
+	transaction *sql.Tx,
+	machineID any,
+	action, actor, remoteAddr, detail string,
+	timestamp int64,
+) (AuditEntry, error) {
+	var lastID int64
+	var prevHash sql.NullString
+	err := transaction.QueryRowContext(ctx,
+		`SELECT id, row_hash FROM audit_logs ORDER BY id DESC LIMIT 1`,
+	).Scan(&lastID, &prevHash)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return AuditEntry{}, err
+	}
+
+	row := auditRow{
+		id:         lastID + 1,
+		action:     action,
+		actor:      actor,
+		remoteAddr: remoteAddr,
+		timestamp:  timestamp,
+		detail:     sql.NullString{String: detail, Valid: detail != ""},
+	}
+	entry := AuditEntry{
+		ID:         row.id,
+		Action:     action,
+		Actor:      actor,
+		RemoteAddr: remoteAddr,
+		Detail:     detail,
+		Timestamp:  time.Unix(timestamp, 0),
+	}
+	if id, ok := machineID.(int64); ok {
+		row.machineID = sql.NullInt64{Int64: id, Valid: true}
+		if err := transaction.QueryRowContext(ctx,
+			`SELECT hostname FROM machines WHERE id = ?`, id,
+		).Scan(&entry.Hostname); err != nil {
+			return AuditEntry{}, err
+		}
+	}
+	_, err = transaction.ExecContext(ctx,
+		`INSERT INTO audit_logs(id, machine_id, action, actor, remote_addr, timestamp, 
+                                detail, prev_hash, row_hash) 
+         VALUES (?,?,?,?,?,?,?,?,?)`,
+		row.id, row.machineID, action, actor, remoteAddr, timestamp, row.detail,
+		prevHash.String, row.hash(prevHash.String, storeInstance.auditKey))
+	if err != nil {
+		return AuditEntry{}, err
+	}
+	return entry, nil
+}
+
+// SetAuditHook registers hook to be called with every audit entry after it
+// has been committed, e.g. to forward it to syslog. The hook runs on the
+// caller's goroutine and must not block or call back into the store. Set
+// it before the store is shared between goroutines.
+func (storeInstance *Store) SetAuditHook(hook func(AuditEntry)) {
+	storeInstance.auditHook = hook
+}
+
+// notifyAudit hands a committed audit entry to the registered hook.
+func (storeInstance *Store) notifyAudit(entry AuditEntry) {
+	if storeInstance.auditHook != nil {
+		storeInstance.auditHook(entry)
+	}
+}
+
+// recordAudit writes a single audit entry in its own transaction.
+func (storeInstance *Store) recordAudit(
+	ctx context.Context,
+	machineID any,
+	action, actor, remoteAddr, detail string,
+) error {
+	transaction, err := storeInstance.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer transaction.Rollback() // nolint:errcheck // no-op after Commit
+
+	entry, err := storeInstance.appendAudit(
+		ctx, transaction, machineID, action, actor, remoteAddr, detail, time.Now().Unix(),
+	)
+	if err != nil {
+		return err
+	}
+	if err := transaction.Commit(); err != nil {
+		return err
+	}
+	storeInstance.notifyAudit(entry)
+	return nil
+}
+
+// AuditChainHead returns the newest audit entry's ID and hash.
+func (storeInstance *Store) AuditChainHead(ctx context.Context) (*AuditHead, error) {
+	var head AuditHead
+	var rowHash sql.NullString
+	var timestamp int64
+	err := storeInstance.db.QueryRowContext(ctx,
+		`SELECT id, row_hash, timestamp FROM audit_logs ORDER BY id DESC LIMIT 1`,
+	).Scan(&head.ID, &rowHash, &timestamp)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &head, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	head.Hash = rowHash.String
+	head.Timestamp = time.Unix(timestamp, 0)
+	return &head, nil
+}
+
+// VerifyAuditChain walks audit_logs from the first row and recomputes every
+// link, stopping at the first row that does not verify. Once an HMAC row
+// has been seen, later plain SHA-256 rows count as broken so the chain
+// cannot be silently downgraded by someone without the key.
+func (storeInstance *Store) VerifyAuditChain(ctx context.Context) (*AuditVerification, error) {
+	rows, err := storeInstance.db.QueryContext(ctx,
+		`SELECT id, machine_id, action, actor, remote_addr, timestamp, detail,
+                prev_hash, row_hash
+           FROM audit_logs ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := &AuditVerification{}
+	prevHash := ""
+	sawHMAC := false
+	for rows.Next() {
+		var row auditRow
+		var storedPrev, storedHash sql.NullString
+		if err := rows.Scan(&row.id, &row.machineID, &row.action, &row.actor,
+			&row.remoteAddr, &row.timestamp, &row.detail, &storedPrev, &storedHash); err != nil {
+			return nil, err
+		}
+
+		reason := ""
+		switch {
+		case !storedHash.Valid:
+			reason = "row has no hash"
+		case storedPrev.String != prevHash:
+			reason = "previous-hash link does not match the preceding row (row deleted or reordered)"
+		case strings.HasPrefix(storedHash.String, auditHashHMAC):
+			sawHMAC = true
+			if storeInstance.auditKey == nil {
+				reason = "row is HMAC-chained but no audit key is configured"
+			} else if row.hash(prevHash, storeInstance.auditKey) != storedHash.String {
+				reason = "row content does not match its hash (row modified)"
+			}
+		case strings.HasPrefix(storedHash.String, auditHashSHA256):
+			if sawHMAC {
+				reason = "plain SHA-256 row follows HMAC rows (chain rewritten without the key)"
+			} else if row.hash(prevHash, nil) != storedHash.String {
+				reason = "row content does not match its hash (row modified)"
+			}
+		default:
+			reason = "unrecognised hash format"
+		}
+		if reason != "" {
+			result.BrokenAtID = row.id
+			result.Reason = reason
+			return result, nil
+		}
+
+		result.Entries++
+		result.Head = AuditHead{
+			ID:        row.id,
+			Hash:      storedHash.String,
+			Timestamp: time.Unix(row.timestamp, 0),
+		}
+		prevHash = storedHash.String
+	}
+	return result, rows.Err()
+}
+
+// chainLegacyAudit hashes audit rows written before the chain existed. It
+// only runs while no row carries a hash yet, i.e. on the first start after
+// upgrading; unhashed rows appearing later are reported by VerifyAuditChain.
+func (storeInstance *Store) chainLegacyAudit(ctx context.Context) error {
+	transaction, err := storeInstance.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer transaction.Rollback() // nolint:errcheck // no-op after Commit
+
+	var hashed int
+	if err := transaction.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM audit_logs WHERE row_hash IS NOT NULL`,
+	).Scan(&hashed); err != nil || hashed > 0 {
+		return err
+	}
+
+	rows, err := transaction.QueryContext(ctx,
+		`SELECT id, machine_id, action, actor, remote_addr, timestamp, detail
+           FROM audit_logs ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	var legacy []auditRow
+	for rows.Next() {
+		var row auditRow
+		if err := rows.Scan(&row.id, &row.machineID, &row.action, &row.actor,
+			&row.remoteAddr, &row.timestamp, &row.detail); err != nil {
+			rows.Close()
+			return err
+		}
+		legacy = append(legacy, row)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	prevHash := ""
+	for _, row := range legacy {
+		rowHash := row.hash(prevHash, storeInstance.auditKey)
+		if _, err := transaction.ExecContext(ctx,
+			`UPDATE audit_logs SET prev_hash = ?, row_hash = ? WHERE id = ?`,
+			prevHash, rowHash, row.id); err != nil {
+			return err
+		}
+		prevHash = rowHash
+	}
+	return transaction.Commit()
+}
@@ -0,0 +1,480 @@
+// internal/store/ca.go
+package store
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"database/sql"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// caLifetime is the validity of the built-in CA certificate.
+const caLifetime = 10 * 365 * 24 * time.Hour
+
+// machineCertificateLifetime is the validity of an issued machine
+// certificate; machines renew well before it runs out.
+const machineCertificateLifetime = 90 * 24 * time.Hour
+
+// defaultCAName is the common name of a CA created without one.
+const defaultCAName = "SHIPS2-Go machine CA"
+
+var (
+	// ErrNoCA is returned when the built-in CA has not been initialised.
+	ErrNoCA = errors.New("no certificate authority; run ships-server ca init")
+	// ErrCAExists is returned by InitCA when a CA already exists.
+	ErrCAExists = errors.New("certificate authority already initialised")
+	// ErrInvalidCSR is returned for certificate requests that cannot be
+	// parsed or whose signature does not verify.
+	ErrInvalidCSR = errors.New("invalid certificate signing request")
+	// ErrInvalidCertificate is returned by RenewMachineCertificate when the
+	// presented certificate is unknown, revoked, expired or another host's.
+	ErrInvalidCertificate = errors.New("unknown, revoked or expired certificate")
+)
+
+// Certificate describes a machine certificate issued by the built-in CA.
+type Certificate struct {
+	Serial       string    `json:"serial"`
+	Hostname     string    `json:"hostname"`
+	NotBefore    time.Time `json:"not_before"`
+	NotAfter     time.Time `json:"not_after"`
+	IssuedAt     time.Time `json:"issued_at"`
+	IssuedBy     string    `json:"issued_by"`
+	RevokedAt    time.Time `json:"revoked_at"`
+	RevokeReason string    `json:"revoke_reason,omitempty"`
+}
+
+// IssuedCertificate is a freshly signed machine certificate together with
+// the CA certificate that verifies it, both PEM encoded.
+type IssuedCertificate struct {
+	Certificate
+	CertificatePEM string
+	CAPEM          string
+}
+
+// certificateAuthority is the unsealed built-in CA.
+type certificateAuthority struct {
+	certificate *x509.Certificate
+	key         crypto.Signer
+	pem         string
+}
+
+// InitCA creates the built-in CA with an ECDSA P-256 key and returns its
+// certificate in PEM form. commonName defaults to "SHIPS2-Go machine CA".
+func (storeInstance *Store) InitCA(ctx context.Context, commonName, actor string) (string, error) {
+	if commonName == "" {
+		commonName = defaultCAName
+	}
+	if actor == "" {
+		actor = defaultUnknownActor
+	}
+	if _, err := storeInstance.CACertificate(ctx); err == nil {
+		return "", ErrCAExists
+	} else if !errors.Is(err, ErrNoCA) {
+		return "", err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             now.Add(-5 * time.Minute),
+		NotAfter:              now.Add(caLifetime),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return "", err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := storeInstance.seal(string(pem.EncodeToMemory(
+		&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})))
+	if err != nil {
+		return "", err
+	}
+	certificatePEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+
+	transaction, err := storeInstance.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer transaction.Rollback() // nolint:errcheck // no-op after Commit
+
+	if _, err := transaction.ExecContext(ctx,
+		`INSERT INTO ca(id, certificate, private_key, data_key, created_at, created_by)
+         VALUES (1,?,?,?,?,?)`,
+		certificatePEM, sealed.ciphertext, sealed.dataKey, now.Unix(), actor); err != nil {
+		return "", err
+	}
+	entry, err := storeInstance.appendAudit(ctx, transaction, nil, "ca_init", actor, "local",
+		fmt.Sprintf("CA %q serial %s", commonName, serial.Text(16)), now.Unix())
+	if err != nil {
+		return "", err
+	}
+	if err := transaction.Commit(); err != nil {
+		return "", err
+	}
+	storeInstance.notifyAudit(entry)
+	return certificatePEM, nil
+}
+
+// CACertificate returns the PEM certificate of the built-in CA, or ErrNoCA.
+func (storeInstance *Store) CACertificate(ctx context.Context) (string, error) {
+	var certificatePEM string
+	err := storeInstance.db.QueryRowContext(ctx,
+		`SELECT certificate FROM ca WHERE id = 1`,
+	).Scan(&certificatePEM)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNoCA
+	}
+	return certificatePEM, err
+}
+
+// loadCA reads and unseals the built-in CA.
+func (storeInstance *Store) loadCA(ctx context.Context) (*certificateAuthority, error) {
+	var authority certificateAuthority
+	var sealed sealedSecret
+	err := storeInstance.db.QueryRowContext(ctx,
+		`SELECT certificate, private_key, data_key FROM ca WHERE id = 1`,
+	).Scan(&authority.pem, &sealed.ciphertext, &sealed.dataKey)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNoCA
+	}
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode([]byte(authority.pem))
+	if block == nil {
+		return nil, errors.New("corrupt CA certificate")
+	}
+	if authority.certificate, err = x509.ParseCertificate(block.Bytes); err != nil {
+		return nil, fmt.Errorf("corrupt CA certificate: %w", err)
+	}
+	keyPEM, err := storeInstance.open(sealed)
+	if err != nil {
+		return nil, fmt.Errorf("CA private key: %w", err)
+	}
+	block, _ = pem.Decode([]byte(keyPEM))
+	if block == nil {
+		return nil, errors.New("corrupt CA private key")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("corrupt CA private key: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("CA private key cannot sign")
+	}
+	authority.key = signer
+	return &authority, nil
+}
+
+// sign issues a client certificate for host over the public key of request.
+// The subject requested in the CSR is ignored.
+func (authority *certificateAuthority) sign(
+	request *x509.CertificateRequest,
+	host string,
+	now time.Time,
+) (*IssuedCertificate, error) {
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+	notAfter := now.Add(machineCertificateLifetime)
+	if notAfter.After(authority.certificate.NotAfter) {
+		notAfter = authority.certificate.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    now.Add(-5 * time.Minute),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, authority.certificate,
+		request.PublicKey, authority.key)
+	if err != nil {
+		return nil, err
+	}
+	return &IssuedCertificate{
+		Certificate: Certificate{
+			Serial:    serial.Text(16),
+			Hostname:  host,
+			NotBefore: time.Unix(template.NotBefore.Unix(), 0),
+			NotAfter:  time.Unix(notAfter.Unix(), 0),
+			IssuedAt:  time.Unix(now.Unix(), 0),
+		},
+		CertificatePEM: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		CAPEM:          authority.pem,
+	}, nil
+}
+
+// insertCertificate records issued in the certificates table.
+func insertCertificate(
+	ctx context.Context,
+	transaction *sql.Tx,
+	machineID int64,
+	issued *IssuedCertificate,
+) error {
+	_, err := transaction.ExecContext(ctx,
+		`INSERT INTO certificates(serial, machine_id, not_before, not_after, issued_at, issued_by)
+         VALUES (?,?,?,?,?,?)`,
+		issued.Serial, machineID, issued.NotBefore.Unix(), issued.NotAfter.Unix(),
+		issued.IssuedAt.Unix(), issued.IssuedBy)
+	return err
+}
+
+// EnrollMachineCertificate redeems enrollmentToken like EnrollMachine but
+// returns a client certificate for host signed over the key in csrPEM.
+func (storeInstance *Store) EnrollMachineCertificate(
+	ctx context.Context,
+	enrollmentToken, host, csrPEM, remoteAddr string,
+) (*IssuedCertificate, error) {
+	request, err := parseCSR(csrPEM)
+	if err != nil {
+		return nil, err
+	}
+	authority, err := storeInstance.loadCA(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var issued *IssuedCertificate
+	err = storeInstance.enroll(ctx, enrollmentToken, host, remoteAddr,
+		func(transaction *sql.Tx, machineID, tokenID, now int64) (string, error) {
+			var err error
+			if issued, err = authority.sign(request, host, time.Unix(now, 0)); err != nil {
+				return "", err
+			}
+			issued.IssuedBy = fmt.Sprintf("enrollment:%d", tokenID)
+			if err := insertCertificate(ctx, transaction, machineID, issued); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("certificate %s via enrollment token %d",
+				issued.Serial, tokenID), nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return issued, nil
+}
+
+// RenewMachineCertificate replaces the valid certificate serial of host with
+// a new one over the key in csrPEM. The old certificate is revoked as
+// superseded.
+func (storeInstance *Store) RenewMachineCertificate(
+	ctx context.Context,
+	serial, host, csrPEM, remoteAddr string,
+) (*IssuedCertificate, error) {
+	request, err := parseCSR(csrPEM)
+	if err != nil {
+		return nil, err
+	}
+	authority, err := storeInstance.loadCA(ctx)
+	if err != nil {
+		return nil, err
+	}
+	machineID, err := storeInstance.existingMachineID(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if machineID == nil {
+		return nil, ErrInvalidCertificate
+	}
+	serial = normalizeSerial(serial)
+
+	transaction, err := storeInstance.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer transaction.Rollback() // nolint:errcheck // no-op after Commit
+
+	now := time.Now()
+	result, err := transaction.ExecContext(ctx,
+		`UPDATE certificates SET revoked_at = ?, revoke_reason = 'superseded'
+          WHERE serial = ? AND machine_id = ? AND revoked_at IS NULL AND not_after > ?`,
+		now.Unix(), serial, machineID, now.Unix())
+	if err != nil {
+		return nil, err
+	}
+	if renewed, err := result.RowsAffected(); err != nil || renewed != 1 {
+		return nil, ErrInvalidCertificate
+	}
+	issued, err := authority.sign(request, host, now)
+	if err != nil {
+		return nil, err
+	}
+	issued.IssuedBy = "renewal:" + serial
+	if err := insertCertificate(ctx, transaction, machineID.(int64), issued); err != nil {
+		return nil, err
+	}
+	entry, err := storeInstance.appendAudit(ctx, transaction, machineID, "renew_certificate",
+		host, remoteAddr, fmt.Sprintf("certificate %s replaces %s", issued.Serial, serial),
+		now.Unix())
+	if err != nil {
+		return nil, err
+	}
+	if err := transaction.Commit(); err != nil {
+		return nil, err
+	}
+	storeInstance.notifyAudit(entry)
+	return issued, nil
+}
+
+// RevokeCertificate revokes the certificate serial. The TLS listener refuses
+// revoked certificates from the next handshake on.
+func (storeInstance *Store) RevokeCertificate(
+	ctx context.Context,
+	serial, reason, actor string,
+) error {
+	if actor == "" {
+		actor = defaultUnknownActor
+	}
+	if reason == "" {
+		reason = "unspecified"
+	}
+	serial = normalizeSerial(serial)
+
+	transaction, err := storeInstance.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer transaction.Rollback() // nolint:errcheck // no-op after Commit
+
+	var machineID int64
+	var revokedAt sql.NullInt64
+	err = transaction.QueryRowContext(ctx,
+		`SELECT machine_id, revoked_at FROM certificates WHERE serial = ?`, serial,
+	).Scan(&machineID, &revokedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("certificate %s not found", serial)
+	}
+	if err != nil {
+		return err
+	}
+	if revokedAt.Valid {
+		return fmt.Errorf("certificate %s is already revoked", serial)
+	}
+	now := time.Now().Unix()
+	if _, err := transaction.ExecContext(ctx,
+		`UPDATE certificates SET revoked_at = ?, revoke_reason = ? WHERE serial = ?`,
+		now, reason, serial); err != nil {
+		return err
+	}
+	entry, err := storeInstance.appendAudit(ctx, transaction, machineID, "revoke_certificate",
+		actor, "local", fmt.Sprintf("certificate %s: %s", serial, reason), now)
+	if err != nil {
+		return err
+	}
+	if err := transaction.Commit(); err != nil {
+		return err
+	}
+	storeInstance.notifyAudit(entry)
+	return nil
+}
+
+// ListCertificates returns every issued certificate, oldest first.
+func (storeInstance *Store) ListCertificates(ctx context.Context) ([]Certificate, error) {
+	rows, err := storeInstance.db.QueryContext(ctx,
+		`SELECT c.serial, m.hostname, c.not_before, c.not_after, c.issued_at, c.issued_by,
+                c.revoked_at, c.revoke_reason
+           FROM certificates c
+           JOIN machines m ON c.machine_id = m.id
+          ORDER BY c.issued_at, c.rowid`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	certificates := []Certificate{}
+	for rows.Next() {
+		var certificate Certificate
+		var notBefore, notAfter, issuedAt int64
+		var revokedAt sql.NullInt64
+		var reason sql.NullString
+		if err := rows.Scan(&certificate.Serial, &certificate.Hostname, &notBefore, &notAfter,
+			&issuedAt, &certificate.IssuedBy, &revokedAt, &reason); err != nil {
+			return nil, err
+		}
+		certificate.NotBefore = time.Unix(notBefore, 0)
+		certificate.NotAfter = time.Unix(notAfter, 0)
+		certificate.IssuedAt = time.Unix(issuedAt, 0)
+		certificate.RevokedAt = nullableTime(revokedAt)
+		certificate.RevokeReason = reason.String
+		certificates = append(certificates, certificate)
+	}
+	return certificates, rows.Err()
+}
+
+// IsCertificateRevoked reports whether the built-in CA revoked serial.
+// Serials it never issued are not revoked.
+func (storeInstance *Store) IsCertificateRevoked(ctx context.Context, serial string) (bool, error) {
+	var revoked bool
+	err := storeInstance.db.QueryRowContext(ctx,
+		`SELECT revoked_at IS NOT NULL FROM certificates WHERE serial = ?`,
+		normalizeSerial(serial),
+	).Scan(&revoked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return revoked, err
+}
+
+// parseCSR decodes a PEM certificate signing request and checks that it is
+// signed by the key it carries.
+func parseCSR(csrPEM string) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode([]byte(csrPEM))
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, fmt.Errorf("%w: no CERTIFICATE REQUEST block", ErrInvalidCSR)
+	}
+	request, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCSR, err)
+	}
+	if err := request.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCSR, err)
+	}
+	return request, nil
+}
+
+// randomSerial returns a random, positive 128-bit certificate serial.
+func randomSerial() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	return serial.Add(serial, big.NewInt(1)), nil
+}
+
+// normalizeSerial accepts a serial as lowercase hex, uppercase hex or with
+// colon separators, and returns the form stored in certificates.
+func normalizeSerial(serial string) string {
+	serial = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(serial), ":", ""))
+	return strings.TrimLeft(serial, "0")
+}
@@ -0,0 +1,85 @@
+// internal/store/compliance.go
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// Issues a compliance report flags a machine for.
+const (
+	IssueNoPassword    = "no_password"
+	IssueNoKey         = "no_bitlocker_key"
+	IssueStalePassword = "stale_password"
+)
+
+// ComplianceEntry is the compliance state of one machine. Issues is empty
+// for a compliant machine.
+type ComplianceEntry struct {
+	Hostname      string    `json:"hostname"`
+	Compliant     bool      `json:"compliant"`
+	Issues        []string  `json:"issues"`
+	LastRotation  time.Time `json:"last_rotation"`
+	LastKeyUpdate time.Time `json:"last_key_update"`
+}
+
+// ComplianceReport states for every machine whether it has an escrowed
+// password rotated within MaxAge and a BitLocker recovery key.
+type ComplianceReport struct {
+	GeneratedAt  time.Time         `json:"generated_at"`
+	MaxAge       string            `json:"max_age"`
+	Total        int               `json:"total"`
+	NonCompliant int               `json:"non_compliant"`
+	Machines     []ComplianceEntry `json:"machines"`
+}
+
+// ComplianceReport checks every machine in service, ordered by hostname;
+// decommissioned machines are left out. A password last rotated more than
+// maxAge ago is stale; a zero maxAge only checks that a password and a key
+// are escrowed.
+func (storeInstance *Store) ComplianceReport(
+	ctx context.Context,
+	maxAge time.Duration,
+) (*ComplianceReport, error) {
+	rows, err := storeInstance.db.QueryContext(ctx,
+		machineInventoryQuery+" WHERE m.retired_at IS NULL ORDER BY m.hostname")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	machines, err := scanMachines(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	report := &ComplianceReport{
+		GeneratedAt: now,
+		MaxAge:      maxAge.String(),
+		Total:       len(machines),
+		Machines:    make([]ComplianceEntry, 0, len(machines)),
+	}
+	for _, machine := range machines {
+		entry := ComplianceEntry{
+			Hostname:      machine.Hostname,
+			Issues:        []string{},
+			LastRotation:  machine.LastRotation,
+			LastKeyUpdate: machine.LastKeyUpdate,
+		}
+		switch {
+		case machine.LastRotation.IsZero():
+			entry.Issues = append(entry.Issues, IssueNoPassword)
+		case maxAge > 0 && machine.LastRotation.Before(now.Add(-maxAge)):
+			entry.Issues = append(entry.Issues, IssueStalePassword)
+		}
+		if machine.LastKeyUpdate.IsZero() {
+			entry.Issues = append(entry.Issues, IssueNoKey)
+		}
+		entry.Compliant = len(entry.Issues) == 0
+		if !entry.Compliant {
+			report.NonCompliant++
+		}
+		report.Machines = append(report.Machines, entry)
+	}
+	return report, nil
+}
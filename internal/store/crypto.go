@@ -0,0 +1,288 @@
+// internal/store/crypto.go
+package store
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MasterKeySize is the length in bytes of the key that wraps data keys.
+const MasterKeySize = 32
+
+// keyIDSize is the number of fingerprint bytes prefixed to a wrapped data key
+// so we can tell which master key sealed it.
+const keyIDSize = 8
+
+// Environment variables consulted by LoadMasterKey.
+const (
+	envMasterKey     = "SHIPS_MASTER_KEY"
+	envMasterKeyFile = "SHIPS_MASTER_KEY_FILE"
+)
+
+// sealedTables lists every table holding sealed secrets together with the
+// column that stores the ciphertext.
+var sealedTables = []struct{ table, secretColumn string }{
+	{"passwords", "password"},
+	{"password_history", "password"},
+	{"bitlocker_keys", "key_text"},
+	{"ca", "private_key"},
+}
+
+// sealedSecret is the at-rest form of a password or recovery key: the
+// secret encrypted with a random data key, and that data key wrapped by
+// the master key. Both are base64 encoded for storage in TEXT columns.
+type sealedSecret struct {
+	ciphertext string
+	dataKey    string
+}
+
+// LoadMasterKey returns the master key configured for the database at dbPath:
+// SHIPS_MASTER_KEY (base64), or else the file named by SHIPS_MASTER_KEY_FILE.
+// One of them must be set. The key file must live outside the database's
+// directory, since a copy of that directory would otherwise take the key
+// along with the secrets it protects.
+func LoadMasterKey(dbPath string) ([]byte, error) {
+	if encoded := os.Getenv(envMasterKey); encoded != "" {
+		return ParseMasterKey(encoded)
+	}
+	keyFile := os.Getenv(envMasterKeyFile)
+	if keyFile == "" {
+		// Releases before this one generated dbPath + ".key" on first start.
+		if _, err := os.Stat(dbPath + ".key"); err == nil {
+			return nil, fmt.Errorf("no master key configured: move %s out of %s and "+
+				"set %s to its new path", dbPath+".key", filepath.Dir(dbPath), envMasterKeyFile)
+		}
+		return nil, fmt.Errorf("no master key configured: set %s or %s "+
+			"(generate a key with `openssl rand -base64 32`)", envMasterKey, envMasterKeyFile)
+	}
+	keyDir, err := filepath.Abs(filepath.Dir(keyFile))
+	if err != nil {
+		return nil, err
+	}
+	dbDir, err := filepath.Abs(filepath.Dir(dbPath))
+	if err != nil {
+		return nil, err
+	}
+	if keyDir == dbDir {
+		return nil, fmt.Errorf("%s: the master key file must not be in the database directory %s",
+			keyFile, dbDir)
+	}
+	return ReadMasterKeyFile(keyFile)
+}
+
+// ReadMasterKeyFile reads a base64 encoded master key from path.
+func ReadMasterKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path) // #nosec G304 – path comes from operator config
+	if err != nil {
+		return nil, err
+	}
+	masterKey, err := ParseMasterKey(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return masterKey, nil
+}
+
+// ParseMasterKey decodes a base64 encoded master key and checks its length.
+func ParseMasterKey(encoded string) ([]byte, error) {
+	masterKey, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("master key is not valid base64: %w", err)
+	}
+	if len(masterKey) != MasterKeySize {
+		return nil, fmt.Errorf("master key must be %d bytes, got %d",
+			MasterKeySize, len(masterKey))
+	}
+	return masterKey, nil
+}
+
+// masterKeyID fingerprints a master key without revealing it.
+func masterKeyID(masterKey []byte) []byte {
+	sum := sha256.Sum256(masterKey)
+	return sum[:keyIDSize]
+}
+
+// gcmSeal encrypts plaintext with key and returns nonce||ciphertext.
+func gcmSeal(key, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// gcmOpen reverses gcmSeal.
+func gcmOpen(key, sealed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
+
+// wrapDataKey encrypts dataKey under masterKey, prefixed with the key ID.
+func wrapDataKey(masterKey, dataKey []byte) (string, error) {
+	wrapped, err := gcmSeal(masterKey, dataKey)
+	if err != nil {
+		return "", err
+	}
+	blob := append(append([]byte{}, masterKeyID(masterKey)...), wrapped...)
+	return base64.StdEncoding.EncodeToString(blob), nil
+}
+
+// wrappedKeyID returns the hex ID of the master key that wrapped encoded.
+func wrappedKeyID(encoded string) (string, error) {
+	blob, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("corrupt data key: %w", err)
+	}
+	if len(blob) < keyIDSize {
+		return "", errors.New("corrupt data key: too short")
+	}
+	return hex.EncodeToString(blob[:keyIDSize]), nil
+}
+
+// unwrapDataKey decrypts a data key produced by wrapDataKey.
+func unwrapDataKey(masterKey []byte, encoded string) ([]byte, error) {
+	blob, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("corrupt data key: %w", err)
+	}
+	if len(blob) < keyIDSize {
+		return nil, errors.New("corrupt data key: too short")
+	}
+	if !bytes.Equal(blob[:keyIDSize], masterKeyID(masterKey)) {
+		return nil, errors.New("data key was wrapped with a different master key")
+	}
+	dataKey, err := gcmOpen(masterKey, blob[keyIDSize:])
+	if err != nil {
+		return nil, fmt.Errorf("unwrapping data key: %w", err)
+	}
+	return dataKey, nil
+}
+
+// setMasterKey makes masterKey the key used to wrap new data keys while
+// keeping earlier keys available for unwrapping.
+func (storeInstance *Store) setMasterKey(masterKey []byte) {
+	storeInstance.keysMu.Lock()
+	defer storeInstance.keysMu.Unlock()
+	if storeInstance.masterKeys == nil {
+		storeInstance.masterKeys = map[string][]byte{}
+	}
+	storeInstance.masterKey = masterKey
+	storeInstance.masterKeys[hex.EncodeToString(masterKeyID(masterKey))] = masterKey
+}
+
+// primaryKey returns the master key used to wrap new data keys.
+func (storeInstance *Store) primaryKey() []byte {
+	storeInstance.keysMu.RLock()
+	defer storeInstance.keysMu.RUnlock()
+	return storeInstance.masterKey
+}
+
+// keyByID looks up a known master key by its hex ID.
+func (storeInstance *Store) keyByID(id string) ([]byte, bool) {
+	storeInstance.keysMu.RLock()
+	defer storeInstance.keysMu.RUnlock()
+	masterKey, ok := storeInstance.masterKeys[id]
+	return masterKey, ok
+}
+
+// ReloadMasterKey re-reads the master key from the source it was loaded from
+// by New. Data keys wrapped with the previous master key remain readable, so
+// a running server keeps working across a rekey.
+func (storeInstance *Store) ReloadMasterKey() error {
+	if storeInstance.loadMasterKey == nil {
+		return errors.New("store was opened with an explicit master key")
+	}
+	masterKey, err := storeInstance.loadMasterKey()
+	if err != nil {
+		return err
+	}
+	storeInstance.setMasterKey(masterKey)
+	return nil
+}
+
+// unwrap decrypts a wrapped data key with whichever master key sealed it,
+// reloading the configured key once if the ID is not yet known.
+func (storeInstance *Store) unwrap(encoded string) ([]byte, error) {
+	id, err := wrappedKeyID(encoded)
+	if err != nil {
+		return nil, err
+	}
+	masterKey, ok := storeInstance.keyByID(id)
+	if !ok && storeInstance.loadMasterKey != nil {
+		if reloadErr := storeInstance.ReloadMasterKey(); reloadErr != nil {
+			return nil, fmt.Errorf("reloading master key: %w", reloadErr)
+		}
+		masterKey, ok = storeInstance.keyByID(id)
+	}
+	if !ok {
+		return nil, fmt.Errorf("data key was wrapped with unknown master key %s", id)
+	}
+	return unwrapDataKey(masterKey, encoded)
+}
+
+// seal encrypts plaintext under a fresh data key wrapped by the master key.
+func (storeInstance *Store) seal(plaintext string) (sealedSecret, error) {
+	dataKey := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return sealedSecret{}, err
+	}
+	ciphertext, err := gcmSeal(dataKey, []byte(plaintext))
+	if err != nil {
+		return sealedSecret{}, err
+	}
+	wrapped, err := wrapDataKey(storeInstance.primaryKey(), dataKey)
+	if err != nil {
+		return sealedSecret{}, err
+	}
+	return sealedSecret{
+		ciphertext: base64.StdEncoding.EncodeToString(ciphertext),
+		dataKey:    wrapped,
+	}, nil
+}
+
+// open decrypts a secret sealed by seal.
+func (storeInstance *Store) open(secret sealedSecret) (string, error) {
+	dataKey, err := storeInstance.unwrap(secret.dataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(secret.ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("corrupt ciphertext: %w", err)
+	}
+	plaintext, err := gcmOpen(dataKey, ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("decrypting secret: %w", err)
+	}
+	return string(plaintext), nil
+}
@@ -0,0 +1,260 @@
+// internal/store/decommission.go
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrUnknownMachine is returned for a hostname the server has never seen.
+	ErrUnknownMachine = errors.New("unknown machine")
+	// ErrMachineRetired is returned when writing secrets of a decommissioned
+	// machine, or decommissioning it again.
+	ErrMachineRetired = errors.New("machine is decommissioned")
+)
+
+// Retirement describes a decommissioned machine. Its secrets are kept,
+// readable only by admins, until PurgeAfter; PurgedAt is set once they have
+// been destroyed.
+type Retirement struct {
+	Hostname   string    `json:"hostname"`
+	RetiredAt  time.Time `json:"retired_at"`
+	RetiredBy  string    `json:"retired_by"`
+	Reason     string    `json:"reason"`
+	PurgeAfter time.Time `json:"purge_after"`
+	PurgedAt   time.Time `json:"purged_at"`
+}
+
+// DecommissionMachine retires host: its secrets can no longer be rotated or
+// updated, pending rotations expire and open checkouts end. The secrets
+// stay for retention and are then destroyed by PurgeRetiredMachines.
+// Audited as "decommission_machine" with reason as detail.
+func (storeInstance *Store) DecommissionMachine(
+	ctx context.Context,
+	host, reason string,
+	retention time.Duration,
+	actor, remoteAddr string,
+) (*Retirement, error) {
+	if reason == "" {
+		return nil, errors.New("reason cannot be empty")
+	}
+	if actor == "" {
+		actor = defaultUnknownActor
+	}
+	machineID, err := storeInstance.existingMachineID(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if machineID == nil {
+		return nil, ErrUnknownMachine
+	}
+
+	transaction, err := storeInstance.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer transaction.Rollback() // nolint:errcheck // no-op after Commit
+
+	now := time.Now()
+	if err := retireMachine(ctx, transaction, machineID, reason, retention, actor, now); err != nil {
+		return nil, err
+	}
+	entry, err := storeInstance.appendAudit(ctx, transaction, machineID, "decommission_machine",
+		actor, remoteAddr, reason, now.Unix())
+	if err != nil {
+		return nil, err
+	}
+	if err := transaction.Commit(); err != nil {
+		return nil, err
+	}
+	storeInstance.notifyAudit(entry)
+
+	return storeInstance.MachineRetirement(ctx, host)
+}
+
+// retireMachine marks machineID decommissioned inside transaction, expires
+// its pending rotations and ends its open checkouts. It returns
+// ErrMachineRetired if the machine already is decommissioned.
+func retireMachine(
+	ctx context.Context,
+	transaction *sql.Tx,
+	machineID any,
+	reason string,
+	retention time.Duration,
+	actor string,
+	now time.Time,
+) error {
+	result, err := transaction.ExecContext(ctx,
+		`UPDATE machines
+            SET retired_at = ?, retired_by = ?, retire_reason = ?, purge_after = ?
+          WHERE id = ? AND retired_at IS NULL`,
+		now.Unix(), actor, reason, now.Add(retention).Unix(), machineID)
+	if err != nil {
+		return err
+	}
+	if changed, err := result.RowsAffected(); err != nil {
+		return err
+	} else if changed == 0 {
+		return ErrMachineRetired
+	}
+	if _, err := transaction.ExecContext(ctx,
+		`UPDATE password_history SET state = 'expired'
+          WHERE machine_id = ? AND state = 'pending'`, machineID); err != nil {
+		return err
+	}
+	_, err = transaction.ExecContext(ctx,
+		`UPDATE password_leases SET ended_at = ?, end_reason = ?
+          WHERE machine_id = ? AND ended_at IS NULL`,
+		now.Unix(), LeaseDecommissioned, machineID)
+	return err
+}
+
+// MachineRetirement returns the retirement of host, or nil when host is in
+// service or unknown. Invalid hostnames give a *hostname.Error.
+func (storeInstance *Store) MachineRetirement(ctx context.Context, host string) (*Retirement, error) {
+	host, err := storeInstance.hostnames.Canonical(host)
+	if err != nil {
+		return nil, err
+	}
+	var retiredAt, purgeAfter, purgedAt sql.NullInt64
+	var retiredBy, reason sql.NullString
+	err = storeInstance.db.QueryRowContext(ctx,
+		`SELECT retired_at, retired_by, retire_reason, purge_after, purged_at
+           FROM machines WHERE hostname = ?`, host,
+	).Scan(&retiredAt, &retiredBy, &reason, &purgeAfter, &purgedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil || !retiredAt.Valid {
+		return nil, err
+	}
+	return &Retirement{
+		Hostname:   host,
+		RetiredAt:  time.Unix(retiredAt.Int64, 0),
+		RetiredBy:  retiredBy.String,
+		Reason:     reason.String,
+		PurgeAfter: nullableTime(purgeAfter),
+		PurgedAt:   nullableTime(purgedAt),
+	}, nil
+}
+
+// PurgeRetiredMachines destroys the secrets of machines retired for longer
+// than their retention: current and past passwords and BitLocker keys are
+// deleted together with their wrapped data keys, with SQLite's secure_delete
+// so the freed pages are overwritten. The machine row and its audit trail
+// stay. Each purge is audited as "purge_machine_secrets". It returns the
+// number of machines purged.
+func (storeInstance *Store) PurgeRetiredMachines(ctx context.Context, now time.Time) (int, error) {
+	rows, err := storeInstance.db.QueryContext(ctx,
+		`SELECT id FROM machines
+          WHERE retired_at IS NOT NULL AND purged_at IS NULL AND purge_after <= ?`,
+		now.Unix())
+	if err != nil {
+		return 0, err
+	}
+	var due []int64
+	for rows.Next() {
+		var machineID int64
+		if err := rows.Scan(&machineID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		due = append(due, machineID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(due) == 0 {
+		return 0, err
+	}
+
+	if _, err := storeInstance.db.ExecContext(ctx, `PRAGMA secure_delete = ON`); err != nil {
+		return 0, err
+	}
+	for purged, machineID := range due {
+		if err := storeInstance.purgeMachine(ctx, machineID, now.Unix()); err != nil {
+			return purged, err
+		}
+	}
+	return len(due), nil
+}
+
+// purgeMachine deletes the secrets of one retired machine and audits it.
+func (storeInstance *Store) purgeMachine(ctx context.Context, machineID, now int64) error {
+	transaction, err := storeInstance.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer transaction.Rollback() // nolint:errcheck // no-op after Commit
+
+	counts := map[string]int64{}
+	for _, table := range []string{"passwords", "password_history", "bitlocker_keys"} {
+		result, err := transaction.ExecContext(ctx,
+			`DELETE FROM `+table+` WHERE machine_id = ?`, machineID)
+		if err != nil {
+			return err
+		}
+		if counts[table], err = result.RowsAffected(); err != nil {
+			return err
+		}
+	}
+	if _, err := transaction.ExecContext(ctx,
+		`UPDATE machines SET purged_at = ?, rotation_required_at = NULL,
+                rotation_required_by = NULL
+          WHERE id = ?`, now, machineID); err != nil {
+		return err
+	}
+	detail := fmt.Sprintf("%d password version(s) and %d BitLocker key(s) destroyed",
+		counts["password_history"], counts["bitlocker_keys"])
+	entry, err := storeInstance.appendAudit(ctx, transaction, machineID, "purge_machine_secrets",
+		expiryActor, "local", detail, now)
+	if err != nil {
+		return err
+	}
+	if err := transaction.Commit(); err != nil {
+		return err
+	}
+	storeInstance.notifyAudit(entry)
+	return nil
+}
+
+// activeMachineID is getMachineID for writes: it refuses decommissioned
+// machines with ErrMachineRetired.
+func (storeInstance *Store) activeMachineID(ctx context.Context, host string) (int64, error) {
+	machineID, err := storeInstance.getMachineID(ctx, host)
+	if err != nil {
+		return 0, err
+	}
+	return machineID, storeInstance.checkInService(ctx, machineID)
+}
+
+// knownMachineID is getMachineID for reads: an unknown host gives
+// ErrUnknownMachine instead of a new row, so looking up a hostname never
+// adds it to the inventory.
+func (storeInstance *Store) knownMachineID(ctx context.Context, host string) (int64, error) {
+	machineID, err := storeInstance.existingMachineID(ctx, host)
+	if err != nil {
+		return 0, err
+	}
+	if machineID == nil {
+		return 0, fmt.Errorf("%w: %s", ErrUnknownMachine, host)
+	}
+	return machineID.(int64), nil
+}
+
+// checkInService returns ErrMachineRetired if the machine is decommissioned.
+func (storeInstance *Store) checkInService(ctx context.Context, machineID any) error {
+	var retired bool
+	err := storeInstance.db.QueryRowContext(ctx,
+		`SELECT retired_at IS NOT NULL FROM machines WHERE id = ?`, machineID,
+	).Scan(&retired)
+	if err != nil {
+		return err
+	}
+	if retired {
+		return ErrMachineRetired
+	}
+	return nil
+}
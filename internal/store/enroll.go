@@ -0,0 +1,319 @@
+// internal/store/enroll.go
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// enrollmentTokenPrefix marks one-time enrollment tokens.
+const enrollmentTokenPrefix = "shipsenroll_"
+
+// defaultEnrollmentTTL is how long an enrollment token stays usable when no
+// lifetime is given.
+const defaultEnrollmentTTL = 24 * time.Hour
+
+// ErrInvalidEnrollmentToken is returned by EnrollMachine for unknown, used,
+// expired or mismatched enrollment tokens.
+var ErrInvalidEnrollmentToken = errors.New("invalid, used or expired enrollment token")
+
+// EnrollmentToken describes a one-time enrollment token. Hostname is empty
+// for tokens any new machine may use.
+type EnrollmentToken struct {
+	ID        int64     `json:"id"`
+	Hostname  string    `json:"hostname,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	CreatedBy string    `json:"created_by"`
+	ExpiresAt time.Time `json:"expires_at"`
+	UsedAt    time.Time `json:"used_at"`
+	UsedBy    string    `json:"used_by,omitempty"`
+}
+
+// CreateEnrollmentToken issues a one-time token valid for ttl (24 hours when
+// zero). A non-empty hostname restricts it to that machine; only such
+// tokens can re-enroll a machine that already holds a credential.
+func (storeInstance *Store) CreateEnrollmentToken(
+	ctx context.Context,
+	hostname string,
+	ttl time.Duration,
+	actor string,
+) (string, *EnrollmentToken, error) {
+	if hostname != "" {
+		canonical, err := storeInstance.hostnames.Canonical(hostname)
+		if err != nil {
+			return "", nil, err
+		}
+		hostname = canonical
+	}
+	if ttl < 0 {
+		return "", nil, errors.New("enrollment token lifetime cannot be negative")
+	}
+	if ttl == 0 {
+		ttl = defaultEnrollmentTTL
+	}
+	if actor == "" {
+		actor = defaultUnknownActor
+	}
+	token, err := newSecretToken(enrollmentTokenPrefix)
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+	info := &EnrollmentToken{
+		Hostname:  hostname,
+		CreatedAt: time.Unix(now.Unix(), 0),
+		CreatedBy: actor,
+		ExpiresAt: time.Unix(now.Add(ttl).Unix(), 0),
+	}
+	transaction, err := storeInstance.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	defer transaction.Rollback() // nolint:errcheck // no-op after Commit
+
+	result, err := transaction.ExecContext(ctx,
+		`INSERT INTO enrollment_tokens(token_hash, hostname, created_at, created_by, expires_at)
+         VALUES (?,?,?,?,?)`,
+		hashAPIToken(token), sql.NullString{String: hostname, Valid: hostname != ""},
+		now.Unix(), actor, info.ExpiresAt.Unix())
+	if err != nil {
+		return "", nil, err
+	}
+	if info.ID, err = result.LastInsertId(); err != nil {
+		return "", nil, err
+	}
+	detail := fmt.Sprintf("enrollment token %d for any host", info.ID)
+	if hostname != "" {
+		detail = fmt.Sprintf("enrollment token %d for %s", info.ID, hostname)
+	}
+	entry, err := storeInstance.appendAudit(ctx, transaction, nil,
+		"create_enrollment_token", actor, "local", detail, now.Unix())
+	if err != nil {
+		return "", nil, err
+	}
+	if err := transaction.Commit(); err != nil {
+		return "", nil, err
+	}
+	storeInstance.notifyAudit(entry)
+	return token, info, nil
+}
+
+// ListEnrollmentTokens returns every enrollment token, oldest first.
+func (storeInstance *Store) ListEnrollmentTokens(ctx context.Context) ([]EnrollmentToken, error) {
+	rows, err := storeInstance.db.QueryContext(ctx,
+		`SELECT e.id, e.hostname, e.created_at, e.created_by, e.expires_at, e.used_at,
+                m.hostname
+           FROM enrollment_tokens e
+           LEFT JOIN machines m ON e.machine_id = m.id
+          ORDER BY e.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []EnrollmentToken{}
+	for rows.Next() {
+		var token EnrollmentToken
+		var hostname, usedBy sql.NullString
+		var createdAt, expiresAt int64
+		var usedAt sql.NullInt64
+		if err := rows.Scan(&token.ID, &hostname, &createdAt, &token.CreatedBy,
+			&expiresAt, &usedAt, &usedBy); err != nil {
+			return nil, err
+		}
+		token.Hostname = hostname.String
+		token.CreatedAt = time.Unix(createdAt, 0)
+		token.ExpiresAt = time.Unix(expiresAt, 0)
+		token.UsedAt = nullableTime(usedAt)
+		token.UsedBy = usedBy.String
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// EnrollMachine redeems enrollmentToken for host and returns a credential
+// that may only write host's secrets. Any earlier credential of host is
+// revoked, which is audited as "reenroll_machine" instead of
+// "enroll_machine". Refused attempts are audited as "enroll_denied".
+func (storeInstance *Store) EnrollMachine(
+	ctx context.Context,
+	enrollmentToken, host, remoteAddr string,
+) (string, error) {
+	// The credential is named after the machine it belongs to.
+	host, err := storeInstance.hostnames.Canonical(host)
+	if err != nil {
+		return "", err
+	}
+	credential, err := newSecretToken(apiTokenPrefix)
+	if err != nil {
+		return "", err
+	}
+	err = storeInstance.enroll(ctx, enrollmentToken, host, remoteAddr,
+		func(transaction *sql.Tx, machineID, tokenID, now int64) (string, error) {
+			_, err := transaction.ExecContext(ctx,
+				`INSERT INTO api_tokens(name, machine_id, token_hash, created_at, created_by)
+                 VALUES (?,?,?,?,?)`,
+				host, machineID, hashAPIToken(credential), now,
+				fmt.Sprintf("enrollment:%d", tokenID))
+			return fmt.Sprintf("enrollment token %d", tokenID), err
+		})
+	if err != nil {
+		return "", err
+	}
+	return credential, nil
+}
+
+// enroll redeems enrollmentToken for host. Inside the transaction that
+// claims the token and revokes host's previous credentials (API tokens and
+// certificates alike) it calls issue to create the new credential; issue
+// returns the detail of the audit entry.
+func (storeInstance *Store) enroll(
+	ctx context.Context,
+	enrollmentToken, host, remoteAddr string,
+	issue func(transaction *sql.Tx, machineID, tokenID, now int64) (string, error),
+) error {
+	host, err := storeInstance.hostnames.Canonical(host)
+	if err != nil {
+		return err
+	}
+
+	var tokenID, expiresAt int64
+	var pinned sql.NullString
+	var usedAt sql.NullInt64
+	err = storeInstance.db.QueryRowContext(ctx,
+		`SELECT id, hostname, expires_at, used_at FROM enrollment_tokens WHERE token_hash = ?`,
+		hashAPIToken(strings.TrimSpace(enrollmentToken)),
+	).Scan(&tokenID, &pinned, &expiresAt, &usedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storeInstance.denyEnrollment(ctx, host, remoteAddr, "unknown enrollment token")
+	}
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	switch {
+	case usedAt.Valid:
+		return storeInstance.denyEnrollment(ctx, host, remoteAddr,
+			fmt.Sprintf("enrollment token %d already used", tokenID))
+	case now >= expiresAt:
+		return storeInstance.denyEnrollment(ctx, host, remoteAddr,
+			fmt.Sprintf("enrollment token %d expired", tokenID))
+	case pinned.Valid && pinned.String != host:
+		return storeInstance.denyEnrollment(ctx, host, remoteAddr,
+			fmt.Sprintf("enrollment token %d is for %s", tokenID, pinned.String))
+	}
+
+	machineID, err := storeInstance.getMachineID(ctx, host)
+	if err != nil {
+		return err
+	}
+
+	transaction, err := storeInstance.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer transaction.Rollback() // nolint:errcheck // no-op after Commit
+
+	var active int
+	if err := transaction.QueryRowContext(ctx,
+		`SELECT (SELECT COUNT(*) FROM api_tokens
+                  WHERE machine_id = ? AND revoked_at IS NULL)
+              + (SELECT COUNT(*) FROM certificates
+                  WHERE machine_id = ? AND revoked_at IS NULL AND not_after > ?)`,
+		machineID, machineID, now,
+	).Scan(&active); err != nil {
+		return err
+	}
+	if active > 0 && !pinned.Valid {
+		transaction.Rollback() // nolint:errcheck // release the connection before auditing
+		return storeInstance.denyEnrollment(ctx, host, remoteAddr, fmt.Sprintf(
+			"%s is already enrolled; re-enrollment needs a token created for it", host))
+	}
+
+	// Claim the token; the used_at condition makes concurrent redemption of
+	// the same token fail for all but one caller.
+	result, err := transaction.ExecContext(ctx,
+		`UPDATE enrollment_tokens SET used_at = ?, machine_id = ?
+          WHERE id = ? AND used_at IS NULL`,
+		now, machineID, tokenID)
+	if err != nil {
+		return err
+	}
+	if claimed, err := result.RowsAffected(); err != nil || claimed != 1 {
+		return ErrInvalidEnrollmentToken
+	}
+	if _, err := transaction.ExecContext(ctx,
+		`UPDATE api_tokens SET revoked_at = ? WHERE machine_id = ? AND revoked_at IS NULL`,
+		now, machineID); err != nil {
+		return err
+	}
+	if _, err := transaction.ExecContext(ctx,
+		`UPDATE certificates SET revoked_at = ?, revoke_reason = 'superseded'
+          WHERE machine_id = ? AND revoked_at IS NULL`,
+		now, machineID); err != nil {
+		return err
+	}
+	detail, err := issue(transaction, machineID, tokenID, now)
+	if err != nil {
+		return err
+	}
+
+	action := "enroll_machine"
+	if active > 0 {
+		action = "reenroll_machine"
+	}
+	entry, err := storeInstance.appendAudit(ctx, transaction, machineID, action, host,
+		remoteAddr, detail, now)
+	if err != nil {
+		return err
+	}
+	if err := transaction.Commit(); err != nil {
+		return err
+	}
+	storeInstance.notifyAudit(entry)
+	return nil
+}
+
+// denyEnrollment audits a refused enrollment and returns
+// ErrInvalidEnrollmentToken. Unknown hostnames are not created.
+func (storeInstance *Store) denyEnrollment(
+	ctx context.Context,
+	host, remoteAddr, reason string,
+) error {
+	machineID, err := storeInstance.existingMachineID(ctx, host)
+	if err != nil {
+		return err
+	}
+	if err := storeInstance.recordAudit(
+		ctx, machineID, "enroll_denied", host, remoteAddr, reason,
+	); err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: %s", ErrInvalidEnrollmentToken, reason)
+}
+
+// existingMachineID returns the ID of host as an int64, or nil when the
+// machine is unknown or host is not a valid hostname. Unlike getMachineID
+// it never creates a row.
+func (storeInstance *Store) existingMachineID(ctx context.Context, host string) (any, error) {
+	host, err := storeInstance.hostnames.Canonical(host)
+	if err != nil {
+		return nil, nil
+	}
+	var id int64
+	err = storeInstance.db.QueryRowContext(ctx,
+		`SELECT id FROM machines WHERE hostname = ?`, host,
+	).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return id, nil
+}
@@ -0,0 +1,98 @@
+// internal/store/history.go
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Password states of a PasswordVersion.
+const (
+	PasswordConfirmed = "confirmed"
+	PasswordPending   = "pending"
+	PasswordExpired   = "expired"
+	PasswordCancelled = "cancelled"
+)
+
+// PasswordVersion is one entry of a machine's password history. ExpiresAt
+// is set for versions of two-phase rotations.
+type PasswordVersion struct {
+	ID        int64     `json:"id"`
+	Password  string    `json:"password"`
+	RotatedAt time.Time `json:"rotated_at"`
+	Actor     string    `json:"actor"`
+	State     string    `json:"state"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ListPasswordHistory returns every password escrowed for host, newest
+// first, and logs the access. Like GetPassword it is refused while someone
+// else has the password checked out.
+func (storeInstance *Store) ListPasswordHistory(
+	ctx context.Context,
+	host, actor, remoteAddr string,
+) ([]PasswordVersion, error) {
+	if actor == "" {
+		actor = defaultUnknownActor
+	}
+	machineID, err := storeInstance.knownMachineID(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if err := storeInstance.checkLease(ctx, machineID, host, actor); err != nil {
+		return nil, err
+	}
+
+	rows, err := storeInstance.db.QueryContext(ctx,
+		`SELECT id, password, data_key, created_at, actor, state, expires_at
+           FROM password_history
+          WHERE machine_id = ?
+          ORDER BY id DESC`,
+		machineID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []PasswordVersion{}
+	for rows.Next() {
+		version, err := storeInstance.scanPasswordVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, *version)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	// Log the history retrieval
+	err = storeInstance.recordFetch(ctx, machineID, "fetch_password_history", actor, remoteAddr)
+	if err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// scanPasswordVersion reads and decrypts a row of id, password, data_key,
+// created_at, actor, state and expires_at.
+func (storeInstance *Store) scanPasswordVersion(rows *sql.Rows) (*PasswordVersion, error) {
+	var version PasswordVersion
+	var sealed sealedSecret
+	var createdAt int64
+	var expiresAt sql.NullInt64
+	if err := rows.Scan(&version.ID, &sealed.ciphertext, &sealed.dataKey,
+		&createdAt, &version.Actor, &version.State, &expiresAt); err != nil {
+		return nil, err
+	}
+	password, err := storeInstance.open(sealed)
+	if err != nil {
+		return nil, err
+	}
+	version.Password = password
+	version.RotatedAt = time.Unix(createdAt, 0)
+	version.ExpiresAt = nullableTime(expiresAt)
+	return &version, nil
+}
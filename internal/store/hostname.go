@@ -0,0 +1,210 @@
+// internal/store/hostname.go
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jottavia/SHIPS2-Go/internal/hostname"
+)
+
+// ErrHostnameTaken is returned when renaming a machine to a hostname that
+// already belongs to another machine.
+var ErrHostnameTaken = errors.New("hostname already in use")
+
+// SetHostnamePolicy replaces the canonical form hostnames are stored and
+// looked up in for this Store only, without recording it in the database as
+// MigrateHostnames does. Set it before the store is shared between
+// goroutines; machines stored under another form can be found with
+// NonCanonicalHostnames and renamed with RenameMachine.
+func (storeInstance *Store) SetHostnamePolicy(policy hostname.Policy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+	storeInstance.hostnames = policy
+	return nil
+}
+
+// HostnamePolicy returns the canonical form hostnames are stored in.
+func (storeInstance *Store) HostnamePolicy() hostname.Policy {
+	return storeInstance.hostnames
+}
+
+// CanonicalHostname returns host in the canonical form of the store's
+// hostname policy, or a *hostname.Error if it is not a valid hostname.
+func (storeInstance *Store) CanonicalHostname(host string) (string, error) {
+	return storeInstance.hostnames.Canonical(host)
+}
+
+// SameHostname reports whether a and b name the same machine under the
+// store's hostname policy. Names that are not valid hostnames are compared
+// case-insensitively.
+func (storeInstance *Store) SameHostname(a, b string) bool {
+	canonicalA, errA := storeInstance.hostnames.Canonical(a)
+	canonicalB, errB := storeInstance.hostnames.Canonical(b)
+	if errA != nil || errB != nil {
+		return strings.EqualFold(a, b)
+	}
+	return canonicalA == canonicalB
+}
+
+// NonCanonicalHostnames lists the machines stored under a name that differs
+// from its canonical form, e.g. because they were recorded before the
+// hostname policy changed. Such machines are only reachable under their
+// canonical name once renamed.
+func (storeInstance *Store) NonCanonicalHostnames(ctx context.Context) ([]string, error) {
+	rows, err := storeInstance.db.QueryContext(ctx,
+		`SELECT hostname FROM machines WHERE merged_into IS NULL ORDER BY hostname`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		if canonical, err := storeInstance.hostnames.Canonical(name); err != nil || canonical != name {
+			names = append(names, name)
+		}
+	}
+	return names, rows.Err()
+}
+
+// RenameMachine gives the machine known as oldHost the canonical form of
+// newHost. Passwords, BitLocker keys, leases, approvals and the audit trail
+// belong to the machine, not the name, so all of them follow; enrollment
+// tokens pinned to the old name are re-pinned. oldHost is matched as stored
+// first, so machines stored under a non-canonical name can be renamed too.
+// Certificates keep naming the old host, so an enrolled machine must
+// re-enroll under its new name. Audited as "rename_machine".
+func (storeInstance *Store) RenameMachine(
+	ctx context.Context,
+	oldHost, newHost, actor, remoteAddr string,
+) error {
+	if actor == "" {
+		actor = defaultUnknownActor
+	}
+	newHost, err := storeInstance.hostnames.Canonical(newHost)
+	if err != nil {
+		return err
+	}
+	if err := checkNotGeneration(newHost); err != nil {
+		return err
+	}
+	machineID, storedName, err := storeInstance.storedMachine(ctx, oldHost)
+	if err != nil {
+		return err
+	}
+	if storedName == newHost {
+		return fmt.Errorf("%w: %s is already named %s", ErrHostnameTaken, oldHost, newHost)
+	}
+	taken, err := storeInstance.existingMachineID(ctx, newHost)
+	if err != nil {
+		return err
+	}
+	if taken != nil {
+		return fmt.Errorf("%w: %s", ErrHostnameTaken, newHost)
+	}
+
+	transaction, err := storeInstance.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer transaction.Rollback() // nolint:errcheck // no-op after Commit
+
+	if _, err := transaction.ExecContext(ctx,
+		`UPDATE machines SET hostname = ? WHERE id = ?`, newHost, machineID); err != nil {
+		return err
+	}
+	if _, err := transaction.ExecContext(ctx,
+		`UPDATE enrollment_tokens SET hostname = ?
+          WHERE hostname = ? AND used_at IS NULL`, newHost, storedName); err != nil {
+		return err
+	}
+	entry, err := storeInstance.appendAudit(ctx, transaction, machineID, "rename_machine",
+		actor, remoteAddr, storedName+" -> "+newHost, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	if err := transaction.Commit(); err != nil {
+		return err
+	}
+	storeInstance.notifyAudit(entry)
+	return nil
+}
+
+// canonicalizeEnrollmentTokens re-pins unused enrollment tokens to the
+// canonical form of their hostname, which enrollment compares against.
+func canonicalizeEnrollmentTokens(
+	ctx context.Context,
+	transaction *sql.Tx,
+	policy hostname.Policy,
+) error {
+	rows, err := transaction.QueryContext(ctx,
+		`SELECT id, hostname FROM enrollment_tokens
+          WHERE hostname IS NOT NULL AND used_at IS NULL`)
+	if err != nil {
+		return err
+	}
+	pins := map[int64]string{}
+	for rows.Next() {
+		var tokenID int64
+		var pinned string
+		if err := rows.Scan(&tokenID, &pinned); err != nil {
+			rows.Close()
+			return err
+		}
+		if canonical, err := policy.Canonical(pinned); err == nil && canonical != pinned {
+			pins[tokenID] = canonical
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for tokenID, canonical := range pins {
+		if _, err := transaction.ExecContext(ctx,
+			`UPDATE enrollment_tokens SET hostname = ? WHERE id = ?`, canonical, tokenID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storedMachine finds host by its stored name, then by its canonical form,
+// and returns its ID and stored name. Unknown hosts give ErrUnknownMachine.
+func (storeInstance *Store) storedMachine(ctx context.Context, host string) (int64, string, error) {
+	candidates := []string{host}
+	if canonical, err := storeInstance.hostnames.Canonical(host); err == nil && canonical != host {
+		candidates = append(candidates, canonical)
+	}
+	for _, candidate := range candidates {
+		var machineID int64
+		err := storeInstance.db.QueryRowContext(ctx,
+			`SELECT id FROM machines WHERE hostname = ? AND merged_into IS NULL`,
+			candidate).Scan(&machineID)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return 0, "", err
+		}
+		return machineID, candidate, nil
+	}
+	return 0, "", ErrUnknownMachine
+}
+
+// checkNotGeneration returns a *hostname.Error if host names a retired
+// generation, which only ClaimMachineIdentity may create.
+func checkNotGeneration(host string) error {
+	if _, generation := hostname.SplitGeneration(host); generation > 0 {
+		return &hostname.Error{Name: host, Reason: "names a retired machine generation"}
+	}
+	return nil
+}
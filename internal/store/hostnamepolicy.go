@@ -0,0 +1,348 @@
+// internal/store/hostnamepolicy.go
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jottavia/SHIPS2-Go/internal/hostname"
+)
+
+// hostnamePolicySetting names the settings row recording the hostname
+// policy the machines were last migrated to.
+const hostnamePolicySetting = "hostname_policy"
+
+// HostnameChange is one step of a hostname migration: the machine stored as
+// From is renamed To or, when Merge is set, merged into the machine that
+// keeps the name To.
+type HostnameChange struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Merge bool   `json:"merge"`
+}
+
+// HostnameStatus describes the hostname policy of a database. Policy is the
+// one in effect and Configured the one SHIPS_HOSTNAME_* ask for. Recorded
+// is false until a policy has been recorded in the database; Pending lists
+// what MigrateHostnames would change to bring the machines in line with
+// Configured.
+type HostnameStatus struct {
+	Policy     hostname.Policy  `json:"policy"`
+	Configured hostname.Policy  `json:"configured"`
+	Recorded   bool             `json:"recorded"`
+	Pending    []HostnameChange `json:"pending"`
+}
+
+// queryer is what *sql.DB and *sql.Tx have in common.
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// plannedHostnameChange is a HostnameChange with the machines it affects.
+type plannedHostnameChange struct {
+	HostnameChange
+	machineID, survivorID int64
+}
+
+// HostnameStatus reports the hostname policy in effect, the configured one
+// and what migrating to the configured one would change.
+func (storeInstance *Store) HostnameStatus(ctx context.Context) (*HostnameStatus, error) {
+	_, recorded, err := recordedHostnamePolicy(ctx, storeInstance.db)
+	if err != nil {
+		return nil, err
+	}
+	plan, err := planHostnameMigration(ctx, storeInstance.db, storeInstance.configuredHostnames)
+	if err != nil {
+		return nil, err
+	}
+	status := &HostnameStatus{
+		Policy:     storeInstance.hostnames,
+		Configured: storeInstance.configuredHostnames,
+		Recorded:   recorded,
+		Pending:    make([]HostnameChange, 0, len(plan)),
+	}
+	for _, change := range plan {
+		status.Pending = append(status.Pending, change.HostnameChange)
+	}
+	return status, nil
+}
+
+// MigrateHostnames brings every machine into the canonical form of policy
+// and records policy in the database, where every process opening it picks
+// it up. A machine stored under another form of its hostname is renamed;
+// machines that end up with the same name are merged into one, keeping the
+// one already named so, else the first in service: passwords, history,
+// BitLocker keys, checkouts, approvals, certificates and tokens move to it,
+// the password confirmed last and the newer key of a volume stay current,
+// and the merged machine stays
+// behind, renamed NAME#ID, to keep its audit trail, which is reported
+// under the surviving machine. Unused enrollment tokens are re-pinned.
+// Invalid names are left alone; NonCanonicalHostnames reports them.
+// Audited as "rename_machine" and "merge_machine".
+func (storeInstance *Store) MigrateHostnames(
+	ctx context.Context,
+	policy hostname.Policy,
+	actor string,
+) ([]HostnameChange, error) {
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	if actor == "" {
+		actor = defaultUnknownActor
+	}
+	transaction, err := storeInstance.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer transaction.Rollback() // nolint:errcheck // no-op after Commit
+
+	plan, err := planHostnameMigration(ctx, transaction, policy)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().Unix()
+	changes := make([]HostnameChange, 0, len(plan))
+	entries := make([]AuditEntry, 0, len(plan))
+	for _, change := range plan {
+		var entry AuditEntry
+		if change.Merge {
+			if err := mergeMachine(ctx, transaction, change.machineID, change.survivorID,
+				change.To, actor, now); err != nil {
+				return nil, fmt.Errorf("merging %s into %s: %w", change.From, change.To, err)
+			}
+			entry, err = storeInstance.appendAudit(ctx, transaction, change.survivorID,
+				"merge_machine", actor, "local",
+				change.From+" -> "+change.To+" (hostname policy)", now)
+		} else {
+			if _, err := transaction.ExecContext(ctx,
+				`UPDATE machines SET hostname = ? WHERE id = ?`,
+				change.To, change.machineID); err != nil {
+				return nil, fmt.Errorf("renaming %s to %s: %w", change.From, change.To, err)
+			}
+			entry, err = storeInstance.appendAudit(ctx, transaction, change.machineID,
+				"rename_machine", actor, "local",
+				change.From+" -> "+change.To+" (hostname policy)", now)
+		}
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, change.HostnameChange)
+		entries = append(entries, entry)
+	}
+	if err := canonicalizeEnrollmentTokens(ctx, transaction, policy); err != nil {
+		return nil, err
+	}
+	if err := recordHostnamePolicy(ctx, transaction, policy); err != nil {
+		return nil, err
+	}
+	if err := transaction.Commit(); err != nil {
+		return nil, err
+	}
+	storeInstance.hostnames = policy
+	for _, entry := range entries {
+		storeInstance.notifyAudit(entry)
+	}
+	return changes, nil
+}
+
+// loadHostnamePolicy puts the policy recorded in the database in effect. A
+// database without one records the configured policy if its machines
+// already follow it; otherwise the configured policy applies until
+// MigrateHostnames has brought them in line.
+func (storeInstance *Store) loadHostnamePolicy(ctx context.Context) error {
+	recorded, ok, err := recordedHostnamePolicy(ctx, storeInstance.db)
+	if err != nil {
+		return err
+	}
+	if ok {
+		storeInstance.hostnames = recorded
+		return nil
+	}
+	plan, err := planHostnameMigration(ctx, storeInstance.db, storeInstance.hostnames)
+	if err != nil || len(plan) > 0 {
+		return err
+	}
+	return recordHostnamePolicy(ctx, storeInstance.db, storeInstance.hostnames)
+}
+
+// recordedHostnamePolicy reads the policy recorded in the database, if any.
+func recordedHostnamePolicy(ctx context.Context, database queryer) (hostname.Policy, bool, error) {
+	var value string
+	err := database.QueryRowContext(ctx,
+		`SELECT value FROM settings WHERE name = ?`, hostnamePolicySetting,
+	).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return hostname.Policy{}, false, nil
+	}
+	if err != nil {
+		return hostname.Policy{}, false, err
+	}
+	var policy hostname.Policy
+	if err := json.Unmarshal([]byte(value), &policy); err != nil {
+		return hostname.Policy{}, false, fmt.Errorf("recorded hostname policy: %w", err)
+	}
+	if err := policy.Validate(); err != nil {
+		return hostname.Policy{}, false, fmt.Errorf("recorded hostname policy: %w", err)
+	}
+	return policy, true, nil
+}
+
+// recordHostnamePolicy stores policy as the one machines follow.
+func recordHostnamePolicy(ctx context.Context, database queryer, policy hostname.Policy) error {
+	value, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	_, err = database.ExecContext(ctx,
+		`REPLACE INTO settings(name, value) VALUES (?, ?)`, hostnamePolicySetting, string(value))
+	return err
+}
+
+// planHostnameMigration works out the renames and merges that bring every
+// machine into the canonical form of policy, ordered by target name.
+func planHostnameMigration(
+	ctx context.Context,
+	database queryer,
+	policy hostname.Policy,
+) ([]plannedHostnameChange, error) {
+	rows, err := database.QueryContext(ctx,
+		`SELECT id, hostname, retired_at IS NULL FROM machines
+          WHERE merged_into IS NULL
+          ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	type machine struct {
+		id        int64
+		stored    string
+		inService bool
+	}
+	groups := map[string][]machine{}
+	for rows.Next() {
+		var current machine
+		if err := rows.Scan(&current.id, &current.stored, &current.inService); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if canonical, err := policy.Canonical(current.stored); err == nil {
+			groups[canonical] = append(groups[canonical], current)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	targets := make([]string, 0, len(groups))
+	for target := range groups {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	var plan []plannedHostnameChange
+	for _, target := range targets {
+		members := groups[target]
+		survivor := -1
+		for i, member := range members {
+			if member.stored == target {
+				survivor = i
+				break
+			}
+			if survivor < 0 && member.inService {
+				survivor = i
+			}
+		}
+		if survivor < 0 {
+			survivor = 0
+		}
+		for i, member := range members {
+			if i == survivor {
+				continue
+			}
+			plan = append(plan, plannedHostnameChange{
+				HostnameChange: HostnameChange{From: member.stored, To: target, Merge: true},
+				machineID:      member.id,
+				survivorID:     members[survivor].id,
+			})
+		}
+		if kept := members[survivor]; kept.stored != target {
+			plan = append(plan, plannedHostnameChange{
+				HostnameChange: HostnameChange{From: kept.stored, To: target},
+				machineID:      kept.id,
+				survivorID:     kept.id,
+			})
+		}
+	}
+	return plan, nil
+}
+
+// mergeMachine moves everything of machine from to machine into inside
+// transaction, keeping the current password confirmed last and the newer
+// of two keys for the same volume, and leaves from behind as a
+// decommissioned alias of into.
+func mergeMachine(
+	ctx context.Context,
+	transaction *sql.Tx,
+	from, into int64,
+	intoName, actor string,
+	now int64,
+) error {
+	for _, statement := range []string{
+		`DELETE FROM passwords WHERE machine_id = :into AND
+            (SELECT MAX(id) FROM password_history
+              WHERE machine_id = :into AND state = 'confirmed') <
+            (SELECT MAX(id) FROM password_history
+              WHERE machine_id = :from AND state = 'confirmed')`,
+		`UPDATE OR IGNORE passwords SET machine_id = :into WHERE machine_id = :from`,
+		`DELETE FROM passwords WHERE machine_id = :from`,
+		`DELETE FROM bitlocker_keys WHERE machine_id = :into AND EXISTS (
+            SELECT 1 FROM bitlocker_keys other
+             WHERE other.machine_id = :from
+               AND other.volume = bitlocker_keys.volume
+               AND other.protector_id = bitlocker_keys.protector_id
+               AND other.updated_at > bitlocker_keys.updated_at)`,
+		`UPDATE OR IGNORE bitlocker_keys SET machine_id = :into WHERE machine_id = :from`,
+		`DELETE FROM bitlocker_keys WHERE machine_id = :from`,
+		`UPDATE password_history SET machine_id = :into WHERE machine_id = :from`,
+		`UPDATE password_leases SET machine_id = :into WHERE machine_id = :from`,
+		`UPDATE approval_requests SET machine_id = :into WHERE machine_id = :from`,
+		`UPDATE certificates SET machine_id = :into WHERE machine_id = :from`,
+		`UPDATE api_tokens SET machine_id = :into WHERE machine_id = :from`,
+		`UPDATE enrollment_tokens SET machine_id = :into WHERE machine_id = :from`,
+		`UPDATE machines SET merged_into = :into WHERE merged_into = :from`,
+		`UPDATE machines
+            SET first_seen = (SELECT MIN(first_seen) FROM machines WHERE id IN (:into, :from)),
+                last_checkin_at = (SELECT MAX(last_checkin_at) FROM machines
+                                    WHERE id IN (:into, :from)),
+                rotation_required_by = (SELECT rotation_required_by FROM machines
+                                         WHERE id IN (:into, :from)
+                                           AND rotation_required_at IS NOT NULL
+                                         ORDER BY rotation_required_at LIMIT 1),
+                rotation_required_at = (SELECT MIN(rotation_required_at) FROM machines
+                                         WHERE id IN (:into, :from)),
+                machine_identity = COALESCE(machine_identity,
+                    (SELECT machine_identity FROM machines WHERE id = :from))
+          WHERE id = :into`,
+	} {
+		if _, err := transaction.ExecContext(ctx, statement,
+			sql.Named("from", from), sql.Named("into", into)); err != nil {
+			return err
+		}
+	}
+	_, err := transaction.ExecContext(ctx,
+		`UPDATE machines
+            SET hostname = hostname || '#' || id, merged_into = ?,
+                rotation_required_at = NULL, rotation_required_by = NULL,
+                retired_at = COALESCE(retired_at, ?), retired_by = COALESCE(retired_by, ?),
+                retire_reason = COALESCE(retire_reason, ?),
+                purge_after = COALESCE(purge_after, ?), purged_at = COALESCE(purged_at, ?)
+          WHERE id = ?`,
+		into, now, actor, "merged into "+intoName, now, now, from)
+	return err
+}
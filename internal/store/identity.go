@@ -0,0 +1,253 @@
+// internal/store/identity.go
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jottavia/SHIPS2-Go/internal/hostname"
+)
+
+// What ClaimMachineIdentity does when a hostname arrives with an identity
+// other than the one on record.
+const (
+	// IdentityReject refuses the write and audits "identity_mismatch".
+	IdentityReject = "reject"
+	// IdentityNewGeneration retires the machine on record and starts a new
+	// generation under the same hostname.
+	IdentityNewGeneration = "generation"
+)
+
+// maxIdentityLength bounds reported identities; a SHA-256 fingerprint with
+// its prefix is 71 characters.
+const maxIdentityLength = 128
+
+// generationSeparator joins a hostname and the generation it was retired
+// as; the hostname package canonicalizes such names, so they can still be
+// looked up, renamed and audited.
+const generationSeparator = hostname.GenerationSeparator
+
+// ErrIdentityMismatch is returned when a machine reports an identity other
+// than the one recorded for its hostname and IdentityReject is in effect.
+var ErrIdentityMismatch = errors.New("machine identity does not match the one on record")
+
+// IdentitySettings controls ClaimMachineIdentity. Retention is how long the
+// secrets of a generation retired by IdentityNewGeneration are kept.
+type IdentitySettings struct {
+	OnMismatch string
+	Retention  time.Duration
+}
+
+// Validate checks that OnMismatch is a known mode.
+func (settings IdentitySettings) Validate() error {
+	if settings.OnMismatch != IdentityReject && settings.OnMismatch != IdentityNewGeneration {
+		return fmt.Errorf("identity mismatch handling must be %s or %s, got %q",
+			IdentityReject, IdentityNewGeneration, settings.OnMismatch)
+	}
+	return nil
+}
+
+// NormalizeMachineIdentity trims identity, such as "smbios:UUID",
+// "serial:NUMBER" or "cert:SHA256", and folds it to lower case so that
+// tools printing UUIDs in either case agree. Empty means none was reported.
+func NormalizeMachineIdentity(identity string) (string, error) {
+	identity = strings.ToLower(strings.TrimSpace(identity))
+	if len(identity) > maxIdentityLength {
+		return "", fmt.Errorf("machine identity longer than %d characters", maxIdentityLength)
+	}
+	for _, character := range identity {
+		if character < '!' || character > '~' {
+			return "", fmt.Errorf("machine identity contains %q", character)
+		}
+	}
+	return identity, nil
+}
+
+// ClaimMachineIdentity checks the stable identity a machine reported with a
+// write to host. The first identity reported for a machine is recorded
+// ("record_machine_identity"); later writes must report the same one. On a
+// mismatch, as when a reimaged PC reuses the name of an old one, it either
+// audits "identity_mismatch" and returns ErrIdentityMismatch, or retires the
+// machine on record as HOST~GENERATION and creates the next generation of
+// host with the new identity, so the new machine does not inherit the old
+// one's secrets. A write without an identity to a machine that has one on
+// record is rejected the same way, or, since no new generation can be
+// started without one, let through and audited as "identity_missing". A
+// machine is read and changed in one transaction, so two writes claiming it
+// at once cannot both supersede the same generation.
+func (storeInstance *Store) ClaimMachineIdentity(
+	ctx context.Context,
+	host, identity string,
+	settings IdentitySettings,
+	actor, remoteAddr string,
+) error {
+	identity, err := NormalizeMachineIdentity(identity)
+	if err != nil {
+		return err
+	}
+	if actor == "" {
+		actor = defaultUnknownActor
+	}
+	hostname, err := storeInstance.hostnames.Canonical(host)
+	if err != nil && identity == "" {
+		// Nothing can be on record; the write itself rejects the name.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if identity != "" {
+		// A new machine is created so its first identity can be recorded.
+		if _, err := storeInstance.getMachineID(ctx, host); err != nil {
+			return err
+		}
+	}
+
+	transaction, err := storeInstance.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer transaction.Rollback() // nolint:errcheck // no-op after Commit
+
+	// Whoever holds the name now, read inside the transaction that changes it.
+	var machineID int64
+	var recorded sql.NullString
+	var generation int
+	var retired bool
+	err = transaction.QueryRowContext(ctx,
+		`SELECT id, machine_identity, generation, retired_at IS NOT NULL
+           FROM machines WHERE hostname = ?`, hostname,
+	).Scan(&machineID, &recorded, &generation, &retired)
+	if errors.Is(err, sql.ErrNoRows) && identity == "" {
+		return nil
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: %s", ErrUnknownMachine, hostname)
+	}
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var entries []AuditEntry
+	var rejected error
+	switch {
+	case recorded.String == identity:
+		return nil
+	case identity == "" && settings.OnMismatch == IdentityNewGeneration:
+		var entry AuditEntry
+		entry, err = storeInstance.appendAudit(ctx, transaction, machineID, "identity_missing",
+			actor, remoteAddr, "no identity reported, recorded "+recorded.String, now.Unix())
+		entries = []AuditEntry{entry}
+	case identity == "":
+		var entry AuditEntry
+		entry, err = storeInstance.appendAudit(ctx, transaction, machineID, "identity_mismatch",
+			actor, remoteAddr, "no identity reported, recorded "+recorded.String, now.Unix())
+		entries = []AuditEntry{entry}
+		rejected = fmt.Errorf("%w: %s reported none", ErrIdentityMismatch, hostname)
+	case !recorded.Valid:
+		entries, err = storeInstance.recordIdentity(ctx, transaction, machineID, identity,
+			actor, remoteAddr, now)
+	case settings.OnMismatch == IdentityNewGeneration:
+		entries, err = storeInstance.newGeneration(ctx, transaction, machineID, hostname,
+			generation, retired, identity, settings.Retention, actor, remoteAddr, now)
+	default:
+		var entry AuditEntry
+		entry, err = storeInstance.appendAudit(ctx, transaction, machineID, "identity_mismatch",
+			actor, remoteAddr,
+			fmt.Sprintf("reported %s, recorded %s", identity, recorded.String), now.Unix())
+		entries = []AuditEntry{entry}
+		rejected = fmt.Errorf("%w: %s", ErrIdentityMismatch, hostname)
+	}
+	if err != nil {
+		return err
+	}
+	if err := transaction.Commit(); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		storeInstance.notifyAudit(entry)
+	}
+	return rejected
+}
+
+// recordIdentity stores the first identity a machine reported inside
+// transaction and returns its audit entry.
+func (storeInstance *Store) recordIdentity(
+	ctx context.Context,
+	transaction *sql.Tx,
+	machineID int64,
+	identity, actor, remoteAddr string,
+	now time.Time,
+) ([]AuditEntry, error) {
+	if _, err := transaction.ExecContext(ctx,
+		`UPDATE machines SET machine_identity = ? WHERE id = ?`, identity, machineID); err != nil {
+		return nil, err
+	}
+	entry, err := storeInstance.appendAudit(ctx, transaction, machineID, "record_machine_identity",
+		actor, remoteAddr, identity, now.Unix())
+	if err != nil {
+		return nil, err
+	}
+	return []AuditEntry{entry}, nil
+}
+
+// newGeneration retires machineID under HOST~GENERATION inside transaction,
+// unless it already is decommissioned, and creates the next generation of
+// hostname with identity. The retired generation keeps its secrets and
+// audit trail until its retention ends; audited as "machine_superseded"
+// and "new_machine_generation".
+func (storeInstance *Store) newGeneration(
+	ctx context.Context,
+	transaction *sql.Tx,
+	machineID int64,
+	hostname string,
+	generation int,
+	retired bool,
+	identity string,
+	retention time.Duration,
+	actor, remoteAddr string,
+	now time.Time,
+) ([]AuditEntry, error) {
+	retiredName := fmt.Sprintf("%s%s%d", hostname, generationSeparator, generation)
+	if !retired {
+		reason := fmt.Sprintf("superseded by generation %d reporting %s", generation+1, identity)
+		if err := retireMachine(ctx, transaction, machineID, reason, retention, actor,
+			now); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := transaction.ExecContext(ctx,
+		`UPDATE machines SET hostname = ? WHERE id = ?`, retiredName, machineID); err != nil {
+		return nil, err
+	}
+	result, err := transaction.ExecContext(ctx,
+		`INSERT INTO machines(hostname, machine_identity, generation) VALUES (?, ?, ?)`,
+		hostname, identity, generation+1)
+	if err != nil {
+		return nil, err
+	}
+	newMachineID, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	superseded, err := storeInstance.appendAudit(ctx, transaction, machineID, "machine_superseded",
+		actor, remoteAddr, fmt.Sprintf("%s retired as %s: a machine reporting %s took its name",
+			hostname, retiredName, identity), now.Unix())
+	if err != nil {
+		return nil, err
+	}
+	created, err := storeInstance.appendAudit(ctx, transaction, newMachineID,
+		"new_machine_generation", actor, remoteAddr,
+		fmt.Sprintf("generation %d reporting %s; previous generation kept as %s",
+			generation+1, identity, retiredName), now.Unix())
+	if err != nil {
+		return nil, err
+	}
+	return []AuditEntry{superseded, created}, nil
+}
@@ -0,0 +1,125 @@
+// internal/store/keyid.go
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// minKeyIDLength is the shortest protector ID prefix accepted by
+// FindBDEKeyByKeyID: the 8 characters printed on the recovery screen.
+// Shorter prefixes would let a caller enumerate keys across the fleet.
+const minKeyIDLength = 8
+
+// BitLockerKeyMatch is a recovery key found by Key ID, with its host.
+type BitLockerKeyMatch struct {
+	Hostname string `json:"hostname"`
+	BitLockerKeyInfo
+}
+
+// FindBDEKeyByKeyID resolves the Key ID shown on a BitLocker recovery screen
+// to the machine and recovery key it belongs to, without needing the
+// hostname. Every lookup is audited as "lookup_bde_key_id", including ones
+// that match nothing.
+func (storeInstance *Store) FindBDEKeyByKeyID(
+	ctx context.Context,
+	keyID, actor, remoteAddr string,
+) ([]BitLockerKeyMatch, error) {
+	keyID, err := normalizeProtectorID(keyID)
+	if err != nil {
+		return nil, err
+	}
+	if len(keyID) < minKeyIDLength {
+		return nil, fmt.Errorf("key ID must be at least %d characters", minKeyIDLength)
+	}
+
+	rows, err := storeInstance.db.QueryContext(ctx,
+		`SELECT k.machine_id, m.hostname, k.volume, k.protector_id, k.key_text, 
+                k.data_key, k.updated_at, k.actor
+           FROM bitlocker_keys k
+           JOIN machines m ON k.machine_id = m.id
+          WHERE k.protector_id LIKE ? || '%'
+          ORDER BY k.updated_at DESC, k.id DESC`,
+		keyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	matches := []BitLockerKeyMatch{}
+	machineIDs := []any{}
+	for rows.Next() {
+		var match BitLockerKeyMatch
+		var machineID, updatedAt int64
+		var sealed sealedSecret
+		if err := rows.Scan(&machineID, &match.Hostname, &match.Volume, &match.ProtectorID,
+			&sealed.ciphertext, &sealed.dataKey, &updatedAt, &match.Actor); err != nil {
+			return nil, err
+		}
+		if match.Key, err = storeInstance.open(sealed); err != nil {
+			return nil, err
+		}
+		match.UpdatedAt = time.Unix(updatedAt, 0)
+		matches = append(matches, match)
+		machineIDs = append(machineIDs, machineID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	// Log the lookup once per machine whose key was revealed, or once
+	// without a machine when nothing matched.
+	if actor == "" {
+		actor = defaultUnknownActor
+	}
+	if len(machineIDs) == 0 {
+		machineIDs = append(machineIDs, nil)
+	}
+	for _, machineID := range machineIDs {
+		if err := storeInstance.recordAudit(
+			ctx, machineID, "lookup_bde_key_id", actor, remoteAddr, "",
+		); err != nil {
+			return nil, err
+		}
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no recovery key with key ID %s", keyID)
+	}
+	return matches, nil
+}
+
+// BDEKeyIDHosts returns the hostnames whose recovery keys match keyID like
+// FindBDEKeyByKeyID would, without revealing or auditing anything.
+func (storeInstance *Store) BDEKeyIDHosts(ctx context.Context, keyID string) ([]string, error) {
+	keyID, err := normalizeProtectorID(keyID)
+	if err != nil {
+		return nil, err
+	}
+	if len(keyID) < minKeyIDLength {
+		return nil, fmt.Errorf("key ID must be at least %d characters", minKeyIDLength)
+	}
+
+	rows, err := storeInstance.db.QueryContext(ctx,
+		`SELECT DISTINCT m.hostname
+           FROM bitlocker_keys k
+           JOIN machines m ON k.machine_id = m.id
+          WHERE k.protector_id LIKE ? || '%'
+          ORDER BY m.hostname`,
+		keyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hosts := []string{}
+	for rows.Next() {
+		var host string
+		if err := rows.Scan(&host); err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts, rows.Err()
+}
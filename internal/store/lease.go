@@ -0,0 +1,369 @@
+// internal/store/lease.go
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// MaxLeaseDuration is the longest a password can be checked out for.
+const MaxLeaseDuration = 24 * time.Hour
+
+// How a lease ended.
+const (
+	LeaseCheckedIn      = "checkin"
+	LeaseExpired        = "expired"
+	LeaseDecommissioned = "decommissioned"
+)
+
+// ErrNoLease is returned when checking in a password the caller does not
+// hold.
+var ErrNoLease = errors.New("password is not checked out by you")
+
+// Lease is an exclusive checkout of a machine's password.
+type Lease struct {
+	ID           int64     `json:"id"`
+	Hostname     string    `json:"hostname"`
+	Holder       string    `json:"holder"`
+	CheckedOutAt time.Time `json:"checked_out_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// LeaseError is returned when a password is checked out by someone else.
+type LeaseError struct {
+	Lease *Lease
+}
+
+func (leaseError *LeaseError) Error() string {
+	return fmt.Sprintf("password of %s is checked out by %s until %s",
+		leaseError.Lease.Hostname, leaseError.Lease.Holder,
+		leaseError.Lease.ExpiresAt.UTC().Format(time.RFC3339))
+}
+
+// CheckoutPassword gives holder an exclusive lease on the password of host
+// for duration and returns the password. Checking out again before the
+// lease ends extends it; while it lasts everyone else gets a *LeaseError.
+// When the lease ends, by CheckinPassword or by expiry, the machine is
+// flagged as requiring rotation, so a decommissioned machine's password
+// cannot be checked out. Audited as "checkout_password".
+func (storeInstance *Store) CheckoutPassword(
+	ctx context.Context,
+	host string,
+	duration time.Duration,
+	holder, remoteAddr string,
+) (*Lease, *PasswordInfo, error) {
+	if duration <= 0 || duration > MaxLeaseDuration {
+		return nil, nil, fmt.Errorf("lease duration must be between 1s and %s", MaxLeaseDuration)
+	}
+	if holder == "" {
+		holder = defaultUnknownActor
+	}
+	machineID, err := storeInstance.knownMachineID(ctx, host)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := storeInstance.checkInService(ctx, machineID); err != nil {
+		return nil, nil, err
+	}
+	info, err := storeInstance.readPassword(ctx, machineID, host)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	transaction, err := storeInstance.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer transaction.Rollback() // nolint:errcheck // no-op after Commit
+
+	now := time.Now().Unix()
+	var entries []AuditEntry
+	lease, err := openLease(ctx, transaction, machineID, host)
+	if err != nil {
+		return nil, nil, err
+	}
+	if lease != nil && lease.ExpiresAt.Unix() <= now {
+		// Expired but not yet collected by ExpireLeases.
+		entry, err := storeInstance.endLease(ctx, transaction, lease, machineID,
+			LeaseExpired, expiryActor, "local", now)
+		if err != nil {
+			return nil, nil, err
+		}
+		entries = append(entries, entry)
+		lease = nil
+	}
+	if lease != nil && lease.Holder != holder {
+		return nil, nil, &LeaseError{Lease: lease}
+	}
+
+	expiresAt := time.Unix(now, 0).Add(duration)
+	action := "checkout_password"
+	if lease != nil {
+		action = "extend_checkout"
+		if _, err := transaction.ExecContext(ctx,
+			`UPDATE password_leases SET expires_at = ? WHERE id = ?`,
+			expiresAt.Unix(), lease.ID); err != nil {
+			return nil, nil, err
+		}
+		lease.ExpiresAt = expiresAt
+	} else {
+		result, err := transaction.ExecContext(ctx,
+			`INSERT INTO password_leases(machine_id, holder, checked_out_at, expires_at)
+             VALUES (?,?,?,?)`,
+			machineID, holder, now, expiresAt.Unix())
+		if err != nil {
+			return nil, nil, err
+		}
+		lease = &Lease{
+			Hostname:     host,
+			Holder:       holder,
+			CheckedOutAt: time.Unix(now, 0),
+			ExpiresAt:    expiresAt,
+		}
+		if lease.ID, err = result.LastInsertId(); err != nil {
+			return nil, nil, err
+		}
+	}
+	entry, err := storeInstance.appendAudit(ctx, transaction, machineID, action, holder,
+		remoteAddr, fmt.Sprintf("lease %d until %s", lease.ID,
+			expiresAt.UTC().Format(time.RFC3339)), now)
+	if err != nil {
+		return nil, nil, err
+	}
+	entries = append(entries, entry)
+	if err := transaction.Commit(); err != nil {
+		return nil, nil, err
+	}
+	for _, entry := range entries {
+		storeInstance.notifyAudit(entry)
+	}
+	return lease, info, nil
+}
+
+// CheckinPassword ends the lease holder has on the password of host before
+// it expires and flags the machine as requiring rotation. Audited as
+// "checkin_password".
+func (storeInstance *Store) CheckinPassword(
+	ctx context.Context,
+	host, holder, remoteAddr string,
+) error {
+	if holder == "" {
+		holder = defaultUnknownActor
+	}
+	machineID, err := storeInstance.knownMachineID(ctx, host)
+	if err != nil {
+		return err
+	}
+	if err := storeInstance.checkInService(ctx, machineID); err != nil {
+		return err
+	}
+
+	transaction, err := storeInstance.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer transaction.Rollback() // nolint:errcheck // no-op after Commit
+
+	now := time.Now().Unix()
+	lease, err := openLease(ctx, transaction, machineID, host)
+	if err != nil {
+		return err
+	}
+	if lease == nil || lease.Holder != holder || lease.ExpiresAt.Unix() <= now {
+		return ErrNoLease
+	}
+	entry, err := storeInstance.endLease(ctx, transaction, lease, machineID,
+		LeaseCheckedIn, holder, remoteAddr, now)
+	if err != nil {
+		return err
+	}
+	if err := transaction.Commit(); err != nil {
+		return err
+	}
+	storeInstance.notifyAudit(entry)
+	return nil
+}
+
+// ActiveLease returns the unexpired lease on the password of host, or nil.
+func (storeInstance *Store) ActiveLease(ctx context.Context, host string) (*Lease, error) {
+	host, err := storeInstance.hostnames.Canonical(host)
+	if err != nil {
+		return nil, nil
+	}
+	var machineID int64
+	err = storeInstance.db.QueryRowContext(ctx,
+		`SELECT id FROM machines WHERE hostname = ?`, host).Scan(&machineID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return storeInstance.activeLease(ctx, machineID, host)
+}
+
+// ExpireLeases ends every lease whose time ran out by now, flagging each
+// machine as requiring rotation and auditing it as "lease_expired". It
+// returns how many leases expired.
+func (storeInstance *Store) ExpireLeases(ctx context.Context, now time.Time) (int, error) {
+	transaction, err := storeInstance.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer transaction.Rollback() // nolint:errcheck // no-op after Commit
+
+	rows, err := transaction.QueryContext(ctx,
+		`SELECT l.id, l.machine_id, m.hostname, l.holder, l.checked_out_at, l.expires_at
+           FROM password_leases l
+           JOIN machines m ON l.machine_id = m.id
+          WHERE l.ended_at IS NULL AND l.expires_at <= ?
+          ORDER BY l.id`,
+		now.Unix())
+	if err != nil {
+		return 0, err
+	}
+	type expiredLease struct {
+		lease     *Lease
+		machineID int64
+	}
+	var expired []expiredLease
+	for rows.Next() {
+		var machineID int64
+		lease, err := scanLease(rows, &machineID)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		expired = append(expired, expiredLease{lease, machineID})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	entries := make([]AuditEntry, 0, len(expired))
+	for _, item := range expired {
+		entry, err := storeInstance.endLease(ctx, transaction, item.lease, item.machineID,
+			LeaseExpired, expiryActor, "local", now.Unix())
+		if err != nil {
+			return 0, err
+		}
+		entries = append(entries, entry)
+	}
+	if err := transaction.Commit(); err != nil {
+		return 0, err
+	}
+	for _, entry := range entries {
+		storeInstance.notifyAudit(entry)
+	}
+	return len(expired), nil
+}
+
+// checkLease returns a *LeaseError when the password of the machine is
+// checked out by someone other than actor.
+func (storeInstance *Store) checkLease(
+	ctx context.Context,
+	machineID int64,
+	host, actor string,
+) error {
+	lease, err := storeInstance.activeLease(ctx, machineID, host)
+	if err != nil {
+		return err
+	}
+	if lease != nil && lease.Holder != actor {
+		return &LeaseError{Lease: lease}
+	}
+	return nil
+}
+
+// activeLease returns the unexpired lease of the machine, or nil.
+func (storeInstance *Store) activeLease(
+	ctx context.Context,
+	machineID int64,
+	host string,
+) (*Lease, error) {
+	return leaseFromRow(storeInstance.db.QueryRowContext(ctx,
+		`SELECT id, holder, checked_out_at, expires_at
+           FROM password_leases
+          WHERE machine_id = ? AND ended_at IS NULL AND expires_at > ?`,
+		machineID, time.Now().Unix(),
+	), host)
+}
+
+// openLease returns the lease of the machine that has not been ended,
+// whether or not it has expired, or nil.
+func openLease(
+	ctx context.Context,
+	transaction *sql.Tx,
+	machineID int64,
+	host string,
+) (*Lease, error) {
+	return leaseFromRow(transaction.QueryRowContext(ctx,
+		`SELECT id, holder, checked_out_at, expires_at
+           FROM password_leases
+          WHERE machine_id = ? AND ended_at IS NULL
+          ORDER BY id DESC
+          LIMIT 1`,
+		machineID,
+	), host)
+}
+
+// leaseFromRow reads a lease of host from a row of id, holder,
+// checked_out_at and expires_at, returning nil when there is none.
+func leaseFromRow(row *sql.Row, host string) (*Lease, error) {
+	lease := Lease{Hostname: host}
+	var checkedOutAt, expiresAt int64
+	err := row.Scan(&lease.ID, &lease.Holder, &checkedOutAt, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	lease.CheckedOutAt = time.Unix(checkedOutAt, 0)
+	lease.ExpiresAt = time.Unix(expiresAt, 0)
+	return &lease, nil
+}
+
+// endLease closes lease for reason, flags the machine as requiring
+// rotation on behalf of the holder and appends the audit entry.
+func (storeInstance *Store) endLease(
+	ctx context.Context,
+	transaction *sql.Tx,
+	lease *Lease,
+	machineID int64,
+	reason, actor, remoteAddr string,
+	now int64,
+) (AuditEntry, error) {
+	if _, err := transaction.ExecContext(ctx,
+		`UPDATE password_leases SET ended_at = ?, end_reason = ? WHERE id = ?`,
+		now, reason, lease.ID); err != nil {
+		return AuditEntry{}, err
+	}
+	if err := requireRotation(ctx, transaction, machineID, lease.Holder, now); err != nil {
+		return AuditEntry{}, err
+	}
+	action := "checkin_password"
+	if reason == LeaseExpired {
+		action = "lease_expired"
+	}
+	return storeInstance.appendAudit(ctx, transaction, machineID, action, actor, remoteAddr,
+		fmt.Sprintf("lease %d of %s ended, rotation required", lease.ID, lease.Holder), now)
+}
+
+// scanLease reads a lease row of id, machine_id, hostname, holder,
+// checked_out_at and expires_at.
+func scanLease(rows *sql.Rows, machineID *int64) (*Lease, error) {
+	var lease Lease
+	var checkedOutAt, expiresAt int64
+	if err := rows.Scan(&lease.ID, machineID, &lease.Hostname, &lease.Holder,
+		&checkedOutAt, &expiresAt); err != nil {
+		return nil, err
+	}
+	lease.CheckedOutAt = time.Unix(checkedOutAt, 0)
+	lease.ExpiresAt = time.Unix(expiresAt, 0)
+	return &lease, nil
+}
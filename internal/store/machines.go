@@ -0,0 +1,247 @@
+// internal/store/machines.go
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Paging limits for ListMachines.
+const (
+	defaultMachineLimit = 100
+	maxMachineLimit     = 1000
+)
+
+// secretReadActions are the audit actions that reveal a secret, as an SQL
+// list for "action IN (...)".
+const secretReadActions = `'fetch_password', 'fetch_password_history', 'checkout_password',
+                           'fetch_bde_key', 'lookup_bde_key_id'`
+
+// Sort orders accepted by ListMachines.
+const (
+	SortHostname      = "hostname"
+	SortFirstSeen     = "first_seen"
+	SortLastRotation  = "last_rotation"
+	SortLastKeyUpdate = "last_key_update"
+	SortLastFetch     = "last_fetch"
+)
+
+// machineSortKeys maps a sort order to the column of machineInventoryQuery
+// it sorts by; a machine that never had the event sorts as time zero.
+var machineSortKeys = map[string]string{
+	SortHostname:      "hostname",
+	SortFirstSeen:     "first_seen",
+	SortLastRotation:  "COALESCE(last_rotation, 0)",
+	SortLastKeyUpdate: "COALESCE(last_key_update, 0)",
+	SortLastFetch:     "COALESCE(last_fetch, 0)",
+}
+
+var (
+	// ErrUnknownSort is returned by ListMachines for a sort order it does
+	// not know.
+	ErrUnknownSort = errors.New("unknown sort order")
+	// ErrInvalidCursor is returned by ListMachines for a cursor it did not
+	// issue for the same sort order.
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+// Machine is one entry of the machine inventory. It carries when secrets
+// changed or were read, never the secrets themselves; times are zero for
+// events that never happened. RetiredAt is set for decommissioned machines;
+// Identity is the stable identity the machine reports, if any, and
+// Generation counts the machines that have used the hostname.
+type Machine struct {
+	Hostname      string    `json:"hostname"`
+	FirstSeen     time.Time `json:"first_seen"`
+	LastRotation  time.Time `json:"last_rotation"`
+	LastKeyUpdate time.Time `json:"last_key_update"`
+	LastFetch     time.Time `json:"last_fetch"`
+	RetiredAt     time.Time `json:"retired_at"`
+	Identity      string    `json:"machine_identity"`
+	Generation    int       `json:"generation"`
+}
+
+// MachineFilter selects machines. Search matches anywhere in the hostname
+// and Prefix at its start, both ignoring case; zero values match everything.
+// Sort is one of the Sort constants, hostname by default. Pass the cursor
+// returned with a page as Cursor to fetch the next one.
+type MachineFilter struct {
+	Search     string
+	Prefix     string
+	Sort       string
+	Descending bool
+	Cursor     string
+	Limit      int
+}
+
+// machineInventoryQuery selects every machine with its inventory times,
+// counting fetches audited for machines merged into it.
+const machineInventoryQuery = `
+SELECT m.hostname, m.first_seen,
+       (SELECT p.updated_at FROM passwords p WHERE p.machine_id = m.id) AS last_rotation,
+       (SELECT MAX(b.updated_at) FROM bitlocker_keys b
+         WHERE b.machine_id = m.id) AS last_key_update,
+       (SELECT MAX(a.timestamp) FROM audit_logs a
+         WHERE a.machine_id IN (SELECT x.id FROM machines x
+                                 WHERE x.id = m.id OR x.merged_into = m.id)
+           AND a.action IN (` + secretReadActions + `)) AS last_fetch,
+       m.retired_at, m.machine_identity, m.generation
+  FROM machines m`
+
+// ListMachines returns the machines matching filter and the cursor of the
+// next page (empty when there are no more).
+func (storeInstance *Store) ListMachines(
+	ctx context.Context,
+	filter MachineFilter,
+) ([]Machine, string, error) {
+	sortName := filter.Sort
+	if sortName == "" {
+		sortName = SortHostname
+	}
+	sortKey, ok := machineSortKeys[sortName]
+	if !ok {
+		return nil, "", fmt.Errorf("%w %q", ErrUnknownSort, filter.Sort)
+	}
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultMachineLimit
+	}
+	if limit > maxMachineLimit {
+		limit = maxMachineLimit
+	}
+
+	conditions := []string{"m.merged_into IS NULL"}
+	var args []any
+	if filter.Search != "" {
+		conditions = append(conditions, `m.hostname LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escapeLike(filter.Search)+"%")
+	}
+	if filter.Prefix != "" {
+		conditions = append(conditions, `m.hostname LIKE ? ESCAPE '\'`)
+		args = append(args, escapeLike(filter.Prefix)+"%")
+	}
+	query := machineInventoryQuery + " WHERE " + strings.Join(conditions, " AND ")
+
+	// Keyset pagination on (sort key, hostname); hostnames are unique.
+	comparison, direction := ">", "ASC"
+	if filter.Descending {
+		comparison, direction = "<", "DESC"
+	}
+	outer := "SELECT * FROM (" + query + ")"
+	if filter.Cursor != "" {
+		value, hostname, err := decodeMachineCursor(filter.Cursor, sortName)
+		if err != nil {
+			return nil, "", err
+		}
+		if sortName == SortHostname {
+			outer += " WHERE hostname " + comparison + " ?"
+			args = append(args, hostname)
+		} else {
+			outer += fmt.Sprintf(" WHERE (%s, hostname) %s (?, ?)", sortKey, comparison)
+			args = append(args, value, hostname)
+		}
+	}
+	if sortName == SortHostname {
+		outer += " ORDER BY hostname " + direction
+	} else {
+		outer += fmt.Sprintf(" ORDER BY %s %s, hostname %s", sortKey, direction, direction)
+	}
+	// Fetch one extra row to learn whether another page exists.
+	outer += " LIMIT ?"
+	args = append(args, limit+1)
+
+	rows, err := storeInstance.db.QueryContext(ctx, outer, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	machines, err := scanMachines(rows)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var nextCursor string
+	if len(machines) > limit {
+		machines = machines[:limit]
+		nextCursor = encodeMachineCursor(machines[limit-1], sortName)
+	}
+	return machines, nextCursor, nil
+}
+
+// scanMachines reads the rows of machineInventoryQuery.
+func scanMachines(rows *sql.Rows) ([]Machine, error) {
+	machines := []Machine{}
+	for rows.Next() {
+		var machine Machine
+		var firstSeen int64
+		var lastRotation, lastKeyUpdate, lastFetch, retiredAt sql.NullInt64
+		var identity sql.NullString
+		if err := rows.Scan(&machine.Hostname, &firstSeen, &lastRotation,
+			&lastKeyUpdate, &lastFetch, &retiredAt, &identity, &machine.Generation); err != nil {
+			return nil, err
+		}
+		machine.FirstSeen = time.Unix(firstSeen, 0)
+		machine.LastRotation = nullableTime(lastRotation)
+		machine.LastKeyUpdate = nullableTime(lastKeyUpdate)
+		machine.LastFetch = nullableTime(lastFetch)
+		machine.RetiredAt = nullableTime(retiredAt)
+		machine.Identity = identity.String
+		machines = append(machines, machine)
+	}
+	return machines, rows.Err()
+}
+
+// encodeMachineCursor returns the opaque cursor of the page after machine:
+// the sort order, the sort key of machine and its hostname.
+func encodeMachineCursor(machine Machine, sortName string) string {
+	var value int64
+	switch sortName {
+	case SortFirstSeen:
+		value = machine.FirstSeen.Unix()
+	case SortLastRotation:
+		value = unixOrZero(machine.LastRotation)
+	case SortLastKeyUpdate:
+		value = unixOrZero(machine.LastKeyUpdate)
+	case SortLastFetch:
+		value = unixOrZero(machine.LastFetch)
+	}
+	raw := sortName + "|" + strconv.FormatInt(value, 10) + "|" + machine.Hostname
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeMachineCursor reverses encodeMachineCursor for sortName.
+func decodeMachineCursor(cursor, sortName string) (int64, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, "", ErrInvalidCursor
+	}
+	parts := strings.SplitN(string(raw), "|", 3)
+	if len(parts) != 3 || parts[0] != sortName {
+		return 0, "", ErrInvalidCursor
+	}
+	value, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, "", ErrInvalidCursor
+	}
+	return value, parts[2], nil
+}
+
+// unixOrZero is the inverse of nullableTime.
+func unixOrZero(timestamp time.Time) int64 {
+	if timestamp.IsZero() {
+		return 0
+	}
+	return timestamp.Unix()
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern with a backslash.
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
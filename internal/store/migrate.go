@@ -0,0 +1,204 @@
+// internal/store/migrate.go
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// migrate upgrades databases created by older releases in place. Every step
+// must be idempotent because it runs on each start.
+func (storeInstance *Store) migrate() error {
+	ctx := context.Background()
+	for _, column := range []struct{ table, name, definition string }{
+		{"passwords", "data_key", "TEXT"},
+		{"bitlocker_keys", "data_key", "TEXT"},
+		{"audit_logs", "prev_hash", "TEXT"},
+		{"audit_logs", "row_hash", "TEXT"},
+		{"audit_logs", "detail", "TEXT"},
+		{"api_tokens", "machine_id", "INTEGER REFERENCES machines(id)"},
+		{"password_history", "state", "TEXT NOT NULL DEFAULT 'confirmed'"},
+		{"password_history", "expires_at", "INTEGER"},
+		{"password_history", "confirmed_at", "INTEGER"},
+		{"machines", "rotation_required_at", "INTEGER"},
+		{"machines", "rotation_required_by", "TEXT"},
+		{"machines", "last_checkin_at", "INTEGER"},
+		{"machines", "retired_at", "INTEGER"},
+		{"machines", "retired_by", "TEXT"},
+		{"machines", "retire_reason", "TEXT"},
+		{"machines", "purge_after", "INTEGER"},
+		{"machines", "purged_at", "INTEGER"},
+		{"machines", "machine_identity", "TEXT"},
+		{"machines", "generation", "INTEGER NOT NULL DEFAULT 1"},
+		{"machines", "merged_into", "INTEGER REFERENCES machines(id)"},
+	} {
+		if err := storeInstance.addColumnIfMissing(
+			ctx, column.table, column.name, column.definition,
+		); err != nil {
+			return err
+		}
+	}
+	if err := storeInstance.rebuildBitLockerKeys(ctx); err != nil {
+		return err
+	}
+	for _, sealedTable := range sealedTables {
+		if err := storeInstance.encryptPlaintext(
+			ctx, sealedTable.table, sealedTable.secretColumn,
+		); err != nil {
+			return err
+		}
+	}
+	if err := storeInstance.backfillPasswordHistory(ctx); err != nil {
+		return err
+	}
+	return storeInstance.chainLegacyAudit(ctx)
+}
+
+// backfillPasswordHistory seeds password_history with the current password
+// of machines that predate the history table.
+func (storeInstance *Store) backfillPasswordHistory(ctx context.Context) error {
+	_, err := storeInstance.db.ExecContext(ctx,
+		`INSERT INTO password_history(machine_id, password, data_key, created_at, actor)
+         SELECT p.machine_id, p.password, p.data_key, p.updated_at, p.actor
+           FROM passwords p
+          WHERE NOT EXISTS (
+                SELECT 1 FROM password_history h WHERE h.machine_id = p.machine_id)`)
+	return err
+}
+
+// rebuildBitLockerKeys converts the original one-key-per-machine
+// bitlocker_keys table (machine_id UNIQUE) to the per-volume layout. SQLite
+// cannot drop a constraint, so the table is copied and swapped. Existing keys
+// keep an empty volume and protector ID.
+func (storeInstance *Store) rebuildBitLockerKeys(ctx context.Context) error {
+	hasProtector, err := storeInstance.hasColumn(ctx, "bitlocker_keys", "protector_id")
+	if err != nil || hasProtector {
+		return err
+	}
+
+	transaction, err := storeInstance.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer transaction.Rollback() // nolint:errcheck // no-op after Commit
+
+	for _, statement := range []string{
+		`CREATE TABLE bitlocker_keys_v2(
+            id           INTEGER PRIMARY KEY,
+            machine_id   INTEGER NOT NULL,
+            volume       TEXT    NOT NULL DEFAULT '',
+            protector_id TEXT    NOT NULL DEFAULT '',
+            key_text     TEXT    NOT NULL,
+            data_key     TEXT,
+            updated_at   INTEGER NOT NULL,
+            actor        TEXT    NOT NULL,
+            UNIQUE(machine_id, volume, protector_id),
+            FOREIGN KEY(machine_id) REFERENCES machines(id))`,
+		`INSERT INTO bitlocker_keys_v2(machine_id, key_text, data_key, updated_at, actor)
+         SELECT machine_id, key_text, data_key, updated_at, actor FROM bitlocker_keys`,
+		`DROP TABLE bitlocker_keys`,
+		`ALTER TABLE bitlocker_keys_v2 RENAME TO bitlocker_keys`,
+	} {
+		if _, err := transaction.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("rebuilding bitlocker_keys: %w", err)
+		}
+	}
+	return transaction.Commit()
+}
+
+// hasColumn reports whether table has a column called column.
+func (storeInstance *Store) hasColumn(
+	ctx context.Context,
+	table, column string,
+) (bool, error) {
+	rows, err := storeInstance.db.QueryContext(ctx,
+		`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// addColumnIfMissing runs ALTER TABLE ADD COLUMN unless the column exists.
+func (storeInstance *Store) addColumnIfMissing(
+	ctx context.Context,
+	table, column, definition string,
+) error {
+	exists, err := storeInstance.hasColumn(ctx, table, column)
+	if err != nil || exists {
+		return err
+	}
+	// Identifiers cannot be bound as parameters; callers only pass constants.
+	_, err = storeInstance.db.ExecContext(ctx,
+		fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, definition))
+	return err
+}
+
+// encryptPlaintext seals every row of table whose data_key is still NULL,
+// i.e. rows written before encryption at rest was introduced.
+func (storeInstance *Store) encryptPlaintext(
+	ctx context.Context,
+	table, secretColumn string,
+) error {
+	transaction, err := storeInstance.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer transaction.Rollback() // nolint:errcheck // no-op after Commit
+
+	rows, err := transaction.QueryContext(ctx, fmt.Sprintf(
+		`SELECT rowid, %s FROM %s WHERE data_key IS NULL`, secretColumn, table))
+	if err != nil {
+		return err
+	}
+	plaintexts := map[int64]string{}
+	for rows.Next() {
+		var rowID int64
+		var plaintext string
+		if err := rows.Scan(&rowID, &plaintext); err != nil {
+			rows.Close()
+			return err
+		}
+		plaintexts[rowID] = plaintext
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for rowID, plaintext := range plaintexts {
+		sealed, err := storeInstance.seal(plaintext)
+		if err != nil {
+			return err
+		}
+		if err := updateSealed(ctx, transaction, table, secretColumn, rowID, sealed); err != nil {
+			return fmt.Errorf("encrypting %s row %d: %w", table, rowID, err)
+		}
+	}
+	return transaction.Commit()
+}
+
+// updateSealed overwrites the secret and data key of a single row.
+func updateSealed(
+	ctx context.Context,
+	transaction *sql.Tx,
+	table, secretColumn string,
+	rowID int64,
+	sealed sealedSecret,
+) error {
+	_, err := transaction.ExecContext(ctx, fmt.Sprintf(
+		`UPDATE %s SET %s = ?, data_key = ? WHERE rowid = ?`, table, secretColumn),
+		sealed.ciphertext, sealed.dataKey, rowID)
+	return err
+}
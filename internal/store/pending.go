@@ -0,0 +1,349 @@
+// internal/store/pending.go
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// expiryActor is the actor audited when the server expires pending
+// rotations on its own.
+const expiryActor = "ships-server"
+
+var (
+	// ErrUnknownRotation is returned for password versions that do not exist.
+	ErrUnknownRotation = errors.New("unknown password version")
+	// ErrRotationNotPending is returned when confirming a version that is
+	// already confirmed or older than the confirmed password.
+	ErrRotationNotPending = errors.New("password version is not pending")
+	// ErrRotationExpired is returned when confirming a version whose
+	// confirmation window has passed.
+	ErrRotationExpired = errors.New("pending password version has expired")
+)
+
+// BeginRotation escrows password for host as a pending version that becomes
+// the current password only when ConfirmRotation is called within ttl,
+// after the client has applied it. Until then GetPassword reports it
+// alongside the confirmed password. Audited as "rotate_password_pending".
+func (storeInstance *Store) BeginRotation(
+	ctx context.Context,
+	host, password string,
+	ttl time.Duration,
+	actor, remoteAddr string,
+) (*PasswordVersion, error) {
+	if password == "" {
+		return nil, errors.New("password cannot be empty")
+	}
+	if ttl <= 0 {
+		return nil, errors.New("pending rotation lifetime must be positive")
+	}
+	if actor == "" {
+		actor = defaultUnknownActor
+	}
+	machineID, err := storeInstance.activeMachineID(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := storeInstance.seal(password)
+	if err != nil {
+		return nil, err
+	}
+
+	transaction, err := storeInstance.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer transaction.Rollback() // nolint:errcheck // no-op after Commit
+
+	now := time.Now().Unix()
+	version := &PasswordVersion{
+		Password:  password,
+		RotatedAt: time.Unix(now, 0),
+		Actor:     actor,
+		State:     PasswordPending,
+		ExpiresAt: time.Unix(now, 0).Add(ttl),
+	}
+	result, err := transaction.ExecContext(ctx,
+		`INSERT INTO password_history(machine_id, password, data_key, created_at, actor,
+                                      state, expires_at)
+         VALUES (?,?,?,?,?,?,?)`,
+		machineID, sealed.ciphertext, sealed.dataKey, now, actor, PasswordPending,
+		version.ExpiresAt.Unix())
+	if err != nil {
+		return nil, err
+	}
+	if version.ID, err = result.LastInsertId(); err != nil {
+		return nil, err
+	}
+	entry, err := storeInstance.appendAudit(ctx, transaction, machineID,
+		"rotate_password_pending", actor, remoteAddr,
+		fmt.Sprintf("password %d pending until %s", version.ID,
+			version.ExpiresAt.UTC().Format(time.RFC3339)), now)
+	if err != nil {
+		return nil, err
+	}
+	if err := transaction.Commit(); err != nil {
+		return nil, err
+	}
+	storeInstance.notifyAudit(entry)
+	return version, nil
+}
+
+// RotationHost returns the hostname a password version belongs to.
+func (storeInstance *Store) RotationHost(ctx context.Context, id int64) (string, error) {
+	var host string
+	err := storeInstance.db.QueryRowContext(ctx,
+		`SELECT m.hostname
+           FROM password_history h
+           JOIN machines m ON h.machine_id = m.id
+          WHERE h.id = ?`, id,
+	).Scan(&host)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrUnknownRotation
+	}
+	return host, err
+}
+
+// ConfirmRotation makes the pending password version id the current
+// password of its machine. Audited as "confirm_password"; a version found
+// expired is marked so and audited as "pending_password_expired" instead.
+// A version begun before the confirmed password cannot replace it, since
+// the machine has moved on to the newer one.
+func (storeInstance *Store) ConfirmRotation(
+	ctx context.Context,
+	id int64,
+	actor, remoteAddr string,
+) error {
+	if actor == "" {
+		actor = defaultUnknownActor
+	}
+	transaction, err := storeInstance.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer transaction.Rollback() // nolint:errcheck // no-op after Commit
+
+	var machineID int64
+	var sealed sealedSecret
+	var state, createdBy string
+	var expiresAt sql.NullInt64
+	err = transaction.QueryRowContext(ctx,
+		`SELECT machine_id, password, data_key, actor, state, expires_at
+           FROM password_history WHERE id = ?`, id,
+	).Scan(&machineID, &sealed.ciphertext, &sealed.dataKey, &createdBy, &state, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrUnknownRotation
+	}
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	switch {
+	case state == PasswordExpired:
+		return ErrRotationExpired
+	case state != PasswordPending:
+		return ErrRotationNotPending
+	case expiresAt.Valid && now >= expiresAt.Int64:
+		entry, err := expirePendingVersion(ctx, storeInstance, transaction, id, machineID, now)
+		if err != nil {
+			return err
+		}
+		if err := transaction.Commit(); err != nil {
+			return err
+		}
+		storeInstance.notifyAudit(entry)
+		return ErrRotationExpired
+	}
+	var latest int64
+	if err := transaction.QueryRowContext(ctx,
+		`SELECT COALESCE(MAX(id), 0) FROM password_history
+          WHERE machine_id = ? AND state = ?`, machineID, PasswordConfirmed,
+	).Scan(&latest); err != nil {
+		return err
+	}
+	if latest > id {
+		return fmt.Errorf("%w: newer version %d is confirmed", ErrRotationNotPending, latest)
+	}
+
+	if _, err := transaction.ExecContext(ctx,
+		`UPDATE password_history SET state = ?, confirmed_at = ? WHERE id = ?`,
+		PasswordConfirmed, now, id); err != nil {
+		return err
+	}
+	if _, err := transaction.ExecContext(ctx,
+		`REPLACE INTO passwords(machine_id, password, data_key, updated_at, actor)
+         VALUES (?,?,?,?,?)`,
+		machineID, sealed.ciphertext, sealed.dataKey, now, createdBy); err != nil {
+		return err
+	}
+	if err := clearRotationRequired(ctx, transaction, machineID); err != nil {
+		return err
+	}
+	entry, err := storeInstance.appendAudit(ctx, transaction, machineID, "confirm_password",
+		actor, remoteAddr, fmt.Sprintf("password %d confirmed", id), now)
+	if err != nil {
+		return err
+	}
+	if err := transaction.Commit(); err != nil {
+		return err
+	}
+	storeInstance.notifyAudit(entry)
+	return nil
+}
+
+// CancelRotation marks the pending or expired password version id as
+// cancelled, for clients that escrowed it but failed to apply it, so it is
+// never mistaken for the password the machine has. The confirmed password
+// stays current. Audited as "cancel_password".
+func (storeInstance *Store) CancelRotation(
+	ctx context.Context,
+	id int64,
+	actor, remoteAddr string,
+) error {
+	if actor == "" {
+		actor = defaultUnknownActor
+	}
+	transaction, err := storeInstance.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer transaction.Rollback() // nolint:errcheck // no-op after Commit
+
+	var machineID int64
+	var state string
+	err = transaction.QueryRowContext(ctx,
+		`SELECT machine_id, state FROM password_history WHERE id = ?`, id,
+	).Scan(&machineID, &state)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrUnknownRotation
+	}
+	if err != nil {
+		return err
+	}
+	if state != PasswordPending && state != PasswordExpired {
+		return ErrRotationNotPending
+	}
+
+	now := time.Now().Unix()
+	if _, err := transaction.ExecContext(ctx,
+		`UPDATE password_history SET state = ? WHERE id = ?`, PasswordCancelled, id); err != nil {
+		return err
+	}
+	entry, err := storeInstance.appendAudit(ctx, transaction, machineID, "cancel_password",
+		actor, remoteAddr, fmt.Sprintf("password %d was not applied", id), now)
+	if err != nil {
+		return err
+	}
+	if err := transaction.Commit(); err != nil {
+		return err
+	}
+	storeInstance.notifyAudit(entry)
+	return nil
+}
+
+// ExpirePendingRotations marks every pending version whose confirmation
+// window has passed by now as expired, auditing each as
+// "pending_password_expired". It returns how many versions expired.
+func (storeInstance *Store) ExpirePendingRotations(ctx context.Context, now time.Time) (int, error) {
+	transaction, err := storeInstance.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer transaction.Rollback() // nolint:errcheck // no-op after Commit
+
+	rows, err := transaction.QueryContext(ctx,
+		`SELECT id, machine_id FROM password_history
+          WHERE state = ? AND expires_at <= ?
+          ORDER BY id`,
+		PasswordPending, now.Unix())
+	if err != nil {
+		return 0, err
+	}
+	type stale struct{ id, machineID int64 }
+	var expired []stale
+	for rows.Next() {
+		var version stale
+		if err := rows.Scan(&version.id, &version.machineID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		expired = append(expired, version)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	entries := make([]AuditEntry, 0, len(expired))
+	for _, version := range expired {
+		entry, err := expirePendingVersion(ctx, storeInstance, transaction, version.id,
+			version.machineID, now.Unix())
+		if err != nil {
+			return 0, err
+		}
+		entries = append(entries, entry)
+	}
+	if err := transaction.Commit(); err != nil {
+		return 0, err
+	}
+	for _, entry := range entries {
+		storeInstance.notifyAudit(entry)
+	}
+	return len(expired), nil
+}
+
+// expirePendingVersion marks version id expired inside transaction and
+// appends its audit entry.
+func expirePendingVersion(
+	ctx context.Context,
+	storeInstance *Store,
+	transaction *sql.Tx,
+	id, machineID, now int64,
+) (AuditEntry, error) {
+	if _, err := transaction.ExecContext(ctx,
+		`UPDATE password_history SET state = ? WHERE id = ?`, PasswordExpired, id); err != nil {
+		return AuditEntry{}, err
+	}
+	return storeInstance.appendAudit(ctx, transaction, machineID, "pending_password_expired",
+		expiryActor, "local", fmt.Sprintf("password %d was never confirmed", id), now)
+}
+
+// pendingPassword returns the newest version of the machine that is newer
+// than its confirmed password and was never confirmed nor cancelled, or nil.
+// Expired versions are included, since a client that applied one and then
+// failed to confirm it leaves the machine with that password; State tells
+// them apart, and reads expired as soon as expires_at has passed even
+// before ExpirePendingRotations marks it.
+func (storeInstance *Store) pendingPassword(
+	ctx context.Context,
+	machineID int64,
+) (*PasswordVersion, error) {
+	rows, err := storeInstance.db.QueryContext(ctx,
+		`SELECT id, password, data_key, created_at, actor, state, expires_at
+           FROM password_history
+          WHERE machine_id = ? AND state IN (?, ?)
+            AND id > COALESCE((SELECT MAX(id) FROM password_history
+                                WHERE machine_id = ? AND state = ?), 0)
+          ORDER BY id DESC
+          LIMIT 1`,
+		machineID, PasswordPending, PasswordExpired, machineID, PasswordConfirmed)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	version, err := storeInstance.scanPasswordVersion(rows)
+	if err != nil {
+		return nil, err
+	}
+	if version.State == PasswordPending && !version.ExpiresAt.IsZero() &&
+		!time.Now().Before(version.ExpiresAt) {
+		version.State = PasswordExpired
+	}
+	return version, nil
+}
@@ -0,0 +1,223 @@
+// internal/store/rekey.go
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrDatabaseInUse is returned by WritePIDFile while another running server
+// holds the PID file of the database.
+var ErrDatabaseInUse = errors.New("database is in use")
+
+// PIDFile returns the file recording which process serves the database at
+// dbPath.
+func PIDFile(dbPath string) string {
+	return dbPath + ".pid"
+}
+
+// WritePIDFile records the current process as the server of the database at
+// dbPath, so that `ships-server rekey` can tell it to reload its master key.
+// It fails with ErrDatabaseInUse if another running process holds the file;
+// a file left behind by a process that is gone is replaced. The returned
+// function removes the file.
+func WritePIDFile(dbPath string) (func(), error) {
+	pidFile := PIDFile(dbPath)
+	for attempt := 0; ; attempt++ {
+		file, err := os.OpenFile(pidFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if errors.Is(err, os.ErrExist) && attempt == 0 {
+			pid, err := ServerPID(dbPath)
+			if err != nil {
+				return nil, err
+			}
+			if pid != 0 {
+				return nil, fmt.Errorf("%w by process %d according to %s",
+					ErrDatabaseInUse, pid, pidFile)
+			}
+			if err := os.Remove(pidFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		if _, err := fmt.Fprintf(file, "%d\n", os.Getpid()); err != nil {
+			os.Remove(pidFile)
+			return nil, err
+		}
+		return func() { os.Remove(pidFile) }, nil
+	}
+}
+
+// ServerPID returns the process ID recorded in the PID file of the database
+// at dbPath, or 0 if there is no such file or the process is gone.
+func ServerPID(dbPath string) (int, error) {
+	data, err := os.ReadFile(PIDFile(dbPath))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		// Written only partially; its process died before finishing.
+		return 0, nil
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil || process.Signal(syscall.Signal(0)) != nil {
+		return 0, nil
+	}
+	return pid, nil
+}
+
+// Rekey re-wraps every data key in the sealed tables with newMasterKey inside
+// a single transaction and records a "rekey" audit entry. The secrets
+// themselves are not re-encrypted. It returns the number of data keys
+// re-wrapped; rows already wrapped with newMasterKey are left untouched so
+// an interrupted rotation can simply be run again. A server running at the
+// same time keeps working: it loads newMasterKey the first time it reads a
+// row wrapped with it, and on reload re-wraps the rows it sealed with the
+// old key meanwhile (see RewrapDataKeys).
+func (storeInstance *Store) Rekey(
+	ctx context.Context,
+	newMasterKey []byte,
+	actor string,
+) (int, error) {
+	if len(newMasterKey) != MasterKeySize {
+		return 0, fmt.Errorf("master key must be %d bytes", MasterKeySize)
+	}
+	if actor == "" {
+		actor = defaultUnknownActor
+	}
+
+	transaction, err := storeInstance.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer transaction.Rollback() // nolint:errcheck // no-op after Commit
+
+	rewrapped, err := storeInstance.rewrapDataKeys(ctx, transaction, newMasterKey)
+	if err != nil {
+		return 0, err
+	}
+	entry, err := storeInstance.appendAudit(
+		ctx, transaction, nil, "rekey", actor, "local", "", time.Now().Unix(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	if err := transaction.Commit(); err != nil {
+		return 0, err
+	}
+	storeInstance.setMasterKey(newMasterKey)
+	storeInstance.notifyAudit(entry)
+	return rewrapped, nil
+}
+
+// RewrapDataKeys re-wraps every data key not wrapped with the current master
+// key, such as those a running server sealed while `ships-server rekey`
+// rotated the key underneath it, and audits "rewrap_data_keys" if there were
+// any. Call it after ReloadMasterKey. It returns the number re-wrapped.
+func (storeInstance *Store) RewrapDataKeys(ctx context.Context, actor string) (int, error) {
+	if actor == "" {
+		actor = defaultUnknownActor
+	}
+	transaction, err := storeInstance.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer transaction.Rollback() // nolint:errcheck // no-op after Commit
+
+	rewrapped, err := storeInstance.rewrapDataKeys(ctx, transaction, storeInstance.primaryKey())
+	if err != nil || rewrapped == 0 {
+		return 0, err
+	}
+	entry, err := storeInstance.appendAudit(ctx, transaction, nil, "rewrap_data_keys", actor,
+		"local", fmt.Sprintf("%d data keys", rewrapped), time.Now().Unix())
+	if err != nil {
+		return 0, err
+	}
+	if err := transaction.Commit(); err != nil {
+		return 0, err
+	}
+	storeInstance.notifyAudit(entry)
+	return rewrapped, nil
+}
+
+// rewrapDataKeys re-wraps, inside transaction, every data key in the sealed
+// tables that is not yet wrapped with masterKey and returns how many it
+// changed.
+func (storeInstance *Store) rewrapDataKeys(
+	ctx context.Context,
+	transaction *sql.Tx,
+	masterKey []byte,
+) (int, error) {
+	keyID := hex.EncodeToString(masterKeyID(masterKey))
+	rewrapped := 0
+	for _, sealedTable := range sealedTables {
+		wrappedKeys, err := selectDataKeys(ctx, transaction, sealedTable.table)
+		if err != nil {
+			return 0, err
+		}
+		for rowID, wrapped := range wrappedKeys {
+			currentID, err := wrappedKeyID(wrapped)
+			if err != nil {
+				return 0, fmt.Errorf("%s row %d: %w", sealedTable.table, rowID, err)
+			}
+			if currentID == keyID {
+				continue
+			}
+			dataKey, err := storeInstance.unwrap(wrapped)
+			if err != nil {
+				return 0, fmt.Errorf("%s row %d: %w", sealedTable.table, rowID, err)
+			}
+			rewrappedKey, err := wrapDataKey(masterKey, dataKey)
+			if err != nil {
+				return 0, err
+			}
+			if _, err := transaction.ExecContext(ctx, fmt.Sprintf(
+				`UPDATE %s SET data_key = ? WHERE rowid = ?`, sealedTable.table),
+				rewrappedKey, rowID); err != nil {
+				return 0, err
+			}
+			rewrapped++
+		}
+	}
+	return rewrapped, nil
+}
+
+// selectDataKeys returns the wrapped data key of every sealed row in table.
+func selectDataKeys(
+	ctx context.Context,
+	transaction *sql.Tx,
+	table string,
+) (map[int64]string, error) {
+	rows, err := transaction.QueryContext(ctx, fmt.Sprintf(
+		`SELECT rowid, data_key FROM %s WHERE data_key IS NOT NULL`, table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	wrappedKeys := map[int64]string{}
+	for rows.Next() {
+		var rowID int64
+		var wrapped string
+		if err := rows.Scan(&rowID, &wrapped); err != nil {
+			return nil, err
+		}
+		wrappedKeys[rowID] = wrapped
+	}
+	return wrappedKeys, rows.Err()
+}
@@ -0,0 +1,189 @@
+// internal/store/roles.go
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Role is the set of API operations a principal may perform.
+type Role string
+
+// Roles, from the narrowest to the broadest. The API layer decides which
+// routes each role may call.
+const (
+	// RoleMachine may only escrow secrets for its own hostname.
+	RoleMachine Role = "machine"
+	// RoleHelpdesk may read BitLocker recovery keys.
+	RoleHelpdesk Role = "helpdesk"
+	// RoleOperator may additionally read and rotate passwords.
+	RoleOperator Role = "operator"
+	// RoleAdmin may do everything, including reading the audit log.
+	RoleAdmin Role = "admin"
+)
+
+// Roles lists every valid role.
+var Roles = []Role{RoleMachine, RoleHelpdesk, RoleOperator, RoleAdmin}
+
+// ErrNoRole is returned by RoleOf for principals without an assignment.
+var ErrNoRole = errors.New("no role assigned")
+
+// RoleAssignment is one row of role_assignments.
+type RoleAssignment struct {
+	Principal  string    `json:"principal"`
+	Role       Role      `json:"role"`
+	AssignedAt time.Time `json:"assigned_at"`
+	AssignedBy string    `json:"assigned_by"`
+}
+
+// ParseRole validates a role name.
+func ParseRole(name string) (Role, error) {
+	for _, role := range Roles {
+		if string(role) == name {
+			return role, nil
+		}
+	}
+	return "", fmt.Errorf("unknown role %q (valid: machine, helpdesk, operator, admin)", name)
+}
+
+// AssignRole gives principal role, replacing any previous assignment, and
+// records an "assign_role" audit entry.
+func (storeInstance *Store) AssignRole(
+	ctx context.Context,
+	principal string,
+	role Role,
+	actor string,
+) error {
+	if err := validateTokenName(principal); err != nil {
+		return err
+	}
+	if _, err := ParseRole(string(role)); err != nil {
+		return err
+	}
+	if actor == "" {
+		actor = defaultUnknownActor
+	}
+	transaction, err := storeInstance.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer transaction.Rollback() // nolint:errcheck // no-op after Commit
+
+	now := time.Now().Unix()
+	if _, err := transaction.ExecContext(ctx,
+		`REPLACE INTO role_assignments(principal, role, assigned_at, assigned_by)
+         VALUES (?,?,?,?)`,
+		principal, string(role), now, actor); err != nil {
+		return err
+	}
+	entry, err := storeInstance.appendAudit(ctx, transaction, nil, "assign_role",
+		actor, "local", fmt.Sprintf("%s is %s", principal, role), now)
+	if err != nil {
+		return err
+	}
+	if err := transaction.Commit(); err != nil {
+		return err
+	}
+	storeInstance.notifyAudit(entry)
+	return nil
+}
+
+// UnassignRole removes the role of principal and records an "unassign_role"
+// audit entry.
+func (storeInstance *Store) UnassignRole(
+	ctx context.Context,
+	principal, actor string,
+) error {
+	if actor == "" {
+		actor = defaultUnknownActor
+	}
+	transaction, err := storeInstance.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer transaction.Rollback() // nolint:errcheck // no-op after Commit
+
+	var role string
+	err = transaction.QueryRowContext(ctx,
+		`SELECT role FROM role_assignments WHERE principal = ?`, principal,
+	).Scan(&role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%s has no role", principal)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := transaction.ExecContext(ctx,
+		`DELETE FROM role_assignments WHERE principal = ?`, principal); err != nil {
+		return err
+	}
+	entry, err := storeInstance.appendAudit(ctx, transaction, nil, "unassign_role",
+		actor, "local", fmt.Sprintf("%s was %s", principal, role), time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	if err := transaction.Commit(); err != nil {
+		return err
+	}
+	storeInstance.notifyAudit(entry)
+	return nil
+}
+
+// RoleOf returns the role assigned to principal, or ErrNoRole.
+func (storeInstance *Store) RoleOf(ctx context.Context, principal string) (Role, error) {
+	var role string
+	err := storeInstance.db.QueryRowContext(ctx,
+		`SELECT role FROM role_assignments WHERE principal = ?`, principal,
+	).Scan(&role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNoRole
+	}
+	if err != nil {
+		return "", err
+	}
+	return Role(role), nil
+}
+
+// ListRoleAssignments returns every assignment ordered by principal.
+func (storeInstance *Store) ListRoleAssignments(ctx context.Context) ([]RoleAssignment, error) {
+	rows, err := storeInstance.db.QueryContext(ctx,
+		`SELECT principal, role, assigned_at, assigned_by
+           FROM role_assignments ORDER BY principal`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	assignments := []RoleAssignment{}
+	for rows.Next() {
+		var assignment RoleAssignment
+		var assignedAt int64
+		if err := rows.Scan(&assignment.Principal, &assignment.Role,
+			&assignedAt, &assignment.AssignedBy); err != nil {
+			return nil, err
+		}
+		assignment.AssignedAt = time.Unix(assignedAt, 0)
+		assignments = append(assignments, assignment)
+	}
+	return assignments, rows.Err()
+}
+
+// RecordDenial audits a request that was refused for lack of permission.
+// detail says what was attempted; host, when not empty, ties the entry to
+// an existing machine (unknown hostnames are not created).
+func (storeInstance *Store) RecordDenial(
+	ctx context.Context,
+	host, actor, remoteAddr, detail string,
+) error {
+	if actor == "" {
+		actor = defaultUnknownActor
+	}
+	machineID, err := storeInstance.existingMachineID(ctx, host)
+	if err != nil {
+		return err
+	}
+	return storeInstance.recordAudit(ctx, machineID, "access_denied", actor, remoteAddr, detail)
+}
@@ -0,0 +1,180 @@
+// internal/store/rotation.go
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Reasons a check-in asks the machine to rotate its password.
+const (
+	RotationReasonFetched    = "password fetched"
+	RotationReasonNoPassword = "no password escrowed"
+)
+
+// CheckInStatus is what the server tells a machine's agent when it checks
+// in. RequiredSince and RequiredBy describe the first fetch of the current
+// password; both are zero when the rotation is required for another reason.
+// While the password is checked out, CheckedOutUntil is set and rotation
+// waits for the lease to end.
+type CheckInStatus struct {
+	Hostname         string    `json:"hostname"`
+	RotationRequired bool      `json:"rotation_required"`
+	Reason           string    `json:"reason,omitempty"`
+	RequiredSince    time.Time `json:"required_since"`
+	RequiredBy       string    `json:"required_by,omitempty"`
+	CheckedOutUntil  time.Time `json:"checked_out_until"`
+}
+
+// OverdueRotation is a machine whose fetched password has not been rotated
+// within the grace period.
+type OverdueRotation struct {
+	Hostname      string    `json:"hostname"`
+	RequiredSince time.Time `json:"required_since"`
+	RequiredBy    string    `json:"required_by"`
+	LastCheckIn   time.Time `json:"last_checkin"`
+}
+
+// recordFetch audits a read that revealed the current password of a machine
+// as action and flags the machine as requiring rotation. Like a LAPS
+// post-authentication action, a password someone has seen is considered
+// burned until the machine rotates it; the flag keeps the first fetch and
+// is only set once the machine has a password.
+func (storeInstance *Store) recordFetch(
+	ctx context.Context,
+	machineID int64,
+	action, actor, remoteAddr string,
+) error {
+	transaction, err := storeInstance.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer transaction.Rollback() // nolint:errcheck // no-op after Commit
+
+	now := time.Now().Unix()
+	if err := requireRotation(ctx, transaction, machineID, actor, now); err != nil {
+		return err
+	}
+	entry, err := storeInstance.appendAudit(ctx, transaction, machineID, action, actor,
+		remoteAddr, "", now)
+	if err != nil {
+		return err
+	}
+	if err := transaction.Commit(); err != nil {
+		return err
+	}
+	storeInstance.notifyAudit(entry)
+	return nil
+}
+
+// requireRotation flags the machine as requiring rotation because actor has
+// seen its password, unless it is flagged already or has no password.
+func requireRotation(
+	ctx context.Context,
+	transaction *sql.Tx,
+	machineID int64,
+	actor string,
+	now int64,
+) error {
+	_, err := transaction.ExecContext(ctx,
+		`UPDATE machines
+            SET rotation_required_by = CASE WHEN rotation_required_at IS NULL
+                                            THEN ? ELSE rotation_required_by END,
+                rotation_required_at = COALESCE(rotation_required_at, ?)
+          WHERE id = ? AND EXISTS (SELECT 1 FROM passwords WHERE machine_id = ?)`,
+		actor, now, machineID, machineID)
+	return err
+}
+
+// clearRotationRequired lifts the rotation flag once a new password is in
+// effect on the machine.
+func clearRotationRequired(ctx context.Context, transaction *sql.Tx, machineID int64) error {
+	_, err := transaction.ExecContext(ctx,
+		`UPDATE machines SET rotation_required_at = NULL, rotation_required_by = NULL
+          WHERE id = ?`, machineID)
+	return err
+}
+
+// CheckIn records that the agent of host contacted the server and reports
+// whether it should rotate the password: because the current one has been
+// fetched, or because none has been escrowed yet. A checked-out password is
+// never rotated under its holder; a decommissioned machine gets
+// ErrMachineRetired.
+func (storeInstance *Store) CheckIn(ctx context.Context, host string) (*CheckInStatus, error) {
+	machineID, err := storeInstance.activeMachineID(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := storeInstance.db.ExecContext(ctx,
+		`UPDATE machines SET last_checkin_at = ? WHERE id = ?`,
+		time.Now().Unix(), machineID); err != nil {
+		return nil, err
+	}
+
+	var requiredAt sql.NullInt64
+	var requiredBy sql.NullString
+	var hasPassword bool
+	err = storeInstance.db.QueryRowContext(ctx,
+		`SELECT m.rotation_required_at, m.rotation_required_by,
+                EXISTS (SELECT 1 FROM passwords p WHERE p.machine_id = m.id)
+           FROM machines m WHERE m.id = ?`, machineID,
+	).Scan(&requiredAt, &requiredBy, &hasPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	lease, err := storeInstance.activeLease(ctx, machineID, host)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &CheckInStatus{Hostname: host}
+	switch {
+	case lease != nil:
+		status.CheckedOutUntil = lease.ExpiresAt
+	case !hasPassword:
+		status.RotationRequired = true
+		status.Reason = RotationReasonNoPassword
+	case requiredAt.Valid:
+		status.RotationRequired = true
+		status.Reason = RotationReasonFetched
+		status.RequiredSince = time.Unix(requiredAt.Int64, 0)
+		status.RequiredBy = requiredBy.String
+	}
+	return status, nil
+}
+
+// OverdueRotations lists machines whose password was fetched more than
+// grace ago and has not been rotated since, oldest first.
+func (storeInstance *Store) OverdueRotations(
+	ctx context.Context,
+	grace time.Duration,
+) ([]OverdueRotation, error) {
+	rows, err := storeInstance.db.QueryContext(ctx,
+		`SELECT hostname, rotation_required_at, rotation_required_by, last_checkin_at
+           FROM machines
+          WHERE rotation_required_at IS NOT NULL AND rotation_required_at <= ?
+          ORDER BY rotation_required_at, hostname`,
+		time.Now().Add(-grace).Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	overdue := []OverdueRotation{}
+	for rows.Next() {
+		var machine OverdueRotation
+		var requiredAt int64
+		var requiredBy sql.NullString
+		var lastCheckIn sql.NullInt64
+		if err := rows.Scan(&machine.Hostname, &requiredAt, &requiredBy, &lastCheckIn); err != nil {
+			return nil, err
+		}
+		machine.RequiredSince = time.Unix(requiredAt, 0)
+		machine.RequiredBy = requiredBy.String
+		machine.LastCheckIn = nullableTime(lastCheckIn)
+		overdue = append(overdue, machine)
+	}
+	return overdue, rows.Err()
+}
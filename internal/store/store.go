@@ -7,45 +7,123 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/jottavia/SHIPS2-Go/internal/bitlocker"
+	"github.com/jottavia/SHIPS2-Go/internal/hostname"
 	_ "modernc.org/sqlite"
 )
 
 // Store wraps a SQLite database that holds machine passwords, 
-// BitLocker keys and an audit log.
+// BitLocker keys and an audit log. Secrets are sealed with per-row data
+// keys which are in turn wrapped by masterKey.
 type Store struct {
 	db *sql.DB
+
+	keysMu     sync.RWMutex
+	masterKey  []byte            // wraps newly created data keys
+	masterKeys map[string][]byte // every known master key by hex ID
+	// loadMasterKey re-reads the configured key; nil when opened via Open.
+	loadMasterKey func() ([]byte, error)
+
+	// auditKey HMACs the audit hash chain; nil means plain SHA-256.
+	auditKey []byte
+	// auditHook receives every committed audit entry; see SetAuditHook.
+	auditHook func(AuditEntry)
+	// hostnames is the canonical form hosts are stored and looked up in;
+	// see SetHostnamePolicy. configuredHostnames is what SHIPS_HOSTNAME_*
+	// ask for, which only MigrateHostnames puts in effect once a policy is
+	// recorded in the database.
+	hostnames           hostname.Policy
+	configuredHostnames hostname.Policy
 }
 
-// PasswordInfo holds password data with metadata
+// PasswordInfo holds password data with metadata. Password is empty when no
+// rotation has been confirmed yet; Pending is the newest unconfirmed
+// two-phase rotation, if any, with State pending or, once its window has
+// passed, expired.
 type PasswordInfo struct {
-	Password  string    `json:"password"`
-	RotatedAt time.Time `json:"rotated_at"`
-	Actor     string    `json:"actor"`
+	Password  string           `json:"password"`
+	RotatedAt time.Time        `json:"rotated_at"`
+	Actor     string           `json:"actor"`
+	Pending   *PasswordVersion `json:"pending,omitempty"`
 }
 
 // BitLockerKeyInfo holds BitLocker key data with metadata
 type BitLockerKeyInfo struct {
-	Key       string    `json:"key"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Actor     string    `json:"actor"`
+	Volume      string    `json:"volume"`
+	ProtectorID string    `json:"protector_id"`
+	Key         string    `json:"key"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Actor       string    `json:"actor"`
 }
 
 // defaultUnknownActor is used when no actor is provided.
 const defaultUnknownActor = "unknown"
 
-// New opens (or creates) the database file at path and ensures the schema exists.
+// New opens (or creates) the database file at path using the master key
+// configured in the environment (see LoadMasterKey).
 func New(path string) (*Store, error) {
+	masterKey, err := LoadMasterKey(path)
+	if err != nil {
+		return nil, fmt.Errorf("loading master key: %w", err)
+	}
+	storeInstance, err := Open(path, masterKey)
+	if err != nil {
+		return nil, err
+	}
+	storeInstance.loadMasterKey = func() ([]byte, error) { return LoadMasterKey(path) }
+	return storeInstance, nil
+}
+
+// Open opens (or creates) the database file at path, ensures the schema
+// exists and encrypts any secrets still stored in plaintext with masterKey.
+// The optional audit chain key is read from SHIPS_AUDIT_KEY_FILE and the
+// hostname policy from SHIPS_HOSTNAME_* (see hostname.FromEnv) so that
+// every process writing to the database chains audit rows. The hostname
+// policy recorded in the database by MigrateHostnames takes precedence, so
+// machines are named the same way whatever a process is started with; a
+// database without one records the configured policy as soon as its
+// machines follow it. Open never renames machines.
+func Open(path string, masterKey []byte) (*Store, error) {
+	if len(masterKey) != MasterKeySize {
+		return nil, fmt.Errorf("master key must be %d bytes", MasterKeySize)
+	}
+	auditKey, err := loadAuditKey()
+	if err != nil {
+		return nil, err
+	}
+	hostnames, err := hostname.FromEnv()
+	if err != nil {
+		return nil, err
+	}
 	database, err := sql.Open("sqlite", path+"?_busy_timeout=10000&_journal_mode=WAL")
 	if err != nil {
 		return nil, err
 	}
-	storeInstance := &Store{db: database}
+	// A single connection serialises writers, which keeps the audit hash
+	// chain linear: each new row reads the previous hash in its own tx.
+	database.SetMaxOpenConns(1)
+	storeInstance := &Store{
+		db:                  database,
+		auditKey:            auditKey,
+		hostnames:           hostnames,
+		configuredHostnames: hostnames,
+	}
+	storeInstance.setMasterKey(masterKey)
 	if err := storeInstance.initSchema(); err != nil {
 		database.Close()
 		return nil, err
 	}
+	if err := storeInstance.migrate(); err != nil {
+		database.Close()
+		return nil, err
+	}
+	if err := storeInstance.loadHostnamePolicy(context.Background()); err != nil {
+		database.Close()
+		return nil, err
+	}
 	return storeInstance, nil
 }
 
@@ -55,75 +133,228 @@ func (storeInstance *Store) Close() error { return storeInstance.db.Close() }
 // initSchema creates tables if they do not yet exist.
 func (storeInstance *Store) initSchema() error {
 	const schema = `
--- Machines we manage.
+-- Machines we manage. rotation_required_at is set by the first fetch of
+-- the current password and cleared when a new one is in effect;
+-- last_checkin_at is when the machine's agent last checked in. A
+-- decommissioned machine has retired_at set; its secrets are destroyed
+-- after purge_after and purged_at records when. machine_identity is the
+-- stable identity (SMBIOS UUID, serial number or certificate fingerprint)
+-- the machine reports; a hostname reused by a machine with another identity
+-- starts a new generation. A machine merged into another by a hostname
+-- migration has merged_into set and is renamed NAME#ID; its secrets moved,
+-- its audit trail stays and is reported under the other machine.
 CREATE TABLE IF NOT EXISTS machines(
     id INTEGER PRIMARY KEY,
     hostname TEXT UNIQUE NOT NULL,
-    first_seen INTEGER NOT NULL DEFAULT (strftime('%s', 'now'))
+    first_seen INTEGER NOT NULL DEFAULT (strftime('%s', 'now')),
+    rotation_required_at INTEGER,
+    rotation_required_by TEXT,
+    last_checkin_at INTEGER,
+    retired_at INTEGER,
+    retired_by TEXT,
+    retire_reason TEXT,
+    purge_after INTEGER,
+    purged_at INTEGER,
+    machine_identity TEXT,
+    generation INTEGER NOT NULL DEFAULT 1,
+    merged_into INTEGER REFERENCES machines(id)
 );
 
 -- Current password for each machine (one‑row ring buffer via REPLACE).
+-- password holds the ciphertext, data_key the wrapped data key.
 CREATE TABLE IF NOT EXISTS passwords(
     machine_id INTEGER NOT NULL UNIQUE,
     password   TEXT    NOT NULL,
+    data_key   TEXT,
     updated_at INTEGER NOT NULL,
     actor      TEXT    NOT NULL,
     FOREIGN KEY(machine_id) REFERENCES machines(id)
 );
 
--- BitLocker recovery keys.
+-- Every password ever escrowed for a machine, newest has the highest id.
+-- state is 'confirmed' once the password is in effect on the machine, or
+-- 'pending' until a two-phase rotation is confirmed, 'expired' if that
+-- never happened before expires_at and 'cancelled' if the client reported
+-- that it could not apply it.
+CREATE TABLE IF NOT EXISTS password_history(
+    id           INTEGER PRIMARY KEY,
+    machine_id   INTEGER NOT NULL,
+    password     TEXT    NOT NULL,
+    data_key     TEXT,
+    created_at   INTEGER NOT NULL,
+    actor        TEXT    NOT NULL,
+    state        TEXT    NOT NULL DEFAULT 'confirmed',
+    expires_at   INTEGER,
+    confirmed_at INTEGER,
+    FOREIGN KEY(machine_id) REFERENCES machines(id)
+);
+CREATE INDEX IF NOT EXISTS idx_password_history_machine
+    ON password_history(machine_id, id);
+
+-- BitLocker recovery keys, one per (machine, volume, key protector) and
+-- sealed the same way as passwords. volume is the mount point or volume
+-- GUID; protector_id the upper-case recovery password protector GUID.
 CREATE TABLE IF NOT EXISTS bitlocker_keys(
-    machine_id INTEGER NOT NULL UNIQUE,
-    key_text   TEXT    NOT NULL,
-    updated_at INTEGER NOT NULL,
-    actor      TEXT    NOT NULL,
+    id           INTEGER PRIMARY KEY,
+    machine_id   INTEGER NOT NULL,
+    volume       TEXT    NOT NULL DEFAULT '',
+    protector_id TEXT    NOT NULL DEFAULT '',
+    key_text     TEXT    NOT NULL,
+    data_key     TEXT,
+    updated_at   INTEGER NOT NULL,
+    actor        TEXT    NOT NULL,
+    UNIQUE(machine_id, volume, protector_id),
     FOREIGN KEY(machine_id) REFERENCES machines(id)
 );
 
--- Audit entries for every change or read.
+-- Audit entries for every change or read. Each row carries the hash of
+-- its content plus prev_hash, the previous row's row_hash.
 CREATE TABLE IF NOT EXISTS audit_logs(
     id         INTEGER PRIMARY KEY,
     machine_id INTEGER,
     action     TEXT    NOT NULL,
     actor      TEXT    NOT NULL,
     remote_addr TEXT   NOT NULL,
-    timestamp  INTEGER NOT NULL
+    timestamp  INTEGER NOT NULL,
+    detail     TEXT,
+    prev_hash  TEXT,
+    row_hash   TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_audit_logs_machine
+    ON audit_logs(machine_id, action, timestamp);
+
+-- API tokens for bearer authentication. Only the SHA-256 of a token is
+-- kept; name identifies its owner and is written to audit_logs as actor.
+-- machine_id is set for machine credentials issued by enrollment.
+CREATE TABLE IF NOT EXISTS api_tokens(
+    id           INTEGER PRIMARY KEY,
+    name         TEXT    NOT NULL,
+    machine_id   INTEGER REFERENCES machines(id),
+    token_hash   TEXT    NOT NULL UNIQUE,
+    created_at   INTEGER NOT NULL,
+    created_by   TEXT    NOT NULL,
+    expires_at   INTEGER,
+    last_used_at INTEGER,
+    revoked_at   INTEGER
+);
+
+-- One-time tokens a new machine exchanges for its own credential. hostname,
+-- when set, restricts the token to that machine.
+CREATE TABLE IF NOT EXISTS enrollment_tokens(
+    id         INTEGER PRIMARY KEY,
+    token_hash TEXT    NOT NULL UNIQUE,
+    hostname   TEXT,
+    created_at INTEGER NOT NULL,
+    created_by TEXT    NOT NULL,
+    expires_at INTEGER NOT NULL,
+    used_at    INTEGER,
+    machine_id INTEGER REFERENCES machines(id)
+);
+
+-- Exclusive checkouts of a machine's password. A lease lasts until
+-- expires_at unless ended_at is set earlier; end_reason is 'checkin',
+-- 'expired' or 'decommissioned'.
+CREATE TABLE IF NOT EXISTS password_leases(
+    id             INTEGER PRIMARY KEY,
+    machine_id     INTEGER NOT NULL REFERENCES machines(id),
+    holder         TEXT    NOT NULL,
+    checked_out_at INTEGER NOT NULL,
+    expires_at     INTEGER NOT NULL,
+    ended_at       INTEGER,
+    end_reason     TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_password_leases_machine
+    ON password_leases(machine_id, ended_at);
+
+-- Machines whose secrets need a second person's approval to retrieve.
+-- pattern is a lower-case hostname or glob; secret is password, bde or all.
+CREATE TABLE IF NOT EXISTS approval_policies(
+    pattern    TEXT    PRIMARY KEY,
+    secret     TEXT    NOT NULL,
+    created_at INTEGER NOT NULL,
+    created_by TEXT    NOT NULL
+);
+
+-- Requests to retrieve a secret under an approval policy. An approved
+-- request can be used once before usable_until.
+CREATE TABLE IF NOT EXISTS approval_requests(
+    id           INTEGER PRIMARY KEY,
+    machine_id   INTEGER NOT NULL REFERENCES machines(id),
+    secret       TEXT    NOT NULL,
+    requester    TEXT    NOT NULL,
+    reason       TEXT    NOT NULL,
+    state        TEXT    NOT NULL,
+    requested_at INTEGER NOT NULL,
+    expires_at   INTEGER NOT NULL,
+    decided_by   TEXT,
+    decided_at   INTEGER,
+    note         TEXT,
+    usable_until INTEGER,
+    used_at      INTEGER
+);
+
+-- Role of each authenticated principal (an API token's name). Principals
+-- without a row are denied everything when tokens are required.
+CREATE TABLE IF NOT EXISTS role_assignments(
+    principal   TEXT    PRIMARY KEY,
+    role        TEXT    NOT NULL,
+    assigned_at INTEGER NOT NULL,
+    assigned_by TEXT    NOT NULL
+);
+
+-- The built-in certificate authority for machine client certificates. The
+-- PKCS#8 private key is sealed like any other secret.
+CREATE TABLE IF NOT EXISTS ca(
+    id          INTEGER PRIMARY KEY CHECK (id = 1),
+    certificate TEXT    NOT NULL,
+    private_key TEXT    NOT NULL,
+    data_key    TEXT    NOT NULL,
+    created_at  INTEGER NOT NULL,
+    created_by  TEXT    NOT NULL
+);
+
+-- Every certificate the built-in CA issued, keyed by lowercase hex serial.
+CREATE TABLE IF NOT EXISTS certificates(
+    serial        TEXT    PRIMARY KEY,
+    machine_id    INTEGER NOT NULL REFERENCES machines(id),
+    not_before    INTEGER NOT NULL,
+    not_after     INTEGER NOT NULL,
+    issued_at     INTEGER NOT NULL,
+    issued_by     TEXT    NOT NULL,
+    revoked_at    INTEGER,
+    revoke_reason TEXT
+);
+
+-- Database-wide settings every process must agree on, such as the hostname
+-- policy machines were last migrated to.
+CREATE TABLE IF NOT EXISTS settings(
+    name  TEXT PRIMARY KEY,
+    value TEXT NOT NULL
 );`
 	_, err := storeInstance.db.Exec(schema)
 	return err
 }
 
-// validateHostname ensures hostname is valid and safe
-func validateHostname(hostname string) error {
-	if hostname == "" {
-		return errors.New("hostname cannot be empty")
-	}
-	if len(hostname) > 253 {
-		return errors.New("hostname too long")
-	}
-	// Basic validation - could be expanded
-	if strings.ContainsAny(hostname, " \t\n\r") {
-		return errors.New("hostname contains invalid characters")
-	}
-	return nil
-}
-
 // getMachineID returns the existing machine id or creates a new machine row.
 func (storeInstance *Store) getMachineID(
 	ctx context.Context,
 	host string,
 ) (int64, error) {
-	if err := validateHostname(host); err != nil {
+	host, err := storeInstance.hostnames.Canonical(host)
+	if err != nil {
 		return 0, err
 	}
 
 	var machineID int64
-	err := storeInstance.db.QueryRowContext(
+	err = storeInstance.db.QueryRowContext(
 		ctx,
 		`SELECT id FROM machines WHERE hostname = ?`,
 		host,
 	).Scan(&machineID)
 	if errors.Is(err, sql.ErrNoRows) {
+		if err := checkNotGeneration(host); err != nil {
+			return 0, err
+		}
 		result, insertErr := storeInstance.db.ExecContext(
 			ctx,
 			`INSERT INTO machines(hostname) VALUES (?)`,
@@ -148,7 +379,11 @@ func (storeInstance *Store) RotatePassword(
 	if actor == "" {
 		actor = defaultUnknownActor
 	}
-	machineID, err := storeInstance.getMachineID(ctx, host)
+	machineID, err := storeInstance.activeMachineID(ctx, host)
+	if err != nil {
+		return err
+	}
+	sealed, err := storeInstance.seal(password)
 	if err != nil {
 		return err
 	}
@@ -160,8 +395,9 @@ func (storeInstance *Store) RotatePassword(
 
 	now := time.Now().Unix()
 	if _, err = transaction.ExecContext(ctx,
-		`REPLACE INTO passwords(machine_id, password, updated_at, actor) VALUES (?,?,?,?)`,
-		machineID, password, now, actor); err != nil {
+		`REPLACE INTO passwords(machine_id, password, data_key, updated_at, actor) 
+         VALUES (?,?,?,?,?)`,
+		machineID, sealed.ciphertext, sealed.dataKey, now, actor); err != nil {
 		// Roll back the transaction and return rollback error if any.
 		if rbErr := transaction.Rollback(); rbErr != nil {
 			return rbErr
@@ -169,75 +405,132 @@ func (storeInstance *Store) RotatePassword(
 		return err
 	}
 	if _, err = transaction.ExecContext(ctx,
-		`INSERT INTO audit_logs(machine_id, action, actor, remote_addr, timestamp) 
-         VALUES (?,?,?,?,?)`,
-		machineID, "rotate_password", actor, remoteAddr, now); err != nil {
+		`INSERT INTO password_history(machine_id, password, data_key, created_at, actor,
+                                      confirmed_at) 
+         VALUES (?,?,?,?,?,?)`,
+		machineID, sealed.ciphertext, sealed.dataKey, now, actor, now); err != nil {
 		if rbErr := transaction.Rollback(); rbErr != nil {
 			return rbErr
 		}
 		return err
 	}
-	return transaction.Commit()
+	if err = clearRotationRequired(ctx, transaction, machineID); err != nil {
+		if rbErr := transaction.Rollback(); rbErr != nil {
+			return rbErr
+		}
+		return err
+	}
+	entry, err := storeInstance.appendAudit(
+		ctx, transaction, machineID, "rotate_password", actor, remoteAddr, "", now,
+	)
+	if err != nil {
+		if rbErr := transaction.Rollback(); rbErr != nil {
+			return rbErr
+		}
+		return err
+	}
+	if err = transaction.Commit(); err != nil {
+		return err
+	}
+	storeInstance.notifyAudit(entry)
+	return nil
 }
 
 // GetPassword returns the latest password info for host and logs the access.
-// nolint:dupl // similar structure to GetBDEKey is intentional
+// While the password is checked out, only the lease holder may fetch it.
 func (storeInstance *Store) GetPassword(
 	ctx context.Context,
 	host, actor, remoteAddr string,
 ) (*PasswordInfo, error) {
-	machineID, err := storeInstance.getMachineID(ctx, host)
+	if actor == "" {
+		actor = defaultUnknownActor
+	}
+	machineID, err := storeInstance.knownMachineID(ctx, host)
 	if err != nil {
 		return nil, err
 	}
-
-	var password string
-	var updatedAt int64
-	var pwActor string
-	err = storeInstance.db.QueryRowContext(ctx,
-		`SELECT p.password, p.updated_at, p.actor
-           FROM passwords p
-           JOIN machines m ON p.machine_id = m.id
-          WHERE m.hostname = ?`,
-		host).Scan(&password, &updatedAt, &pwActor)
-	if errors.Is(err, sql.ErrNoRows) {
-		return nil, fmt.Errorf("no password recorded for host %s", host)
+	if err := storeInstance.checkLease(ctx, machineID, host, actor); err != nil {
+		return nil, err
 	}
+	info, err := storeInstance.readPassword(ctx, machineID, host)
 	if err != nil {
 		return nil, err
 	}
 
 	// Log the password retrieval
-	if actor == "" {
-		actor = defaultUnknownActor
-	}
-	_, err = storeInstance.db.ExecContext(ctx,
-		`INSERT INTO audit_logs(machine_id, action, actor, remote_addr, timestamp) 
-         VALUES (?,?,?,?,?)`,
-		machineID, "fetch_password", actor, remoteAddr, time.Now().Unix())
+	err = storeInstance.recordFetch(ctx, machineID, "fetch_password", actor, remoteAddr)
 	if err != nil {
 		return nil, err
 	}
 
-	return &PasswordInfo{
-		Password:  password,
-		RotatedAt: time.Unix(updatedAt, 0),
-		Actor:     pwActor,
-	}, nil
+	return info, nil
 }
 
-// UpdateBDEKey stores or updates the BitLocker key for host and audits the event.
+// readPassword returns the confirmed and pending password of a machine
+// without auditing the read.
+func (storeInstance *Store) readPassword(
+	ctx context.Context,
+	machineID int64,
+	host string,
+) (*PasswordInfo, error) {
+	var sealed sealedSecret
+	var updatedAt int64
+	var pwActor string
+	err := storeInstance.db.QueryRowContext(ctx,
+		`SELECT password, data_key, updated_at, actor
+           FROM passwords
+          WHERE machine_id = ?`,
+		machineID).Scan(&sealed.ciphertext, &sealed.dataKey, &updatedAt, &pwActor)
+	confirmed := !errors.Is(err, sql.ErrNoRows)
+	if err != nil && confirmed {
+		return nil, err
+	}
+	pending, err := storeInstance.pendingPassword(ctx, machineID)
+	if err != nil {
+		return nil, err
+	}
+	if !confirmed && pending == nil {
+		return nil, fmt.Errorf("no password recorded for host %s", host)
+	}
+	info := &PasswordInfo{Pending: pending}
+	if confirmed {
+		if info.Password, err = storeInstance.open(sealed); err != nil {
+			return nil, err
+		}
+		info.RotatedAt = time.Unix(updatedAt, 0)
+		info.Actor = pwActor
+	}
+	return info, nil
+}
+
+// UpdateBDEKey stores or updates the BitLocker key protecting one volume of
+// host and audits the event. Keys are kept per (volume, protector ID), so a
+// machine with several encrypted drives escrows one key for each; clients
+// that send neither share the single legacy slot with an empty volume.
 func (storeInstance *Store) UpdateBDEKey(
 	ctx context.Context,
-	host, keyText, actor, remoteAddr string,
+	host, volume, protectorID, keyText, actor, remoteAddr string,
 ) error {
 	if keyText == "" {
 		return errors.New("recovery key cannot be empty")
 	}
+	keyText, err := bitlocker.NormalizeRecoveryKey(keyText)
+	if err != nil {
+		return err
+	}
 	if actor == "" {
 		actor = defaultUnknownActor
 	}
-	machineID, err := storeInstance.getMachineID(ctx, host)
+	protectorID, err = normalizeProtectorID(protectorID)
+	if err != nil {
+		return err
+	}
+	volume = strings.TrimSpace(volume)
+	machineID, err := storeInstance.activeMachineID(ctx, host)
+	if err != nil {
+		return err
+	}
+	sealed, err := storeInstance.seal(keyText)
 	if err != nil {
 		return err
 	}
@@ -249,68 +542,106 @@ func (storeInstance *Store) UpdateBDEKey(
 
 	now := time.Now().Unix()
 	if _, err = transaction.ExecContext(ctx,
-		`REPLACE INTO bitlocker_keys(machine_id, key_text, updated_at, actor) 
-         VALUES (?,?,?,?)`,
-		machineID, keyText, now, actor); err != nil {
+		`REPLACE INTO bitlocker_keys(machine_id, volume, protector_id, key_text, data_key, 
+                                     updated_at, actor) 
+         VALUES (?,?,?,?,?,?,?)`,
+		machineID, volume, protectorID, sealed.ciphertext, sealed.dataKey, now, actor); err != nil {
 		if rbErr := transaction.Rollback(); rbErr != nil {
 			return rbErr
 		}
 		return err
 	}
-	if _, err = transaction.ExecContext(ctx,
-		`INSERT INTO audit_logs(machine_id, action, actor, remote_addr, timestamp) 
-         VALUES (?,?,?,?,?)`,
-		machineID, "update_key", actor, remoteAddr, now); err != nil {
+	entry, err := storeInstance.appendAudit(
+		ctx, transaction, machineID, "update_key", actor, remoteAddr, "", now,
+	)
+	if err != nil {
 		if rbErr := transaction.Rollback(); rbErr != nil {
 			return rbErr
 		}
 		return err
 	}
-	return transaction.Commit()
+	if err = transaction.Commit(); err != nil {
+		return err
+	}
+	storeInstance.notifyAudit(entry)
+	return nil
 }
 
-// GetBDEKey returns the BitLocker recovery key info for host and logs the access.
-// nolint:dupl // similar structure to GetPassword is intentional
-func (storeInstance *Store) GetBDEKey(
+// GetBDEKeys returns the BitLocker recovery keys of every volume of host,
+// most recently updated first, and logs the access. A non-empty
+// protectorPrefix (e.g. the Key ID shown on the recovery screen) restricts
+// the result to matching key protectors.
+func (storeInstance *Store) GetBDEKeys(
 	ctx context.Context,
-	host, actor, remoteAddr string,
-) (*BitLockerKeyInfo, error) {
-	machineID, err := storeInstance.getMachineID(ctx, host)
+	host, protectorPrefix, actor, remoteAddr string,
+) ([]BitLockerKeyInfo, error) {
+	machineID, err := storeInstance.knownMachineID(ctx, host)
 	if err != nil {
 		return nil, err
 	}
-
-	var key string
-	var updatedAt int64
-	var keyActor string
-	err = storeInstance.db.QueryRowContext(ctx,
-		`SELECT k.key_text, k.updated_at, k.actor
-           FROM bitlocker_keys k
-           JOIN machines m ON k.machine_id = m.id
-          WHERE m.hostname = ?`,
-		host).Scan(&key, &updatedAt, &keyActor)
-	if errors.Is(err, sql.ErrNoRows) {
-		return nil, fmt.Errorf("no recovery key for host %s", host)
+	protectorPrefix, err = normalizeProtectorID(protectorPrefix)
+	if err != nil {
+		return nil, err
 	}
+
+	rows, err := storeInstance.db.QueryContext(ctx,
+		`SELECT volume, protector_id, key_text, data_key, updated_at, actor
+           FROM bitlocker_keys
+          WHERE machine_id = ? AND protector_id LIKE ? || '%'
+          ORDER BY updated_at DESC, id DESC`,
+		machineID, protectorPrefix)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
+
+	keys := []BitLockerKeyInfo{}
+	for rows.Next() {
+		var keyInfo BitLockerKeyInfo
+		var sealed sealedSecret
+		var updatedAt int64
+		if err := rows.Scan(&keyInfo.Volume, &keyInfo.ProtectorID, &sealed.ciphertext,
+			&sealed.dataKey, &updatedAt, &keyInfo.Actor); err != nil {
+			return nil, err
+		}
+		if keyInfo.Key, err = storeInstance.open(sealed); err != nil {
+			return nil, err
+		}
+		keyInfo.UpdatedAt = time.Unix(updatedAt, 0)
+		keys = append(keys, keyInfo)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	if len(keys) == 0 {
+		if protectorPrefix != "" {
+			return nil, fmt.Errorf("no recovery key for host %s matching key ID %s",
+				host, protectorPrefix)
+		}
+		return nil, fmt.Errorf("no recovery key for host %s", host)
+	}
 
 	// Log the key retrieval
 	if actor == "" {
 		actor = defaultUnknownActor
 	}
-	_, err = storeInstance.db.ExecContext(ctx,
-		`INSERT INTO audit_logs(machine_id, action, actor, remote_addr, timestamp) 
-         VALUES (?,?,?,?,?)`,
-		machineID, "fetch_bde_key", actor, remoteAddr, time.Now().Unix())
+	err = storeInstance.recordAudit(ctx, machineID, "fetch_bde_key", actor, remoteAddr, "")
 	if err != nil {
 		return nil, err
 	}
+	return keys, nil
+}
 
-	return &BitLockerKeyInfo{
-		Key:       key,
-		UpdatedAt: time.Unix(updatedAt, 0),
-		Actor:     keyActor,
-	}, nil
+// normalizeProtectorID upper-cases a key protector GUID (or a prefix of one)
+// and strips the braces manage-bde prints around it.
+func normalizeProtectorID(protectorID string) (string, error) {
+	protectorID = strings.ToUpper(strings.Trim(strings.TrimSpace(protectorID), "{}"))
+	for _, char := range protectorID {
+		isHex := (char >= '0' && char <= '9') || (char >= 'A' && char <= 'F')
+		if !isHex && char != '-' {
+			return "", fmt.Errorf("invalid key protector ID %q", protectorID)
+		}
+	}
+	return protectorID, nil
 }
@@ -0,0 +1,278 @@
+// internal/store/tokens.go
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// apiTokenPrefix marks SHIPS API tokens so they are easy to spot in
+// configuration files and secret scanners.
+const apiTokenPrefix = "ships_"
+
+// lastUsedResolution limits how often authenticating with a token writes
+// last_used_at back to the database.
+const lastUsedResolution = time.Minute
+
+// ErrInvalidToken is returned by AuthenticateAPIToken for unknown, revoked
+// and expired tokens alike so callers cannot tell them apart.
+var ErrInvalidToken = errors.New("invalid, revoked or expired API token")
+
+// APIToken describes an issued API token. The token itself is only ever
+// returned by IssueAPIToken or EnrollMachine; zero times mean "never".
+// Hostname is set for machine credentials, which may only write the
+// secrets of that machine.
+type APIToken struct {
+	ID         int64     `json:"id"`
+	Name       string    `json:"name"`
+	Hostname   string    `json:"hostname,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	CreatedBy  string    `json:"created_by"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	RevokedAt  time.Time `json:"revoked_at"`
+}
+
+// Active reports whether the token can still be used at now.
+func (token APIToken) Active(now time.Time) bool {
+	return token.RevokedAt.IsZero() && (token.ExpiresAt.IsZero() || now.Before(token.ExpiresAt))
+}
+
+// hashAPIToken returns the stored form of a token.
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newSecretToken returns prefix followed by 256 random bits.
+func newSecretToken(prefix string) (string, error) {
+	secret := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+		return "", err
+	}
+	return prefix + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// validateTokenName checks that name is usable as an audit actor.
+func validateTokenName(name string) error {
+	if name == "" {
+		return errors.New("token name cannot be empty")
+	}
+	if len(name) > 128 {
+		return errors.New("token name too long")
+	}
+	if strings.IndexFunc(name, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsControl(r)
+	}) >= 0 {
+		return errors.New("token name contains invalid characters")
+	}
+	return nil
+}
+
+// IssueAPIToken creates a token for name that expires after ttl (0 means
+// never) and records an "issue_token" audit entry. It returns the token,
+// which cannot be recovered later, and its metadata.
+func (storeInstance *Store) IssueAPIToken(
+	ctx context.Context,
+	name string,
+	ttl time.Duration,
+	actor string,
+) (string, *APIToken, error) {
+	if err := validateTokenName(name); err != nil {
+		return "", nil, err
+	}
+	if ttl < 0 {
+		return "", nil, errors.New("token lifetime cannot be negative")
+	}
+	if actor == "" {
+		actor = defaultUnknownActor
+	}
+	token, err := newSecretToken(apiTokenPrefix)
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+	info := &APIToken{Name: name, CreatedAt: time.Unix(now.Unix(), 0), CreatedBy: actor}
+	var expiresAt sql.NullInt64
+	if ttl > 0 {
+		info.ExpiresAt = time.Unix(now.Add(ttl).Unix(), 0)
+		expiresAt = sql.NullInt64{Int64: info.ExpiresAt.Unix(), Valid: true}
+	}
+
+	transaction, err := storeInstance.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	defer transaction.Rollback() // nolint:errcheck // no-op after Commit
+
+	result, err := transaction.ExecContext(ctx,
+		`INSERT INTO api_tokens(name, token_hash, created_at, created_by, expires_at)
+         VALUES (?,?,?,?,?)`,
+		name, hashAPIToken(token), now.Unix(), actor, expiresAt)
+	if err != nil {
+		return "", nil, err
+	}
+	if info.ID, err = result.LastInsertId(); err != nil {
+		return "", nil, err
+	}
+	entry, err := storeInstance.appendAudit(ctx, transaction, nil, "issue_token",
+		actor, "local", fmt.Sprintf("token %d for %s", info.ID, name), now.Unix())
+	if err != nil {
+		return "", nil, err
+	}
+	if err := transaction.Commit(); err != nil {
+		return "", nil, err
+	}
+	storeInstance.notifyAudit(entry)
+	return token, info, nil
+}
+
+// RevokeAPIToken revokes the token with the given ID and records a
+// "revoke_token" audit entry.
+func (storeInstance *Store) RevokeAPIToken(
+	ctx context.Context,
+	id int64,
+	actor string,
+) error {
+	if actor == "" {
+		actor = defaultUnknownActor
+	}
+	transaction, err := storeInstance.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer transaction.Rollback() // nolint:errcheck // no-op after Commit
+
+	var name string
+	var revokedAt sql.NullInt64
+	err = transaction.QueryRowContext(ctx,
+		`SELECT name, revoked_at FROM api_tokens WHERE id = ?`, id,
+	).Scan(&name, &revokedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("no API token with ID %d", id)
+	}
+	if err != nil {
+		return err
+	}
+	if revokedAt.Valid {
+		return fmt.Errorf("API token %d is already revoked", id)
+	}
+
+	now := time.Now().Unix()
+	if _, err := transaction.ExecContext(ctx,
+		`UPDATE api_tokens SET revoked_at = ? WHERE id = ?`, now, id); err != nil {
+		return err
+	}
+	entry, err := storeInstance.appendAudit(ctx, transaction, nil, "revoke_token",
+		actor, "local", fmt.Sprintf("token %d for %s", id, name), now)
+	if err != nil {
+		return err
+	}
+	if err := transaction.Commit(); err != nil {
+		return err
+	}
+	storeInstance.notifyAudit(entry)
+	return nil
+}
+
+// ListAPITokens returns every token ever issued, oldest first.
+func (storeInstance *Store) ListAPITokens(ctx context.Context) ([]APIToken, error) {
+	rows, err := storeInstance.db.QueryContext(ctx,
+		`SELECT t.id, t.name, m.hostname, t.created_at, t.created_by, t.expires_at,
+                t.last_used_at, t.revoked_at
+           FROM api_tokens t
+           LEFT JOIN machines m ON t.machine_id = m.id
+          ORDER BY t.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []APIToken{}
+	for rows.Next() {
+		var token APIToken
+		var hostname sql.NullString
+		var createdAt int64
+		var expiresAt, lastUsedAt, revokedAt sql.NullInt64
+		if err := rows.Scan(&token.ID, &token.Name, &hostname, &createdAt, &token.CreatedBy,
+			&expiresAt, &lastUsedAt, &revokedAt); err != nil {
+			return nil, err
+		}
+		token.Hostname = hostname.String
+		token.CreatedAt = time.Unix(createdAt, 0)
+		token.ExpiresAt = nullableTime(expiresAt)
+		token.LastUsedAt = nullableTime(lastUsedAt)
+		token.RevokedAt = nullableTime(revokedAt)
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// AuthenticateAPIToken returns the metadata of token if it is active and
+// notes when it was last used.
+func (storeInstance *Store) AuthenticateAPIToken(
+	ctx context.Context,
+	token string,
+) (*APIToken, error) {
+	if !strings.HasPrefix(token, apiTokenPrefix) {
+		return nil, ErrInvalidToken
+	}
+	info := &APIToken{}
+	var hostname sql.NullString
+	var createdAt int64
+	var expiresAt, lastUsedAt, revokedAt sql.NullInt64
+	err := storeInstance.db.QueryRowContext(ctx,
+		`SELECT t.id, t.name, m.hostname, t.created_at, t.created_by, t.expires_at,
+                t.last_used_at, t.revoked_at
+           FROM api_tokens t
+           LEFT JOIN machines m ON t.machine_id = m.id
+          WHERE t.token_hash = ?`,
+		hashAPIToken(token),
+	).Scan(&info.ID, &info.Name, &hostname, &createdAt, &info.CreatedBy,
+		&expiresAt, &lastUsedAt, &revokedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	info.Hostname = hostname.String
+	info.CreatedAt = time.Unix(createdAt, 0)
+	info.ExpiresAt = nullableTime(expiresAt)
+	info.LastUsedAt = nullableTime(lastUsedAt)
+	info.RevokedAt = nullableTime(revokedAt)
+
+	now := time.Now()
+	if !info.Active(now) {
+		return nil, ErrInvalidToken
+	}
+	if now.Sub(info.LastUsedAt) >= lastUsedResolution {
+		if _, err := storeInstance.db.ExecContext(ctx,
+			`UPDATE api_tokens SET last_used_at = ? WHERE id = ?`,
+			now.Unix(), info.ID); err != nil {
+			return nil, err
+		}
+		info.LastUsedAt = time.Unix(now.Unix(), 0)
+	}
+	return info, nil
+}
+
+// nullableTime converts an optional Unix timestamp column to a time that
+// is zero when the column is NULL.
+func nullableTime(value sql.NullInt64) time.Time {
+	if !value.Valid {
+		return time.Time{}
+	}
+	return time.Unix(value.Int64, 0)
+}
@@ -0,0 +1,283 @@
+// internal/syslog/forwarder.go
+//
+// Package syslog forwards audit events from the server to a syslog daemon or
+// SIEM as RFC 5424 messages whose body is the same JSON the SSH wrapper
+// (bin/shipsc_wrapper.sh) emits, so existing rsyslog and Wazuh rules match
+// both sources.
+package syslog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jottavia/SHIPS2-Go/internal/store"
+)
+
+// Environment variables consulted by FromEnv.
+const (
+	envTarget   = "SHIPS_SYSLOG"
+	envTag      = "SHIPS_SYSLOG_TAG"
+	envFacility = "SHIPS_SYSLOG_FACILITY"
+)
+
+// DefaultTag is the APP-NAME of forwarded messages. It matches the wrapper's
+// logger tag, which rule 91000 and the rsyslog drop-in filter on.
+const DefaultTag = "shipsc-wrapper"
+
+// queueSize bounds the number of events waiting to be sent. When the
+// collector is unreachable for long, further events are dropped (and
+// counted) rather than slowing down API requests; they remain in audit_logs.
+const queueSize = 1024
+
+const (
+	dialTimeout  = 5 * time.Second
+	writeTimeout = 5 * time.Second
+)
+
+// Syslog severities used for audit events.
+const (
+	severityWarning = 4
+	severityInfo    = 6
+)
+
+// facilities maps the facility names accepted in SHIPS_SYSLOG_FACILITY to
+// their RFC 5424 codes.
+var facilities = map[string]int{
+	"user": 1, "daemon": 3, "auth": 4, "authpriv": 10,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// Config describes where and how to forward events.
+type Config struct {
+	// Network is "udp", "tcp" or "unixgram"; Address is host:port or a
+	// socket path.
+	Network  string
+	Address  string
+	Tag      string
+	Facility int
+}
+
+// Event is the JSON body of a forwarded message. The first six fields use
+// the names of the wrapper's log_json so one decoder handles both sources.
+type Event struct {
+	Timestamp  string `json:"timestamp"`
+	Action     string `json:"action"` // ALLOW or DENIED
+	Verb       string `json:"verb"`   // the audit_logs action, e.g. fetch_password
+	Hostname   string `json:"hostname"`
+	User       string `json:"user"`
+	RemoteAddr string `json:"remote_addr"`
+	Detail     string `json:"detail,omitempty"`
+	Source     string `json:"source"`
+	AuditID    int64  `json:"audit_id"`
+}
+
+// Forwarder sends audit entries to a syslog collector in the background.
+type Forwarder struct {
+	config   Config
+	hostname string
+	queue    chan store.AuditEntry
+	done     chan struct{}
+
+	mu      sync.Mutex
+	closed  bool
+	dropped int
+	conn    net.Conn
+}
+
+// ParseTarget parses a SHIPS_SYSLOG value: udp://host:port,
+// tcp://host:port or unix:///path/to/socket. A missing port defaults to 514.
+func ParseTarget(target string) (network, address string, err error) {
+	parsed, err := url.Parse(target)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid syslog target %q: %w", target, err)
+	}
+	switch parsed.Scheme {
+	case "udp", "tcp":
+		if parsed.Host == "" {
+			return "", "", fmt.Errorf("syslog target %q has no host", target)
+		}
+		address = parsed.Host
+		if parsed.Port() == "" {
+			address = net.JoinHostPort(parsed.Hostname(), "514")
+		}
+		return parsed.Scheme, address, nil
+	case "unix":
+		if parsed.Path == "" {
+			return "", "", fmt.Errorf("syslog target %q has no socket path", target)
+		}
+		return "unixgram", parsed.Path, nil
+	default:
+		return "", "", fmt.Errorf("syslog target %q must start with udp://, tcp:// or unix://", target)
+	}
+}
+
+// FromEnv builds a Forwarder from SHIPS_SYSLOG, SHIPS_SYSLOG_TAG and
+// SHIPS_SYSLOG_FACILITY. It returns nil, nil when SHIPS_SYSLOG is unset.
+func FromEnv() (*Forwarder, error) {
+	target := os.Getenv(envTarget)
+	if target == "" {
+		return nil, nil
+	}
+	network, address, err := ParseTarget(target)
+	if err != nil {
+		return nil, err
+	}
+	config := Config{
+		Network:  network,
+		Address:  address,
+		Tag:      os.Getenv(envTag),
+		Facility: facilities["authpriv"],
+	}
+	if name := os.Getenv(envFacility); name != "" {
+		facility, ok := facilities[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown syslog facility %q", name)
+		}
+		config.Facility = facility
+	}
+	return New(config), nil
+}
+
+// New starts a Forwarder for config. The connection is made lazily, so a
+// collector that is down at start-up does not keep the server from running.
+func New(config Config) *Forwarder {
+	if config.Tag == "" {
+		config.Tag = DefaultTag
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	forwarder := &Forwarder{
+		config:   config,
+		hostname: hostname,
+		queue:    make(chan store.AuditEntry, queueSize),
+		done:     make(chan struct{}),
+	}
+	go forwarder.run()
+	return forwarder
+}
+
+// Audit queues entry for forwarding. Its signature matches
+// store.Store.SetAuditHook; it never blocks.
+func (forwarder *Forwarder) Audit(entry store.AuditEntry) {
+	forwarder.mu.Lock()
+	defer forwarder.mu.Unlock()
+	if forwarder.closed {
+		return
+	}
+	select {
+	case forwarder.queue <- entry:
+	default:
+		forwarder.dropped++
+	}
+}
+
+// Close sends the queued events, waiting at most timeout, and closes the
+// connection.
+func (forwarder *Forwarder) Close(timeout time.Duration) error {
+	forwarder.mu.Lock()
+	if forwarder.closed {
+		forwarder.mu.Unlock()
+		return nil
+	}
+	forwarder.closed = true
+	close(forwarder.queue)
+	forwarder.mu.Unlock()
+
+	select {
+	case <-forwarder.done:
+	case <-time.After(timeout):
+		return errors.New("timed out flushing syslog queue")
+	}
+	if forwarder.conn != nil {
+		return forwarder.conn.Close()
+	}
+	return nil
+}
+
+// run sends queued entries until the queue is closed.
+func (forwarder *Forwarder) run() {
+	defer close(forwarder.done)
+	for entry := range forwarder.queue {
+		if dropped := forwarder.takeDropped(); dropped > 0 {
+			log.Printf("syslog: queue full, %d audit events not forwarded", dropped)
+		}
+		if err := forwarder.send(forwarder.Format(entry)); err != nil {
+			log.Printf("syslog: forwarding audit event %d: %v", entry.ID, err)
+		}
+	}
+}
+
+// takeDropped returns and resets the number of events dropped so far.
+func (forwarder *Forwarder) takeDropped() int {
+	forwarder.mu.Lock()
+	defer forwarder.mu.Unlock()
+	dropped := forwarder.dropped
+	forwarder.dropped = 0
+	return dropped
+}
+
+// send writes message, reconnecting once if the connection has gone away.
+func (forwarder *Forwarder) send(message []byte) error {
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if forwarder.conn == nil {
+			forwarder.conn, err = net.DialTimeout(
+				forwarder.config.Network, forwarder.config.Address, dialTimeout)
+			if err != nil {
+				forwarder.conn = nil
+				continue
+			}
+		}
+		forwarder.conn.SetWriteDeadline(time.Now().Add(writeTimeout)) // nolint:errcheck // best effort
+		if _, err = forwarder.conn.Write(message); err == nil {
+			return nil
+		}
+		forwarder.conn.Close() // nolint:errcheck // connection is being replaced
+		forwarder.conn = nil
+	}
+	return err
+}
+
+// Format renders entry as an RFC 5424 message. TCP messages are terminated
+// by a newline (RFC 6587 non-transparent framing), which rsyslog and the
+// Wazuh remote syslog listener both accept.
+func (forwarder *Forwarder) Format(entry store.AuditEntry) []byte {
+	outcome, severity := "ALLOW", severityInfo
+	if strings.HasSuffix(entry.Action, "_denied") {
+		outcome, severity = "DENIED", severityWarning
+	}
+	hostname := entry.Hostname
+	if hostname == "" {
+		hostname = "unknown"
+	}
+	body, _ := json.Marshal(Event{ // nolint:errcheck // plain strings always marshal
+		Timestamp:  entry.Timestamp.UTC().Format("2006-01-02T15:04:05.000Z"),
+		Action:     outcome,
+		Verb:       entry.Action,
+		Hostname:   hostname,
+		User:       entry.Actor,
+		RemoteAddr: entry.RemoteAddr,
+		Detail:     entry.Detail,
+		Source:     "ships-server",
+		AuditID:    entry.ID,
+	})
+	message := fmt.Sprintf("<%d>1 %s %s %s %d audit - %s",
+		forwarder.config.Facility*8+severity,
+		entry.Timestamp.UTC().Format(time.RFC3339),
+		forwarder.hostname, forwarder.config.Tag, os.Getpid(), body)
+	if forwarder.config.Network == "tcp" {
+		message += "\n"
+	}
+	return []byte(message)
+}
@@ -0,0 +1,236 @@
+// tests/approval_test.go
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jottavia/SHIPS2-Go/internal/api"
+	"github.com/jottavia/SHIPS2-Go/internal/store"
+)
+
+// operatorToken issues an API token for name with the operator role.
+func operatorToken(t *testing.T, st *store.Store, name string) string {
+	t.Helper()
+	ctx := context.Background()
+	token, _, err := st.IssueAPIToken(ctx, name, 0, "test-admin")
+	if err != nil {
+		t.Fatalf("Failed to issue token: %v", err)
+	}
+	if err := st.AssignRole(ctx, name, store.RoleOperator, "test-admin"); err != nil {
+		t.Fatalf("Failed to assign role: %v", err)
+	}
+	return token
+}
+
+func getAs(t *testing.T, url, token string) (int, map[string]any) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatalf("Failed to build request: %v", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to GET %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	var body map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	return resp.StatusCode, body
+}
+
+func decide(t *testing.T, serverURL string, id int64, verb, token, body string) int {
+	t.Helper()
+	url := fmt.Sprintf("%s/api/v1/approvals/%d/%s", serverURL, id, verb)
+	return doWithToken(t, http.MethodPost, url, token, []byte(body)).StatusCode
+}
+
+func TestTwoPersonApproval(t *testing.T) {
+	server, st := setupTestServer(t)
+	defer server.Close()
+	defer st.Close()
+	ctx := context.Background()
+
+	if err := st.SetApprovalPolicy(ctx, "EXEC-*", store.SecretBitLocker, "admin"); err != nil {
+		t.Fatalf("Failed to set policy: %v", err)
+	}
+	if err := st.SetApprovalPolicy(ctx, "EXEC-*", "secrets", "admin"); err == nil {
+		t.Error("Expected an unknown secret to be rejected")
+	}
+	if err := st.UpdateBDEKey(ctx, "EXEC-01", "C:", "", osVolumeKey, "test", "local"); err != nil {
+		t.Fatalf("Failed to store key: %v", err)
+	}
+	if err := st.RotatePassword(ctx, "EXEC-01", "Exec123!", "test", "local"); err != nil {
+		t.Fatalf("Failed to rotate: %v", err)
+	}
+
+	alice := operatorToken(t, st, "alice")
+	bob := operatorToken(t, st, "bob")
+	carol := operatorToken(t, st, "carol")
+
+	bdeURL := server.URL + "/api/v1/bde/EXEC-01"
+	// Approvals are tied to who asked, which a declared actor cannot prove.
+	if status, _ := getAs(t, bdeURL, ""); status != http.StatusForbidden {
+		t.Errorf("Expected 403 requesting approval unauthenticated, got %d", status)
+	}
+	status, body := getAs(t, bdeURL, alice)
+	if status != http.StatusAccepted || body["status"] != "approval_required" {
+		t.Fatalf("Expected 202 approval_required, got %d %v", status, body)
+	}
+	id := int64(body["request_id"].(float64))
+	if status, again := getAs(t, bdeURL, alice); status != http.StatusAccepted ||
+		int64(again["request_id"].(float64)) != id {
+		t.Errorf("Expected the same pending request, got %d %v", status, again)
+	}
+	if status, _ := getAs(t, server.URL+"/api/v1/password/EXEC-01", alice); status != http.StatusOK {
+		t.Errorf("Expected the password to need no approval under a bde policy, got %d", status)
+	}
+
+	if status := decide(t, server.URL, id, "approve", "", `{"actor":"bob"}`); status != http.StatusUnauthorized {
+		t.Errorf("Expected 401 approving unauthenticated, got %d", status)
+	}
+	if status := decide(t, server.URL, id, "approve", alice, `{"actor":"bob"}`); status != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a declared approver, got %d", status)
+	}
+	if status := decide(t, server.URL, id, "approve", alice, ""); status != http.StatusForbidden {
+		t.Errorf("Expected 403 for self-approval, got %d", status)
+	}
+	if status := decide(t, server.URL, id, "approve", bob, ""); status != http.StatusOK {
+		t.Fatalf("Expected bob to approve, got %d", status)
+	}
+	if status := decide(t, server.URL, id, "deny", carol, ""); status != http.StatusConflict {
+		t.Errorf("Expected 409 deciding twice, got %d", status)
+	}
+
+	if status, body := getAs(t, bdeURL, alice); status != http.StatusOK || body["key"] != osVolumeKey {
+		t.Fatalf("Expected alice to retrieve the key once approved, got %d %v", status, body)
+	}
+	if status, _ := getAs(t, bdeURL, alice); status != http.StatusAccepted {
+		t.Errorf("Expected an approval to be usable only once, got %d", status)
+	}
+	if status, _ := getAs(t, server.URL+"/api/v1/bde/by-key-id/"+"ABCDEF12", alice); status != http.StatusNotFound {
+		t.Errorf("Expected an unknown key ID to stay 404, got %d", status)
+	}
+
+	pending, err := st.ListApprovalRequests(ctx, store.ApprovalPending)
+	if err != nil || len(pending) != 1 {
+		t.Fatalf("Expected one pending request, got %+v (%v)", pending, err)
+	}
+	if status := decide(t, server.URL, pending[0].ID, "deny", bob, ""); status != http.StatusOK {
+		t.Errorf("Expected bob to deny, got %d", status)
+	}
+	if _, err := st.DecideApproval(ctx, 9999, true, "bob", "", "local",
+		store.DefaultApprovalSettings()); !errors.Is(err, store.ErrUnknownApproval) {
+		t.Errorf("Expected ErrUnknownApproval, got %v", err)
+	}
+
+	for action, want := range map[string]int{
+		"approval_requested": 2,
+		"approval_granted":   1,
+		"approval_denied":    1,
+		"approval_used":      1,
+	} {
+		entries, _, err := st.QueryAudit(ctx, store.AuditFilter{Action: action})
+		if err != nil {
+			t.Fatalf("Failed to query audit log: %v", err)
+		}
+		if len(entries) != want {
+			t.Errorf("Expected %d %s entries, got %d", want, action, len(entries))
+		}
+	}
+}
+
+func TestBulkRetrievalNeedsApproval(t *testing.T) {
+	st, err := store.New(t.TempDir() + "/test_ships.db")
+	if err != nil {
+		t.Fatalf("Failed to create test store: %v", err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+
+	settings := store.DefaultApprovalSettings()
+	settings.BulkThreshold = 2
+	router := gin.New()
+	api.New(st).WithApprovalSettings(settings).Register(router)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	for _, host := range []string{"BULK1", "BULK2", "BULK3"} {
+		if err := st.RotatePassword(ctx, host, "Bulk123!", "test", "local"); err != nil {
+			t.Fatalf("Failed to rotate: %v", err)
+		}
+	}
+	alice := operatorToken(t, st, "alice")
+	bob := operatorToken(t, st, "bob")
+	for _, host := range []string{"BULK1", "BULK2"} {
+		if status, _ := getAs(t, server.URL+"/api/v1/password/"+host, alice); status != http.StatusOK {
+			t.Fatalf("Expected fetch of %s to succeed, got %d", host, status)
+		}
+	}
+	status, body := getAs(t, server.URL+"/api/v1/password/BULK3", alice)
+	if status != http.StatusAccepted {
+		t.Fatalf("Expected the third fetch to need approval, got %d", status)
+	}
+	if request, _ := body["request"].(map[string]any); request["reason"] == "" {
+		t.Errorf("Expected a bulk reason, got %v", body)
+	}
+	if status, _ := getAs(t, server.URL+"/api/v1/password/BULK3", bob); status != http.StatusOK {
+		t.Errorf("Expected another user to be under the limit, got %d", status)
+	}
+}
+
+func TestApprovalUsedOnlyOnSuccess(t *testing.T) {
+	server, st := setupTestServer(t)
+	defer server.Close()
+	defer st.Close()
+	ctx := context.Background()
+
+	if err := st.SetApprovalPolicy(ctx, "HELD-01", store.SecretPassword, "admin"); err != nil {
+		t.Fatalf("Failed to set policy: %v", err)
+	}
+	if err := st.RotatePassword(ctx, "HELD-01", "Held123!", "test", "local"); err != nil {
+		t.Fatalf("Failed to rotate: %v", err)
+	}
+	alice := operatorToken(t, st, "alice")
+	bob := operatorToken(t, st, "bob")
+
+	url := server.URL + "/api/v1/password/HELD-01"
+	status, body := getAs(t, url, alice)
+	if status != http.StatusAccepted {
+		t.Fatalf("Expected 202 approval_required, got %d %v", status, body)
+	}
+	if status := decide(t, server.URL, int64(body["request_id"].(float64)), "approve", bob,
+		""); status != http.StatusOK {
+		t.Fatalf("Expected bob to approve, got %d", status)
+	}
+
+	// A retrieval that fails, here because carol holds the password, leaves
+	// the approval for the next attempt.
+	if _, _, err := st.CheckoutPassword(ctx, "HELD-01", time.Hour, "carol", "local"); err != nil {
+		t.Fatalf("Failed to check out: %v", err)
+	}
+	if status, _ := getAs(t, url, alice); status != http.StatusConflict {
+		t.Errorf("Expected 409 while carol holds the password, got %d", status)
+	}
+	if err := st.CheckinPassword(ctx, "HELD-01", "carol", "local"); err != nil {
+		t.Fatalf("Failed to check in: %v", err)
+	}
+	if status, body := getAs(t, url, alice); status != http.StatusOK || body["password"] != "Held123!" {
+		t.Fatalf("Expected the approval to survive the failed retrieval, got %d %v", status, body)
+	}
+	if status, _ := getAs(t, url, alice); status != http.StatusAccepted {
+		t.Errorf("Expected the approval to be used up, got %d", status)
+	}
+}
@@ -0,0 +1,109 @@
+// tests/audit_test.go
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+type auditPage struct {
+	Entries []struct {
+		ID       int64  `json:"id"`
+		Hostname string `json:"hostname"`
+		Action   string `json:"action"`
+		Actor    string `json:"actor"`
+	} `json:"entries"`
+	NextBefore int64 `json:"next_before"`
+}
+
+// getAudit queries the audit API and decodes one page.
+func getAudit(t *testing.T, url string) auditPage {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("Failed to query audit log: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+	var page auditPage
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		t.Fatalf("Failed to decode audit response: %v", err)
+	}
+	return page
+}
+
+func TestAuditQuery(t *testing.T) {
+	server, st := setupTestServer(t)
+	defer server.Close()
+	defer st.Close()
+
+	for _, host := range []string{"AUDITA", "AUDITB"} {
+		body, err := json.Marshal(map[string]string{
+			"host": host, "password": "AuditPassword1!", "actor": "rotator",
+		})
+		if err != nil {
+			t.Fatalf("Failed to marshal rotation payload: %v", err)
+		}
+		resp, err := http.Post(server.URL+"/api/v1/rotate", "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("Failed to post rotation: %v", err)
+		}
+		resp.Body.Close()
+	}
+	for _, actor := range []string{"alice", "bob", "alice"} {
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/api/v1/password/AUDITA", nil)
+		if err != nil {
+			t.Fatalf("Failed to build request: %v", err)
+		}
+		req.Header.Set("X-Actor", actor)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to fetch password: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	page := getAudit(t, server.URL+"/api/v1/audit?host=AUDITA&action=fetch_password&actor=alice")
+	if len(page.Entries) != 2 {
+		t.Fatalf("Expected 2 fetches by alice, got %d", len(page.Entries))
+	}
+	for _, entry := range page.Entries {
+		if entry.Hostname != "AUDITA" || entry.Actor != "alice" || entry.Action != "fetch_password" {
+			t.Errorf("Unexpected entry %+v", entry)
+		}
+	}
+
+	page = getAudit(t, server.URL+"/api/v1/audit?host=AUDITB")
+	if len(page.Entries) != 1 || page.Entries[0].Action != "rotate_password" {
+		t.Errorf("Expected only the AUDITB rotation, got %+v", page.Entries)
+	}
+
+	// Walk the whole log one entry per page.
+	seen := 0
+	url := server.URL + "/api/v1/audit?limit=1"
+	for {
+		page = getAudit(t, url)
+		seen += len(page.Entries)
+		if page.NextBefore == 0 {
+			break
+		}
+		url = fmt.Sprintf("%s/api/v1/audit?limit=1&before=%d", server.URL, page.NextBefore)
+	}
+	if seen != 5 {
+		t.Errorf("Expected 5 audit entries across pages, got %d", seen)
+	}
+
+	resp, err := http.Get(server.URL + "/api/v1/audit?since=yesterday")
+	if err != nil {
+		t.Fatalf("Failed to query audit log: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for bad since, got %d", resp.StatusCode)
+	}
+}
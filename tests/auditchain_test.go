@@ -0,0 +1,137 @@
+// tests/auditchain_test.go
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/jottavia/SHIPS2-Go/internal/store"
+)
+
+// seedAudit produces a few audit entries through the store.
+func seedAudit(t *testing.T, st *store.Store) {
+	t.Helper()
+	ctx := context.Background()
+	if err := st.RotatePassword(ctx, "CHAINHOST", "ChainPassword1!", "rotator", "10.0.0.1"); err != nil {
+		t.Fatalf("Failed to rotate password: %v", err)
+	}
+	for _, actor := range []string{"alice", "bob", "carol"} {
+		if _, err := st.GetPassword(ctx, "CHAINHOST", actor, "10.0.0.2"); err != nil {
+			t.Fatalf("Failed to get password: %v", err)
+		}
+	}
+}
+
+func TestAuditChainDetectsTampering(t *testing.T) {
+	dbPath := t.TempDir() + "/ships.db"
+	st, err := store.New(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer st.Close()
+	seedAudit(t, st)
+	ctx := context.Background()
+
+	result, err := st.VerifyAuditChain(ctx)
+	if err != nil {
+		t.Fatalf("Failed to verify chain: %v", err)
+	}
+	if result.BrokenAtID != 0 || result.Entries != 4 {
+		t.Fatalf("Expected intact chain of 4 entries, got %+v", result)
+	}
+	head, err := st.AuditChainHead(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get chain head: %v", err)
+	}
+	if head.ID != result.Head.ID || head.Hash != result.Head.Hash {
+		t.Errorf("Expected head %+v to match verified head %+v", head, result.Head)
+	}
+
+	raw, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer raw.Close()
+
+	// Hide that bob fetched the password.
+	if _, err := raw.Exec(`UPDATE audit_logs SET actor = 'alice' WHERE actor = 'bob'`); err != nil {
+		t.Fatalf("Failed to tamper with audit log: %v", err)
+	}
+	result, err = st.VerifyAuditChain(ctx)
+	if err != nil {
+		t.Fatalf("Failed to verify chain: %v", err)
+	}
+	if result.BrokenAtID != 3 || !strings.Contains(result.Reason, "modified") {
+		t.Errorf("Expected modification detected at entry 3, got %+v", result)
+	}
+
+	// Deleting the row instead breaks the following link.
+	if _, err := raw.Exec(`DELETE FROM audit_logs WHERE id = 3`); err != nil {
+		t.Fatalf("Failed to delete audit row: %v", err)
+	}
+	result, err = st.VerifyAuditChain(ctx)
+	if err != nil {
+		t.Fatalf("Failed to verify chain: %v", err)
+	}
+	if result.BrokenAtID != 4 || !strings.Contains(result.Reason, "deleted") {
+		t.Errorf("Expected deletion detected at entry 4, got %+v", result)
+	}
+}
+
+func TestAuditChainHMAC(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := dir + "/audit.key"
+	if err := os.WriteFile(keyFile, []byte("correct horse battery staple\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write audit key: %v", err)
+	}
+	t.Setenv("SHIPS_AUDIT_KEY_FILE", keyFile)
+
+	st, err := store.New(dir + "/ships.db")
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	seedAudit(t, st)
+	st.Close()
+
+	t.Setenv("SHIPS_AUDIT_KEY_FILE", "")
+	unkeyed, err := store.New(dir + "/ships.db")
+	if err != nil {
+		t.Fatalf("Failed to reopen store: %v", err)
+	}
+	defer unkeyed.Close()
+	result, err := unkeyed.VerifyAuditChain(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to verify chain: %v", err)
+	}
+	if result.BrokenAtID != 1 || !strings.Contains(result.Reason, "no audit key") {
+		t.Errorf("Expected HMAC chain to need the key, got %+v", result)
+	}
+}
+
+func TestAuditHeadEndpoint(t *testing.T) {
+	server, st := setupTestServer(t)
+	defer server.Close()
+	defer st.Close()
+	seedAudit(t, st)
+
+	resp, err := http.Get(server.URL + "/api/v1/audit/head")
+	if err != nil {
+		t.Fatalf("Failed to get audit head: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+	var head store.AuditHead
+	if err := json.NewDecoder(resp.Body).Decode(&head); err != nil {
+		t.Fatalf("Failed to decode audit head: %v", err)
+	}
+	if head.ID != 4 || !strings.HasPrefix(head.Hash, "sha256:") {
+		t.Errorf("Expected head at entry 4 with a sha256 hash, got %+v", head)
+	}
+}
@@ -0,0 +1,203 @@
+// tests/bitlocker_test.go
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const (
+	osVolumeKey   = "111111-222222-333333-444444-555555-666666-111111-222222"
+	dataVolumeKey = "222222-333333-444444-555555-666666-111111-222222-333333"
+)
+
+// postKey escrows a BitLocker key through the API and fails the test on error.
+func postKey(t *testing.T, serverURL string, payload map[string]string) {
+	t.Helper()
+	body, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatalf("Failed to marshal key payload: %v", err)
+	}
+	resp, err := http.Post(serverURL+"/api/v1/update_key", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to post key update: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+}
+
+type bdeVolume struct {
+	Volume      string `json:"volume"`
+	ProtectorID string `json:"protector_id"`
+	Key         string `json:"key"`
+}
+
+// getVolumes fetches the escrowed BitLocker keys of host via the API.
+func getVolumes(t *testing.T, url string) (int, []bdeVolume) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("Failed to fetch BitLocker keys: %v", err)
+	}
+	defer resp.Body.Close()
+	var keyResp struct {
+		Volumes []bdeVolume `json:"volumes"`
+	}
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&keyResp); err != nil {
+			t.Fatalf("Failed to decode key response: %v", err)
+		}
+	}
+	return resp.StatusCode, keyResp.Volumes
+}
+
+func TestMultipleBitLockerVolumes(t *testing.T) {
+	server, st := setupTestServer(t)
+	defer server.Close()
+	defer st.Close()
+
+	postKey(t, server.URL, map[string]string{
+		"host": "LAPTOP01", "key": osVolumeKey, "volume": "C:",
+		"protector_id": "{1a2b3c4d-0000-1111-2222-333344445555}", "actor": "test",
+	})
+	postKey(t, server.URL, map[string]string{
+		"host": "LAPTOP01", "key": dataVolumeKey, "volume": "D:",
+		"protector_id": "{9F8E7D6C-0000-1111-2222-333344445555}", "actor": "test",
+	})
+
+	status, volumes := getVolumes(t, server.URL+"/api/v1/bde/LAPTOP01")
+	if status != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", status)
+	}
+	if len(volumes) != 2 {
+		t.Fatalf("Expected keys for 2 volumes, got %d", len(volumes))
+	}
+	found := map[string]string{}
+	for _, volume := range volumes {
+		found[volume.Volume] = volume.Key
+	}
+	if found["C:"] != osVolumeKey || found["D:"] != dataVolumeKey {
+		t.Errorf("Expected both volume keys to be kept, got %+v", volumes)
+	}
+
+	status, volumes = getVolumes(t, server.URL+"/api/v1/bde/LAPTOP01?protector=1a2b3c4d")
+	if status != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", status)
+	}
+	if len(volumes) != 1 || volumes[0].Volume != "C:" {
+		t.Errorf("Expected only the C: key for Key ID 1A2B3C4D, got %+v", volumes)
+	}
+	if volumes[0].ProtectorID != "1A2B3C4D-0000-1111-2222-333344445555" {
+		t.Errorf("Expected normalized protector ID, got %q", volumes[0].ProtectorID)
+	}
+
+	status, _ = getVolumes(t, server.URL+"/api/v1/bde/LAPTOP01?protector=DEADBEEF")
+	if status != http.StatusNotFound {
+		t.Errorf("Expected status 404 for unknown Key ID, got %d", status)
+	}
+}
+
+func TestFindBDEKeyByKeyID(t *testing.T) {
+	server, st := setupTestServer(t)
+	defer server.Close()
+	defer st.Close()
+
+	postKey(t, server.URL, map[string]string{
+		"host": "DESK01", "key": osVolumeKey, "volume": "C:",
+		"protector_id": "{AAAA1111-0000-1111-2222-333344445555}", "actor": "test",
+	})
+	postKey(t, server.URL, map[string]string{
+		"host": "DESK02", "key": dataVolumeKey, "volume": "C:",
+		"protector_id": "{BBBB2222-0000-1111-2222-333344445555}", "actor": "test",
+	})
+
+	resp, err := http.Get(server.URL + "/api/v1/bde/by-key-id/bbbb2222")
+	if err != nil {
+		t.Fatalf("Failed to look up key ID: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+	var lookupResp struct {
+		Matches []struct {
+			Hostname string `json:"hostname"`
+			Key      string `json:"key"`
+		} `json:"matches"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&lookupResp); err != nil {
+		t.Fatalf("Failed to decode lookup response: %v", err)
+	}
+	if len(lookupResp.Matches) != 1 || lookupResp.Matches[0].Hostname != "DESK02" {
+		t.Fatalf("Expected key ID to resolve to DESK02, got %+v", lookupResp.Matches)
+	}
+	if lookupResp.Matches[0].Key != dataVolumeKey {
+		t.Errorf("Expected DESK02 recovery key, got %q", lookupResp.Matches[0].Key)
+	}
+
+	// Short prefixes would allow enumerating keys and are refused.
+	short, err := http.Get(server.URL + "/api/v1/bde/by-key-id/AAAA")
+	if err != nil {
+		t.Fatalf("Failed to look up short key ID: %v", err)
+	}
+	short.Body.Close()
+	if short.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status 404 for short key ID, got %d", short.StatusCode)
+	}
+}
+
+func TestRecoveryKeyValidation(t *testing.T) {
+	server, st := setupTestServer(t)
+	defer server.Close()
+	defer st.Close()
+
+	cases := map[string]struct {
+		key   string
+		group int
+	}{
+		"checksum": {"123456-123456-123456-123456-123456-123456-123456-123456", 1},
+		"range":    {"111111-222222-333333-444444-555555-666666-777777-888888", 7},
+		"groups":   {"111111-222222-333333", 0},
+		"digits":   {"111111-222222-333333-44444A-555555-666666-111111-222222", 4},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			body, err := json.Marshal(map[string]string{"host": "BADKEYHOST", "key": tc.key})
+			if err != nil {
+				t.Fatalf("Failed to marshal key payload: %v", err)
+			}
+			resp, err := http.Post(server.URL+"/api/v1/update_key", "application/json", bytes.NewReader(body))
+			if err != nil {
+				t.Fatalf("Failed to post key update: %v", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Fatalf("Expected status 400, got %d", resp.StatusCode)
+			}
+			var errResp struct {
+				Code  string `json:"code"`
+				Group int    `json:"group"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
+				t.Fatalf("Failed to decode error response: %v", err)
+			}
+			if errResp.Code != "invalid_recovery_key" || errResp.Group != tc.group {
+				t.Errorf("Expected invalid_recovery_key in group %d, got %+v", tc.group, errResp)
+			}
+		})
+	}
+
+	// 48 digits without dashes are accepted and stored in canonical form.
+	postKey(t, server.URL, map[string]string{
+		"host": "NODASHHOST", "key": strings.ReplaceAll(osVolumeKey, "-", ""),
+	})
+	status, volumes := getVolumes(t, server.URL+"/api/v1/bde/NODASHHOST")
+	if status != http.StatusOK || len(volumes) != 1 || volumes[0].Key != osVolumeKey {
+		t.Errorf("Expected canonical key to be stored, got %d %+v", status, volumes)
+	}
+}
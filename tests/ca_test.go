@@ -0,0 +1,154 @@
+// tests/ca_test.go
+package tests
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jottavia/SHIPS2-Go/internal/api"
+	"github.com/jottavia/SHIPS2-Go/internal/store"
+)
+
+// certificateRequest returns a new key and a PEM CSR for host.
+func certificateRequest(t *testing.T, host string) (*ecdsa.PrivateKey, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader,
+		&x509.CertificateRequest{Subject: pkix.Name{CommonName: "ignored"}}, key)
+	if err != nil {
+		t.Fatalf("Failed to create CSR: %v", err)
+	}
+	return key, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+}
+
+// postCertificate sends payload to path and returns the status and the
+// issued certificate paired with key.
+func postCertificate(
+	t *testing.T,
+	client *http.Client,
+	url string,
+	payload map[string]string,
+	key *ecdsa.PrivateKey,
+) (int, tls.Certificate, string) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatalf("Failed to marshal payload: %v", err)
+	}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Serial      string `json:"serial"`
+		Certificate string `json:"certificate"`
+	}
+	json.NewDecoder(resp.Body).Decode(&result) // nolint:errcheck // error bodies have no certificate
+	block, _ := pem.Decode([]byte(result.Certificate))
+	if block == nil {
+		return resp.StatusCode, tls.Certificate{}, ""
+	}
+	return resp.StatusCode, tls.Certificate{Certificate: [][]byte{block.Bytes}, PrivateKey: key},
+		result.Serial
+}
+
+func TestBuiltinCA(t *testing.T) {
+	st, err := store.New(t.TempDir() + "/test_ships.db")
+	if err != nil {
+		t.Fatalf("Failed to create test store: %v", err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+
+	caPEM, err := st.InitCA(ctx, "", "test-admin")
+	if err != nil {
+		t.Fatalf("Failed to initialise CA: %v", err)
+	}
+	if _, err := st.InitCA(ctx, "", "test-admin"); err == nil {
+		t.Error("Expected a second ca init to fail")
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM([]byte(caPEM)) {
+		t.Fatal("CA certificate is not PEM")
+	}
+	router := gin.New()
+	api.New(st).RequireTokens().Register(router)
+	server := httptest.NewUnstartedServer(router)
+	server.TLS = &tls.Config{ClientCAs: pool, ClientAuth: tls.VerifyClientCertIfGiven}
+	server.StartTLS()
+	defer server.Close()
+
+	enrollmentToken, _, err := st.CreateEnrollmentToken(ctx, "", 0, "test-admin")
+	if err != nil {
+		t.Fatalf("Failed to create enrollment token: %v", err)
+	}
+	if status, _, _ := postCertificate(t, server.Client(), server.URL+"/api/v1/enroll",
+		map[string]string{"host": "CAHOST", "enrollment_token": enrollmentToken, "csr": "junk"},
+		nil); status != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid CSR, got %d", status)
+	}
+	key, csr := certificateRequest(t, "CAHOST")
+	status, certificate, serial := postCertificate(t, server.Client(), server.URL+"/api/v1/enroll",
+		map[string]string{"host": "CAHOST", "enrollment_token": enrollmentToken, "csr": csr}, key)
+	if status != http.StatusOK {
+		t.Fatalf("Expected certificate enrollment to succeed, got %d", status)
+	}
+
+	rotate := func(client *http.Client) int {
+		resp, err := client.Post(server.URL+"/api/v1/rotate", "application/json",
+			bytes.NewReader([]byte(`{"host":"CAHOST","password":"Secret123!"}`)))
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	original := clientWithCert(server, certificate)
+	if status := rotate(original); status != http.StatusOK {
+		t.Errorf("Expected issued certificate to rotate its host, got %d", status)
+	}
+
+	key, csr = certificateRequest(t, "CAHOST")
+	status, renewedCertificate, renewedSerial := postCertificate(t, original,
+		server.URL+"/api/v1/certificates/renew", map[string]string{"csr": csr}, key)
+	if status != http.StatusOK || renewedSerial == serial {
+		t.Fatalf("Expected renewal to issue a new certificate, got %d", status)
+	}
+	renewed := clientWithCert(server, renewedCertificate)
+	if status := rotate(original); status != http.StatusUnauthorized {
+		t.Errorf("Expected superseded certificate to be refused, got %d", status)
+	}
+	if status := rotate(renewed); status != http.StatusOK {
+		t.Errorf("Expected renewed certificate to rotate its host, got %d", status)
+	}
+
+	if err := st.RevokeCertificate(ctx, renewedSerial, "key compromise", "test-admin"); err != nil {
+		t.Fatalf("Failed to revoke certificate: %v", err)
+	}
+	if status := rotate(renewed); status != http.StatusUnauthorized {
+		t.Errorf("Expected revoked certificate to be refused, got %d", status)
+	}
+
+	certificates, err := st.ListCertificates(ctx)
+	if err != nil {
+		t.Fatalf("Failed to list certificates: %v", err)
+	}
+	if len(certificates) != 2 || certificates[0].RevokeReason != "superseded" ||
+		certificates[1].RevokeReason != "key compromise" {
+		t.Errorf("Expected superseded and revoked certificates, got %+v", certificates)
+	}
+}
@@ -0,0 +1,95 @@
+// tests/compliance_test.go
+package tests
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jottavia/SHIPS2-Go/internal/store"
+)
+
+func TestComplianceReport(t *testing.T) {
+	server, st := setupTestServer(t)
+	defer server.Close()
+	defer st.Close()
+	ctx := context.Background()
+
+	for _, host := range []string{"GOODHOST", "NOKEYHOST"} {
+		if err := st.RotatePassword(ctx, host, "Initial123!", "test", "local"); err != nil {
+			t.Fatalf("Failed to rotate %s: %v", host, err)
+		}
+	}
+	for _, host := range []string{"GOODHOST", "NOPWHOST"} {
+		if err := st.UpdateBDEKey(ctx, host, "C:", "", osVolumeKey, "test", "local"); err != nil {
+			t.Fatalf("Failed to update key of %s: %v", host, err)
+		}
+	}
+
+	// Looking up a mistyped hostname must not add a non-compliant machine.
+	if _, err := st.GetPassword(ctx, "GOODHOTS", "test", "local"); err == nil {
+		t.Error("Expected an unknown machine to have no password")
+	}
+
+	report, err := st.ComplianceReport(ctx, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to build report: %v", err)
+	}
+	issues := map[string]string{}
+	for _, entry := range report.Machines {
+		issues[entry.Hostname] = strings.Join(entry.Issues, ",")
+	}
+	if report.Total != 3 || report.NonCompliant != 2 || issues["GOODHOST"] != "" ||
+		issues["NOKEYHOST"] != store.IssueNoKey || issues["NOPWHOST"] != store.IssueNoPassword {
+		t.Errorf("Expected GOODHOST compliant and one issue each for the others, got %+v", report)
+	}
+
+	// Every password is older than a nanosecond.
+	report, err = st.ComplianceReport(ctx, time.Nanosecond)
+	if err != nil || report.NonCompliant != 3 ||
+		report.Machines[0].Issues[0] != store.IssueStalePassword {
+		t.Errorf("Expected GOODHOST to have a stale password, got %+v (%v)", report, err)
+	}
+
+	resp, err := http.Get(server.URL + "/api/v1/reports/compliance?max_age=30d")
+	if err != nil {
+		t.Fatalf("Failed to get report: %v", err)
+	}
+	var result store.ComplianceReport
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK || result.NonCompliant != 2 ||
+		result.MaxAge != (30*24*time.Hour).String() {
+		t.Errorf("Expected two non-compliant machines over 30 days, got %d %+v (%v)",
+			resp.StatusCode, result, err)
+	}
+
+	resp, err = http.Get(server.URL + "/api/v1/reports/compliance?format=csv")
+	if err != nil {
+		t.Fatalf("Failed to get CSV report: %v", err)
+	}
+	records, err := csv.NewReader(resp.Body).ReadAll()
+	resp.Body.Close()
+	if err != nil || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/csv") {
+		t.Fatalf("Expected a CSV report, got %q (%v)", resp.Header.Get("Content-Type"), err)
+	}
+	if len(records) != 4 || records[0][0] != "hostname" || records[1][0] != "GOODHOST" ||
+		records[1][1] != "true" || records[3][2] != store.IssueNoPassword {
+		t.Errorf("Expected a header and three machines, got %v", records)
+	}
+
+	for _, maxAge := range []string{"soon", "0", "0d", "-1h"} {
+		resp, err = http.Get(server.URL + "/api/v1/reports/compliance?max_age=" + maxAge)
+		if err != nil {
+			t.Fatalf("Failed to get report: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected max_age=%s to be rejected, got %d", maxAge, resp.StatusCode)
+		}
+	}
+}
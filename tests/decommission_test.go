@@ -0,0 +1,131 @@
+// tests/decommission_test.go
+package tests
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jottavia/SHIPS2-Go/internal/store"
+)
+
+func TestDecommissionMachine(t *testing.T) {
+	server, st := setupTokenServer(t)
+	defer server.Close()
+	defer st.Close()
+	ctx := context.Background()
+
+	tokens := map[string]string{}
+	for name, role := range map[string]store.Role{
+		"OLDBOX": store.RoleMachine,
+		"ops":    store.RoleOperator,
+		"root":   store.RoleAdmin,
+	} {
+		token, _, err := st.IssueAPIToken(ctx, name, 0, "test-admin")
+		if err != nil {
+			t.Fatalf("Failed to issue token: %v", err)
+		}
+		if err := st.AssignRole(ctx, name, role, "test-admin"); err != nil {
+			t.Fatalf("Failed to assign role: %v", err)
+		}
+		tokens[name] = token
+	}
+	if err := st.RotatePassword(ctx, "OLDBOX", "Initial123!", "test", "local"); err != nil {
+		t.Fatalf("Failed to rotate: %v", err)
+	}
+	if err := st.UpdateBDEKey(ctx, "OLDBOX", "C:", "", osVolumeKey, "test", "local"); err != nil {
+		t.Fatalf("Failed to update key: %v", err)
+	}
+	pending, err := st.BeginRotation(ctx, "OLDBOX", "Pending123!", time.Hour, "test", "local")
+	if err != nil {
+		t.Fatalf("Failed to begin rotation: %v", err)
+	}
+
+	decommission := server.URL + "/api/v1/machines/OLDBOX/decommission"
+	body := []byte(`{"reason":"INC-42 laptop stolen"}`)
+	for _, tc := range []struct {
+		caller, url string
+		body        []byte
+		want        int
+	}{
+		{"ops", decommission, body, http.StatusForbidden},
+		{"root", decommission, []byte(`{}`), http.StatusBadRequest},
+		{"root", decommission, body, http.StatusOK},
+		{"root", decommission, body, http.StatusConflict},
+		{"root", server.URL + "/api/v1/machines/NOBOX/decommission", body, http.StatusNotFound},
+	} {
+		resp := doWithToken(t, http.MethodPost, tc.url, tokens[tc.caller], tc.body)
+		if resp.StatusCode != tc.want {
+			t.Fatalf("Decommission %s as %s: expected %d, got %d", tc.url, tc.caller,
+				tc.want, resp.StatusCode)
+		}
+	}
+
+	for _, tc := range []struct {
+		caller, method, path string
+		body                 []byte
+		want                 int
+	}{
+		{"OLDBOX", http.MethodPost, "/api/v1/rotate",
+			[]byte(`{"host":"OLDBOX","password":"Another123!"}`), http.StatusGone},
+		{"OLDBOX", http.MethodPost, "/api/v1/update_key",
+			[]byte(`{"host":"OLDBOX","key":"` + osVolumeKey + `"}`), http.StatusGone},
+		{"OLDBOX", http.MethodPost, "/api/v1/agent/checkin",
+			[]byte(`{"host":"OLDBOX"}`), http.StatusGone},
+		{"ops", http.MethodGet, "/api/v1/password/OLDBOX", nil, http.StatusForbidden},
+		{"ops", http.MethodGet, "/api/v1/bde/OLDBOX", nil, http.StatusForbidden},
+		{"root", http.MethodPost, "/api/v1/password/OLDBOX/checkout", []byte(`{}`), http.StatusGone},
+		{"root", http.MethodGet, "/api/v1/password/OLDBOX", nil, http.StatusOK},
+		{"root", http.MethodGet, "/api/v1/bde/OLDBOX", nil, http.StatusOK},
+	} {
+		resp := doWithToken(t, tc.method, server.URL+tc.path, tokens[tc.caller], tc.body)
+		if resp.StatusCode != tc.want {
+			t.Errorf("%s %s as %s: expected %d, got %d", tc.method, tc.path, tc.caller,
+				tc.want, resp.StatusCode)
+		}
+	}
+	err = st.ConfirmRotation(ctx, pending.ID, "test", "local")
+	if !errors.Is(err, store.ErrRotationExpired) {
+		t.Errorf("Expected the pending rotation to have expired, got %v", err)
+	}
+
+	report, err := st.ComplianceReport(ctx, 0)
+	if err != nil || report.Total != 0 {
+		t.Errorf("Expected no decommissioned machine in the report, got %+v (%v)", report, err)
+	}
+
+	// Nothing is due within the retention period.
+	if purged, err := st.PurgeRetiredMachines(ctx, time.Now()); err != nil || purged != 0 {
+		t.Fatalf("Expected no purge before the retention ends, got %d (%v)", purged, err)
+	}
+	retirement, err := st.MachineRetirement(ctx, "OLDBOX")
+	if err != nil || retirement == nil || retirement.Reason != "INC-42 laptop stolen" ||
+		retirement.RetiredBy != "root" {
+		t.Fatalf("Expected the retirement by root, got %+v (%v)", retirement, err)
+	}
+	if purged, err := st.PurgeRetiredMachines(ctx, retirement.PurgeAfter); err != nil || purged != 1 {
+		t.Fatalf("Expected one machine purged, got %d (%v)", purged, err)
+	}
+	if _, err := st.GetPassword(ctx, "OLDBOX", "root", "local"); err == nil {
+		t.Error("Expected the password to be gone after the purge")
+	}
+	if _, err := st.GetBDEKeys(ctx, "OLDBOX", "", "root", "local"); err == nil {
+		t.Error("Expected the BitLocker key to be gone after the purge")
+	}
+
+	entries, _, err := st.QueryAudit(ctx, store.AuditFilter{Host: "OLDBOX"})
+	if err != nil {
+		t.Fatalf("Failed to query audit: %v", err)
+	}
+	actions := map[string]bool{}
+	for _, entry := range entries {
+		actions[entry.Action] = true
+	}
+	for _, action := range []string{"decommission_machine", "access_denied", "purge_machine_secrets"} {
+		if !actions[action] {
+			t.Errorf("Expected an audited %s, got %v", action, actions)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+// tests/encryption_test.go
+package tests
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/jottavia/SHIPS2-Go/internal/store"
+)
+
+func TestPlaintextSecretsMigratedOnOpen(t *testing.T) {
+	dbPath := t.TempDir() + "/legacy_ships.db"
+
+	// Build a database the way releases before encryption at rest left it.
+	legacy, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open legacy database: %v", err)
+	}
+	_, err = legacy.Exec(`
+CREATE TABLE machines(id INTEGER PRIMARY KEY, hostname TEXT UNIQUE NOT NULL,
+    first_seen INTEGER NOT NULL DEFAULT (strftime('%s', 'now')));
+CREATE TABLE passwords(machine_id INTEGER NOT NULL UNIQUE, password TEXT NOT NULL,
+    updated_at INTEGER NOT NULL, actor TEXT NOT NULL);
+CREATE TABLE bitlocker_keys(machine_id INTEGER NOT NULL UNIQUE, key_text TEXT NOT NULL,
+    updated_at INTEGER NOT NULL, actor TEXT NOT NULL);
+INSERT INTO machines(id, hostname) VALUES (1, 'LEGACYHOST');
+INSERT INTO passwords VALUES (1, 'LegacyPassword1!', 1700000000, 'old-actor');
+INSERT INTO bitlocker_keys VALUES (1, '111111-222222-333333-444444-555555-666666-777777-888888',
+    1700000000, 'old-actor');`)
+	if err != nil {
+		t.Fatalf("Failed to create legacy schema: %v", err)
+	}
+	legacy.Close()
+
+	st, err := store.New(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open legacy store: %v", err)
+	}
+	defer st.Close()
+
+	raw, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	defer raw.Close()
+
+	var storedPassword, storedKey string
+	if err := raw.QueryRow(`SELECT password FROM passwords`).Scan(&storedPassword); err != nil {
+		t.Fatalf("Failed to read raw password: %v", err)
+	}
+	if err := raw.QueryRow(`SELECT key_text FROM bitlocker_keys`).Scan(&storedKey); err != nil {
+		t.Fatalf("Failed to read raw key: %v", err)
+	}
+	if strings.Contains(storedPassword, "LegacyPassword1!") || strings.Contains(storedKey, "111111") {
+		t.Error("Expected legacy secrets to be encrypted at rest")
+	}
+
+	ctx := context.Background()
+	pwInfo, err := st.GetPassword(ctx, "LEGACYHOST", "test", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("Failed to get migrated password: %v", err)
+	}
+	if pwInfo.Password != "LegacyPassword1!" {
+		t.Errorf("Expected migrated password to decrypt, got %q", pwInfo.Password)
+	}
+	keys, err := st.GetBDEKeys(ctx, "LEGACYHOST", "", "test", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("Failed to get migrated key: %v", err)
+	}
+	if len(keys) != 1 || !strings.HasPrefix(keys[0].Key, "111111-") {
+		t.Errorf("Expected migrated key to decrypt, got %+v", keys)
+	}
+}
+
+func TestSecretsUnreadableWithWrongMasterKey(t *testing.T) {
+	dbPath := t.TempDir() + "/ships.db"
+	st, err := store.New(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	ctx := context.Background()
+	if err := st.RotatePassword(ctx, "KEYHOST", "Secret123!", "test", "127.0.0.1"); err != nil {
+		t.Fatalf("Failed to rotate password: %v", err)
+	}
+	st.Close()
+
+	otherKey := make([]byte, store.MasterKeySize)
+	other, err := store.Open(dbPath, otherKey)
+	if err != nil {
+		t.Fatalf("Failed to reopen store: %v", err)
+	}
+	defer other.Close()
+
+	if _, err := other.GetPassword(ctx, "KEYHOST", "test", "127.0.0.1"); err == nil {
+		t.Error("Expected decryption to fail with a different master key")
+	}
+}
+
+func TestMasterKeyRequired(t *testing.T) {
+	dbDir, keyDir := t.TempDir(), t.TempDir()
+	dbPath := dbDir + "/ships.db"
+	encoded := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{4}, store.MasterKeySize))
+
+	t.Setenv("SHIPS_MASTER_KEY", "")
+	t.Setenv("SHIPS_MASTER_KEY_FILE", "")
+	if st, err := store.New(dbPath); err == nil {
+		st.Close()
+		t.Fatal("Expected a store without a configured master key to be refused")
+	}
+	if _, err := os.Stat(dbPath + ".key"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected no key file to be generated beside the database, got %v", err)
+	}
+
+	// A key file beside the database would be copied along with it.
+	for _, keyFile := range []string{dbDir + "/master.key", keyDir + "/master.key"} {
+		if err := os.WriteFile(keyFile, []byte(encoded+"\n"), 0o600); err != nil {
+			t.Fatalf("Failed to write key file: %v", err)
+		}
+	}
+	t.Setenv("SHIPS_MASTER_KEY_FILE", dbDir+"/master.key")
+	if st, err := store.New(dbPath); err == nil {
+		st.Close()
+		t.Error("Expected a key file in the database directory to be refused")
+	}
+	t.Setenv("SHIPS_MASTER_KEY_FILE", keyDir+"/master.key")
+	st, err := store.New(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open store with a key file: %v", err)
+	}
+	st.Close()
+}
+
+func TestRekeyRewrapsDataKeys(t *testing.T) {
+	dbPath := t.TempDir() + "/ships.db"
+	oldKey := bytes.Repeat([]byte{1}, store.MasterKeySize)
+	newKey := bytes.Repeat([]byte{2}, store.MasterKeySize)
+	ctx := context.Background()
+
+	st, err := store.Open(dbPath, oldKey)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	if err := st.RotatePassword(ctx, "REKEYHOST", "BeforeRekey1!", "test", "127.0.0.1"); err != nil {
+		t.Fatalf("Failed to rotate password: %v", err)
+	}
+	if err := st.UpdateBDEKey(ctx, "REKEYHOST", "C:", "", "111111-222222-333333-444444-555555-666666-111111-222222",
+		"test", "127.0.0.1"); err != nil {
+		t.Fatalf("Failed to update key: %v", err)
+	}
+
+	count, err := st.Rekey(ctx, newKey, "test-admin")
+	if err != nil {
+		t.Fatalf("Rekey failed: %v", err)
+	}
+	if count != 3 {
+		t.Errorf("Expected 3 data keys re-wrapped, got %d", count)
+	}
+	st.Close()
+
+	withOld, err := store.Open(dbPath, oldKey)
+	if err != nil {
+		t.Fatalf("Failed to reopen store with old key: %v", err)
+	}
+	if _, err := withOld.GetPassword(ctx, "REKEYHOST", "test", "127.0.0.1"); err == nil {
+		t.Error("Expected old master key to be useless after rekey")
+	}
+	withOld.Close()
+
+	withNew, err := store.Open(dbPath, newKey)
+	if err != nil {
+		t.Fatalf("Failed to reopen store with new key: %v", err)
+	}
+	defer withNew.Close()
+	pwInfo, err := withNew.GetPassword(ctx, "REKEYHOST", "test", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("Failed to get password after rekey: %v", err)
+	}
+	if pwInfo.Password != "BeforeRekey1!" {
+		t.Errorf("Expected password to survive rekey, got %q", pwInfo.Password)
+	}
+
+	raw, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer raw.Close()
+	var rekeyEntries int
+	if err := raw.QueryRow(`SELECT COUNT(*) FROM audit_logs WHERE action = 'rekey' AND actor = 'test-admin'`).
+		Scan(&rekeyEntries); err != nil {
+		t.Fatalf("Failed to query audit log: %v", err)
+	}
+	if rekeyEntries != 1 {
+		t.Errorf("Expected one rekey audit entry, got %d", rekeyEntries)
+	}
+}
+
+func TestRekeyWhileServerRuns(t *testing.T) {
+	dbPath := t.TempDir() + "/ships.db"
+	keyFile := t.TempDir() + "/master.key"
+	oldKey := bytes.Repeat([]byte{1}, store.MasterKeySize)
+	newKey := bytes.Repeat([]byte{2}, store.MasterKeySize)
+	ctx := context.Background()
+	writeKey := func(masterKey []byte) {
+		encoded := base64.StdEncoding.EncodeToString(masterKey) + "\n"
+		if err := os.WriteFile(keyFile, []byte(encoded), 0o600); err != nil {
+			t.Fatalf("Failed to write key file: %v", err)
+		}
+	}
+
+	writeKey(oldKey)
+	t.Setenv("SHIPS_MASTER_KEY", "")
+	t.Setenv("SHIPS_MASTER_KEY_FILE", keyFile)
+	server, err := store.New(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open server store: %v", err)
+	}
+	defer server.Close()
+	if err := server.RotatePassword(ctx, "EARLYHOST", "Early123!", "test", "local"); err != nil {
+		t.Fatalf("Failed to rotate: %v", err)
+	}
+
+	// The operator installs the new key and rekeys with the server up.
+	writeKey(newKey)
+	admin, err := store.Open(dbPath, oldKey)
+	if err != nil {
+		t.Fatalf("Failed to open admin store: %v", err)
+	}
+	if _, err := admin.Rekey(ctx, newKey, "test-admin"); err != nil {
+		t.Fatalf("Rekey failed: %v", err)
+	}
+	admin.Close()
+
+	// Until it reloads, the server still seals with the old key, and it
+	// picks up the new key on the first row wrapped with it.
+	if err := server.RotatePassword(ctx, "LATEHOST", "Late123!", "test", "local"); err != nil {
+		t.Fatalf("Failed to rotate during the rekey: %v", err)
+	}
+	if info, err := server.GetPassword(ctx, "EARLYHOST", "test", "local"); err != nil ||
+		info.Password != "Early123!" {
+		t.Fatalf("Expected the server to read rekeyed rows, got %+v (%v)", info, err)
+	}
+	if err := server.ReloadMasterKey(); err != nil {
+		t.Fatalf("Failed to reload master key: %v", err)
+	}
+	if rewrapped, err := server.RewrapDataKeys(ctx, "ships-server"); err != nil || rewrapped == 0 {
+		t.Fatalf("Expected the late rotation to be re-wrapped, got %d (%v)", rewrapped, err)
+	}
+	if rewrapped, err := server.RewrapDataKeys(ctx, "ships-server"); err != nil || rewrapped != 0 {
+		t.Errorf("Expected nothing left to re-wrap, got %d (%v)", rewrapped, err)
+	}
+
+	withNew, err := store.Open(dbPath, newKey)
+	if err != nil {
+		t.Fatalf("Failed to reopen store with new key: %v", err)
+	}
+	defer withNew.Close()
+	for host, password := range map[string]string{"EARLYHOST": "Early123!", "LATEHOST": "Late123!"} {
+		if info, err := withNew.GetPassword(ctx, host, "test", "local"); err != nil ||
+			info.Password != password {
+			t.Errorf("Expected %s readable with the new key alone, got %+v (%v)", host, info, err)
+		}
+	}
+}
+
+func TestPIDFile(t *testing.T) {
+	dbPath := t.TempDir() + "/ships.db"
+	remove, err := store.WritePIDFile(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to write PID file: %v", err)
+	}
+	if pid, err := store.ServerPID(dbPath); err != nil || pid != os.Getpid() {
+		t.Errorf("Expected the PID file to name this process, got %d (%v)", pid, err)
+	}
+	if _, err := store.WritePIDFile(dbPath); !errors.Is(err, store.ErrDatabaseInUse) {
+		t.Errorf("Expected a second server to be refused, got %v", err)
+	}
+	remove()
+
+	// A file left behind by a crashed server is taken over.
+	exited := exec.Command("true")
+	if err := exited.Run(); err != nil {
+		t.Skipf("Cannot run a short-lived process: %v", err)
+	}
+	stale := []byte(strconv.Itoa(exited.Process.Pid) + "\n")
+	if err := os.WriteFile(store.PIDFile(dbPath), stale, 0o644); err != nil {
+		t.Fatalf("Failed to write stale PID file: %v", err)
+	}
+	remove, err = store.WritePIDFile(dbPath)
+	if err != nil {
+		t.Fatalf("Expected a stale PID file to be replaced, got %v", err)
+	}
+	remove()
+}
@@ -0,0 +1,150 @@
+// tests/enroll_test.go
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jottavia/SHIPS2-Go/internal/api"
+	"github.com/jottavia/SHIPS2-Go/internal/store"
+)
+
+// enroll redeems an enrollment token over the API and returns the status
+// and credential.
+func enroll(t *testing.T, serverURL, host, enrollmentToken string) (int, string) {
+	body, err := json.Marshal(map[string]string{"host": host, "enrollment_token": enrollmentToken})
+	if err != nil {
+		t.Fatalf("Failed to marshal enrollment: %v", err)
+	}
+	resp, err := http.Post(serverURL+"/api/v1/enroll", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to enroll: %v", err)
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Token string `json:"token"`
+	}
+	json.NewDecoder(resp.Body).Decode(&result) // nolint:errcheck // error bodies have no token
+	return resp.StatusCode, result.Token
+}
+
+func TestMachineEnrollment(t *testing.T) {
+	server, st := setupTokenServer(t)
+	defer server.Close()
+	defer st.Close()
+	ctx := context.Background()
+
+	enrollmentToken, _, err := st.CreateEnrollmentToken(ctx, "", 0, "test-admin")
+	if err != nil {
+		t.Fatalf("Failed to create enrollment token: %v", err)
+	}
+	status, credential := enroll(t, server.URL, "ENROLLHOST", enrollmentToken)
+	if status != http.StatusOK || credential == "" {
+		t.Fatalf("Expected enrollment to succeed, got %d", status)
+	}
+
+	rotateOwn := []byte(`{"host":"ENROLLHOST","password":"Secret123!"}`)
+	rotateOther := []byte(`{"host":"OTHERHOST","password":"Secret123!"}`)
+	if resp := doWithToken(t, http.MethodPost, server.URL+"/api/v1/rotate", credential, rotateOwn); resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected own-host rotation to succeed, got %d", resp.StatusCode)
+	}
+	if resp := doWithToken(t, http.MethodPost, server.URL+"/api/v1/rotate", credential, rotateOther); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected cross-host rotation to be refused, got %d", resp.StatusCode)
+	}
+	if resp := doWithToken(t, http.MethodGet, server.URL+"/api/v1/password/ENROLLHOST", credential, nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected machine credential to be unable to read passwords, got %d", resp.StatusCode)
+	}
+
+	// The token is single-use, and an unpinned token cannot take over an
+	// enrolled machine.
+	if status, _ := enroll(t, server.URL, "SECONDHOST", enrollmentToken); status != http.StatusForbidden {
+		t.Errorf("Expected reused enrollment token to be refused, got %d", status)
+	}
+	anyHost, _, err := st.CreateEnrollmentToken(ctx, "", 0, "test-admin")
+	if err != nil {
+		t.Fatalf("Failed to create enrollment token: %v", err)
+	}
+	if status, _ := enroll(t, server.URL, "ENROLLHOST", anyHost); status != http.StatusForbidden {
+		t.Errorf("Expected re-enrollment with an unpinned token to be refused, got %d", status)
+	}
+
+	pinned, _, err := st.CreateEnrollmentToken(ctx, "ENROLLHOST", 0, "test-admin")
+	if err != nil {
+		t.Fatalf("Failed to create enrollment token: %v", err)
+	}
+	status, renewed := enroll(t, server.URL, "ENROLLHOST", pinned)
+	if status != http.StatusOK {
+		t.Fatalf("Expected re-enrollment with a pinned token to succeed, got %d", status)
+	}
+	if resp := doWithToken(t, http.MethodPost, server.URL+"/api/v1/rotate", credential, rotateOwn); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected the previous credential to be revoked, got %d", resp.StatusCode)
+	}
+	if resp := doWithToken(t, http.MethodPost, server.URL+"/api/v1/rotate", renewed, rotateOwn); resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected the new credential to work, got %d", resp.StatusCode)
+	}
+
+	for action, want := range map[string]int{
+		"enroll_machine":   1,
+		"reenroll_machine": 1,
+		"enroll_denied":    2,
+	} {
+		entries, _, err := st.QueryAudit(ctx, store.AuditFilter{Action: action})
+		if err != nil {
+			t.Fatalf("Failed to query audit log: %v", err)
+		}
+		if len(entries) != want {
+			t.Errorf("Expected %d %s entries, got %d", want, action, len(entries))
+		}
+	}
+	denials, _, err := st.QueryAudit(ctx, store.AuditFilter{Action: "access_denied", Actor: "ENROLLHOST"})
+	if err != nil {
+		t.Fatalf("Failed to query audit log: %v", err)
+	}
+	crossHost := 0
+	for _, denial := range denials {
+		if strings.Contains(denial.Detail, "cross-host") {
+			crossHost++
+		}
+	}
+	if crossHost != 1 {
+		t.Errorf("Expected one audited cross-host write, got %+v", denials)
+	}
+}
+
+func TestEnrollmentBypassesMiddleware(t *testing.T) {
+	st, err := store.New(t.TempDir() + "/test_ships.db")
+	if err != nil {
+		t.Fatalf("Failed to create test store: %v", err)
+	}
+	defer st.Close()
+	router := gin.New()
+	// Stands in for basic auth, which machines cannot pass before enrolling.
+	locked := func(ctx *gin.Context) { ctx.AbortWithStatus(http.StatusUnauthorized) }
+	api.New(st).Register(router, locked)
+	server := httptest.NewServer(router)
+	defer server.Close()
+	ctx := context.Background()
+
+	enrollmentToken, _, err := st.CreateEnrollmentToken(ctx, "", 0, "test-admin")
+	if err != nil {
+		t.Fatalf("Failed to create enrollment token: %v", err)
+	}
+	if status, _ := enroll(t, server.URL, "lowerhost", enrollmentToken); status != http.StatusOK {
+		t.Fatalf("Expected enrollment to bypass the middleware, got %d", status)
+	}
+	resp := doWithToken(t, http.MethodGet, server.URL+"/api/v1/machines", "", nil)
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected the middleware to guard other routes, got %d", resp.StatusCode)
+	}
+	tokens, err := st.ListAPITokens(ctx)
+	if err != nil || len(tokens) != 1 || tokens[0].Name != "LOWERHOST" {
+		t.Errorf("Expected the credential to carry the canonical hostname, got %+v (%v)",
+			tokens, err)
+	}
+}
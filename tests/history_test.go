@@ -0,0 +1,110 @@
+// tests/history_test.go
+package tests
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jottavia/SHIPS2-Go/internal/store"
+)
+
+func TestPasswordHistory(t *testing.T) {
+	server, st := setupTestServer(t)
+	defer server.Close()
+	defer st.Close()
+
+	for _, password := range []string{"FirstPassword1!", "SecondPassword2!"} {
+		body, err := json.Marshal(map[string]string{
+			"host":     "HISTHOST",
+			"password": password,
+			"actor":    "history-user",
+		})
+		if err != nil {
+			t.Fatalf("Failed to marshal rotation payload: %v", err)
+		}
+		resp, err := http.Post(server.URL+"/api/v1/rotate", "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("Failed to post rotation: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/api/v1/password/HISTHOST/history", nil)
+	if err != nil {
+		t.Fatalf("Failed to build request: %v", err)
+	}
+	req.Header.Set("X-Actor", "history-auditor")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to fetch history: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+
+	var historyResp struct {
+		Hostname string `json:"hostname"`
+		History  []struct {
+			ID        int64     `json:"id"`
+			Password  string    `json:"password"`
+			RotatedAt time.Time `json:"rotated_at"`
+			Actor     string    `json:"actor"`
+		} `json:"history"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&historyResp); err != nil {
+		t.Fatalf("Failed to decode history response: %v", err)
+	}
+
+	if len(historyResp.History) != 2 {
+		t.Fatalf("Expected 2 history entries, got %d", len(historyResp.History))
+	}
+	if historyResp.History[0].Password != "SecondPassword2!" {
+		t.Errorf("Expected newest password first, got %v", historyResp.History[0].Password)
+	}
+	if historyResp.History[1].Password != "FirstPassword1!" {
+		t.Errorf("Expected previous password to be kept, got %v", historyResp.History[1].Password)
+	}
+}
+
+func TestPasswordHistoryBackfilledFromLegacyRow(t *testing.T) {
+	dbPath := t.TempDir() + "/legacy_ships.db"
+	legacy, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open legacy database: %v", err)
+	}
+	_, err = legacy.Exec(`
+CREATE TABLE machines(id INTEGER PRIMARY KEY, hostname TEXT UNIQUE NOT NULL,
+    first_seen INTEGER NOT NULL DEFAULT (strftime('%s', 'now')));
+CREATE TABLE passwords(machine_id INTEGER NOT NULL UNIQUE, password TEXT NOT NULL,
+    updated_at INTEGER NOT NULL, actor TEXT NOT NULL);
+INSERT INTO machines(id, hostname) VALUES (1, 'OLDHOST');
+INSERT INTO passwords VALUES (1, 'OldPassword1!', 1700000000, 'old-actor');`)
+	if err != nil {
+		t.Fatalf("Failed to create legacy schema: %v", err)
+	}
+	legacy.Close()
+
+	st, err := store.New(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open legacy store: %v", err)
+	}
+	defer st.Close()
+
+	history, err := st.ListPasswordHistory(context.Background(), "OLDHOST", "test", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("Failed to list history: %v", err)
+	}
+	if len(history) != 1 || history[0].Password != "OldPassword1!" {
+		t.Errorf("Expected legacy password in history, got %+v", history)
+	}
+}
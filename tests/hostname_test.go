@@ -0,0 +1,282 @@
+// tests/hostname_test.go
+package tests
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/jottavia/SHIPS2-Go/internal/hostname"
+	"github.com/jottavia/SHIPS2-Go/internal/store"
+)
+
+func TestHostnameCanonical(t *testing.T) {
+	strip := hostname.Policy{Case: hostname.CaseLower, StripDomain: true, IDNA: true}
+	for _, tc := range []struct {
+		policy hostname.Policy
+		name   string
+		want   string // empty when name must be rejected
+	}{
+		{hostname.Default(), "winbox01", "WINBOX01"},
+		{hostname.Default(), "winbox01.corp.local.", "WINBOX01.CORP.LOCAL"},
+		{hostname.Default(), "bücher", "XN--BCHER-KVA"},
+		{strip, "WinBox01.Corp.Local", "winbox01"},
+		{hostname.Policy{Case: hostname.CasePreserve}, "WinBox01", "WinBox01"},
+		{hostname.Policy{Case: hostname.CasePreserve}, "bücher-01", ""},
+		{hostname.Default(), "", ""},
+		{hostname.Default(), "bad host", ""},
+		// Underscores are a NetBIOS allowance a policy has to turn on.
+		{hostname.Default(), "lab_1", ""},
+		{hostname.Policy{Case: hostname.CaseUpper, AllowUnderscore: true}, "lab_1", "LAB_1"},
+		// Retired generations keep their suffix.
+		{hostname.Default(), "pc01~2", "PC01~2"},
+		{strip, "PC01.corp.local~12", "pc01~12"},
+		{hostname.Default(), "pc01~0", ""},
+		{hostname.Default(), "pc01~02", ""},
+		{hostname.Default(), "~2", ""},
+		{hostname.Default(), "-edge", ""},
+		{hostname.Default(), "edge-", ""},
+		{hostname.Default(), "a..b", ""},
+		{hostname.Default(), strings.Repeat("a", 64), ""},
+		{hostname.Default(), strings.Repeat("a.", 127) + "a", ""},
+	} {
+		got, err := tc.policy.Canonical(tc.name)
+		var hostnameError *hostname.Error
+		switch {
+		case tc.want == "" && !errors.As(err, &hostnameError):
+			t.Errorf("Canonical(%q) under %s: expected a hostname error, got %q (%v)",
+				tc.name, tc.policy, got, err)
+		case tc.want != "" && (err != nil || got != tc.want):
+			t.Errorf("Canonical(%q) under %s: expected %q, got %q (%v)",
+				tc.name, tc.policy, tc.want, got, err)
+		}
+	}
+	if err := hostname.Validate("LAB_1"); err == nil {
+		t.Error("Expected RFC 1123 validation to reject underscores")
+	}
+	if err := (hostname.Policy{Case: "title"}).Validate(); err == nil {
+		t.Error("Expected an unknown case folding to be rejected")
+	}
+}
+
+func TestRenameMachine(t *testing.T) {
+	server, st := setupTestServer(t)
+	defer server.Close()
+	defer st.Close()
+	ctx := context.Background()
+
+	// Lookups are case-insensitive under the default policy.
+	if err := st.RotatePassword(ctx, "winbox01", "Initial123!", "test", "local"); err != nil {
+		t.Fatalf("Failed to rotate: %v", err)
+	}
+	if err := st.UpdateBDEKey(ctx, "WinBox01", "C:", "", osVolumeKey, "test", "local"); err != nil {
+		t.Fatalf("Failed to update key: %v", err)
+	}
+	if err := st.RotatePassword(ctx, "OTHERBOX", "Other123!", "test", "local"); err != nil {
+		t.Fatalf("Failed to rotate: %v", err)
+	}
+	if info, err := st.GetPassword(ctx, "WINBOX01", "test", "local"); err != nil ||
+		info.Password != "Initial123!" {
+		t.Fatalf("Expected winbox01 to be stored as WINBOX01, got %+v (%v)", info, err)
+	}
+	if err := st.RotatePassword(ctx, "bad host", "Initial123!", "test", "local"); err == nil {
+		t.Error("Expected a hostname with a space to be rejected")
+	}
+
+	rename := server.URL + "/api/v1/machines/winbox01/rename"
+	for _, tc := range []struct {
+		url, body string
+		want      int
+	}{
+		{rename, `{}`, http.StatusBadRequest},
+		{rename, `{"new_host":"new box"}`, http.StatusBadRequest},
+		{rename, `{"new_host":"otherbox"}`, http.StatusConflict},
+		{server.URL + "/api/v1/machines/NOBOX/rename", `{"new_host":"NEWBOX"}`,
+			http.StatusNotFound},
+		{rename, `{"new_host":"newbox"}`, http.StatusOK},
+	} {
+		resp := doWithToken(t, http.MethodPost, tc.url, "", []byte(tc.body))
+		if resp.StatusCode != tc.want {
+			t.Errorf("Rename %s to %s: expected %d, got %d", tc.url, tc.body, tc.want,
+				resp.StatusCode)
+		}
+	}
+
+	if info, err := st.GetPassword(ctx, "NEWBOX", "test", "local"); err != nil ||
+		info.Password != "Initial123!" {
+		t.Errorf("Expected the password to follow the rename, got %+v (%v)", info, err)
+	}
+	if keys, err := st.GetBDEKeys(ctx, "NEWBOX", "", "test", "local"); err != nil || len(keys) != 1 {
+		t.Errorf("Expected the BitLocker key to follow the rename, got %+v (%v)", keys, err)
+	}
+	machines, _, err := st.ListMachines(ctx, store.MachineFilter{})
+	if err != nil || len(machines) != 2 || machines[0].Hostname != "NEWBOX" {
+		t.Errorf("Expected the old name to be gone, got %+v (%v)", machines, err)
+	}
+	entries, _, err := st.QueryAudit(ctx, store.AuditFilter{Host: "newbox"})
+	if err != nil {
+		t.Fatalf("Failed to query audit: %v", err)
+	}
+	var renamed bool
+	for _, entry := range entries {
+		renamed = renamed || entry.Action == "rename_machine" && entry.Detail == "WINBOX01 -> NEWBOX"
+	}
+	if !renamed || len(entries) < 3 {
+		t.Errorf("Expected the history to include the rename, got %+v", entries)
+	}
+
+	// Machines stored before a policy change are found as stored and can be
+	// renamed to their canonical form.
+	if err := st.SetHostnamePolicy(hostname.Policy{Case: hostname.CaseLower}); err != nil {
+		t.Fatalf("Failed to set policy: %v", err)
+	}
+	legacy, err := st.NonCanonicalHostnames(ctx)
+	if err != nil || strings.Join(legacy, ",") != "NEWBOX,OTHERBOX" {
+		t.Fatalf("Expected both machines to be non-canonical, got %v (%v)", legacy, err)
+	}
+	if err := st.RenameMachine(ctx, "OTHERBOX", "OTHERBOX", "test", "local"); err != nil {
+		t.Fatalf("Failed to rename to the canonical form: %v", err)
+	}
+	if info, err := st.GetPassword(ctx, "OtherBox", "test", "local"); err != nil ||
+		info.Password != "Other123!" {
+		t.Errorf("Expected otherbox to be found, got %+v (%v)", info, err)
+	}
+	err = st.RenameMachine(ctx, "otherbox", "OTHERBOX", "test", "local")
+	if !errors.Is(err, store.ErrHostnameTaken) {
+		t.Errorf("Expected renaming to the same name to fail, got %v", err)
+	}
+}
+
+func TestHostnameMigration(t *testing.T) {
+	dbPath := t.TempDir() + "/ships.db"
+	masterKey := bytes.Repeat([]byte{3}, store.MasterKeySize)
+	ctx := context.Background()
+	t.Setenv("SHIPS_HOSTNAME_UNDERSCORE", "true")
+
+	// Machines recorded while hostnames kept their case, including one
+	// that was escrowed under two spellings.
+	t.Setenv("SHIPS_HOSTNAME_CASE", hostname.CasePreserve)
+	legacy, err := store.Open(dbPath, masterKey)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	for _, escrow := range []struct{ host, password string }{
+		{"winbox01", "Legacy123!"},
+		{"lab_1", "Legacy123!"},
+		{"dupbox", "Older123!"},
+		{"DupBox", "Newer123!"},
+	} {
+		if err := legacy.RotatePassword(ctx, escrow.host, escrow.password, "test", "local"); err != nil {
+			t.Fatalf("Failed to rotate %s: %v", escrow.host, err)
+		}
+	}
+	if err := legacy.UpdateBDEKey(ctx, "dupbox", "C:", "", osVolumeKey, "test", "local"); err != nil {
+		t.Fatalf("Failed to update key: %v", err)
+	}
+	if _, err := legacy.GetPassword(ctx, "dupbox", "alice", "local"); err != nil {
+		t.Fatalf("Failed to fetch password: %v", err)
+	}
+	enrollmentToken, _, err := legacy.CreateEnrollmentToken(ctx, "newbox", 0, "test-admin")
+	if err != nil {
+		t.Fatalf("Failed to create enrollment token: %v", err)
+	}
+	legacy.Close()
+
+	// Other settings neither rename anything nor take effect on their own.
+	t.Setenv("SHIPS_HOSTNAME_CASE", hostname.CaseUpper)
+	st, err := store.Open(dbPath, masterKey)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	defer func() { st.Close() }()
+	if policy := st.HostnamePolicy(); policy.Case != hostname.CasePreserve {
+		t.Errorf("Expected the recorded policy to stay in effect, got %s", policy)
+	}
+	if info, err := st.GetPassword(ctx, "winbox01", "test", "local"); err != nil ||
+		info.Password != "Legacy123!" {
+		t.Errorf("Expected winbox01 to be reachable as stored, got %+v (%v)", info, err)
+	}
+	status, err := st.HostnameStatus(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get hostname status: %v", err)
+	}
+	want := []store.HostnameChange{
+		{From: "DupBox", To: "DUPBOX", Merge: true},
+		{From: "dupbox", To: "DUPBOX"},
+		{From: "lab_1", To: "LAB_1"},
+		{From: "winbox01", To: "WINBOX01"},
+	}
+	if !status.Recorded || status.Configured.Case != hostname.CaseUpper ||
+		fmt.Sprint(status.Pending) != fmt.Sprint(want) {
+		t.Errorf("Expected %v pending, got %+v", want, status)
+	}
+
+	changes, err := st.MigrateHostnames(ctx, status.Configured, "admin")
+	if err != nil {
+		t.Fatalf("Failed to migrate hostnames: %v", err)
+	}
+	if fmt.Sprint(changes) != fmt.Sprint(want) {
+		t.Errorf("Expected %v, got %v", want, changes)
+	}
+	machines, _, err := st.ListMachines(ctx, store.MachineFilter{})
+	if err != nil || len(machines) != 3 || machines[0].Hostname != "DUPBOX" ||
+		machines[1].Hostname != "LAB_1" || machines[2].Hostname != "WINBOX01" {
+		t.Fatalf("Expected three machines in canonical form, got %+v (%v)", machines, err)
+	}
+	if machines[0].LastFetch.IsZero() || machines[0].LastKeyUpdate.IsZero() {
+		t.Errorf("Expected the merged machine's activity to carry over, got %+v", machines[0])
+	}
+
+	// The merged machine's secrets and history moved, the password escrowed
+	// last is current and its audit trail shows under the surviving name.
+	info, err := st.GetPassword(ctx, "dupbox", "test", "local")
+	if err != nil || info.Password != "Newer123!" {
+		t.Errorf("Expected the newer password to be current, got %+v (%v)", info, err)
+	}
+	history, err := st.ListPasswordHistory(ctx, "DUPBOX", "test", "local")
+	if err != nil || len(history) != 2 {
+		t.Errorf("Expected both histories to be merged, got %+v (%v)", history, err)
+	}
+	if keys, err := st.GetBDEKeys(ctx, "DUPBOX", "", "test", "local"); err != nil || len(keys) != 1 {
+		t.Errorf("Expected the BitLocker key to follow, got %+v (%v)", keys, err)
+	}
+	counts := map[string]int{}
+	entries, _, err := st.QueryAudit(ctx, store.AuditFilter{Host: "DUPBOX", Limit: 100})
+	if err != nil {
+		t.Fatalf("Failed to query audit log: %v", err)
+	}
+	for _, entry := range entries {
+		counts[entry.Action]++
+	}
+	if counts["rotate_password"] != 2 || counts["merge_machine"] != 1 ||
+		counts["rename_machine"] != 1 {
+		t.Errorf("Expected both machines' audit trails under DUPBOX, got %v", counts)
+	}
+	if result, err := st.VerifyAuditChain(ctx); err != nil || result.BrokenAtID != 0 {
+		t.Errorf("Expected the audit chain to survive the merge, got %+v (%v)", result, err)
+	}
+	if _, err := st.EnrollMachine(ctx, enrollmentToken, "NEWBOX", "local"); err != nil {
+		t.Errorf("Expected the pinned enrollment token to follow, got %v", err)
+	}
+	if status, err = st.HostnameStatus(ctx); err != nil || len(status.Pending) != 0 {
+		t.Errorf("Expected nothing left to migrate, got %+v (%v)", status, err)
+	}
+
+	// Once recorded, the policy holds for processes with other settings.
+	st.Close()
+	t.Setenv("SHIPS_HOSTNAME_CASE", hostname.CaseLower)
+	if st, err = store.Open(dbPath, masterKey); err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	if policy := st.HostnamePolicy(); policy.Case != hostname.CaseUpper {
+		t.Errorf("Expected the migrated policy to stay in effect, got %s", policy)
+	}
+	if info, err := st.GetPassword(ctx, "winbox01", "test", "local"); err != nil ||
+		info.Password != "Legacy123!" {
+		t.Errorf("Expected WINBOX01 to be reachable, got %+v (%v)", info, err)
+	}
+}
@@ -0,0 +1,218 @@
+// tests/identity_test.go
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jottavia/SHIPS2-Go/internal/api"
+	"github.com/jottavia/SHIPS2-Go/internal/store"
+)
+
+const (
+	firstIdentity  = "smbios:4c4c4544-0042-3510-8052-b4c04f4e4e31"
+	secondIdentity = "smbios:9a3f2c1e-5b7d-4e8a-9c6f-0d1e2f3a4b5c"
+)
+
+// auditActions returns which actions were audited for host.
+func auditActions(t *testing.T, st *store.Store, host string) map[string]bool {
+	entries, _, err := st.QueryAudit(context.Background(), store.AuditFilter{Host: host})
+	if err != nil {
+		t.Fatalf("Failed to query audit: %v", err)
+	}
+	actions := map[string]bool{}
+	for _, entry := range entries {
+		actions[entry.Action] = true
+	}
+	return actions
+}
+
+func TestMachineIdentityReject(t *testing.T) {
+	server, st := setupTestServer(t)
+	defer server.Close()
+	defer st.Close()
+
+	for _, tc := range []struct {
+		path, body string
+		want       int
+	}{
+		{"/api/v1/rotate", `{"host":"PC01","password":"First123!",` +
+			`"machine_identity":"` + firstIdentity + `"}`, http.StatusOK},
+		// Identities are compared ignoring case.
+		{"/api/v1/agent/checkin", `{"host":"PC01","machine_identity":"SMBIOS:4C4C4544-0042-` +
+			`3510-8052-B4C04F4E4E31"}`, http.StatusOK},
+		{"/api/v1/rotate", `{"host":"PC01","password":"Second123!",` +
+			`"machine_identity":"` + secondIdentity + `"}`, http.StatusConflict},
+		{"/api/v1/update_key", `{"host":"PC01","key":"` + osVolumeKey + `",` +
+			`"machine_identity":"` + secondIdentity + `"}`, http.StatusConflict},
+		{"/api/v1/agent/checkin", `{"host":"PC01","machine_identity":"bad identity"}`,
+			http.StatusBadRequest},
+		// A machine with an identity on record must keep reporting it.
+		{"/api/v1/rotate", `{"host":"PC01","password":"Third123!"}`, http.StatusConflict},
+	} {
+		resp := doWithToken(t, http.MethodPost, server.URL+tc.path, "", []byte(tc.body))
+		if resp.StatusCode != tc.want {
+			t.Errorf("POST %s %s: expected %d, got %d", tc.path, tc.body, tc.want,
+				resp.StatusCode)
+		}
+	}
+
+	info, err := st.GetPassword(context.Background(), "PC01", "test", "local")
+	if err != nil || info.Password != "First123!" {
+		t.Errorf("Expected the rejected rotations to change nothing, got %+v (%v)", info, err)
+	}
+
+	// Operators write for other machines and report no identity.
+	token := operatorToken(t, st, "alice")
+	resp := doWithToken(t, http.MethodPost, server.URL+"/api/v1/rotate", token,
+		[]byte(`{"host":"PC01","password":"Fourth123!"}`))
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Operator rotate without identity: expected 200, got %d", resp.StatusCode)
+	}
+	actions := auditActions(t, st, "PC01")
+	if !actions["record_machine_identity"] || !actions["identity_mismatch"] {
+		t.Errorf("Expected the identity and the mismatch to be audited, got %v", actions)
+	}
+}
+
+func TestMachineIdentityNewGeneration(t *testing.T) {
+	st, err := store.New(t.TempDir() + "/test_ships.db")
+	if err != nil {
+		t.Fatalf("Failed to create test store: %v", err)
+	}
+	defer st.Close()
+	router := gin.New()
+	api.New(st).AllowAnonymous().WithIdentityMismatch(store.IdentityNewGeneration).
+		Register(router)
+	server := httptest.NewServer(router)
+	defer server.Close()
+	ctx := context.Background()
+
+	if err := st.UpdateBDEKey(ctx, "PC01", "C:", "{AAAA1111-0000-1111-2222-333344445555}",
+		osVolumeKey, "test", "local"); err != nil {
+		t.Fatalf("Failed to update key: %v", err)
+	}
+	for _, tc := range []struct{ password, identity string }{
+		{"First123!", firstIdentity},
+		{"Second123!", secondIdentity},
+	} {
+		body := `{"host":"PC01","password":"` + tc.password + `","machine_identity":"` +
+			tc.identity + `"}`
+		resp := doWithToken(t, http.MethodPost, server.URL+"/api/v1/rotate", "", []byte(body))
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Rotate as %s: expected 200, got %d", tc.identity, resp.StatusCode)
+		}
+	}
+
+	history, err := st.ListPasswordHistory(ctx, "PC01", "test", "local")
+	if err != nil || len(history) != 1 || history[0].Password != "Second123!" {
+		t.Errorf("Expected the new machine not to inherit the old password, got %+v (%v)",
+			history, err)
+	}
+	machines, _, err := st.ListMachines(ctx, store.MachineFilter{})
+	if err != nil || len(machines) != 2 {
+		t.Fatalf("Expected two generations, got %+v (%v)", machines, err)
+	}
+	current, retired := machines[0], machines[1]
+	if current.Hostname != "PC01" || current.Generation != 2 ||
+		current.Identity != secondIdentity || !current.RetiredAt.IsZero() {
+		t.Errorf("Expected PC01 to be generation 2, got %+v", current)
+	}
+	if retired.Hostname != "PC01~1" || retired.Generation != 1 || retired.RetiredAt.IsZero() {
+		t.Errorf("Expected generation 1 to be retired as PC01~1, got %+v", retired)
+	}
+	if actions := auditActions(t, st, "PC01"); !actions["new_machine_generation"] {
+		t.Errorf("Expected the new generation to be audited, got %v", actions)
+	}
+
+	// Without an identity no generation can be started; the write is let
+	// through and flagged.
+	resp := doWithToken(t, http.MethodPost, server.URL+"/api/v1/rotate", "",
+		[]byte(`{"host":"PC01","password":"Third123!"}`))
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Rotate without identity: expected 200, got %d", resp.StatusCode)
+	}
+	if actions := auditActions(t, st, "PC01"); !actions["identity_missing"] {
+		t.Errorf("Expected the missing identity to be audited, got %v", actions)
+	}
+	if verification, err := st.VerifyAuditChain(ctx); err != nil || verification.BrokenAtID != 0 {
+		t.Errorf("Expected an intact audit chain, got %+v (%v)", verification, err)
+	}
+
+	// Secrets of the retired generation can still be looked up by Key ID,
+	// by admins only since the generation is retired.
+	admin, _, err := st.IssueAPIToken(ctx, "admin1", 0, "test-admin")
+	if err != nil {
+		t.Fatalf("Failed to issue token: %v", err)
+	}
+	if err := st.AssignRole(ctx, "admin1", store.RoleAdmin, "test-admin"); err != nil {
+		t.Fatalf("Failed to assign role: %v", err)
+	}
+	lookupURL := server.URL + "/api/v1/bde/by-key-id/aaaa1111"
+	status, body := getAs(t, lookupURL, admin)
+	matches, _ := body["matches"].([]any)
+	if status != http.StatusOK || len(matches) != 1 ||
+		matches[0].(map[string]any)["hostname"] != "PC01~1" {
+		t.Errorf("Expected the key ID to resolve to PC01~1, got %d %v", status, body)
+	}
+	if status, body := getAs(t, lookupURL, operatorToken(t, st, "alice")); status != http.StatusForbidden {
+		t.Errorf("Expected operators to be refused the retired generation, got %d %v", status, body)
+	}
+	if retirement, err := st.MachineRetirement(ctx, "pc01~1"); err != nil || retirement == nil {
+		t.Errorf("Expected pc01~1 to name the retired generation, got %+v (%v)", retirement, err)
+	}
+	if err := st.RotatePassword(ctx, "PC01~7", "Ghost123!", "test", "local"); err == nil {
+		t.Error("Expected a generation that was never retired not to be created")
+	}
+
+	// The retired generation can be renamed to read its secrets and is
+	// purged after the retention like any decommissioned machine.
+	if err := st.RenameMachine(ctx, "PC01~1", "PC01-OLD", "test", "local"); err != nil {
+		t.Fatalf("Failed to rename the retired generation: %v", err)
+	}
+	if info, err := st.GetPassword(ctx, "PC01-OLD", "test", "local"); err != nil ||
+		info.Password != "First123!" {
+		t.Errorf("Expected the old password to be kept, got %+v (%v)", info, err)
+	}
+	purged, err := st.PurgeRetiredMachines(ctx, time.Now().Add(api.DefaultRetention))
+	if err != nil || purged != 1 {
+		t.Errorf("Expected the retired generation to be purged, got %d (%v)", purged, err)
+	}
+}
+
+func TestMachineIdentityConcurrentClaims(t *testing.T) {
+	st, err := store.New(t.TempDir() + "/test_ships.db")
+	if err != nil {
+		t.Fatalf("Failed to create test store: %v", err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	settings := store.IdentitySettings{OnMismatch: store.IdentityNewGeneration, Retention: time.Hour}
+
+	if err := st.ClaimMachineIdentity(ctx, "PC01", firstIdentity, settings, "test", "local"); err != nil {
+		t.Fatalf("Failed to record identity: %v", err)
+	}
+	// A reimaged machine retrying its first writes must start one generation.
+	start := make(chan struct{})
+	errs := make(chan error, 32)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			<-start
+			errs <- st.ClaimMachineIdentity(ctx, "PC01", secondIdentity, settings, "test", "local")
+		}()
+	}
+	close(start)
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("Expected every claim to succeed, got %v", err)
+		}
+	}
+	entries, _, err := st.QueryAudit(ctx, store.AuditFilter{Action: "new_machine_generation"})
+	if err != nil || len(entries) != 1 {
+		t.Errorf("Expected exactly one new generation, got %+v (%v)", entries, err)
+	}
+}
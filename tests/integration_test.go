@@ -3,6 +3,7 @@ package tests
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"io"
 	"net/http"
@@ -19,6 +20,9 @@ import (
 
 func TestMain(m *testing.M) {
 	gin.SetMode(gin.TestMode)
+	// store.New refuses to run without a configured master key.
+	os.Setenv("SHIPS_MASTER_KEY", base64.StdEncoding.EncodeToString(
+		bytes.Repeat([]byte{7}, store.MasterKeySize)))
 	os.Exit(m.Run())
 }
 
@@ -32,7 +36,7 @@ func setupTestServer(t *testing.T) (*httptest.Server, *store.Store) {
 	}
 
 	router := gin.New()
-	api.New(st).Register(router)
+	api.New(st).AllowAnonymous().Register(router)
 
 	server := httptest.NewServer(router)
 	return server, st
@@ -114,7 +118,7 @@ func TestBitLockerKeyManagement(t *testing.T) {
 	// Test BitLocker key update
 	keyPayload := map[string]string{
 		"host":  "TESTHOST02",
-		"key":   "123456-123456-123456-123456-123456-123456-123456-123456",
+		"key":   "123453-234564-345675-456786-567897-011011-122122-233233",
 		"actor": "test-admin",
 	}
 	keyBody, err := json.Marshal(keyPayload)
@@ -152,7 +156,7 @@ func TestBitLockerKeyManagement(t *testing.T) {
 		t.Fatalf("Failed to decode key response: %v", err)
 	}
 
-	expectedKey := "123456-123456-123456-123456-123456-123456-123456-123456"
+	expectedKey := "123453-234564-345675-456786-567897-011011-122122-233233"
 	if keyResp.Key != expectedKey {
 		t.Errorf("Expected key '%s', got %v", expectedKey, keyResp.Key)
 	}
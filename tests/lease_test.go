@@ -0,0 +1,114 @@
+// tests/lease_test.go
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jottavia/SHIPS2-Go/internal/store"
+)
+
+func TestPasswordCheckout(t *testing.T) {
+	server, st := setupTestServer(t)
+	defer server.Close()
+	defer st.Close()
+	ctx := context.Background()
+
+	if err := st.RotatePassword(ctx, "LEASEHOST", "Leased123!", "test", "local"); err != nil {
+		t.Fatalf("Failed to rotate: %v", err)
+	}
+
+	checkout := func(actor, duration string) (int, map[string]any) {
+		resp, err := http.Post(server.URL+"/api/v1/password/LEASEHOST/checkout",
+			"application/json",
+			bytes.NewReader([]byte(`{"actor":"`+actor+`","duration":"`+duration+`"}`)))
+		if err != nil {
+			t.Fatalf("Failed to check out: %v", err)
+		}
+		defer resp.Body.Close()
+		var body map[string]any
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("Failed to decode checkout: %v", err)
+		}
+		return resp.StatusCode, body
+	}
+
+	status, body := checkout("alice", "2h")
+	if status != http.StatusOK || body["password"] != "Leased123!" {
+		t.Fatalf("Expected alice to check out the password, got %d %v", status, body)
+	}
+	status, body = checkout("bob", "1h")
+	if status != http.StatusConflict {
+		t.Fatalf("Expected 409 for a second operator, got %d", status)
+	}
+	if lease, _ := body["lease"].(map[string]any); lease["holder"] != "alice" {
+		t.Errorf("Expected the conflict to name alice, got %v", body)
+	}
+	if _, err := st.GetPassword(ctx, "LEASEHOST", "bob", "local"); err == nil {
+		t.Error("Expected a plain fetch by bob to be refused during the lease")
+	}
+	if status, _ := checkout("alice", "3h"); status != http.StatusOK {
+		t.Errorf("Expected alice to extend her lease, got %d", status)
+	}
+	if status, _ := checkout("alice", "48h"); status != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a lease beyond the maximum, got %d", status)
+	}
+	if status, err := st.CheckIn(ctx, "LEASEHOST"); err != nil || status.RotationRequired {
+		t.Errorf("Expected no rotation while the password is checked out, got %+v (%v)", status, err)
+	}
+
+	if err := st.CheckinPassword(ctx, "LEASEHOST", "bob", "local"); !errors.Is(err, store.ErrNoLease) {
+		t.Errorf("Expected ErrNoLease when bob checks in, got %v", err)
+	}
+	resp, err := http.Post(server.URL+"/api/v1/password/LEASEHOST/checkin", "application/json",
+		bytes.NewReader([]byte(`{"actor":"alice"}`)))
+	if err != nil {
+		t.Fatalf("Failed to check in: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected alice to check in, got %d", resp.StatusCode)
+	}
+	if status, err := st.CheckIn(ctx, "LEASEHOST"); err != nil || !status.RotationRequired {
+		t.Errorf("Expected rotation required after check-in, got %+v (%v)", status, err)
+	}
+
+	// An expired lease frees the password and requires rotation.
+	if err := st.RotatePassword(ctx, "LEASEHOST", "Rotated123!", "agent", "local"); err != nil {
+		t.Fatalf("Failed to rotate: %v", err)
+	}
+	if _, _, err := st.CheckoutPassword(ctx, "LEASEHOST", time.Minute, "bob", "local"); err != nil {
+		t.Fatalf("Failed to check out: %v", err)
+	}
+	expired, err := st.ExpireLeases(ctx, time.Now().Add(2*time.Minute))
+	if err != nil || expired != 1 {
+		t.Fatalf("Expected one expired lease, got %d (%v)", expired, err)
+	}
+	if status, err := st.CheckIn(ctx, "LEASEHOST"); err != nil || !status.RotationRequired ||
+		status.RequiredBy != "bob" {
+		t.Errorf("Expected rotation required for bob's lease, got %+v (%v)", status, err)
+	}
+	if _, err := st.GetPassword(ctx, "LEASEHOST", "alice", "local"); err != nil {
+		t.Errorf("Expected the password to be fetchable after the lease: %v", err)
+	}
+
+	for action, want := range map[string]int{
+		"checkout_password": 2,
+		"extend_checkout":   1,
+		"checkin_password":  1,
+		"lease_expired":     1,
+	} {
+		entries, _, err := st.QueryAudit(ctx, store.AuditFilter{Action: action})
+		if err != nil {
+			t.Fatalf("Failed to query audit log: %v", err)
+		}
+		if len(entries) != want {
+			t.Errorf("Expected %d %s entries, got %d", want, action, len(entries))
+		}
+	}
+}
@@ -0,0 +1,127 @@
+// tests/machines_test.go
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/jottavia/SHIPS2-Go/internal/store"
+)
+
+func TestMachineInventory(t *testing.T) {
+	t.Setenv("SHIPS_HOSTNAME_UNDERSCORE", "true")
+	server, st := setupTestServer(t)
+	defer server.Close()
+	defer st.Close()
+	ctx := context.Background()
+
+	for _, host := range []string{"WS-001", "WS-002", "WS-003", "LAB_1", "LABX1"} {
+		if err := st.RotatePassword(ctx, host, "Initial123!", "test", "local"); err != nil {
+			t.Fatalf("Failed to rotate %s: %v", host, err)
+		}
+	}
+	if err := st.UpdateBDEKey(ctx, "WS-002", "C:", "", osVolumeKey, "test", "local"); err != nil {
+		t.Fatalf("Failed to update key: %v", err)
+	}
+	if _, err := st.GetPassword(ctx, "WS-003", "alice", "local"); err != nil {
+		t.Fatalf("Failed to fetch password: %v", err)
+	}
+
+	machines, next, err := st.ListMachines(ctx, store.MachineFilter{Prefix: "ws-"})
+	if err != nil || next != "" || len(machines) != 3 {
+		t.Fatalf("Expected three WS machines on one page, got %+v %q (%v)", machines, next, err)
+	}
+	if machines[1].Hostname != "WS-002" || machines[1].LastKeyUpdate.IsZero() ||
+		machines[1].LastRotation.IsZero() || !machines[0].LastKeyUpdate.IsZero() {
+		t.Errorf("Expected only WS-002 to have a key, got %+v", machines)
+	}
+	if machines[2].LastFetch.IsZero() || !machines[0].LastFetch.IsZero() {
+		t.Errorf("Expected only WS-003 to have been fetched, got %+v", machines)
+	}
+
+	if machines, _, err = st.ListMachines(ctx, store.MachineFilter{Search: "_"}); err != nil ||
+		len(machines) != 1 || machines[0].Hostname != "LAB_1" {
+		t.Errorf("Expected _ to match literally, got %+v (%v)", machines, err)
+	}
+
+	// Page through newest fetch first, two at a time.
+	var seen []string
+	filter := store.MachineFilter{Sort: store.SortLastFetch, Descending: true, Limit: 2}
+	for page := 0; page < 5; page++ {
+		machines, next, err = st.ListMachines(ctx, filter)
+		if err != nil {
+			t.Fatalf("Failed to list machines: %v", err)
+		}
+		for _, machine := range machines {
+			seen = append(seen, machine.Hostname)
+		}
+		if next == "" {
+			break
+		}
+		filter.Cursor = next
+	}
+	if strings.Join(seen, ",") != "WS-003,WS-002,WS-001,LAB_1,LABX1" {
+		t.Errorf("Expected every machine once, fetched first, got %v", seen)
+	}
+
+	_, _, err = st.ListMachines(ctx, store.MachineFilter{Sort: store.SortFirstSeen, Cursor: filter.Cursor})
+	if !errors.Is(err, store.ErrInvalidCursor) {
+		t.Errorf("Expected a cursor of another sort order to be rejected, got %v", err)
+	}
+
+	resp, err := http.Get(server.URL + "/api/v1/machines?q=ws&sort=-last_key_update&limit=1")
+	if err != nil {
+		t.Fatalf("Failed to list machines: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	var result struct {
+		Machines   []store.Machine `json:"machines"`
+		NextCursor string          `json:"next_cursor"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || len(result.Machines) != 1 ||
+		result.Machines[0].Hostname != "WS-002" || result.NextCursor == "" {
+		t.Errorf("Expected WS-002 first with another page, got %d %s", resp.StatusCode, body)
+	}
+	if strings.Contains(string(body), "Initial123!") || strings.Contains(string(body), osVolumeKey) {
+		t.Errorf("Expected no secrets in the inventory, got %s", body)
+	}
+
+	resp, err = http.Get(server.URL + "/api/v1/machines?sort=password")
+	if err != nil {
+		t.Fatalf("Failed to list machines: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected an unknown sort order to be rejected, got %d", resp.StatusCode)
+	}
+
+	// Looking up a machine the server has never seen does not add it.
+	for _, request := range []struct{ method, path string }{
+		{http.MethodGet, "/api/v1/password/GHOST"},
+		{http.MethodGet, "/api/v1/password/GHOST/history"},
+		{http.MethodGet, "/api/v1/bde/GHOST"},
+		{http.MethodPost, "/api/v1/password/GHOST/checkout"},
+		{http.MethodPost, "/api/v1/password/GHOST/checkin"},
+	} {
+		resp := doWithToken(t, request.method, server.URL+request.path, "", nil)
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s %s: expected 404, got %d", request.method, request.path, resp.StatusCode)
+		}
+	}
+	if machines, _, err = st.ListMachines(ctx, store.MachineFilter{}); err != nil ||
+		len(machines) != 5 {
+		t.Errorf("Expected lookups not to add machines, got %+v (%v)", machines, err)
+	}
+}
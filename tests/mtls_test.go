@@ -0,0 +1,141 @@
+// tests/mtls_test.go
+package tests
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jottavia/SHIPS2-Go/internal/api"
+	"github.com/jottavia/SHIPS2-Go/internal/store"
+)
+
+// testCA is a throwaway certificate authority for client certificates.
+type testCA struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate CA key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "SHIPS test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create CA certificate: %v", err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse CA certificate: %v", err)
+	}
+	return &testCA{certificate: certificate, key: key}
+}
+
+// issue returns a client certificate signed by the CA.
+func (ca *testCA) issue(t *testing.T, commonName string, dnsNames ...string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate client key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.certificate, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("Failed to create client certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// clientWithCert returns a client of server presenting certificate.
+func clientWithCert(server *httptest.Server, certificate tls.Certificate) *http.Client {
+	transport := server.Client().Transport.(*http.Transport).Clone()
+	transport.TLSClientConfig.Certificates = []tls.Certificate{certificate}
+	return &http.Client{Transport: transport}
+}
+
+func TestClientCertificateIdentity(t *testing.T) {
+	st, err := store.New(t.TempDir() + "/test_ships.db")
+	if err != nil {
+		t.Fatalf("Failed to create test store: %v", err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	if err := st.AssignRole(ctx, "carol", store.RoleOperator, "test-admin"); err != nil {
+		t.Fatalf("Failed to assign role: %v", err)
+	}
+
+	ca := newTestCA(t)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.certificate)
+	router := gin.New()
+	api.New(st).RequireTokens().Register(router)
+	server := httptest.NewUnstartedServer(router)
+	server.TLS = &tls.Config{ClientCAs: pool, ClientAuth: tls.VerifyClientCertIfGiven}
+	server.StartTLS()
+	defer server.Close()
+
+	machine := clientWithCert(server, ca.issue(t, "CERTHOST", "CERTHOST"))
+	operator := clientWithCert(server, ca.issue(t, "carol"))
+	post := func(client *http.Client, body string) int {
+		resp, err := client.Post(server.URL+"/api/v1/rotate", "application/json",
+			bytes.NewReader([]byte(body)))
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status := post(server.Client(), `{"host":"CERTHOST","password":"Secret123!"}`); status != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without certificate or token, got %d", status)
+	}
+	if status := post(machine, `{"host":"CERTHOST","password":"Secret123!","actor":"spoofed"}`); status != http.StatusOK {
+		t.Errorf("Expected machine certificate to rotate its own host, got %d", status)
+	}
+	if status := post(machine, `{"host":"OTHERHOST","password":"Secret123!"}`); status != http.StatusForbidden {
+		t.Errorf("Expected machine certificate to be refused for another host, got %d", status)
+	}
+	if status := post(operator, `{"host":"OTHERHOST","password":"Secret123!"}`); status != http.StatusOK {
+		t.Errorf("Expected operator certificate to rotate any host, got %d", status)
+	}
+
+	entries, _, err := st.QueryAudit(ctx, store.AuditFilter{Action: "rotate_password"})
+	if err != nil {
+		t.Fatalf("Failed to query audit log: %v", err)
+	}
+	actors := map[string]string{}
+	for _, entry := range entries {
+		actors[entry.Hostname] = entry.Actor
+	}
+	if actors["CERTHOST"] != "CERTHOST" || actors["OTHERHOST"] != "carol" {
+		t.Errorf("Expected certificate identities as actors, got %v", actors)
+	}
+}
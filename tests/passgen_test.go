@@ -0,0 +1,109 @@
+// tests/passgen_test.go
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/jottavia/SHIPS2-Go/internal/passgen"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	policy := passgen.Default()
+	for i := 0; i < 50; i++ {
+		password, err := passgen.Generate(policy)
+		if err != nil {
+			t.Fatalf("Failed to generate password: %v", err)
+		}
+		if len(password) != policy.Length {
+			t.Fatalf("Expected %d characters, got %q", policy.Length, password)
+		}
+		if strings.ContainsAny(password, "0O1lI") {
+			t.Errorf("Expected no ambiguous characters, got %q", password)
+		}
+		for _, class := range []string{"abcdefghijkmnpqrstuvwxyz", "ABCDEFGHJKLMNPQRSTUVWXYZ",
+			"23456789", "!#$%&*+-=?@^_~"} {
+			if !strings.ContainsAny(password, class) {
+				t.Errorf("Expected a character of %q in %q", class, password)
+			}
+		}
+	}
+
+	digits := passgen.Policy{Length: 12, Classes: []string{passgen.ClassDigit}}
+	if password, err := passgen.Generate(digits); err != nil || strings.Trim(password, "0123456789") != "" {
+		t.Errorf("Expected a 12-digit PIN, got %q (%v)", password, err)
+	}
+	for _, invalid := range []passgen.Policy{
+		{Length: 4, Classes: []string{passgen.ClassLower}},
+		{Length: 16},
+		{Length: 16, Classes: []string{"emoji"}},
+		{Wordlist: []string{"alpha", "bravo"}, Words: 5},
+	} {
+		if _, err := passgen.Generate(invalid); err == nil {
+			t.Errorf("Expected policy %+v to be rejected", invalid)
+		}
+	}
+
+	var list strings.Builder
+	for i := 0; i < 8192; i++ {
+		fmt.Fprintf(&list, "%05d word%d\n", i, i)
+	}
+	path := t.TempDir() + "/words.txt"
+	if err := os.WriteFile(path, []byte(list.String()), 0o600); err != nil {
+		t.Fatalf("Failed to write wordlist: %v", err)
+	}
+	words, err := passgen.LoadWordlist(path)
+	if err != nil || len(words) != 8192 {
+		t.Fatalf("Expected 8192 words, got %d (%v)", len(words), err)
+	}
+	passphrase, err := passgen.Generate(passgen.Policy{Wordlist: words, Words: 6, Separator: "."})
+	if err != nil {
+		t.Fatalf("Failed to generate passphrase: %v", err)
+	}
+	if parts := strings.Split(passphrase, "."); len(parts) != 6 || !strings.HasPrefix(parts[0], "word") {
+		t.Errorf("Expected six words, got %q", passphrase)
+	}
+}
+
+func TestServerGeneratedRotation(t *testing.T) {
+	server, st := setupTestServer(t)
+	defer server.Close()
+	defer st.Close()
+
+	resp, err := http.Post(server.URL+"/api/v1/rotate", "application/json",
+		bytes.NewReader([]byte(`{"host":"GENHOST","actor":"ssh:alice"}`)))
+	if err != nil {
+		t.Fatalf("Failed to rotate: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected generated rotation to succeed, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Cache-Control") != "no-store" {
+		t.Errorf("Expected generated password response to be uncacheable")
+	}
+	var result struct {
+		Password  string `json:"password"`
+		Generated bool   `json:"generated"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !result.Generated || len(result.Password) != passgen.Default().Length {
+		t.Fatalf("Expected a generated password, got %+v", result)
+	}
+
+	stored, err := st.GetPassword(context.Background(), "GENHOST", "test", "local")
+	if err != nil {
+		t.Fatalf("Failed to fetch password: %v", err)
+	}
+	if stored.Password != result.Password {
+		t.Errorf("Expected the escrowed password to be the one returned")
+	}
+}
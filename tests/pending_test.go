@@ -0,0 +1,215 @@
+// tests/pending_test.go
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jottavia/SHIPS2-Go/internal/store"
+)
+
+func TestTwoPhaseRotation(t *testing.T) {
+	server, st := setupTestServer(t)
+	defer server.Close()
+	defer st.Close()
+	ctx := context.Background()
+
+	if err := st.RotatePassword(ctx, "PENDHOST", "Confirmed123!", "test", "local"); err != nil {
+		t.Fatalf("Failed to rotate: %v", err)
+	}
+
+	resp, err := http.Post(server.URL+"/api/v1/rotate", "application/json",
+		bytes.NewReader([]byte(`{"host":"PENDHOST","password":"Pending123!","two_phase":true}`)))
+	if err != nil {
+		t.Fatalf("Failed to begin rotation: %v", err)
+	}
+	var pending struct {
+		Status    string    `json:"status"`
+		ID        int64     `json:"id"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&pending)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.StatusCode != http.StatusAccepted || pending.Status != "pending" || pending.ID == 0 {
+		t.Fatalf("Expected 202 with a pending version, got %d %+v", resp.StatusCode, pending)
+	}
+
+	info, err := st.GetPassword(ctx, "PENDHOST", "test", "local")
+	if err != nil {
+		t.Fatalf("Failed to fetch password: %v", err)
+	}
+	if info.Password != "Confirmed123!" {
+		t.Errorf("Expected the confirmed password to stay current, got %q", info.Password)
+	}
+	if info.Pending == nil || info.Pending.Password != "Pending123!" || info.Pending.ID != pending.ID {
+		t.Fatalf("Expected the pending version alongside it, got %+v", info.Pending)
+	}
+
+	confirm := func(id int64) int {
+		resp, err := http.Post(fmt.Sprintf("%s/api/v1/rotate/%d/confirm", server.URL, id),
+			"application/json", bytes.NewReader([]byte(`{"actor":"agent"}`)))
+		if err != nil {
+			t.Fatalf("Failed to confirm: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := confirm(pending.ID); status != http.StatusOK {
+		t.Fatalf("Expected confirmation to succeed, got %d", status)
+	}
+	if status := confirm(pending.ID); status != http.StatusConflict {
+		t.Errorf("Expected 409 confirming twice, got %d", status)
+	}
+	if status := confirm(999999); status != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown version, got %d", status)
+	}
+
+	info, err = st.GetPassword(ctx, "PENDHOST", "test", "local")
+	if err != nil {
+		t.Fatalf("Failed to fetch password: %v", err)
+	}
+	if info.Password != "Pending123!" || info.Pending != nil {
+		t.Errorf("Expected the confirmed version to be current, got %q pending %+v",
+			info.Password, info.Pending)
+	}
+
+	// A version nobody confirms expires and can no longer be confirmed.
+	stale, err := st.BeginRotation(ctx, "PENDHOST", "Stale123!", time.Hour, "test", "local")
+	if err != nil {
+		t.Fatalf("Failed to begin rotation: %v", err)
+	}
+	expired, err := st.ExpirePendingRotations(ctx, time.Now().Add(2*time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to expire rotations: %v", err)
+	}
+	if expired != 1 {
+		t.Errorf("Expected one expired rotation, got %d", expired)
+	}
+	if err := st.ConfirmRotation(ctx, stale.ID, "test", "local"); !errors.Is(err, store.ErrRotationExpired) {
+		t.Errorf("Expected ErrRotationExpired, got %v", err)
+	}
+	info, err = st.GetPassword(ctx, "PENDHOST", "test", "local")
+	if err != nil {
+		t.Fatalf("Failed to fetch password: %v", err)
+	}
+	if info.Pending == nil || info.Pending.ID != stale.ID || info.Pending.State != store.PasswordExpired {
+		t.Errorf("Expected the expired version to be reported, got %+v", info.Pending)
+	}
+
+	history, err := st.ListPasswordHistory(ctx, "PENDHOST", "test", "local")
+	if err != nil {
+		t.Fatalf("Failed to list history: %v", err)
+	}
+	states := map[string]int{}
+	for _, version := range history {
+		states[version.State]++
+	}
+	if states[store.PasswordConfirmed] != 2 || states[store.PasswordExpired] != 1 {
+		t.Errorf("Unexpected history states: %v", states)
+	}
+
+	for action, want := range map[string]int{
+		"rotate_password_pending":  2,
+		"confirm_password":         1,
+		"pending_password_expired": 1,
+	} {
+		entries, _, err := st.QueryAudit(ctx, store.AuditFilter{Action: action})
+		if err != nil {
+			t.Fatalf("Failed to query audit log: %v", err)
+		}
+		if len(entries) != want {
+			t.Errorf("Expected %d %s entries, got %d", want, action, len(entries))
+		}
+	}
+}
+
+func TestConfirmRotationOutOfOrder(t *testing.T) {
+	st, err := store.New(t.TempDir() + "/test_ships.db")
+	if err != nil {
+		t.Fatalf("Failed to create test store: %v", err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+
+	first, err := st.BeginRotation(ctx, "ORDERHOST", "First123!", time.Hour, "test", "local")
+	if err != nil {
+		t.Fatalf("Failed to begin rotation: %v", err)
+	}
+	second, err := st.BeginRotation(ctx, "ORDERHOST", "Second123!", time.Hour, "test", "local")
+	if err != nil {
+		t.Fatalf("Failed to begin rotation: %v", err)
+	}
+	if err := st.ConfirmRotation(ctx, second.ID, "test", "local"); err != nil {
+		t.Fatalf("Failed to confirm: %v", err)
+	}
+	err = st.ConfirmRotation(ctx, first.ID, "test", "local")
+	if !errors.Is(err, store.ErrRotationNotPending) {
+		t.Errorf("Expected confirming the older version to fail, got %v", err)
+	}
+	info, err := st.GetPassword(ctx, "ORDERHOST", "test", "local")
+	if err != nil || info.Password != "Second123!" {
+		t.Errorf("Expected the newer password to stay current, got %+v (%v)", info, err)
+	}
+}
+
+func TestCancelRotation(t *testing.T) {
+	server, st := setupTestServer(t)
+	defer server.Close()
+	defer st.Close()
+	ctx := context.Background()
+
+	if err := st.RotatePassword(ctx, "CANCELHOST", "Confirmed123!", "test", "local"); err != nil {
+		t.Fatalf("Failed to rotate: %v", err)
+	}
+	pending, err := st.BeginRotation(ctx, "CANCELHOST", "Unapplied123!", time.Hour, "test", "local")
+	if err != nil {
+		t.Fatalf("Failed to begin rotation: %v", err)
+	}
+
+	settle := func(id int64, action string) int {
+		resp, err := http.Post(fmt.Sprintf("%s/api/v1/rotate/%d/%s", server.URL, id, action),
+			"application/json", bytes.NewReader([]byte(`{"actor":"agent"}`)))
+		if err != nil {
+			t.Fatalf("Failed to %s: %v", action, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := settle(pending.ID, "cancel"); status != http.StatusOK {
+		t.Fatalf("Expected cancelling to succeed, got %d", status)
+	}
+	if status := settle(pending.ID, "confirm"); status != http.StatusConflict {
+		t.Errorf("Expected 409 confirming a cancelled version, got %d", status)
+	}
+	if status := settle(pending.ID, "cancel"); status != http.StatusConflict {
+		t.Errorf("Expected 409 cancelling twice, got %d", status)
+	}
+	if status := settle(999999, "cancel"); status != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown version, got %d", status)
+	}
+
+	info, err := st.GetPassword(ctx, "CANCELHOST", "test", "local")
+	if err != nil {
+		t.Fatalf("Failed to fetch password: %v", err)
+	}
+	if info.Password != "Confirmed123!" || info.Pending != nil {
+		t.Errorf("Expected only the confirmed password, got %q pending %+v",
+			info.Password, info.Pending)
+	}
+	entries, _, err := st.QueryAudit(ctx, store.AuditFilter{Action: "cancel_password"})
+	if err != nil {
+		t.Fatalf("Failed to query audit log: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Actor != "agent" {
+		t.Errorf("Expected one cancel_password entry by agent, got %+v", entries)
+	}
+}
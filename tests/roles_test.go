@@ -0,0 +1,118 @@
+// tests/roles_test.go
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jottavia/SHIPS2-Go/internal/api"
+	"github.com/jottavia/SHIPS2-Go/internal/store"
+)
+
+func TestRoleBasedAccess(t *testing.T) {
+	server, st := setupTokenServer(t)
+	defer server.Close()
+	defer st.Close()
+	ctx := context.Background()
+
+	tokens := map[string]string{}
+	for name, role := range map[string]store.Role{
+		"WINBOX01": store.RoleMachine,
+		"desk":     store.RoleHelpdesk,
+		"ops":      store.RoleOperator,
+		"root":     store.RoleAdmin,
+		"nobody":   "",
+	} {
+		token, _, err := st.IssueAPIToken(ctx, name, 0, "test-admin")
+		if err != nil {
+			t.Fatalf("Failed to issue token: %v", err)
+		}
+		if role != "" {
+			if err := st.AssignRole(ctx, name, role, "test-admin"); err != nil {
+				t.Fatalf("Failed to assign role: %v", err)
+			}
+		}
+		tokens[name] = token
+	}
+
+	rotateOwn := []byte(`{"host":"WINBOX01","password":"Secret123!"}`)
+	rotateOther := []byte(`{"host":"WINBOX02","password":"Secret123!"}`)
+	keyBody := []byte(`{"host":"WINBOX01","key":"` + osVolumeKey + `"}`)
+
+	for _, tc := range []struct {
+		caller, method, path string
+		body                 []byte
+		want                 int
+	}{
+		{"WINBOX01", http.MethodPost, "/api/v1/rotate", rotateOwn, http.StatusOK},
+		{"WINBOX01", http.MethodPost, "/api/v1/update_key", keyBody, http.StatusOK},
+		{"WINBOX01", http.MethodPost, "/api/v1/rotate", rotateOther, http.StatusForbidden},
+		{"WINBOX01", http.MethodGet, "/api/v1/password/WINBOX01", nil, http.StatusForbidden},
+		{"desk", http.MethodGet, "/api/v1/bde/WINBOX01", nil, http.StatusOK},
+		{"desk", http.MethodGet, "/api/v1/password/WINBOX01", nil, http.StatusForbidden},
+		{"desk", http.MethodPost, "/api/v1/rotate", rotateOwn, http.StatusForbidden},
+		{"ops", http.MethodGet, "/api/v1/password/WINBOX01", nil, http.StatusOK},
+		{"ops", http.MethodPost, "/api/v1/rotate", rotateOther, http.StatusOK},
+		{"ops", http.MethodGet, "/api/v1/audit", nil, http.StatusForbidden},
+		{"root", http.MethodGet, "/api/v1/audit", nil, http.StatusOK},
+		{"nobody", http.MethodGet, "/api/v1/bde/WINBOX01", nil, http.StatusForbidden},
+	} {
+		resp := doWithToken(t, tc.method, server.URL+tc.path, tokens[tc.caller], tc.body)
+		if resp.StatusCode != tc.want {
+			t.Errorf("%s %s %s: expected %d, got %d", tc.caller, tc.method, tc.path,
+				tc.want, resp.StatusCode)
+		}
+	}
+
+	denials, _, err := st.QueryAudit(ctx, store.AuditFilter{Action: "access_denied"})
+	if err != nil {
+		t.Fatalf("Failed to query audit log: %v", err)
+	}
+	if len(denials) != 6 {
+		t.Errorf("Expected 6 audited denials, got %d: %+v", len(denials), denials)
+	}
+	for _, denial := range denials {
+		if denial.Detail == "" {
+			t.Errorf("Expected denial %d to say what was attempted", denial.ID)
+		}
+	}
+}
+
+func TestRolesWithoutRequiredTokens(t *testing.T) {
+	st, err := store.New(t.TempDir() + "/test_ships.db")
+	if err != nil {
+		t.Fatalf("Failed to create test store: %v", err)
+	}
+	defer st.Close()
+	router := gin.New()
+	api.New(st).Register(router)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	if err := st.RotatePassword(context.Background(), "OPENHOST", "Secret123!", "test", "local"); err != nil {
+		t.Fatalf("Failed to rotate: %v", err)
+	}
+	ops := operatorToken(t, st, "ops")
+
+	// Anonymous callers pass no role check unless AllowAnonymous is set.
+	for _, tc := range []struct {
+		method, path, token string
+		want                int
+	}{
+		{http.MethodGet, "/api/v1/password/OPENHOST", "", http.StatusUnauthorized},
+		{http.MethodGet, "/api/v1/bde/OPENHOST", "", http.StatusUnauthorized},
+		{http.MethodGet, "/api/v1/audit", "", http.StatusUnauthorized},
+		{http.MethodPost, "/api/v1/machines/OPENHOST/decommission", "", http.StatusUnauthorized},
+		{http.MethodGet, "/api/v1/password/OPENHOST", ops, http.StatusOK},
+		{http.MethodGet, "/api/v1/audit", ops, http.StatusForbidden},
+	} {
+		resp := doWithToken(t, tc.method, server.URL+tc.path, tc.token, []byte(`{"reason":"x"}`))
+		if resp.StatusCode != tc.want {
+			t.Errorf("%s %s with token %q: expected %d, got %d", tc.method, tc.path, tc.token,
+				tc.want, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+// tests/rotation_test.go
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jottavia/SHIPS2-Go/internal/store"
+)
+
+func checkIn(t *testing.T, serverURL, host string) store.CheckInStatus {
+	t.Helper()
+	resp, err := http.Post(serverURL+"/api/v1/agent/checkin", "application/json",
+		bytes.NewReader([]byte(`{"host":"`+host+`"}`)))
+	if err != nil {
+		t.Fatalf("Failed to check in: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected check-in to succeed, got %d", resp.StatusCode)
+	}
+	var status store.CheckInStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		t.Fatalf("Failed to decode check-in: %v", err)
+	}
+	return status
+}
+
+func TestRotationRequiredAfterFetch(t *testing.T) {
+	server, st := setupTestServer(t)
+	defer server.Close()
+	defer st.Close()
+	ctx := context.Background()
+
+	if status := checkIn(t, server.URL, "NEWHOST"); !status.RotationRequired ||
+		status.Reason != store.RotationReasonNoPassword {
+		t.Errorf("Expected a machine without a password to need one, got %+v", status)
+	}
+
+	if err := st.RotatePassword(ctx, "BURNHOST", "Initial123!", "test", "local"); err != nil {
+		t.Fatalf("Failed to rotate: %v", err)
+	}
+	if status := checkIn(t, server.URL, "BURNHOST"); status.RotationRequired {
+		t.Errorf("Expected no rotation before any fetch, got %+v", status)
+	}
+
+	for _, actor := range []string{"alice", "bob"} {
+		if _, err := st.GetPassword(ctx, "BURNHOST", actor, "local"); err != nil {
+			t.Fatalf("Failed to fetch password: %v", err)
+		}
+	}
+	status := checkIn(t, server.URL, "BURNHOST")
+	if !status.RotationRequired || status.Reason != store.RotationReasonFetched ||
+		status.RequiredBy != "alice" {
+		t.Errorf("Expected rotation required since alice's fetch, got %+v", status)
+	}
+
+	overdue, err := st.OverdueRotations(ctx, 0)
+	if err != nil {
+		t.Fatalf("Failed to list overdue rotations: %v", err)
+	}
+	if len(overdue) != 1 || overdue[0].Hostname != "BURNHOST" || overdue[0].LastCheckIn.IsZero() {
+		t.Errorf("Expected BURNHOST overdue with a check-in, got %+v", overdue)
+	}
+	if overdue, err = st.OverdueRotations(ctx, time.Hour); err != nil || len(overdue) != 0 {
+		t.Errorf("Expected nothing overdue within the grace period, got %+v (%v)", overdue, err)
+	}
+
+	resp, err := http.Get(server.URL + "/api/v1/rotations/overdue?grace=0s")
+	if err != nil {
+		t.Fatalf("Failed to query overdue rotations: %v", err)
+	}
+	var report struct {
+		Machines []store.OverdueRotation `json:"machines"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&report)
+	resp.Body.Close()
+	if err != nil || len(report.Machines) != 1 {
+		t.Errorf("Expected one overdue machine from the API, got %+v (%v)", report, err)
+	}
+	resp, err = http.Get(server.URL + "/api/v1/rotations/overdue?grace=soon")
+	if err != nil {
+		t.Fatalf("Failed to query overdue rotations: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid grace period, got %d", resp.StatusCode)
+	}
+
+	if err := st.RotatePassword(ctx, "BURNHOST", "Fresh123!", "agent", "local"); err != nil {
+		t.Fatalf("Failed to rotate: %v", err)
+	}
+	if status := checkIn(t, server.URL, "BURNHOST"); status.RotationRequired {
+		t.Errorf("Expected rotation to clear the flag, got %+v", status)
+	}
+}
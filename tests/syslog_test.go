@@ -0,0 +1,88 @@
+// tests/syslog_test.go
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jottavia/SHIPS2-Go/internal/store"
+	"github.com/jottavia/SHIPS2-Go/internal/syslog"
+)
+
+func TestAuditEventsForwardedToSyslog(t *testing.T) {
+	collector, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer collector.Close()
+
+	st, err := store.New(t.TempDir() + "/ships.db")
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer st.Close()
+	forwarder := syslog.New(syslog.Config{
+		Network:  "udp",
+		Address:  collector.LocalAddr().String(),
+		Facility: 10,
+	})
+	defer forwarder.Close(time.Second)
+	st.SetAuditHook(forwarder.Audit)
+
+	ctx := context.Background()
+	if err := st.RotatePassword(ctx, "SYSLOGHOST", "Secret123!", "scheduled-task", "10.0.0.5"); err != nil {
+		t.Fatalf("Failed to rotate password: %v", err)
+	}
+
+	buffer := make([]byte, 4096)
+	collector.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := collector.ReadFrom(buffer)
+	if err != nil {
+		t.Fatalf("No syslog message received: %v", err)
+	}
+	message := string(buffer[:n])
+
+	// <authpriv.info>1 TIMESTAMP HOST APP-NAME PROCID MSGID SD MSG
+	if !strings.HasPrefix(message, "<86>1 ") {
+		t.Errorf("Expected RFC 5424 header with PRI 86, got %q", message)
+	}
+	fields := strings.SplitN(message, " ", 8)
+	if len(fields) != 8 || fields[3] != syslog.DefaultTag || fields[6] != "-" {
+		t.Fatalf("Unexpected header fields in %q", message)
+	}
+
+	var event syslog.Event
+	if err := json.Unmarshal([]byte(fields[7]), &event); err != nil {
+		t.Fatalf("Message body is not JSON: %v", err)
+	}
+	if event.Action != "ALLOW" || event.Verb != "rotate_password" ||
+		event.Hostname != "SYSLOGHOST" || event.User != "scheduled-task" ||
+		event.RemoteAddr != "10.0.0.5" || event.AuditID == 0 {
+		t.Errorf("Unexpected event: %+v", event)
+	}
+}
+
+func TestParseSyslogTarget(t *testing.T) {
+	for target, want := range map[string]string{
+		"udp://wazuh.example.com":     "udp wazuh.example.com:514",
+		"tcp://10.0.0.1:1514":         "tcp 10.0.0.1:1514",
+		"unix:///dev/log":             "unixgram /dev/log",
+		"http://wazuh.example.com:80": "",
+		"udp://":                      "",
+	} {
+		network, address, err := syslog.ParseTarget(target)
+		if want == "" {
+			if err == nil {
+				t.Errorf("Expected %q to be rejected", target)
+			}
+			continue
+		}
+		if err != nil || network+" "+address != want {
+			t.Errorf("ParseTarget(%q) = %s %s, %v; want %s", target, network, address, err, want)
+		}
+	}
+}
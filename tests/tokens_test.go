@@ -0,0 +1,154 @@
+// tests/tokens_test.go
+package tests
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jottavia/SHIPS2-Go/internal/api"
+	"github.com/jottavia/SHIPS2-Go/internal/store"
+)
+
+// setupTokenServer is setupTestServer with API tokens required.
+func setupTokenServer(t *testing.T) (*httptest.Server, *store.Store) {
+	st, err := store.New(t.TempDir() + "/test_ships.db")
+	if err != nil {
+		t.Fatalf("Failed to create test store: %v", err)
+	}
+	router := gin.New()
+	api.New(st).RequireTokens().Register(router)
+	return httptest.NewServer(router), st
+}
+
+// doWithToken sends a request with an optional bearer token.
+func doWithToken(t *testing.T, method, url, token string, body []byte) *http.Response {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to build request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestAPITokenAuthentication(t *testing.T) {
+	server, st := setupTokenServer(t)
+	defer server.Close()
+	defer st.Close()
+	ctx := context.Background()
+
+	token, info, err := st.IssueAPIToken(ctx, "alice", 0, "test-admin")
+	if err != nil {
+		t.Fatalf("Failed to issue token: %v", err)
+	}
+	if err := st.AssignRole(ctx, "alice", store.RoleOperator, "test-admin"); err != nil {
+		t.Fatalf("Failed to assign role: %v", err)
+	}
+	rotateBody := []byte(`{"host":"TOKENHOST","password":"Secret123!","actor":"mallory"}`)
+
+	if resp := doWithToken(t, http.MethodPost, server.URL+"/api/v1/rotate", "", rotateBody); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a token, got %d", resp.StatusCode)
+	}
+	if resp := doWithToken(t, http.MethodPost, server.URL+"/api/v1/rotate", "ships_bogus", rotateBody); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for an unknown token, got %d", resp.StatusCode)
+	}
+	if resp := doWithToken(t, http.MethodPost, server.URL+"/api/v1/rotate", token, rotateBody); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 with a valid token, got %d", resp.StatusCode)
+	}
+
+	// The token owner, not the self-declared actor, is audited.
+	entries, _, err := st.QueryAudit(ctx, store.AuditFilter{Action: "rotate_password"})
+	if err != nil {
+		t.Fatalf("Failed to query audit log: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Actor != "alice" {
+		t.Errorf("Expected rotation audited as alice, got %+v", entries)
+	}
+	issued, _, err := st.QueryAudit(ctx, store.AuditFilter{Action: "issue_token"})
+	if err != nil {
+		t.Fatalf("Failed to query audit log: %v", err)
+	}
+	if len(issued) != 1 || issued[0].Actor != "test-admin" || issued[0].Detail == "" {
+		t.Errorf("Expected issue_token entry naming the owner, got %+v", issued)
+	}
+
+	if err := st.RevokeAPIToken(ctx, info.ID, "test-admin"); err != nil {
+		t.Fatalf("Failed to revoke token: %v", err)
+	}
+	if resp := doWithToken(t, http.MethodGet, server.URL+"/api/v1/password/TOKENHOST", token, nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a revoked token, got %d", resp.StatusCode)
+	}
+
+	tokens, err := st.ListAPITokens(ctx)
+	if err != nil {
+		t.Fatalf("Failed to list tokens: %v", err)
+	}
+	if len(tokens) != 1 || tokens[0].LastUsedAt.IsZero() || tokens[0].RevokedAt.IsZero() {
+		t.Errorf("Expected one used and revoked token, got %+v", tokens)
+	}
+
+	result, err := st.VerifyAuditChain(ctx)
+	if err != nil {
+		t.Fatalf("Failed to verify audit chain: %v", err)
+	}
+	if result.BrokenAtID != 0 {
+		t.Errorf("Expected intact audit chain, broken at %d: %s", result.BrokenAtID, result.Reason)
+	}
+}
+
+func TestAPITokenExpiry(t *testing.T) {
+	now := time.Now()
+	token := store.APIToken{ExpiresAt: now.Add(-time.Second)}
+	if token.Active(now) {
+		t.Error("Expected expired token to be inactive")
+	}
+	token.ExpiresAt = time.Time{}
+	if !token.Active(now) {
+		t.Error("Expected token without expiry to be active")
+	}
+}
+
+func TestBearerTokenWithoutRequiredTokens(t *testing.T) {
+	server, st := setupTestServer(t)
+	defer server.Close()
+	defer st.Close()
+	ctx := context.Background()
+
+	token, _, err := st.IssueAPIToken(ctx, "helpdesk1", 0, "test-admin")
+	if err != nil {
+		t.Fatalf("Failed to issue token: %v", err)
+	}
+	if err := st.AssignRole(ctx, "helpdesk1", store.RoleHelpdesk, "test-admin"); err != nil {
+		t.Fatalf("Failed to assign role: %v", err)
+	}
+	if err := st.RotatePassword(ctx, "OPENHOST", "Secret123!", "test", "local"); err != nil {
+		t.Fatalf("Failed to rotate: %v", err)
+	}
+
+	url := server.URL + "/api/v1/password/OPENHOST"
+	for _, tc := range []struct {
+		token string
+		want  int
+	}{
+		{"", http.StatusOK},
+		{"ships_bogus", http.StatusUnauthorized},
+		// A presented token's role applies even where none is required.
+		{token, http.StatusForbidden},
+	} {
+		if resp := doWithToken(t, http.MethodGet, url, tc.token, nil); resp.StatusCode != tc.want {
+			t.Errorf("GET with token %q: expected %d, got %d", tc.token, tc.want, resp.StatusCode)
+		}
+	}
+}